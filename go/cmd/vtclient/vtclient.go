@@ -16,6 +16,9 @@ import (
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/exit"
 	"github.com/youtube/vitess/go/vt/logutil"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateconn"
+	"golang.org/x/net/context"
 
 	// import the 'vitess' sql driver
 	_ "github.com/youtube/vitess/go/vt/client"
@@ -28,11 +31,15 @@ Version 3 of the API is used, we do not send any hint to the server.
 
 For query bound variables, we assume place-holders in the query string
 in the form of :v1, :v2, etc.
+
+With -explain, the query is not executed. Instead, vtclient prints
+the plan vtgate would use for it, with the shards it would be sent to.
 `
 	server        = flag.String("server", "", "vtgate server to connect to")
 	tabletType    = flag.String("tablet_type", "rdonly", "tablet type to direct queries to")
 	timeout       = flag.Duration("timeout", 30*time.Second, "timeout for queries")
 	streaming     = flag.Bool("streaming", false, "use a streaming query")
+	explain       = flag.Bool("explain", false, "print the plan of the query instead of executing it")
 	bindVariables = newBindvars("bind_variables", "bind variables as a json list")
 )
 
@@ -92,6 +99,37 @@ func isDml(sql string) bool {
 	return strings.HasPrefix(lower, "insert") || strings.HasPrefix(lower, "update") || strings.HasPrefix(lower, "delete")
 }
 
+// explainQuery prints the plan of a query as JSON.
+func explainQuery(query string) error {
+	tt, err := topo.ParseTabletType(*tabletType)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	conn, err := vtgateconn.Dial(ctx, *server, *timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Use the same bind var names as the sql driver.
+	bv := make(map[string]interface{}, len(*bindVariables))
+	for i, v := range *bindVariables {
+		bv[fmt.Sprintf("v%d", i+1)] = v
+	}
+	plan, err := conn.Explain(ctx, query, bv, tt)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
+
 func main() {
 	defer exit.Recover()
 	defer logutil.Flush()
@@ -104,6 +142,14 @@ func main() {
 		exit.Return(1)
 	}
 
+	if *explain {
+		if err := explainQuery(args[0]); err != nil {
+			log.Errorf("explain failed: %v", err)
+			exit.Return(1)
+		}
+		return
+	}
+
 	connStr := fmt.Sprintf(`{"address": "%s", "tablet_type": "%s", "streaming": %v, "timeout": %d}`, *server, *tabletType, *streaming, int64(30*(*timeout)))
 	db, err := sql.Open("vitess", connStr)
 	if err != nil {
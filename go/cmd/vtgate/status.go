@@ -3,9 +3,83 @@ package main
 import (
 	"github.com/youtube/vitess/go/vt/servenv"
 	_ "github.com/youtube/vitess/go/vt/status"
+	"github.com/youtube/vitess/go/vt/vtgate"
 )
 
 var (
+	vschemaTemplate = `
+<table>
+  <tr>
+    <th>Version</th>
+    <th>Last Update</th>
+    <th>Last Error</th>
+  </tr>
+  <tr>
+    <td>{{if .Loaded}}{{.Version}}{{else}}not loaded{{end}}</td>
+    <td>{{if .Loaded}}{{.LastUpdate}}{{end}}</td>
+    <td>{{if .LastError}}<b>{{.LastError}}</b>{{end}}</td>
+  </tr>
+</table>
+`
+
+	healthCheckTemplate = `
+<p>Cells: {{range $i, $cell := .Cells}}{{$cell}}&nbsp;{{end}}<br>
+Last topo refresh: {{.LastRefresh}}{{range $i, $err := .RefreshErrors}}<br><b>{{$err}}</b>{{end}}</p>
+<table>
+  <tr>
+    <th>Tablet</th>
+    <th>Host</th>
+    <th>Keyspace</th>
+    <th>Shard</th>
+    <th>TabletType</th>
+    <th>Status</th>
+    <th>Last Response</th>
+  </tr>
+  {{range $i, $th := .Tablets}}
+  <tr>
+    <td>{{$th.Alias}}</td>
+    <td>{{$th.Host}}</td>
+    <td>{{if $th.Target}}{{$th.Target.Keyspace}}{{end}}</td>
+    <td>{{if $th.Target}}{{$th.Target.Shard}}{{end}}</td>
+    <td>{{if $th.Target}}{{$th.Target.TabletType}}{{end}}</td>
+    <td>{{if $th.Serving}}serving{{else if $th.HealthError}}<b>{{$th.HealthError}}</b>{{else}}not serving{{end}}</td>
+    <td>{{$th.LastResponse}}</td>
+  </tr>
+  {{end}}
+</table>
+`
+
+	balancerTemplate = `
+<table>
+  <tr>
+    <th>Keyspace</th>
+    <th>Shard</th>
+    <th>TabletType</th>
+    <th>Policy</th>
+    <th>EndPoint</th>
+    <th>Status</th>
+    <th>Lag</th>
+    <th>CPU</th>
+    <th>Latency</th>
+    <th>Weight</th>
+  </tr>
+  {{range $i, $sb := .}}{{range $j, $ep := $sb.EndPoints}}
+  <tr>
+    <td>{{$sb.Keyspace}}</td>
+    <td>{{$sb.Shard}}</td>
+    <td>{{$sb.TabletType}}</td>
+    <td>{{$sb.Policy}}</td>
+    <td>{{$ep.EndPoint.Host}} ({{$ep.EndPoint.Uid}})</td>
+    <td>{{if $ep.MarkedDown}}<b>marked down</b>{{else if $ep.HealthError}}<b>{{$ep.HealthError}}</b>{{else}}ok{{end}}</td>
+    <td>{{$ep.Lag}}</td>
+    <td>{{printf "%.2f" $ep.CPUUsage}}</td>
+    <td>{{$ep.Latency}}</td>
+    <td>{{printf "%.2f" $ep.Weight}}</td>
+  </tr>
+  {{end}}{{end}}
+</table>
+`
+
 	topoTemplate = `
 <style>
   table {
@@ -67,7 +141,7 @@ var (
     <td>{{github_com_youtube_vitess_vtctld_srv_keyspace $ep.Cell $ep.Keyspace}}</td>
     <td>{{github_com_youtube_vitess_vtctld_srv_shard $ep.Cell $ep.Keyspace $ep.Shard}}</td>
     <td>{{github_com_youtube_vitess_vtctld_srv_type $ep.Cell $ep.Keyspace $ep.Shard $ep.TabletType}}</td>
-    <td>{{if $ep.LastError}}<b>{{$ep.LastError}}</b>{{else}}{{$ep.StatusAsHTML}}{{end}}</td>
+    <td>{{if $ep.LastError}}<b>{{$ep.LastError}}</b>{{if $ep.FallbackCell}}, <b>serving from cell {{$ep.FallbackCell}}</b>{{end}}{{else}}{{$ep.StatusAsHTML}}{{end}}</td>
   </tr>
   {{end}}
 </table>
@@ -203,6 +277,19 @@ func init() {
 		servenv.AddStatusPart("Topology Cache", topoTemplate, func() interface{} {
 			return resilientSrvTopoServer.CacheStatus()
 		})
+		if vschemaWatcher != nil {
+			servenv.AddStatusPart("VSchema", vschemaTemplate, func() interface{} {
+				return vschemaWatcher.Status()
+			})
+		}
+		if healthCheck != nil {
+			servenv.AddStatusPart("Health Check", healthCheckTemplate, func() interface{} {
+				return healthCheck.Status()
+			})
+		}
+		servenv.AddStatusPart("Load Balancing", balancerTemplate, func() interface{} {
+			return vtgate.BalancerStatus()
+		})
 		servenv.AddStatusPart("Stats", statsTemplate, func() interface{} {
 			return nil
 		})
@@ -6,6 +6,7 @@ package main
 
 import (
 	"flag"
+	"strings"
 	"time"
 
 	log "github.com/golang/glog"
@@ -26,10 +27,16 @@ var (
 	connTimeoutPerConn = flag.Duration("conn-timeout-per-conn", 1500*time.Millisecond, "vttablet connection timeout (per connection)")
 	connLife           = flag.Duration("conn-life", 365*24*time.Hour, "average life of vttablet connections")
 	maxInFlight        = flag.Int("max-in-flight", 0, "maximum number of calls to allow simultaneously")
+
+	healthCheckCells       = flag.String("healthcheck_cells", "", "comma-separated list of cells whose tablets are discovered through their health stream instead of the serving graph")
+	healthCheckRetryDelay  = flag.Duration("healthcheck_retry_delay", 5*time.Second, "delay before reopening a failed health stream")
+	healthCheckTopoRefresh = flag.Duration("healthcheck_topo_refresh", 1*time.Minute, "how often the list of tablets of the healthcheck cells is read from the topo")
 )
 
 var resilientSrvTopoServer *vtgate.ResilientSrvTopoServer
 var topoReader *TopoReader
+var vschemaWatcher *vtgate.VSchemaWatcher
+var healthCheck *vtgate.HealthCheck
 
 func init() {
 	servenv.RegisterDefaultFlags()
@@ -47,15 +54,22 @@ func main() {
 	defer topo.CloseServers()
 
 	var schema *planbuilder.Schema
+	var schemaRules *vtgate.QueryRules
+	var schemafier topo.Schemafier
 	if *schemaFile != "" {
 		var err error
 		if schema, err = planbuilder.LoadFile(*schemaFile); err != nil {
 			log.Error(err)
 			exit.Return(1)
 		}
+		if schemaRules, err = vtgate.LoadVSchemaQueryRulesFile(*schemaFile); err != nil {
+			log.Error(err)
+			exit.Return(1)
+		}
 		log.Infof("v3 is enabled: loaded schema from file: %v", *schemaFile)
 	} else {
-		schemafier, ok := ts.(topo.Schemafier)
+		var ok bool
+		schemafier, ok = ts.(topo.Schemafier)
 		if !ok {
 			log.Infof("Skipping v3 initialization: topo does not suppurt schemafier interface")
 			goto startServer
@@ -71,6 +85,9 @@ func main() {
 			log.Warningf("Skipping v3 initialization: GetVSchema failed: %v", err)
 			goto startServer
 		}
+		if schemaRules, err = vtgate.NewVSchemaQueryRules([]byte(schemaJSON)); err != nil {
+			log.Warningf("Ignoring the query rules of the vschema: %v", err)
+		}
 		log.Infof("v3 is enabled: loaded schema from topo")
 	}
 
@@ -83,6 +100,23 @@ startServer:
 	topoReader = NewTopoReader(resilientSrvTopoServer)
 	servenv.Register("toporeader", topoReader)
 
-	vtgate.Init(resilientSrvTopoServer, schema, *cell, *retryDelay, *retryCount, *connTimeoutTotal, *connTimeoutPerConn, *connLife, *maxInFlight)
+	var serv vtgate.SrvTopoServer = resilientSrvTopoServer
+	if *healthCheckCells != "" {
+		healthCheck = vtgate.NewHealthCheck(resilientSrvTopoServer, ts, strings.Split(*healthCheckCells, ","), *healthCheckRetryDelay, *healthCheckTopoRefresh, *connTimeoutPerConn)
+		defer healthCheck.Close()
+		serv = healthCheck
+	}
+
+	vtgate.Init(serv, schema, *cell, *retryDelay, *retryCount, *connTimeoutTotal, *connTimeoutPerConn, *connLife, *maxInFlight)
+	vtgate.SetQueryRules(vtgate.VSchemaQueryRuleSource, schemaRules)
+
+	// If the schema comes from the topo, keep watching it so that
+	// changes are applied without a restart.
+	if schemafier != nil {
+		var err error
+		if vschemaWatcher, err = vtgate.WatchVSchema(context.Background(), schemafier); err != nil {
+			log.Warningf("Not watching vschema: %v", err)
+		}
+	}
 	servenv.RunDefault()
 }
@@ -170,6 +170,13 @@ func (c *callerIDClient) GetSrvKeyspace(ctx context.Context, keyspace string) (*
 	return c.fallback.GetSrvKeyspace(ctx, keyspace)
 }
 
+func (c *callerIDClient) Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error {
+	if ok, err := c.checkCallerID(ctx, req.Sql); ok {
+		return err
+	}
+	return c.fallback.Explain(ctx, req, reply)
+}
+
 func (c *callerIDClient) HandlePanic(err *error) {
 	if x := recover(); x != nil {
 		log.Errorf("Uncaught panic:\n%v\n%s", x, tb.Stack(4))
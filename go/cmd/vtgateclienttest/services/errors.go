@@ -124,6 +124,10 @@ func (c *errorClient) GetSrvKeyspace(ctx context.Context, keyspace string) (*top
 	return c.fallback.GetSrvKeyspace(ctx, keyspace)
 }
 
+func (c *errorClient) Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error {
+	return c.fallback.Explain(ctx, req, reply)
+}
+
 func (c *errorClient) HandlePanic(err *error) {
 	if x := recover(); x != nil {
 		log.Errorf("Uncaught panic:\n%v\n%s", x, tb.Stack(4))
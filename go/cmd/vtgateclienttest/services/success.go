@@ -120,6 +120,10 @@ func (c *successClient) GetSrvKeyspace(ctx context.Context, keyspace string) (*t
 	return c.fallback.GetSrvKeyspace(ctx, keyspace)
 }
 
+func (c *successClient) Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error {
+	return c.fallback.Explain(ctx, req, reply)
+}
+
 func (c *successClient) HandlePanic(err *error) {
 	if x := recover(); x != nil {
 		log.Errorf("Uncaught panic:\n%v\n%s", x, tb.Stack(4))
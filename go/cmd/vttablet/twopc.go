@@ -0,0 +1,43 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+var participantTimeout = flag.Duration("twopc-participant-timeout", 10*time.Second, "timeout for connecting to the participants of an abandoned distributed transaction")
+
+// initParticipantDialer lets the query service reach the masters
+// of other shards, to resolve abandoned distributed transactions.
+func initParticipantDialer(ts topo.Server) {
+	tabletserver.ParticipantDialer = func(ctx context.Context, keyspace, shard string) (tabletserver.ParticipantConn, error) {
+		si, err := topo.GetShard(ctx, ts, keyspace, shard)
+		if err != nil {
+			return nil, fmt.Errorf("initParticipantDialer: %v", err)
+		}
+		if si.MasterAlias == nil || topo.TabletAliasIsZero(si.MasterAlias) {
+			return nil, fmt.Errorf("initParticipantDialer: no master for %s/%s", keyspace, shard)
+		}
+		ti, err := topo.GetTablet(ctx, ts, si.MasterAlias)
+		if err != nil {
+			return nil, fmt.Errorf("initParticipantDialer: %v", err)
+		}
+		endPoint, err := topo.TabletEndPoint(ti.Tablet)
+		if err != nil {
+			return nil, fmt.Errorf("initParticipantDialer: %v", err)
+		}
+		return tabletconn.GetDialer()(ctx, endPoint, keyspace, shard, pb.TabletType_MASTER, *participantTimeout)
+	}
+}
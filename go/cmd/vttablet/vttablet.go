@@ -115,6 +115,8 @@ func main() {
 		exit.Return(1)
 	}
 
+	initParticipantDialer(agent.TopoServer)
+
 	servenv.OnRun(func() {
 		addStatusParts(qsc)
 	})
@@ -0,0 +1,98 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package collation compares strings the way MySQL does it
+// with utf8_general_ci, the default collation of utf8 columns.
+package collation
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// utf8_general_ci compares strings character by character, after
+// replacing every character by its weight. Letters are converted
+// to upper case, and the accented Latin letters are mapped to their
+// base letter. All characters outside of the Basic Multilingual
+// Plane have the same weight. Trailing spaces are ignored.
+
+const (
+	spaceWeight       = ' '
+	replacementWeight = utf8.RuneError
+)
+
+// latinWeights contains the weights of U+00C0 to U+017F,
+// which are not simply the upper case of the characters.
+var latinWeights = []rune("" +
+	"AAAAAAÆCEEEEIIII" + // U+00C0
+	"ÐNOOOOO×ØUUUUYÞS" + // U+00D0
+	"AAAAAAÆCEEEEIIII" + // U+00E0
+	"ÐNOOOOO÷ØUUUUYÞY" + // U+00F0
+	"AAAAAACCCCCCCCDD" + // U+0100
+	"ĐĐEEEEEEEEEEGGGG" + // U+0110
+	"GGGGHHĦĦIIIIIIII" + // U+0120
+	"IIĲĲJJKKĸLLLLLLĿ" + // U+0130
+	"ĿŁŁNNNNNNŉŊŊOOOO" + // U+0140
+	"OOŒŒRRRRRRSSSSSS" + // U+0150
+	"SSTTTTŦŦUUUUUUUU" + // U+0160
+	"UUUUWWYYYZZZZZZS") // U+0170
+
+const (
+	latinStart = 0xC0
+	latinEnd   = 0x180
+)
+
+// weight returns the weight of r.
+func weight(r rune) rune {
+	switch {
+	case r >= latinStart && r < latinEnd:
+		return latinWeights[r-latinStart]
+	case r > 0xFFFF:
+		return replacementWeight
+	}
+	return unicode.ToUpper(r)
+}
+
+// Key returns the sort key of s. Two strings are equal
+// if and only if their keys are equal.
+func Key(s []byte) []byte {
+	key := make([]byte, 0, 2*len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		s = s[size:]
+		w := weight(r)
+		key = append(key, byte(w>>8), byte(w))
+	}
+	// Trailing spaces are not significant.
+	for len(key) >= 2 && key[len(key)-2] == 0 && key[len(key)-1] == spaceWeight {
+		key = key[:len(key)-2]
+	}
+	return key
+}
+
+// Compare compares a and b. The result is 0 if a == b,
+// -1 if a < b, and +1 if a > b. The shorter string is
+// padded with spaces, so trailing spaces are ignored.
+func Compare(a, b []byte) int {
+	for len(a) > 0 || len(b) > 0 {
+		wa, wb := rune(spaceWeight), rune(spaceWeight)
+		if len(a) > 0 {
+			r, size := utf8.DecodeRune(a)
+			a = a[size:]
+			wa = weight(r)
+		}
+		if len(b) > 0 {
+			r, size := utf8.DecodeRune(b)
+			b = b[size:]
+			wb = weight(r)
+		}
+		switch {
+		case wa < wb:
+			return -1
+		case wa > wb:
+			return 1
+		}
+	}
+	return 0
+}
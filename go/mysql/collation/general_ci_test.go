@@ -0,0 +1,54 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package collation
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLatinWeights(t *testing.T) {
+	if got, want := len(latinWeights), latinEnd-latinStart; got != want {
+		t.Errorf("len(latinWeights): %d, want %d", got, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tcases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "ABC", 0},
+		{"abc", "abd", -1},
+		{"Z", "a", 1},
+		{"é", "E", 0},
+		{"Ångström", "angstrom", 0},
+		{"Straße", "strase", 0},
+		{"Straße", "strasse", -1},
+		{"æ", "Æ", 0},
+		{"æ", "a", 1},
+		{"abc  ", "abc", 0},
+		{"abc", "abc\t", 1},
+		{"ab", "abc", -1},
+		{" abc", "abc", -1},
+		{"日本", "日本", 0},
+		{"日本", "日付", 1},
+		{"\U0001F600", "\U0001F601", 0},
+	}
+	for _, tcase := range tcases {
+		if got := Compare([]byte(tcase.a), []byte(tcase.b)); got != tcase.want {
+			t.Errorf("Compare(%q, %q): %d, want %d", tcase.a, tcase.b, got, tcase.want)
+		}
+		if got := Compare([]byte(tcase.b), []byte(tcase.a)); got != -tcase.want {
+			t.Errorf("Compare(%q, %q): %d, want %d", tcase.b, tcase.a, got, -tcase.want)
+		}
+		keysEqual := bytes.Equal(Key([]byte(tcase.a)), Key([]byte(tcase.b)))
+		if keysEqual != (tcase.want == 0) {
+			t.Errorf("Key(%q) == Key(%q): %v, want %v", tcase.a, tcase.b, keysEqual, tcase.want == 0)
+		}
+	}
+}
@@ -136,6 +136,11 @@ func (f *fakeVTGateService) GetSrvKeyspace(ctx context.Context, keyspace string)
 	return &topo.SrvKeyspace{}, nil
 }
 
+// Explain is part of the VTGateService interface
+func (f *fakeVTGateService) Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error {
+	return nil
+}
+
 // HandlePanic is part of the VTGateService interface
 func (f *fakeVTGateService) HandlePanic(err *error) {
 	if x := recover(); x != nil {
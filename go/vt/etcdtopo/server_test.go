@@ -111,3 +111,14 @@ func TestVSchema(t *testing.T) {
 	defer ts.Close()
 	test.CheckVSchema(ctx, t, ts)
 }
+
+func TestWatchVSchema(t *testing.T) {
+	ctx := context.Background()
+	if testing.Short() {
+		t.Skip("skipping wait-based test in short mode.")
+	}
+
+	ts := newTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckWatchVSchema(ctx, t, ts)
+}
@@ -1,6 +1,10 @@
 package etcdtopo
 
 import (
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"golang.org/x/net/context"
@@ -43,3 +47,72 @@ func (s *Server) GetVSchema(ctx context.Context) (string, error) {
 	}
 	return resp.Node.Value, nil
 }
+
+// WatchVSchema is part of the topo.Schemafier interface.
+func (s *Server) WatchVSchema(ctx context.Context) (<-chan *topo.VSchemaInfo, chan<- struct{}, error) {
+	notifications := make(chan *topo.VSchemaInfo, 10)
+	stopWatching := make(chan struct{})
+
+	// The watch go routine will stop if the 'stop' channel is closed.
+	// Otherwise it will try to watch everything in a loop, and send events
+	// to the 'watch' channel.
+	watch := make(chan *etcd.Response)
+	stop := make(chan bool)
+	go func() {
+		// get the current version of the file
+		info := &topo.VSchemaInfo{VSchema: "{}", Version: topo.NoVSchemaVersion}
+		var modifiedVersion uint64
+		resp, err := s.getGlobal().Get(vschemaPath, false /* sort */, false /* recursive */)
+		if err == nil && resp.Node != nil {
+			info.VSchema = resp.Node.Value
+			info.Version = int64(resp.Node.ModifiedIndex)
+			modifiedVersion = resp.Node.ModifiedIndex
+		}
+
+		// re-check for stop here to be safe, in case the
+		// Get took a long time
+		select {
+		case <-stop:
+			return
+		case notifications <- info:
+		}
+
+		for {
+			if _, err := s.getGlobal().Watch(vschemaPath, modifiedVersion+1, false /* recursive */, watch, stop); err != nil {
+				log.Errorf("Watch on %v failed, waiting for %v to retry: %v", vschemaPath, WatchSleepDuration, err)
+				timer := time.After(WatchSleepDuration)
+				select {
+				case <-stop:
+					return
+				case <-timer:
+				}
+			}
+		}
+	}()
+
+	// This go routine is the main event handling routine:
+	// - it will stop if stopWatching is closed.
+	// - if it receives a notification from the watch, it will forward it
+	// to the notifications channel.
+	go func() {
+		for {
+			select {
+			case resp := <-watch:
+				info := &topo.VSchemaInfo{VSchema: "{}", Version: topo.NoVSchemaVersion}
+				if resp.Node != nil {
+					if resp.Node.Value != "" {
+						info.VSchema = resp.Node.Value
+					}
+					info.Version = int64(resp.Node.ModifiedIndex)
+				}
+				notifications <- info
+			case <-stopWatching:
+				close(stop)
+				close(notifications)
+				return
+			}
+		}
+	}()
+
+	return notifications, stopWatching, nil
+}
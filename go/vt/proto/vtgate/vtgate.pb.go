@@ -44,6 +44,9 @@ It has these top-level messages:
 	SplitQueryResponse
 	GetSrvKeyspaceRequest
 	GetSrvKeyspaceResponse
+	ExplainRequest
+	PlanDescription
+	ExplainResponse
 */
 package vtgate
 
@@ -90,6 +93,15 @@ func (x ExecuteEntityIdsRequest_EntityId_Type) String() string {
 type Session struct {
 	InTransaction bool                    `protobuf:"varint,1,opt,name=in_transaction" json:"in_transaction,omitempty"`
 	ShardSessions []*Session_ShardSession `protobuf:"bytes,2,rep,name=shard_sessions" json:"shard_sessions,omitempty"`
+	// single_shard rejects any statement that would add a second
+	// shard to the transaction.
+	SingleShard bool `protobuf:"varint,3,opt,name=single_shard" json:"single_shard,omitempty"`
+	// read_your_writes makes the replica reads of the session
+	// wait until the replicas have applied shard_positions.
+	ReadYourWrites bool `protobuf:"varint,4,opt,name=read_your_writes" json:"read_your_writes,omitempty"`
+	// shard_positions are the replication positions of the last
+	// commits of the session, by shard.
+	ShardPositions []*Session_ShardPosition `protobuf:"bytes,5,rep,name=shard_positions" json:"shard_positions,omitempty"`
 }
 
 func (m *Session) Reset()         { *m = Session{} }
@@ -103,6 +115,13 @@ func (m *Session) GetShardSessions() []*Session_ShardSession {
 	return nil
 }
 
+func (m *Session) GetShardPositions() []*Session_ShardPosition {
+	if m != nil {
+		return m.ShardPositions
+	}
+	return nil
+}
+
 type Session_ShardSession struct {
 	Target        *query.Target `protobuf:"bytes,1,opt,name=target" json:"target,omitempty"`
 	TransactionId int64         `protobuf:"varint,2,opt,name=transaction_id" json:"transaction_id,omitempty"`
@@ -119,6 +138,16 @@ func (m *Session_ShardSession) GetTarget() *query.Target {
 	return nil
 }
 
+type Session_ShardPosition struct {
+	Keyspace string `protobuf:"bytes,1,opt,name=keyspace" json:"keyspace,omitempty"`
+	Shard    string `protobuf:"bytes,2,opt,name=shard" json:"shard,omitempty"`
+	Position string `protobuf:"bytes,3,opt,name=position" json:"position,omitempty"`
+}
+
+func (m *Session_ShardPosition) Reset()         { *m = Session_ShardPosition{} }
+func (m *Session_ShardPosition) String() string { return proto.CompactTextString(m) }
+func (*Session_ShardPosition) ProtoMessage()    {}
+
 // ExecuteRequest is the payload to Execute
 type ExecuteRequest struct {
 	CallerId         *vtrpc.CallerID     `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
@@ -869,6 +898,8 @@ func (m *StreamExecuteKeyRangesResponse) GetResult() *query.QueryResult {
 // BeginRequest is the payload to Begin
 type BeginRequest struct {
 	CallerId *vtrpc.CallerID `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
+	// single_shard limits the transaction to a single shard.
+	SingleShard bool `protobuf:"varint,2,opt,name=single_shard" json:"single_shard,omitempty"`
 }
 
 func (m *BeginRequest) Reset()         { *m = BeginRequest{} }
@@ -933,6 +964,9 @@ func (m *CommitRequest) GetSession() *Session {
 // CommitResponse is the returned value from Commit
 type CommitResponse struct {
 	Error *vtrpc.RPCError `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
+	// session carries the replication positions of the commit,
+	// if the session asked for read-your-writes.
+	Session *Session `protobuf:"bytes,2,opt,name=session" json:"session,omitempty"`
 }
 
 func (m *CommitResponse) Reset()         { *m = CommitResponse{} }
@@ -946,6 +980,13 @@ func (m *CommitResponse) GetError() *vtrpc.RPCError {
 	return nil
 }
 
+func (m *CommitResponse) GetSession() *Session {
+	if m != nil {
+		return m.Session
+	}
+	return nil
+}
+
 // RollbackRequest is the payload to Rollback
 type RollbackRequest struct {
 	CallerId *vtrpc.CallerID `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
@@ -1111,6 +1152,90 @@ func (m *GetSrvKeyspaceResponse) GetSrvKeyspace() *topodata.SrvKeyspace {
 	return nil
 }
 
+// ExplainRequest is the payload to Explain
+type ExplainRequest struct {
+	CallerId   *vtrpc.CallerID     `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
+	Query      *query.BoundQuery   `protobuf:"bytes,2,opt,name=query" json:"query,omitempty"`
+	TabletType topodata.TabletType `protobuf:"varint,3,opt,name=tablet_type,enum=topodata.TabletType" json:"tablet_type,omitempty"`
+}
+
+func (m *ExplainRequest) Reset()         { *m = ExplainRequest{} }
+func (m *ExplainRequest) String() string { return proto.CompactTextString(m) }
+func (*ExplainRequest) ProtoMessage()    {}
+
+func (m *ExplainRequest) GetCallerId() *vtrpc.CallerID {
+	if m != nil {
+		return m.CallerId
+	}
+	return nil
+}
+
+func (m *ExplainRequest) GetQuery() *query.BoundQuery {
+	if m != nil {
+		return m.Query
+	}
+	return nil
+}
+
+// PlanDescription describes how vtgate routes a V3 query
+type PlanDescription struct {
+	PlanId      string           `protobuf:"bytes,1,opt,name=plan_id" json:"plan_id,omitempty"`
+	Reason      string           `protobuf:"bytes,2,opt,name=reason" json:"reason,omitempty"`
+	Table       string           `protobuf:"bytes,3,opt,name=table" json:"table,omitempty"`
+	Keyspace    string           `protobuf:"bytes,4,opt,name=keyspace" json:"keyspace,omitempty"`
+	Vindex      string           `protobuf:"bytes,5,opt,name=vindex" json:"vindex,omitempty"`
+	Original    string           `protobuf:"bytes,6,opt,name=original" json:"original,omitempty"`
+	Rewritten   string           `protobuf:"bytes,7,opt,name=rewritten" json:"rewritten,omitempty"`
+	Subquery    string           `protobuf:"bytes,8,opt,name=subquery" json:"subquery,omitempty"`
+	Route       string           `protobuf:"bytes,9,opt,name=route" json:"route,omitempty"`
+	Shards      []string         `protobuf:"bytes,10,rep,name=shards" json:"shards,omitempty"`
+	ShardsError string           `protobuf:"bytes,11,opt,name=shards_error" json:"shards_error,omitempty"`
+	Left        *PlanDescription `protobuf:"bytes,12,opt,name=left" json:"left,omitempty"`
+	Right       *PlanDescription `protobuf:"bytes,13,opt,name=right" json:"right,omitempty"`
+}
+
+func (m *PlanDescription) Reset()         { *m = PlanDescription{} }
+func (m *PlanDescription) String() string { return proto.CompactTextString(m) }
+func (*PlanDescription) ProtoMessage()    {}
+
+func (m *PlanDescription) GetLeft() *PlanDescription {
+	if m != nil {
+		return m.Left
+	}
+	return nil
+}
+
+func (m *PlanDescription) GetRight() *PlanDescription {
+	if m != nil {
+		return m.Right
+	}
+	return nil
+}
+
+// ExplainResponse is the returned value from Explain
+type ExplainResponse struct {
+	Error *vtrpc.RPCError  `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
+	Plan  *PlanDescription `protobuf:"bytes,2,opt,name=plan" json:"plan,omitempty"`
+}
+
+func (m *ExplainResponse) Reset()         { *m = ExplainResponse{} }
+func (m *ExplainResponse) String() string { return proto.CompactTextString(m) }
+func (*ExplainResponse) ProtoMessage()    {}
+
+func (m *ExplainResponse) GetError() *vtrpc.RPCError {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+func (m *ExplainResponse) GetPlan() *PlanDescription {
+	if m != nil {
+		return m.Plan
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("vtgate.ExecuteEntityIdsRequest_EntityId_Type", ExecuteEntityIdsRequest_EntityId_Type_name, ExecuteEntityIdsRequest_EntityId_Type_value)
 }
@@ -73,6 +73,10 @@ type VitessClient interface {
 	// It is convenient for monitoring applications for instance, or if
 	// using custom sharding.
 	GetSrvKeyspace(ctx context.Context, in *vtgate.GetSrvKeyspaceRequest, opts ...grpc.CallOption) (*vtgate.GetSrvKeyspaceResponse, error)
+	// Explain returns the plan of a V3 query, and the shards it would
+	// be sent to, without executing it.
+	// (this is a vtgate v3 API, use carefully)
+	Explain(ctx context.Context, in *vtgate.ExplainRequest, opts ...grpc.CallOption) (*vtgate.ExplainResponse, error)
 }
 
 type vitessClient struct {
@@ -319,6 +323,15 @@ func (c *vitessClient) GetSrvKeyspace(ctx context.Context, in *vtgate.GetSrvKeys
 	return out, nil
 }
 
+func (c *vitessClient) Explain(ctx context.Context, in *vtgate.ExplainRequest, opts ...grpc.CallOption) (*vtgate.ExplainResponse, error) {
+	out := new(vtgate.ExplainResponse)
+	err := grpc.Invoke(ctx, "/vtgateservice.Vitess/Explain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Vitess service
 
 type VitessServer interface {
@@ -365,6 +378,10 @@ type VitessServer interface {
 	// It is convenient for monitoring applications for instance, or if
 	// using custom sharding.
 	GetSrvKeyspace(context.Context, *vtgate.GetSrvKeyspaceRequest) (*vtgate.GetSrvKeyspaceResponse, error)
+	// Explain returns the plan of a V3 query, and the shards it would
+	// be sent to, without executing it.
+	// (this is a vtgate v3 API, use carefully)
+	Explain(context.Context, *vtgate.ExplainRequest) (*vtgate.ExplainResponse, error)
 }
 
 func RegisterVitessServer(s *grpc.Server, srv VitessServer) {
@@ -599,6 +616,18 @@ func _Vitess_GetSrvKeyspace_Handler(srv interface{}, ctx context.Context, codec
 	return out, nil
 }
 
+func _Vitess_Explain_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(vtgate.ExplainRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(VitessServer).Explain(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _Vitess_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "vtgateservice.Vitess",
 	HandlerType: (*VitessServer)(nil),
@@ -651,6 +680,10 @@ var _Vitess_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetSrvKeyspace",
 			Handler:    _Vitess_GetSrvKeyspace_Handler,
 		},
+		{
+			MethodName: "Explain",
+			Handler:    _Vitess_Explain_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -137,6 +137,127 @@ func (sq *SqlQuery) Rollback2(ctx context.Context, rollbackRequest *proto.Rollba
 	return tErr
 }
 
+// Prepare is exposing tabletserver.SqlQuery.Prepare
+func (sq *SqlQuery) Prepare(ctx context.Context, req *proto.PrepareRequest, resp *proto.PrepareResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.Prepare(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.TransactionId, req.Dtid)
+	tabletserver.AddTabletErrorToPrepareResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// CommitPrepared is exposing tabletserver.SqlQuery.CommitPrepared
+func (sq *SqlQuery) CommitPrepared(ctx context.Context, req *proto.CommitPreparedRequest, resp *proto.CommitPreparedResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.CommitPrepared(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.Dtid)
+	tabletserver.AddTabletErrorToCommitPreparedResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// RollbackPrepared is exposing tabletserver.SqlQuery.RollbackPrepared
+func (sq *SqlQuery) RollbackPrepared(ctx context.Context, req *proto.RollbackPreparedRequest, resp *proto.RollbackPreparedResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.RollbackPrepared(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.Dtid, req.TransactionId)
+	tabletserver.AddTabletErrorToRollbackPreparedResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// CreateTransaction is exposing tabletserver.SqlQuery.CreateTransaction
+func (sq *SqlQuery) CreateTransaction(ctx context.Context, req *proto.CreateTransactionRequest, resp *proto.CreateTransactionResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.CreateTransaction(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.Dtid, proto.TargetsToProto3(req.Participants))
+	tabletserver.AddTabletErrorToCreateTransactionResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// StartCommit is exposing tabletserver.SqlQuery.StartCommit
+func (sq *SqlQuery) StartCommit(ctx context.Context, req *proto.StartCommitRequest, resp *proto.StartCommitResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.StartCommit(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.TransactionId, req.Dtid)
+	tabletserver.AddTabletErrorToStartCommitResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// ConcludeTransaction is exposing tabletserver.SqlQuery.ConcludeTransaction
+func (sq *SqlQuery) ConcludeTransaction(ctx context.Context, req *proto.ConcludeTransactionRequest, resp *proto.ConcludeTransactionResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.ConcludeTransaction(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.Dtid)
+	tabletserver.AddTabletErrorToConcludeTransactionResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// CommitWithPosition is exposing tabletserver.SqlQuery.CommitWithPosition
+func (sq *SqlQuery) CommitWithPosition(ctx context.Context, req *proto.CommitWithPositionRequest, resp *proto.CommitWithPositionResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	position, tErr := sq.server.CommitWithPosition(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.TransactionId)
+	resp.Position = position
+	tabletserver.AddTabletErrorToCommitWithPositionResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// WaitForPosition is exposing tabletserver.SqlQuery.WaitForPosition
+func (sq *SqlQuery) WaitForPosition(ctx context.Context, req *proto.WaitForPositionRequest, resp *proto.WaitForPositionResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(req.EffectiveCallerID),
+		callerid.GoRPCImmediateCallerID(req.ImmediateCallerID),
+	)
+	tErr := sq.server.WaitForPosition(callinfo.RPCWrapCallInfo(ctx), proto.TargetToProto3(req.Target), req.Position, req.Timeout)
+	tabletserver.AddTabletErrorToWaitForPositionResponse(tErr, resp)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
 // Execute is exposing tabletserver.SqlQuery.Execute
 func (sq *SqlQuery) Execute(ctx context.Context, query *proto.Query, reply *mproto.QueryResult) (err error) {
 	defer sq.server.HandlePanic(&err)
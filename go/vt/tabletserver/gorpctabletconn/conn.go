@@ -520,6 +520,246 @@ func (conn *TabletBson) Rollback2(ctx context.Context, transactionID int64) erro
 	return tabletError(err)
 }
 
+// Prepare prepares the transaction for a two-phase commit.
+func (conn *TabletBson) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("Prepare requires a target")
+	}
+
+	req := &tproto.PrepareRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		TransactionId:     transactionID,
+		Dtid:              dtid,
+	}
+	resp := new(tproto.PrepareResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.Prepare", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// CommitPrepared commits a prepared transaction.
+func (conn *TabletBson) CommitPrepared(ctx context.Context, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("CommitPrepared requires a target")
+	}
+
+	req := &tproto.CommitPreparedRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		Dtid:              dtid,
+	}
+	resp := new(tproto.CommitPreparedResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.CommitPrepared", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// RollbackPrepared rolls back a prepared transaction.
+func (conn *TabletBson) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("RollbackPrepared requires a target")
+	}
+
+	req := &tproto.RollbackPreparedRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		TransactionId:     originalID,
+		Dtid:              dtid,
+	}
+	resp := new(tproto.RollbackPreparedResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.RollbackPrepared", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// CreateTransaction records a distributed transaction in the metadata shard.
+func (conn *TabletBson) CreateTransaction(ctx context.Context, dtid string, participants []*pb.Target) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("CreateTransaction requires a target")
+	}
+
+	req := &tproto.CreateTransactionRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		Dtid:              dtid,
+		Participants:      tproto.Proto3ToTargets(participants),
+	}
+	resp := new(tproto.CreateTransactionResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.CreateTransaction", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// StartCommit records the commit decision and commits the transaction.
+func (conn *TabletBson) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("StartCommit requires a target")
+	}
+
+	req := &tproto.StartCommitRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		TransactionId:     transactionID,
+		Dtid:              dtid,
+	}
+	resp := new(tproto.StartCommitResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.StartCommit", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// ConcludeTransaction deletes the metadata of a distributed transaction.
+func (conn *TabletBson) ConcludeTransaction(ctx context.Context, dtid string) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("ConcludeTransaction requires a target")
+	}
+
+	req := &tproto.ConcludeTransactionRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		Dtid:              dtid,
+	}
+	resp := new(tproto.ConcludeTransactionResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.ConcludeTransaction", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// CommitWithPosition commits a transaction, and returns the
+// replication position of the master after the commit.
+func (conn *TabletBson) CommitWithPosition(ctx context.Context, transactionID int64) (string, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return "", tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return "", fmt.Errorf("CommitWithPosition requires a target")
+	}
+
+	req := &tproto.CommitWithPositionRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		TransactionId:     transactionID,
+	}
+	resp := new(tproto.CommitWithPositionResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.CommitWithPosition", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	if err := conn.withTimeout(ctx, action); err != nil {
+		return "", tabletError(err)
+	}
+	return resp.Position, nil
+}
+
+// WaitForPosition waits until the tablet has applied a replication position.
+func (conn *TabletBson) WaitForPosition(ctx context.Context, position string, timeout time.Duration) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+	if conn.target == nil {
+		return fmt.Errorf("WaitForPosition requires a target")
+	}
+
+	req := &tproto.WaitForPositionRequest{
+		Target:            conn.target,
+		EffectiveCallerID: getEffectiveCallerID(ctx),
+		ImmediateCallerID: getImmediateCallerID(ctx),
+		Position:          position,
+		Timeout:           timeout,
+	}
+	resp := new(tproto.WaitForPositionResponse)
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, "SqlQuery.WaitForPosition", req, resp)
+		if err != nil {
+			return err
+		}
+		return vterrors.FromRPCError(resp.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
 // SplitQuery is the stub for SqlQuery.SplitQuery RPC
 func (conn *TabletBson) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitColumn string, splitCount int) (queries []tproto.QuerySplit, err error) {
 	conn.mu.RLock()
@@ -45,13 +45,16 @@ func testGoRPCTabletConn(t *testing.T, rpcOnlyInReply bool) {
 	// Handle errors appropriately
 	*tabletserver.RPCErrorOnlyInReply = rpcOnlyInReply
 
-	// run the test suite
-	tabletconntest.TestSuite(t, protocolName, &pb.EndPoint{
+	// run the test suites
+	endPoint := &pb.EndPoint{
 		Host: "localhost",
 		PortMap: map[string]int32{
 			"vt": int32(port),
 		},
-	}, service)
+	}
+	tabletconntest.TestSuite(t, protocolName, endPoint, service)
+	tabletconntest.TestTwoPCSuite(t, protocolName, endPoint, service)
+	tabletconntest.TestReadYourWritesSuite(t, protocolName, endPoint, service)
 }
 
 func TestGoRPCTabletConn(t *testing.T) {
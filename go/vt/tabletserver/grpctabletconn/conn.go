@@ -266,6 +266,55 @@ func (conn *gRPCQueryClient) Rollback2(ctx context.Context, transactionID int64)
 	return conn.Rollback(ctx, transactionID)
 }
 
+// errTwoPCNotSupported is returned by the two-phase commit calls,
+// which are not part of the gRPC query service yet.
+var errTwoPCNotSupported = fmt.Errorf("two-phase commit is not supported over gRPC")
+
+// Prepare is not supported over gRPC yet.
+func (conn *gRPCQueryClient) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// CommitPrepared is not supported over gRPC yet.
+func (conn *gRPCQueryClient) CommitPrepared(ctx context.Context, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// RollbackPrepared is not supported over gRPC yet.
+func (conn *gRPCQueryClient) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	return errTwoPCNotSupported
+}
+
+// CreateTransaction is not supported over gRPC yet.
+func (conn *gRPCQueryClient) CreateTransaction(ctx context.Context, dtid string, participants []*pb.Target) error {
+	return errTwoPCNotSupported
+}
+
+// StartCommit is not supported over gRPC yet.
+func (conn *gRPCQueryClient) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// ConcludeTransaction is not supported over gRPC yet.
+func (conn *gRPCQueryClient) ConcludeTransaction(ctx context.Context, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// errReadYourWritesNotSupported is returned by WaitForPosition,
+// which is not part of the gRPC query service yet.
+var errReadYourWritesNotSupported = fmt.Errorf("read-your-writes is not supported over gRPC")
+
+// CommitWithPosition commits the transaction. The gRPC query service
+// doesn't return positions yet, so the position is always empty.
+func (conn *gRPCQueryClient) CommitWithPosition(ctx context.Context, transactionID int64) (string, error) {
+	return "", conn.Commit(ctx, transactionID)
+}
+
+// WaitForPosition is not supported over gRPC yet.
+func (conn *gRPCQueryClient) WaitForPosition(ctx context.Context, position string, timeout time.Duration) error {
+	return errReadYourWritesNotSupported
+}
+
 // SplitQuery is the stub for SqlQuery.SplitQuery RPC
 func (conn *gRPCQueryClient) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitColumn string, splitCount int) (queries []tproto.QuerySplit, err error) {
 	conn.mu.RLock()
@@ -23,6 +23,42 @@ func TargetToProto3(target *Target) *pb.Target {
 	}
 }
 
+// Proto3ToTarget transforms a proto3 target to the bson RPC target
+func Proto3ToTarget(target *pb.Target) *Target {
+	if target == nil {
+		return nil
+	}
+	return &Target{
+		Keyspace:   target.Keyspace,
+		Shard:      target.Shard,
+		TabletType: TabletType(target.TabletType),
+	}
+}
+
+// TargetsToProto3 transforms a list of bson RPC targets to proto3
+func TargetsToProto3(targets []*Target) []*pb.Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]*pb.Target, len(targets))
+	for i, target := range targets {
+		result[i] = TargetToProto3(target)
+	}
+	return result
+}
+
+// Proto3ToTargets transforms a list of proto3 targets to bson RPC targets
+func Proto3ToTargets(targets []*pb.Target) []*Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]*Target, len(targets))
+	for i, target := range targets {
+		result[i] = Proto3ToTarget(target)
+	}
+	return result
+}
+
 // BoundQueryToProto3 converts internal types to proto3 BoundQuery
 func BoundQueryToProto3(sql string, bindVars map[string]interface{}) *pb.BoundQuery {
 	result := &pb.BoundQuery{
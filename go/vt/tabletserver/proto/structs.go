@@ -6,6 +6,7 @@ package proto
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/youtube/vitess/go/bytes2"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
@@ -262,3 +263,120 @@ type StreamExecuteResponse struct {
 	// consistent with other BSON structs.
 	Err *mproto.RPCError
 }
+
+// The following structs are used by the two-phase commit RPCs.
+// A distributed transaction is identified by its dtid.
+
+// PrepareRequest is the BSON request for the Prepare RPC
+type PrepareRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	TransactionId     int64
+	Dtid              string
+}
+
+// PrepareResponse is the BSON response for the Prepare RPC
+type PrepareResponse struct {
+	Err *mproto.RPCError
+}
+
+// CommitPreparedRequest is the BSON request for the CommitPrepared RPC
+type CommitPreparedRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	Dtid              string
+}
+
+// CommitPreparedResponse is the BSON response for the CommitPrepared RPC
+type CommitPreparedResponse struct {
+	Err *mproto.RPCError
+}
+
+// RollbackPreparedRequest is the BSON request for the RollbackPrepared RPC
+type RollbackPreparedRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	TransactionId     int64
+	Dtid              string
+}
+
+// RollbackPreparedResponse is the BSON response for the RollbackPrepared RPC
+type RollbackPreparedResponse struct {
+	Err *mproto.RPCError
+}
+
+// CreateTransactionRequest is the BSON request for the CreateTransaction RPC
+type CreateTransactionRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	Dtid              string
+	Participants      []*Target
+}
+
+// CreateTransactionResponse is the BSON response for the CreateTransaction RPC
+type CreateTransactionResponse struct {
+	Err *mproto.RPCError
+}
+
+// StartCommitRequest is the BSON request for the StartCommit RPC
+type StartCommitRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	TransactionId     int64
+	Dtid              string
+}
+
+// StartCommitResponse is the BSON response for the StartCommit RPC
+type StartCommitResponse struct {
+	Err *mproto.RPCError
+}
+
+// ConcludeTransactionRequest is the BSON request for the ConcludeTransaction RPC
+type ConcludeTransactionRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	Dtid              string
+}
+
+// ConcludeTransactionResponse is the BSON response for the ConcludeTransaction RPC
+type ConcludeTransactionResponse struct {
+	Err *mproto.RPCError
+}
+
+// The following structs are used by the read-your-writes RPCs.
+// Replication positions are encoded with
+// mysqlctl/proto.EncodeReplicationPosition.
+
+// CommitWithPositionRequest is the BSON request for the CommitWithPosition RPC
+type CommitWithPositionRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	TransactionId     int64
+}
+
+// CommitWithPositionResponse is the BSON response for the CommitWithPosition RPC
+type CommitWithPositionResponse struct {
+	Position string
+	Err      *mproto.RPCError
+}
+
+// WaitForPositionRequest is the BSON request for the WaitForPosition RPC
+type WaitForPositionRequest struct {
+	EffectiveCallerID *CallerID
+	ImmediateCallerID *VTGateCallerID
+	Target            *Target
+	Position          string
+	Timeout           time.Duration
+}
+
+// WaitForPositionResponse is the BSON response for the WaitForPosition RPC
+type WaitForPositionResponse struct {
+	Err *mproto.RPCError
+}
@@ -12,6 +12,7 @@ import (
 	log "github.com/golang/glog"
 	"golang.org/x/net/context"
 
+	"github.com/youtube/vitess/go/sqldb"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/dbconfigs"
@@ -50,6 +51,7 @@ type QueryEngine struct {
 
 	// Services
 	txPool       *TxPool
+	twoPC        *TwoPC
 	consolidator *sync2.Consolidator
 	invalidator  *RowcacheInvalidator
 	streamQList  *QueryList
@@ -161,6 +163,7 @@ func NewQueryEngine(config Config) *QueryEngine {
 		config.EnablePublishStats,
 		qe.queryServiceStats,
 	)
+	qe.twoPC = NewTwoPC(qe, time.Duration(config.TwoPCAbandonAge*1e9))
 	qe.consolidator = sync2.NewConsolidator()
 	http.Handle(config.DebugURLPrefix+"/consolidations", qe.consolidator)
 	qe.invalidator = NewRowcacheInvalidator(config.StatsPrefix, qe, config.EnablePublishStats)
@@ -211,13 +214,7 @@ func NewQueryEngine(config Config) *QueryEngine {
 func (qe *QueryEngine) Open(dbconfigs *dbconfigs.DBConfigs, schemaOverrides []SchemaOverride, mysqld mysqlctl.MysqlDaemon) {
 	qe.dbconfigs = dbconfigs
 	appParams := dbconfigs.App.ConnParams
-	// Create dba params based on App connection params
-	// and Dba credentials.
-	dbaParams := dbconfigs.App.ConnParams
-	if dbconfigs.Dba.Uname != "" {
-		dbaParams.Uname = dbconfigs.Dba.Uname
-		dbaParams.Pass = dbconfigs.Dba.Pass
-	}
+	dbaParams := dbaConnParams(dbconfigs)
 
 	strictMode := false
 	if qe.strictMode.Get() != 0 {
@@ -253,6 +250,24 @@ func (qe *QueryEngine) Open(dbconfigs *dbconfigs.DBConfigs, schemaOverrides []Sc
 	qe.txPool.Open(&appParams, &dbaParams)
 }
 
+// OpenTwoPC opens the two-phase commit services. It must be
+// called after Open, and only on masters.
+func (qe *QueryEngine) OpenTwoPC() {
+	dbaParams := dbaConnParams(qe.dbconfigs)
+	qe.twoPC.Open(&dbaParams)
+}
+
+// dbaConnParams creates dba params based on App connection params
+// and Dba credentials.
+func dbaConnParams(dbconfigs *dbconfigs.DBConfigs) sqldb.ConnParams {
+	dbaParams := dbconfigs.App.ConnParams
+	if dbconfigs.Dba.Uname != "" {
+		dbaParams.Uname = dbconfigs.Dba.Uname
+		dbaParams.Pass = dbconfigs.Dba.Pass
+	}
+	return dbaParams
+}
+
 // Launch launches the specified function inside a goroutine.
 // If Close or WaitForTxEmpty is called while a goroutine is running,
 // QueryEngine will not return until the existing functions have completed.
@@ -290,6 +305,8 @@ func (qe *QueryEngine) CheckMySQL() bool {
 // Before calling WaitForTxEmpty, you must ensure that there
 // will be no more calls to Begin.
 func (qe *QueryEngine) WaitForTxEmpty() {
+	// The resolver begins new transactions.
+	qe.twoPC.StopResolver()
 	qe.txPool.WaitForEmpty()
 }
 
@@ -299,6 +316,7 @@ func (qe *QueryEngine) WaitForTxEmpty() {
 func (qe *QueryEngine) Close() {
 	qe.tasks.Wait()
 	// Close in reverse order of Open.
+	qe.twoPC.Close()
 	qe.txPool.Close()
 	qe.streamConnPool.Close()
 	qe.connPool.Close()
@@ -72,20 +72,23 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult, err error) {
 		if qre.plan.TableInfo != nil && qre.plan.TableInfo.CacheType != schema.CACHE_NONE {
 			invalidator = conn.DirtyKeys(qre.plan.TableName)
 		}
+		// DMLs go through redo, which records them for
+		// the redo log in case the transaction gets prepared.
+		redo := redoConn{conn}
 		switch qre.plan.PlanId {
 		case planbuilder.PLAN_PASS_DML:
 			if qre.qe.strictMode.Get() != 0 {
 				return nil, NewTabletError(ErrFail, "DML too complex")
 			}
-			reply, err = qre.directFetch(conn, qre.plan.FullQuery, qre.bindVars, nil)
+			reply, err = qre.directFetch(redo, qre.plan.FullQuery, qre.bindVars, nil)
 		case planbuilder.PLAN_INSERT_PK:
-			reply, err = qre.execInsertPK(conn)
+			reply, err = qre.execInsertPK(redo)
 		case planbuilder.PLAN_INSERT_SUBQUERY:
-			reply, err = qre.execInsertSubquery(conn)
+			reply, err = qre.execInsertSubquery(redo)
 		case planbuilder.PLAN_DML_PK:
-			reply, err = qre.execDMLPK(conn, invalidator)
+			reply, err = qre.execDMLPK(redo, invalidator)
 		case planbuilder.PLAN_DML_SUBQUERY:
-			reply, err = qre.execDMLSubquery(conn, invalidator)
+			reply, err = qre.execDMLSubquery(redo, invalidator)
 		case planbuilder.PLAN_OTHER:
 			reply, err = qre.execSQL(conn, qre.query, true)
 		default: // select or set in a transaction, just count as select
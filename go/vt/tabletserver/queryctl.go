@@ -65,6 +65,8 @@ func init() {
 	flag.StringVar(&qsConfig.DebugURLPrefix, "debug-url-prefix", DefaultQsConfig.DebugURLPrefix, "debug url prefix, vttablet will report various system debug pages and this config controls the prefix of these debug urls")
 	flag.StringVar(&qsConfig.PoolNamePrefix, "pool-name-prefix", DefaultQsConfig.PoolNamePrefix, "pool name prefix, vttablet has several pools and each of them has a name. This config specifies the prefix of these pool names")
 	flag.BoolVar(&qsConfig.EnableAutoCommit, "enable-autocommit", DefaultQsConfig.EnableAutoCommit, "if the flag is on, a DML outsides a transaction will be auto committed.")
+	flag.BoolVar(&qsConfig.TwoPCEnable, "twopc-enable", DefaultQsConfig.TwoPCEnable, "if the flag is on, the master supports two-phase commit: it keeps a redo log of the prepared transactions, and resolves the abandoned distributed transactions it coordinates.")
+	flag.Float64Var(&qsConfig.TwoPCAbandonAge, "twopc-abandon-age", DefaultQsConfig.TwoPCAbandonAge, "time in seconds after which a distributed transaction that was not concluded by its vtgate is resolved by the master of its metadata shard. 0 disables the resolver.")
 }
 
 // RowCacheConfig encapsulates the configuration for RowCache
@@ -124,6 +126,8 @@ type Config struct {
 	EnablePublishStats   bool
 	EnableAutoCommit     bool
 	EnableTableAclDryRun bool
+	TwoPCEnable          bool
+	TwoPCAbandonAge      float64
 	StatsPrefix          string
 	DebugURLPrefix       string
 	PoolNamePrefix       string
@@ -159,6 +163,8 @@ var DefaultQsConfig = Config{
 	EnablePublishStats:   true,
 	EnableAutoCommit:     false,
 	EnableTableAclDryRun: false,
+	TwoPCEnable:          false,
+	TwoPCAbandonAge:      30,
 	StatsPrefix:          "",
 	DebugURLPrefix:       "/debug",
 	PoolNamePrefix:       "",
@@ -8,6 +8,7 @@ package queryservice
 
 import (
 	"fmt"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/proto"
@@ -26,6 +27,24 @@ type QueryService interface {
 	Commit(ctx context.Context, target *pb.Target, session *proto.Session) error
 	Rollback(ctx context.Context, target *pb.Target, session *proto.Session) error
 
+	// Two-phase commit support. Prepare, CommitPrepared and
+	// RollbackPrepared are sent to the participants of a
+	// distributed transaction. CreateTransaction, StartCommit
+	// and ConcludeTransaction are sent to its metadata shard.
+	Prepare(ctx context.Context, target *pb.Target, transactionID int64, dtid string) error
+	CommitPrepared(ctx context.Context, target *pb.Target, dtid string) error
+	RollbackPrepared(ctx context.Context, target *pb.Target, dtid string, originalID int64) error
+	CreateTransaction(ctx context.Context, target *pb.Target, dtid string, participants []*pb.Target) error
+	StartCommit(ctx context.Context, target *pb.Target, transactionID int64, dtid string) error
+	ConcludeTransaction(ctx context.Context, target *pb.Target, dtid string) error
+
+	// Read-your-writes support. CommitWithPosition commits a
+	// transaction and returns the replication position of the
+	// master after it. WaitForPosition waits until a replica has
+	// applied a position.
+	CommitWithPosition(ctx context.Context, target *pb.Target, transactionID int64) (string, error)
+	WaitForPosition(ctx context.Context, target *pb.Target, position string, timeout time.Duration) error
+
 	// Query execution
 	Execute(ctx context.Context, target *pb.Target, query *proto.Query, reply *mproto.QueryResult) error
 	StreamExecute(ctx context.Context, target *pb.Target, query *proto.Query, sendReply func(*mproto.QueryResult) error) error
@@ -71,6 +90,46 @@ func (e *ErrorQueryService) Rollback(ctx context.Context, target *pb.Target, ses
 	return fmt.Errorf("ErrorQueryService does not implement any method")
 }
 
+// Prepare is part of QueryService interface
+func (e *ErrorQueryService) Prepare(ctx context.Context, target *pb.Target, transactionID int64, dtid string) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// CommitPrepared is part of QueryService interface
+func (e *ErrorQueryService) CommitPrepared(ctx context.Context, target *pb.Target, dtid string) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// RollbackPrepared is part of QueryService interface
+func (e *ErrorQueryService) RollbackPrepared(ctx context.Context, target *pb.Target, dtid string, originalID int64) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// CreateTransaction is part of QueryService interface
+func (e *ErrorQueryService) CreateTransaction(ctx context.Context, target *pb.Target, dtid string, participants []*pb.Target) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// StartCommit is part of QueryService interface
+func (e *ErrorQueryService) StartCommit(ctx context.Context, target *pb.Target, transactionID int64, dtid string) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// ConcludeTransaction is part of QueryService interface
+func (e *ErrorQueryService) ConcludeTransaction(ctx context.Context, target *pb.Target, dtid string) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// CommitWithPosition is part of QueryService interface
+func (e *ErrorQueryService) CommitWithPosition(ctx context.Context, target *pb.Target, transactionID int64) (string, error) {
+	return "", fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// WaitForPosition is part of QueryService interface
+func (e *ErrorQueryService) WaitForPosition(ctx context.Context, target *pb.Target, position string, timeout time.Duration) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
 // Execute is part of QueryService interface
 func (e *ErrorQueryService) Execute(ctx context.Context, target *pb.Target, query *proto.Query, reply *mproto.QueryResult) error {
 	return fmt.Errorf("ErrorQueryService does not implement any method")
@@ -20,10 +20,12 @@ import (
 	"github.com/youtube/vitess/go/vt/dbconfigs"
 	"github.com/youtube/vitess/go/vt/dbconnpool"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"golang.org/x/net/context"
 
 	pb "github.com/youtube/vitess/go/vt/proto/query"
+	pbt "github.com/youtube/vitess/go/vt/proto/topodata"
 )
 
 // Allowed state transitions:
@@ -91,6 +93,7 @@ type SqlQuery struct {
 	sessionID int64
 	dbconfig  *dbconfigs.DBConfig
 	target    *pb.Target
+	mysqld    mysqlctl.MysqlDaemon
 
 	// streamHealthMutex protects all the following fields
 	streamHealthMutex        sync.Mutex
@@ -181,8 +184,12 @@ func (sq *SqlQuery) allowQueries(target *pb.Target, dbconfigs *dbconfigs.DBConfi
 	}()
 
 	sq.qe.Open(dbconfigs, schemaOverrides, mysqld)
+	if sq.config.TwoPCEnable && target != nil && target.TabletType == pbt.TabletType_MASTER {
+		sq.qe.OpenTwoPC()
+	}
 	sq.dbconfig = &dbconfigs.App
 	sq.target = target
+	sq.mysqld = mysqld
 	sq.sessionID = Rand()
 	log.Infof("Session id: %d", sq.sessionID)
 	return nil
@@ -344,6 +351,198 @@ func (sq *SqlQuery) Rollback(ctx context.Context, target *pb.Target, session *pr
 	return nil
 }
 
+// CommitWithPosition commits the specified transaction, and returns
+// the encoded replication position of the master after the commit.
+// The position is empty if it could not be read.
+func (sq *SqlQuery) CommitWithPosition(ctx context.Context, target *pb.Target, transactionID int64) (position string, err error) {
+	logStats := newSqlQueryStats("CommitWithPosition", ctx)
+	logStats.OriginalSql = "commit"
+	logStats.TransactionID = transactionID
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, true); err != nil {
+		return "", err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("COMMIT", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.Commit(ctx, logStats, transactionID)
+	if sq.mysqld == nil {
+		return "", nil
+	}
+	pos, perr := sq.mysqld.MasterPosition()
+	if perr != nil {
+		log.Warningf("CommitWithPosition: cannot read master position: %v", perr)
+		return "", nil
+	}
+	return myproto.EncodeReplicationPosition(pos), nil
+}
+
+// WaitForPosition waits until this tablet has applied the encoded
+// replication position, for at most timeout. The timeout is capped
+// by the query timeout.
+func (sq *SqlQuery) WaitForPosition(ctx context.Context, target *pb.Target, position string, timeout time.Duration) (err error) {
+	logStats := newSqlQueryStats("WaitForPosition", ctx)
+	logStats.OriginalSql = "wait for position " + position
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, false); err != nil {
+		return err
+	}
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("WAIT_FOR_POSITION", time.Now())
+		sq.endRequest()
+	}()
+
+	if queryTimeout := sq.qe.queryTimeout.Get(); queryTimeout != 0 && (timeout == 0 || timeout > queryTimeout) {
+		timeout = queryTimeout
+	}
+	pos, err := myproto.DecodeReplicationPosition(position)
+	if err != nil {
+		return NewTabletError(ErrFail, "WaitForPosition: %v", err)
+	}
+	if sq.mysqld == nil {
+		return NewTabletError(ErrFail, "WaitForPosition: no mysqld")
+	}
+	if err := sq.mysqld.WaitMasterPos(pos, timeout); err != nil {
+		return NewTabletError(ErrFail, "WaitForPosition: %v", err)
+	}
+	return nil
+}
+
+// Prepare prepares the transaction for a two-phase commit. Its
+// DMLs are saved in the redo log, and it's kept open until
+// CommitPrepared or RollbackPrepared is called.
+func (sq *SqlQuery) Prepare(ctx context.Context, target *pb.Target, transactionID int64, dtid string) (err error) {
+	logStats := newSqlQueryStats("Prepare", ctx)
+	logStats.OriginalSql = "prepare " + dtid
+	logStats.TransactionID = transactionID
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, true); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("PREPARE", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.twoPC.Prepare(ctx, transactionID, dtid)
+	return nil
+}
+
+// CommitPrepared commits a prepared transaction.
+func (sq *SqlQuery) CommitPrepared(ctx context.Context, target *pb.Target, dtid string) (err error) {
+	logStats := newSqlQueryStats("CommitPrepared", ctx)
+	logStats.OriginalSql = "commit prepared " + dtid
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, true); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("COMMIT_PREPARED", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.twoPC.CommitPrepared(ctx, logStats, dtid)
+	return nil
+}
+
+// RollbackPrepared rolls back a prepared transaction. If the
+// transaction was not prepared yet, originalID is rolled back instead.
+func (sq *SqlQuery) RollbackPrepared(ctx context.Context, target *pb.Target, dtid string, originalID int64) (err error) {
+	logStats := newSqlQueryStats("RollbackPrepared", ctx)
+	logStats.OriginalSql = "rollback prepared " + dtid
+	logStats.TransactionID = originalID
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, true); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("ROLLBACK_PREPARED", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.twoPC.RollbackPrepared(ctx, dtid, originalID)
+	return nil
+}
+
+// CreateTransaction records a new distributed transaction in
+// this shard, which becomes its metadata shard.
+func (sq *SqlQuery) CreateTransaction(ctx context.Context, target *pb.Target, dtid string, participants []*pb.Target) (err error) {
+	logStats := newSqlQueryStats("CreateTransaction", ctx)
+	logStats.OriginalSql = "create transaction " + dtid
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, false); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("CREATE_TRANSACTION", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.twoPC.CreateTransaction(ctx, dtid, participants)
+	return nil
+}
+
+// StartCommit atomically records the decision to commit the
+// distributed transaction, and commits transactionID.
+func (sq *SqlQuery) StartCommit(ctx context.Context, target *pb.Target, transactionID int64, dtid string) (err error) {
+	logStats := newSqlQueryStats("StartCommit", ctx)
+	logStats.OriginalSql = "start commit " + dtid
+	logStats.TransactionID = transactionID
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, true); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("START_COMMIT", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.twoPC.StartCommit(ctx, logStats, transactionID, dtid)
+	return nil
+}
+
+// ConcludeTransaction deletes the metadata of a distributed
+// transaction that's resolved on all its participants.
+func (sq *SqlQuery) ConcludeTransaction(ctx context.Context, target *pb.Target, dtid string) (err error) {
+	logStats := newSqlQueryStats("ConcludeTransaction", ctx)
+	logStats.OriginalSql = "conclude transaction " + dtid
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(target, 0, false, true); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func() {
+		sq.qe.queryServiceStats.QueryStats.Record("CONCLUDE_TRANSACTION", time.Now())
+		cancel()
+		sq.endRequest()
+	}()
+
+	sq.qe.twoPC.ConcludeTransaction(ctx, dtid)
+	return nil
+}
+
 // handleExecError handles panics during query execution and sets
 // the supplied error return value.
 func (sq *SqlQuery) handleExecError(query *proto.Query, err *error, logStats *SQLQueryStats) {
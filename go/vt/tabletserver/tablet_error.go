@@ -320,6 +320,78 @@ func AddTabletErrorToRollbackResponse(err error, reply *proto.RollbackResponse)
 	reply.Err = rpcErrFromTabletError(err)
 }
 
+// AddTabletErrorToPrepareResponse will mutate a PrepareResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToPrepareResponse(err error, reply *proto.PrepareResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToCommitPreparedResponse will mutate a CommitPreparedResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToCommitPreparedResponse(err error, reply *proto.CommitPreparedResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToRollbackPreparedResponse will mutate a RollbackPreparedResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToRollbackPreparedResponse(err error, reply *proto.RollbackPreparedResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToCreateTransactionResponse will mutate a CreateTransactionResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToCreateTransactionResponse(err error, reply *proto.CreateTransactionResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToStartCommitResponse will mutate a StartCommitResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToStartCommitResponse(err error, reply *proto.StartCommitResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToConcludeTransactionResponse will mutate a ConcludeTransactionResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToConcludeTransactionResponse(err error, reply *proto.ConcludeTransactionResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToCommitWithPositionResponse will mutate a CommitWithPositionResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToCommitWithPositionResponse(err error, reply *proto.CommitWithPositionResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToWaitForPositionResponse will mutate a WaitForPositionResponse struct to fill in the Err
+// field with details from the TabletError.
+func AddTabletErrorToWaitForPositionResponse(err error, reply *proto.WaitForPositionResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
 // TabletErrorToRPCError transforms the provided error to a RPCError,
 // if any.
 func TabletErrorToRPCError(err error) *vtrpc.RPCError {
@@ -86,6 +86,20 @@ type TabletConn interface {
 	Commit(ctx context.Context, transactionId int64) error
 	Rollback(ctx context.Context, transactionId int64) error
 
+	// Two-phase commit support. These can only be used with a Target.
+	Prepare(ctx context.Context, transactionID int64, dtid string) error
+	CommitPrepared(ctx context.Context, dtid string) error
+	RollbackPrepared(ctx context.Context, dtid string, originalID int64) error
+	CreateTransaction(ctx context.Context, dtid string, participants []*pb.Target) error
+	StartCommit(ctx context.Context, transactionID int64, dtid string) error
+	ConcludeTransaction(ctx context.Context, dtid string) error
+
+	// Read-your-writes support. These can only be used with a Target.
+	// CommitWithPosition returns an empty position if the protocol
+	// can't return it.
+	CommitWithPosition(ctx context.Context, transactionID int64) (string, error)
+	WaitForPosition(ctx context.Context, position string, timeout time.Duration) error
+
 	// These should not be used for anything except tests for now; they will eventually
 	// replace the existing methods.
 	Execute2(ctx context.Context, query string, bindVars map[string]interface{}, transactionId int64) (*mproto.QueryResult, error)
@@ -0,0 +1,93 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletconntest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/query"
+	pbt "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+const testPosition = "MariaDB/0-1-42"
+
+const testWaitTimeout = 3 * time.Second
+
+const readYourWritesTransactionID int64 = 667788
+
+// CommitWithPosition is part of the queryservice.QueryService interface
+func (f *FakeQueryService) CommitWithPosition(ctx context.Context, target *pb.Target, transactionID int64) (string, error) {
+	if f.hasError {
+		return "", testTabletError
+	}
+	if f.panics {
+		panic(fmt.Errorf("test-triggered panic"))
+	}
+	f.checkTargetCallerID(ctx, "CommitWithPosition", target)
+	if transactionID != readYourWritesTransactionID {
+		f.t.Errorf("CommitWithPosition: invalid TransactionId: got %v expected %v", transactionID, readYourWritesTransactionID)
+	}
+	return testPosition, nil
+}
+
+// WaitForPosition is part of the queryservice.QueryService interface
+func (f *FakeQueryService) WaitForPosition(ctx context.Context, target *pb.Target, position string, timeout time.Duration) error {
+	if f.hasError {
+		return testTabletError
+	}
+	if f.panics {
+		panic(fmt.Errorf("test-triggered panic"))
+	}
+	f.checkTargetCallerID(ctx, "WaitForPosition", target)
+	if position != testPosition {
+		f.t.Errorf("WaitForPosition: invalid position: got %v expected %v", position, testPosition)
+	}
+	if timeout != testWaitTimeout {
+		f.t.Errorf("WaitForPosition: invalid timeout: got %v expected %v", timeout, testWaitTimeout)
+	}
+	return nil
+}
+
+// TestReadYourWritesSuite runs the tests of the read-your-writes
+// calls. They are separate from TestSuite, because not all
+// protocols support them.
+func TestReadYourWritesSuite(t *testing.T, protocol string, endPoint *pbt.EndPoint, fake *FakeQueryService) {
+	*tabletconn.TabletProtocol = protocol
+	ctx := context.Background()
+	conn, err := tabletconn.GetDialer()(ctx, endPoint, testTarget.Keyspace, testTarget.Shard, testTarget.TabletType, 30*time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	fake.checkExtraFields = true
+	defer func() { fake.checkExtraFields = false }()
+	ctx = callerid.NewContext(ctx, testCallerID, testVTGateCallerID)
+
+	t.Log("testReadYourWrites")
+	position, err := conn.CommitWithPosition(ctx, readYourWritesTransactionID)
+	if err != nil {
+		t.Errorf("CommitWithPosition failed: %v", err)
+	}
+	if position != testPosition {
+		t.Errorf("CommitWithPosition: got %v expected %v", position, testPosition)
+	}
+	if err := conn.WaitForPosition(ctx, testPosition, testWaitTimeout); err != nil {
+		t.Errorf("WaitForPosition failed: %v", err)
+	}
+
+	t.Log("testReadYourWritesError")
+	fake.hasError = true
+	_, err = conn.CommitWithPosition(ctx, readYourWritesTransactionID)
+	verifyError(t, err, "CommitWithPosition")
+	err = conn.WaitForPosition(ctx, testPosition, testWaitTimeout)
+	verifyError(t, err, "WaitForPosition")
+	fake.hasError = false
+}
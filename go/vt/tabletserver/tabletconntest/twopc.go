@@ -0,0 +1,141 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletconntest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/query"
+	pbt "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+const testDtid = "test_dtid"
+
+const twoPCTransactionID int64 = 889922
+
+var testParticipants = []*pb.Target{{
+	Keyspace:   "test_keyspace2",
+	Shard:      "test_shard2",
+	TabletType: pbt.TabletType_MASTER,
+}}
+
+// checkTwoPC performs the checks common to all the two-phase
+// commit calls of the fake.
+func (f *FakeQueryService) checkTwoPC(ctx context.Context, name string, target *pb.Target, dtid string) error {
+	if f.hasError {
+		return testTabletError
+	}
+	if f.panics {
+		panic(fmt.Errorf("test-triggered panic"))
+	}
+	f.checkTargetCallerID(ctx, name, target)
+	if dtid != testDtid {
+		f.t.Errorf("%s: invalid dtid: got %v expected %v", name, dtid, testDtid)
+	}
+	return nil
+}
+
+func (f *FakeQueryService) checkTransactionID(name string, transactionID int64) {
+	if transactionID != twoPCTransactionID {
+		f.t.Errorf("%s: invalid TransactionId: got %v expected %v", name, transactionID, twoPCTransactionID)
+	}
+}
+
+// Prepare is part of the queryservice.QueryService interface
+func (f *FakeQueryService) Prepare(ctx context.Context, target *pb.Target, transactionID int64, dtid string) error {
+	if err := f.checkTwoPC(ctx, "Prepare", target, dtid); err != nil {
+		return err
+	}
+	f.checkTransactionID("Prepare", transactionID)
+	return nil
+}
+
+// CommitPrepared is part of the queryservice.QueryService interface
+func (f *FakeQueryService) CommitPrepared(ctx context.Context, target *pb.Target, dtid string) error {
+	return f.checkTwoPC(ctx, "CommitPrepared", target, dtid)
+}
+
+// RollbackPrepared is part of the queryservice.QueryService interface
+func (f *FakeQueryService) RollbackPrepared(ctx context.Context, target *pb.Target, dtid string, originalID int64) error {
+	if err := f.checkTwoPC(ctx, "RollbackPrepared", target, dtid); err != nil {
+		return err
+	}
+	f.checkTransactionID("RollbackPrepared", originalID)
+	return nil
+}
+
+// CreateTransaction is part of the queryservice.QueryService interface
+func (f *FakeQueryService) CreateTransaction(ctx context.Context, target *pb.Target, dtid string, participants []*pb.Target) error {
+	if err := f.checkTwoPC(ctx, "CreateTransaction", target, dtid); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(participants, testParticipants) {
+		f.t.Errorf("CreateTransaction: invalid participants: got %v expected %v", participants, testParticipants)
+	}
+	return nil
+}
+
+// StartCommit is part of the queryservice.QueryService interface
+func (f *FakeQueryService) StartCommit(ctx context.Context, target *pb.Target, transactionID int64, dtid string) error {
+	if err := f.checkTwoPC(ctx, "StartCommit", target, dtid); err != nil {
+		return err
+	}
+	f.checkTransactionID("StartCommit", transactionID)
+	return nil
+}
+
+// ConcludeTransaction is part of the queryservice.QueryService interface
+func (f *FakeQueryService) ConcludeTransaction(ctx context.Context, target *pb.Target, dtid string) error {
+	return f.checkTwoPC(ctx, "ConcludeTransaction", target, dtid)
+}
+
+// twoPCCalls runs all the two-phase commit calls on conn, and
+// returns their errors by name.
+func twoPCCalls(conn tabletconn.TabletConn) map[string]error {
+	ctx := callerid.NewContext(context.Background(), testCallerID, testVTGateCallerID)
+	return map[string]error{
+		"Prepare":             conn.Prepare(ctx, twoPCTransactionID, testDtid),
+		"CommitPrepared":      conn.CommitPrepared(ctx, testDtid),
+		"RollbackPrepared":    conn.RollbackPrepared(ctx, testDtid, twoPCTransactionID),
+		"CreateTransaction":   conn.CreateTransaction(ctx, testDtid, testParticipants),
+		"StartCommit":         conn.StartCommit(ctx, twoPCTransactionID, testDtid),
+		"ConcludeTransaction": conn.ConcludeTransaction(ctx, testDtid),
+	}
+}
+
+// TestTwoPCSuite runs the tests of the two-phase commit calls. They
+// are separate from TestSuite, because not all protocols support them.
+func TestTwoPCSuite(t *testing.T, protocol string, endPoint *pbt.EndPoint, fake *FakeQueryService) {
+	*tabletconn.TabletProtocol = protocol
+	ctx := context.Background()
+	conn, err := tabletconn.GetDialer()(ctx, endPoint, testTarget.Keyspace, testTarget.Shard, testTarget.TabletType, 30*time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	fake.checkExtraFields = true
+	defer func() { fake.checkExtraFields = false }()
+
+	t.Log("testTwoPC")
+	for name, err := range twoPCCalls(conn) {
+		if err != nil {
+			t.Errorf("%s failed: %v", name, err)
+		}
+	}
+
+	t.Log("testTwoPCError")
+	fake.hasError = true
+	for name, err := range twoPCCalls(conn) {
+		verifyError(t, err, name)
+	}
+	fake.hasError = false
+}
@@ -0,0 +1,572 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/query"
+)
+
+// twoPCSchema creates the sidecar tables used by two-phase commit.
+//
+// The redo log is kept by the participants of a distributed
+// transaction. It contains the DMLs of the transactions that were
+// prepared on this tablet, so they can be prepared again after a
+// restart, or by the next master after a reparent.
+//
+// The transaction metadata is kept by the metadata shard of a
+// distributed transaction. It contains the participants, and the
+// decision to commit or roll back. The resolver uses it to finish
+// the transactions that were abandoned by their vtgate.
+var twoPCSchema = []string{
+	"create database if not exists _vt",
+	`create table if not exists _vt.redo_log_transaction(
+  dtid varbinary(512),
+  state bigint,
+  time_created bigint,
+  primary key(dtid)
+) engine=InnoDB`,
+	`create table if not exists _vt.redo_log_statement(
+  dtid varbinary(512),
+  id bigint,
+  statement mediumblob,
+  primary key(dtid, id)
+) engine=InnoDB`,
+	`create table if not exists _vt.dt_state(
+  dtid varbinary(512),
+  state bigint,
+  time_created bigint,
+  primary key(dtid)
+) engine=InnoDB`,
+	`create table if not exists _vt.dt_participant(
+  dtid varbinary(512),
+  id bigint,
+  keyspace varchar(256),
+  shard varchar(256),
+  primary key(dtid, id)
+) engine=InnoDB`,
+}
+
+// States of a transaction in the redo log.
+const (
+	redoStateFailed   = 0
+	redoStatePrepared = 1
+)
+
+// States of a distributed transaction in the metadata shard.
+const (
+	dtStatePrepare  = 1
+	dtStateCommit   = 2
+	dtStateRollback = 3
+)
+
+const (
+	sqlInsertRedoTransaction = "insert into _vt.redo_log_transaction(dtid, state, time_created) values (%s, %s, %s)"
+	sqlInsertRedoStatements  = "insert into _vt.redo_log_statement(dtid, id, statement) values %s"
+	sqlUpdateRedoState       = "update _vt.redo_log_transaction set state = %s where dtid = %s"
+	sqlDeleteRedoTransaction = "delete from _vt.redo_log_transaction where dtid = %s"
+	sqlDeleteRedoStatements  = "delete from _vt.redo_log_statement where dtid = %s"
+	sqlReadRedoTransactions  = "select dtid, state from _vt.redo_log_transaction"
+	sqlReadRedoStatements    = "select dtid, statement from _vt.redo_log_statement order by dtid, id"
+	sqlInsertDTState         = "insert into _vt.dt_state(dtid, state, time_created) values (%s, %s, %s)"
+	sqlInsertDTParticipants  = "insert into _vt.dt_participant(dtid, id, keyspace, shard) values %s"
+	sqlTransitionDTState     = "update _vt.dt_state set state = %s where dtid = %s and state = %s"
+	sqlDeleteDTState         = "delete from _vt.dt_state where dtid = %s"
+	sqlDeleteDTParticipants  = "delete from _vt.dt_participant where dtid = %s"
+	sqlReadAbandonedDTState  = "select dtid, state from _vt.dt_state where time_created < %s"
+	sqlReadDTParticipants    = "select keyspace, shard from _vt.dt_participant where dtid = %s order by id"
+)
+
+// ParticipantConn is the connection the resolver uses to finish
+// an abandoned distributed transaction on one of its participants.
+// tabletconn.TabletConn implements it.
+type ParticipantConn interface {
+	CommitPrepared(ctx context.Context, dtid string) error
+	RollbackPrepared(ctx context.Context, dtid string, originalID int64) error
+	Close()
+}
+
+// ParticipantDialer returns a connection to the master of a
+// participant shard. It must be set by the main program for the
+// resolver to run.
+var ParticipantDialer func(ctx context.Context, keyspace, shard string) (ParticipantConn, error)
+
+// TwoPC implements the tabletserver side of two-phase commit.
+// It's only opened on masters, and only if two-phase commit
+// is enabled.
+//
+// Prepared transactions are detached from the transaction pool,
+// and kept in the prepared pool until they're committed or
+// rolled back. This way, they're not killed by the transaction
+// timeout, and they don't prevent the query service from shutting
+// down. Like other TabletServer functions, the functions of TwoPC
+// panic with a TabletError on failure.
+type TwoPC struct {
+	qe         *QueryEngine
+	abandonAge time.Duration
+	ticks      *timer.Timer
+	// now returns the current time. Tests override it.
+	now func() time.Time
+
+	mu     sync.Mutex
+	isOpen bool
+	// prepared contains the connections of the prepared
+	// transactions. A nil value means that the transaction
+	// is being prepared.
+	prepared map[string]*TxConnection
+	// failed contains the prepared transactions that could
+	// not be recovered, or whose commit failed. They can only
+	// be rolled back, or recovered by reopening.
+	failed map[string]bool
+}
+
+// NewTwoPC creates a new TwoPC. Transactions whose metadata
+// is older than abandonAge are resolved by the resolver.
+// If abandonAge is 0, the resolver is disabled.
+func NewTwoPC(qe *QueryEngine, abandonAge time.Duration) *TwoPC {
+	return &TwoPC{
+		qe:         qe,
+		abandonAge: abandonAge,
+		ticks:      timer.NewTimer(abandonAge / 2),
+		now:        time.Now,
+	}
+}
+
+// Open creates the sidecar tables if needed, prepares again the
+// transactions of the redo log, and starts the resolver. It must
+// be called after the QueryEngine is opened.
+func (tpc *TwoPC) Open(dbaParams *sqldb.ConnParams) {
+	conn, err := dbconnpool.NewDBConnection(dbaParams, tpc.qe.queryServiceStats.MySQLStats)
+	if err != nil {
+		panic(NewTabletErrorSql(ErrFatal, err))
+	}
+	defer conn.Close()
+	for _, query := range twoPCSchema {
+		if _, err := conn.ExecuteFetch(query, 0, false); err != nil {
+			panic(NewTabletErrorSql(ErrFatal, err))
+		}
+	}
+
+	tpc.mu.Lock()
+	tpc.isOpen = true
+	tpc.prepared = make(map[string]*TxConnection)
+	tpc.failed = make(map[string]bool)
+	tpc.mu.Unlock()
+
+	tpc.recoverPrepared(context.Background())
+	if tpc.abandonAge > 0 {
+		tpc.ticks.Start(func() { tpc.resolveAbandoned() })
+	}
+}
+
+// StopResolver stops the resolver. It must be called before
+// waiting for the transactions to end, because it begins new ones.
+func (tpc *TwoPC) StopResolver() {
+	tpc.ticks.Stop()
+}
+
+// Close rolls back the prepared transactions in MySQL. Their
+// redo log is kept, so they can be prepared again by the next
+// call to Open, on this tablet or on the next master.
+func (tpc *TwoPC) Close() {
+	tpc.ticks.Stop()
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if !tpc.isOpen {
+		return
+	}
+	for _, conn := range tpc.prepared {
+		if conn == nil {
+			continue
+		}
+		conn.Close()
+		conn.discard(TxClose)
+	}
+	tpc.isOpen = false
+	tpc.prepared = nil
+	tpc.failed = nil
+}
+
+// recoverPrepared replays the redo log: every transaction that
+// was prepared when MySQL last went away is prepared again. The
+// ones that fail to replay are marked as failed, and must be
+// resolved manually.
+func (tpc *TwoPC) recoverPrepared(ctx context.Context) {
+	transactions := tpc.read(ctx, sqlReadRedoTransactions)
+	statements := make(map[string][]string)
+	for _, row := range tpc.read(ctx, sqlReadRedoStatements).Rows {
+		dtid := row[0].String()
+		statements[dtid] = append(statements[dtid], row[1].String())
+	}
+	for _, row := range transactions.Rows {
+		dtid := row[0].String()
+		if state, err := row[1].ParseInt64(); err != nil || state != redoStatePrepared {
+			tpc.markFailed(dtid)
+			continue
+		}
+		if err := tpc.replay(ctx, dtid, statements[dtid]); err != nil {
+			log.Errorf("Could not recover prepared transaction %s: %v", dtid, err)
+			tpc.markFailed(dtid)
+			tpc.execInTx(ctx, buildQuery(sqlUpdateRedoState, redoStateFailed, dtid))
+		}
+	}
+}
+
+func (tpc *TwoPC) replay(ctx context.Context, dtid string, statements []string) (err error) {
+	defer handleError(&err, nil, tpc.qe.queryServiceStats)
+	transactionID := tpc.qe.txPool.Begin(ctx)
+	conn := tpc.qe.txPool.Get(transactionID)
+	for _, statement := range statements {
+		conn.RecordQuery(statement)
+		conn.RecordRedo(statement)
+		if _, err := conn.Exec(ctx, statement, int(tpc.qe.maxResultSize.Get()), false); err != nil {
+			conn.Recycle()
+			tpc.qe.txPool.Rollback(ctx, transactionID)
+			return err
+		}
+	}
+	conn.Recycle()
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	tpc.prepared[dtid] = tpc.qe.txPool.Detach(transactionID)
+	return nil
+}
+
+// Prepare saves the redo log of the transaction, and moves it to
+// the prepared pool. From then on, the transaction can only be
+// concluded by CommitPrepared or RollbackPrepared, even across
+// restarts of the tablet.
+func (tpc *TwoPC) Prepare(ctx context.Context, transactionID int64, dtid string) {
+	tpc.reserve(dtid)
+	prepared := false
+	defer func() {
+		if !prepared {
+			tpc.release(dtid)
+		}
+	}()
+
+	conn := tpc.qe.txPool.Get(transactionID)
+	queries := []string{buildQuery(sqlInsertRedoTransaction, dtid, redoStatePrepared, tpc.now().UnixNano())}
+	if len(conn.RedoStatements) != 0 {
+		rows := make([]string, len(conn.RedoStatements))
+		for i, statement := range conn.RedoStatements {
+			rows[i] = encodeValue([]interface{}{dtid, int64(i + 1), statement})
+		}
+		queries = append(queries, fmt.Sprintf(sqlInsertRedoStatements, strings.Join(rows, ", ")))
+	}
+	conn.Recycle()
+
+	// The redo log is saved in its own transaction, so that it
+	// survives if MySQL rolls back the prepared one.
+	tpc.execInTx(ctx, queries...)
+
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	tpc.prepared[dtid] = tpc.qe.txPool.Detach(transactionID)
+	prepared = true
+}
+
+// CommitPrepared commits a prepared transaction. It's a no-op
+// if the transaction is not in the prepared pool, because it was
+// already committed.
+func (tpc *TwoPC) CommitPrepared(ctx context.Context, logStats *SQLQueryStats, dtid string) {
+	conn := tpc.take(dtid, false)
+	if conn == nil {
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tpc.markFailed(dtid)
+		}
+	}()
+
+	// The redo log is deleted by the transaction itself, so
+	// it's gone if and only if the transaction is committed.
+	tpc.qe.txPool.Attach(conn)
+	conn = tpc.qe.txPool.Get(conn.TransactionID)
+	err := tpc.deleteRedo(ctx, conn, dtid)
+	conn.Recycle()
+	if err != nil {
+		tpc.qe.txPool.RollbackIfActive(ctx, conn.TransactionID)
+		panic(err)
+	}
+	tpc.qe.Commit(ctx, logStats, conn.TransactionID)
+	committed = true
+}
+
+// RollbackPrepared rolls back a prepared transaction. If the
+// transaction was not prepared, the transaction originalID is
+// rolled back instead, if it's still open.
+func (tpc *TwoPC) RollbackPrepared(ctx context.Context, dtid string, originalID int64) {
+	tpc.checkOpen()
+	tpc.execInTx(ctx,
+		buildQuery(sqlDeleteRedoTransaction, dtid),
+		buildQuery(sqlDeleteRedoStatements, dtid),
+	)
+	if conn := tpc.take(dtid, true); conn != nil {
+		tpc.qe.txPool.Attach(conn)
+		tpc.qe.txPool.Rollback(ctx, conn.TransactionID)
+		return
+	}
+	if originalID != 0 {
+		tpc.qe.txPool.RollbackIfActive(ctx, originalID)
+	}
+}
+
+// CreateTransaction records a new distributed transaction and
+// its participants in the metadata shard. Its state is Prepare
+// until StartCommit is called.
+func (tpc *TwoPC) CreateTransaction(ctx context.Context, dtid string, participants []*pb.Target) {
+	tpc.checkOpen()
+	if len(participants) == 0 {
+		panic(NewTabletError(ErrFail, "transaction %s has no participants", dtid))
+	}
+	rows := make([]string, len(participants))
+	for i, participant := range participants {
+		rows[i] = encodeValue([]interface{}{dtid, int64(i + 1), participant.Keyspace, participant.Shard})
+	}
+	tpc.execInTx(ctx,
+		buildQuery(sqlInsertDTState, dtid, dtStatePrepare, tpc.now().UnixNano()),
+		fmt.Sprintf(sqlInsertDTParticipants, strings.Join(rows, ", ")),
+	)
+}
+
+// StartCommit records the decision to commit the distributed
+// transaction as part of transactionID, and commits it. Once it
+// succeeds, the transaction must be committed on all participants.
+func (tpc *TwoPC) StartCommit(ctx context.Context, logStats *SQLQueryStats, transactionID int64, dtid string) {
+	tpc.checkOpen()
+	conn := tpc.qe.txPool.Get(transactionID)
+	result, err := conn.Exec(ctx, buildQuery(sqlTransitionDTState, dtStateCommit, dtid, dtStatePrepare), 1, false)
+	conn.Recycle()
+	if err != nil {
+		panic(err)
+	}
+	if result.RowsAffected != 1 {
+		panic(NewTabletError(ErrFail, "could not commit transaction %s: it was not found or already resolved", dtid))
+	}
+	tpc.qe.Commit(ctx, logStats, transactionID)
+}
+
+// ConcludeTransaction deletes the metadata of a distributed
+// transaction once all its participants are resolved.
+func (tpc *TwoPC) ConcludeTransaction(ctx context.Context, dtid string) {
+	tpc.checkOpen()
+	tpc.execInTx(ctx,
+		buildQuery(sqlDeleteDTState, dtid),
+		buildQuery(sqlDeleteDTParticipants, dtid),
+	)
+}
+
+// resolveAbandoned finishes the distributed transactions whose
+// vtgate did not conclude them within abandonAge. The ones that
+// were decided are committed, the others are rolled back.
+func (tpc *TwoPC) resolveAbandoned() {
+	defer logError(tpc.qe.queryServiceStats)
+	if ParticipantDialer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tpc.abandonAge)
+	defer cancel()
+	result := tpc.read(ctx, buildQuery(sqlReadAbandonedDTState, tpc.now().Add(-tpc.abandonAge).UnixNano()))
+	for _, row := range result.Rows {
+		dtid := row[0].String()
+		state, err := row[1].ParseInt64()
+		if err == nil {
+			err = tpc.resolve(ctx, dtid, state)
+		}
+		if err != nil {
+			log.Errorf("Could not resolve abandoned transaction %s: %v", dtid, err)
+			tpc.qe.queryServiceStats.InternalErrors.Add("TwoPCResolve", 1)
+		}
+	}
+}
+
+func (tpc *TwoPC) resolve(ctx context.Context, dtid string, state int64) (err error) {
+	defer handleError(&err, nil, tpc.qe.queryServiceStats)
+	if state == dtStatePrepare {
+		// The commit was not decided. Switching to Rollback
+		// makes a late StartCommit fail.
+		result := tpc.execInTx(ctx, buildQuery(sqlTransitionDTState, dtStateRollback, dtid, dtStatePrepare))
+		if result.RowsAffected != 1 {
+			// The state changed under us. We'll retry later.
+			return nil
+		}
+		state = dtStateRollback
+	}
+	participants := tpc.read(ctx, buildQuery(sqlReadDTParticipants, dtid))
+	for _, row := range participants.Rows {
+		conn, err := ParticipantDialer(ctx, row[0].String(), row[1].String())
+		if err != nil {
+			return err
+		}
+		if state == dtStateCommit {
+			err = conn.CommitPrepared(ctx, dtid)
+		} else {
+			err = conn.RollbackPrepared(ctx, dtid, 0)
+		}
+		conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Resolved abandoned transaction %s, state %d", dtid, state)
+	tpc.execInTx(ctx,
+		buildQuery(sqlDeleteDTState, dtid),
+		buildQuery(sqlDeleteDTParticipants, dtid),
+	)
+	return nil
+}
+
+func (tpc *TwoPC) checkOpen() {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if !tpc.isOpen {
+		panic(NewTabletError(ErrFail, "two-phase commit is not enabled on this tablet"))
+	}
+}
+
+// reserve marks dtid as being prepared.
+func (tpc *TwoPC) reserve(dtid string) {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if !tpc.isOpen {
+		panic(NewTabletError(ErrFail, "two-phase commit is not enabled on this tablet"))
+	}
+	if _, ok := tpc.prepared[dtid]; ok || tpc.failed[dtid] {
+		panic(NewTabletError(ErrFail, "transaction %s is already prepared", dtid))
+	}
+	tpc.prepared[dtid] = nil
+}
+
+// release undoes reserve.
+func (tpc *TwoPC) release(dtid string) {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if tpc.prepared[dtid] == nil {
+		delete(tpc.prepared, dtid)
+	}
+}
+
+// take removes dtid from the prepared pool, and returns its
+// connection. It returns nil if dtid is not prepared. Failed
+// transactions can only be taken for rollback.
+func (tpc *TwoPC) take(dtid string, forRollback bool) *TxConnection {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if !tpc.isOpen {
+		panic(NewTabletError(ErrFail, "two-phase commit is not enabled on this tablet"))
+	}
+	if tpc.failed[dtid] {
+		if !forRollback {
+			panic(NewTabletError(ErrFail, "prepared transaction %s failed, it must be resolved manually", dtid))
+		}
+		delete(tpc.failed, dtid)
+	}
+	conn, ok := tpc.prepared[dtid]
+	if !ok {
+		return nil
+	}
+	if conn == nil {
+		panic(NewTabletError(ErrFail, "transaction %s is being prepared", dtid))
+	}
+	delete(tpc.prepared, dtid)
+	return conn
+}
+
+func (tpc *TwoPC) markFailed(dtid string) {
+	tpc.qe.queryServiceStats.InternalErrors.Add("TwoPCFailed", 1)
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if tpc.isOpen {
+		tpc.failed[dtid] = true
+	}
+}
+
+func (tpc *TwoPC) deleteRedo(ctx context.Context, conn poolConn, dtid string) error {
+	if _, err := conn.Exec(ctx, buildQuery(sqlDeleteRedoTransaction, dtid), 1, false); err != nil {
+		return err
+	}
+	_, err := conn.Exec(ctx, buildQuery(sqlDeleteRedoStatements, dtid), 1, false)
+	return err
+}
+
+// execInTx executes queries in a new transaction, and commits it.
+// It returns the result of the last query.
+func (tpc *TwoPC) execInTx(ctx context.Context, queries ...string) *mproto.QueryResult {
+	transactionID := tpc.qe.txPool.Begin(ctx)
+	conn := tpc.qe.txPool.Get(transactionID)
+	var result *mproto.QueryResult
+	for _, query := range queries {
+		var err error
+		if result, err = conn.Exec(ctx, query, 1, false); err != nil {
+			conn.Recycle()
+			tpc.qe.txPool.Rollback(ctx, transactionID)
+			panic(err)
+		}
+	}
+	conn.Recycle()
+	if _, err := tpc.qe.txPool.SafeCommit(ctx, transactionID); err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (tpc *TwoPC) read(ctx context.Context, query string) *mproto.QueryResult {
+	conn := getOrPanic(ctx, tpc.qe.connPool)
+	defer conn.Recycle()
+	result, err := conn.Exec(ctx, query, int(tpc.qe.maxResultSize.Get()), false)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// redoConn records the statements executed through it in the
+// redo log of its transaction. This includes the selects issued
+// for DMLs with subqueries: replaying them takes the same locks.
+type redoConn struct {
+	*TxConnection
+}
+
+func (rc redoConn) Exec(ctx context.Context, query string, maxrows int, wantfields bool) (*mproto.QueryResult, error) {
+	result, err := rc.TxConnection.Exec(ctx, query, maxrows, wantfields)
+	if err == nil {
+		rc.RecordRedo(query)
+	}
+	return result, err
+}
+
+// buildQuery replaces the %s of query with vals, encoded as
+// sql literals.
+func buildQuery(query string, vals ...interface{}) string {
+	encoded := make([]interface{}, len(vals))
+	for i, val := range vals {
+		encoded[i] = encodeValue(val)
+	}
+	return fmt.Sprintf(query, encoded...)
+}
+
+func encodeValue(val interface{}) string {
+	buf := &bytes.Buffer{}
+	if err := sqlparser.EncodeValue(buf, val); err != nil {
+		panic(NewTabletError(ErrFail, "could not encode %v: %v", val, err))
+	}
+	return buf.String()
+}
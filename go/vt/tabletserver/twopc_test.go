@@ -0,0 +1,338 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vttest/fakesqldb"
+	"golang.org/x/net/context"
+)
+
+const testRedoStatement = "update test_table set name = 2 where pk = 1"
+
+var testTwoPCTime = time.Unix(1000, 0)
+
+// newTestTwoPC opens the two-phase commit services of a new
+// SqlQuery. transactions and statements are the redo log it
+// recovers.
+func newTestTwoPC(db *fakesqldb.DB, transactions, statements *mproto.QueryResult) (*SqlQuery, *TwoPC) {
+	for _, query := range twoPCSchema {
+		db.AddQuery(query, &mproto.QueryResult{})
+	}
+	db.AddQuery(sqlReadRedoTransactions, transactions)
+	db.AddQuery(sqlReadRedoStatements, statements)
+	sqlQuery := newTestSQLQuery(context.Background(), noFlags)
+	tpc := sqlQuery.qe.twoPC
+	tpc.abandonAge = 0
+	tpc.now = func() time.Time { return testTwoPCTime }
+	tpc.Open(&sqldb.ConnParams{})
+	return sqlQuery, tpc
+}
+
+// rowsAffected returns the result of a DML that affected n rows.
+func rowsAffected(n int) *mproto.QueryResult {
+	return &mproto.QueryResult{
+		RowsAffected: uint64(n),
+		Rows:         make([][]sqltypes.Value, n),
+	}
+}
+
+func textRow(vals ...string) []sqltypes.Value {
+	row := make([]sqltypes.Value, len(vals))
+	for i, val := range vals {
+		row[i] = sqltypes.MakeString([]byte(val))
+	}
+	return row
+}
+
+// prepareTestTransaction begins a transaction that executed
+// testRedoStatement, and prepares it as dtid.
+func prepareTestTransaction(t *testing.T, db *fakesqldb.DB, sqlQuery *SqlQuery, dtid string) int64 {
+	transactionID := newTransaction(sqlQuery)
+	conn := sqlQuery.qe.txPool.Get(transactionID)
+	conn.RecordRedo(testRedoStatement)
+	conn.Recycle()
+
+	insertTransaction := buildQuery(sqlInsertRedoTransaction, dtid, redoStatePrepared, testTwoPCTime.UnixNano())
+	insertStatements := fmt.Sprintf(sqlInsertRedoStatements, encodeValue([]interface{}{dtid, int64(1), testRedoStatement}))
+	db.AddQuery(insertTransaction, &mproto.QueryResult{})
+	db.AddQuery(insertStatements, &mproto.QueryResult{})
+	sqlQuery.qe.twoPC.Prepare(context.Background(), transactionID, dtid)
+	for _, query := range []string{insertTransaction, insertStatements} {
+		if got := db.GetQueryCalledNum(query); got != 1 {
+			t.Fatalf("%s was executed %d times, want 1", query, got)
+		}
+	}
+	return transactionID
+}
+
+func TestTwoPCPrepare(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	sqlQuery, tpc := newTestTwoPC(db, &mproto.QueryResult{}, &mproto.QueryResult{})
+	defer sqlQuery.disallowQueries()
+
+	transactionID := prepareTestTransaction(t, db, sqlQuery, "dtid0")
+	conn := tpc.prepared["dtid0"]
+	if conn == nil || conn.TransactionID != transactionID {
+		t.Fatalf("prepared[dtid0]: %v, want transaction %d", conn, transactionID)
+	}
+	if _, err := sqlQuery.qe.txPool.activePool.Get(transactionID, "for test"); err == nil {
+		t.Errorf("transaction %d is still in the active pool after Prepare", transactionID)
+	}
+
+	transactionID = newTransaction(sqlQuery)
+	testUtils := newTestUtils()
+	func() {
+		defer testUtils.checkTabletErrorWithRecover(t, ErrFail, "transaction dtid0 is already prepared")
+		tpc.Prepare(context.Background(), transactionID, "dtid0")
+	}()
+	sqlQuery.qe.txPool.Rollback(context.Background(), transactionID)
+}
+
+func TestTwoPCCommitPrepared(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	sqlQuery, tpc := newTestTwoPC(db, &mproto.QueryResult{}, &mproto.QueryResult{})
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	transactionID := prepareTestTransaction(t, db, sqlQuery, "dtid0")
+	deletes := []string{
+		buildQuery(sqlDeleteRedoTransaction, "dtid0"),
+		buildQuery(sqlDeleteRedoStatements, "dtid0"),
+	}
+	for _, query := range deletes {
+		db.AddQuery(query, &mproto.QueryResult{})
+	}
+	begins := db.GetQueryCalledNum("begin")
+	commits := db.GetQueryCalledNum("commit")
+	tpc.CommitPrepared(ctx, newSqlQueryStats("CommitPrepared", ctx), "dtid0")
+
+	// The redo log must be deleted by the prepared transaction
+	// itself, not by a new one.
+	for _, query := range deletes {
+		if got := db.GetQueryCalledNum(query); got != 1 {
+			t.Errorf("%s was executed %d times, want 1", query, got)
+		}
+	}
+	if got := db.GetQueryCalledNum("begin"); got != begins {
+		t.Errorf("CommitPrepared began %d transactions, want 0", got-begins)
+	}
+	if got := db.GetQueryCalledNum("commit"); got != commits+1 {
+		t.Errorf("CommitPrepared committed %d transactions, want 1", got-commits)
+	}
+	if _, ok := tpc.prepared["dtid0"]; ok {
+		t.Errorf("dtid0 is still prepared after CommitPrepared")
+	}
+	if _, err := sqlQuery.qe.txPool.activePool.Get(transactionID, "for test"); err == nil {
+		t.Errorf("transaction %d is still open after CommitPrepared", transactionID)
+	}
+
+	// Committing again is a no-op.
+	tpc.CommitPrepared(ctx, newSqlQueryStats("CommitPrepared", ctx), "dtid0")
+	if got := db.GetQueryCalledNum("commit"); got != commits+1 {
+		t.Errorf("second CommitPrepared committed %d transactions, want 0", got-commits-1)
+	}
+}
+
+func TestTwoPCCommitPreparedFail(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	sqlQuery, tpc := newTestTwoPC(db, &mproto.QueryResult{}, &mproto.QueryResult{})
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	prepareTestTransaction(t, db, sqlQuery, "dtid0")
+	db.AddRejectedQuery(buildQuery(sqlDeleteRedoTransaction, "dtid0"))
+	func() {
+		defer func() {
+			if x := recover(); x == nil {
+				t.Errorf("CommitPrepared: no error, want the error of the redo log delete")
+			}
+		}()
+		tpc.CommitPrepared(ctx, newSqlQueryStats("CommitPrepared", ctx), "dtid0")
+	}()
+	if !tpc.failed["dtid0"] {
+		t.Errorf("dtid0 is not marked as failed after its commit failed")
+	}
+
+	testUtils := newTestUtils()
+	func() {
+		defer testUtils.checkTabletErrorWithRecover(t, ErrFail, "prepared transaction dtid0 failed")
+		tpc.CommitPrepared(ctx, newSqlQueryStats("CommitPrepared", ctx), "dtid0")
+	}()
+}
+
+func TestTwoPCRecoverPrepared(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	transactions := &mproto.QueryResult{
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeString([]byte("dtid0")), sqltypes.MakeNumeric([]byte("1"))},
+			{sqltypes.MakeString([]byte("dtid1")), sqltypes.MakeNumeric([]byte("1"))},
+			{sqltypes.MakeString([]byte("dtid2")), sqltypes.MakeNumeric([]byte("0"))},
+		},
+	}
+	failedStatement := "update test_table set name = 3 where pk = 2"
+	statements := &mproto.QueryResult{
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{
+			textRow("dtid0", testRedoStatement),
+			textRow("dtid1", failedStatement),
+		},
+	}
+	db.AddQuery(testRedoStatement, &mproto.QueryResult{})
+	db.AddRejectedQuery(failedStatement)
+	markFailed := buildQuery(sqlUpdateRedoState, redoStateFailed, "dtid1")
+	db.AddQuery(markFailed, &mproto.QueryResult{})
+
+	sqlQuery, tpc := newTestTwoPC(db, transactions, statements)
+	defer sqlQuery.disallowQueries()
+
+	conn := tpc.prepared["dtid0"]
+	if conn == nil {
+		t.Fatalf("dtid0 was not prepared again")
+	}
+	if want := []string{testRedoStatement}; !reflect.DeepEqual(conn.RedoStatements, want) {
+		t.Errorf("RedoStatements of dtid0: %v, want %v", conn.RedoStatements, want)
+	}
+	if _, ok := tpc.prepared["dtid1"]; ok {
+		t.Errorf("dtid1 was prepared again, but its replay failed")
+	}
+	if want := map[string]bool{"dtid1": true, "dtid2": true}; !reflect.DeepEqual(tpc.failed, want) {
+		t.Errorf("failed: %v, want %v", tpc.failed, want)
+	}
+	if got := db.GetQueryCalledNum(markFailed); got != 1 {
+		t.Errorf("%s was executed %d times, want 1", markFailed, got)
+	}
+}
+
+type fakeParticipantConn struct {
+	name  string
+	calls *[]string
+}
+
+func (conn *fakeParticipantConn) CommitPrepared(ctx context.Context, dtid string) error {
+	*conn.calls = append(*conn.calls, "CommitPrepared "+conn.name+" "+dtid)
+	return nil
+}
+
+func (conn *fakeParticipantConn) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	*conn.calls = append(*conn.calls, fmt.Sprintf("RollbackPrepared %s %s %d", conn.name, dtid, originalID))
+	return nil
+}
+
+func (conn *fakeParticipantConn) Close() {}
+
+func TestTwoPCResolve(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	sqlQuery, tpc := newTestTwoPC(db, &mproto.QueryResult{}, &mproto.QueryResult{})
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	var calls []string
+	defer func(dialer func(context.Context, string, string) (ParticipantConn, error)) {
+		ParticipantDialer = dialer
+	}(ParticipantDialer)
+	ParticipantDialer = func(ctx context.Context, keyspace, shard string) (ParticipantConn, error) {
+		return &fakeParticipantConn{name: keyspace + "/" + shard, calls: &calls}, nil
+	}
+	participants := &mproto.QueryResult{
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{
+			textRow("ks", "-80"),
+			textRow("ks", "80-"),
+		},
+	}
+
+	testCases := []struct {
+		dtid  string
+		state int64
+		want  []string
+	}{{
+		dtid:  "dtid0",
+		state: dtStatePrepare,
+		want: []string{
+			"RollbackPrepared ks/-80 dtid0 0",
+			"RollbackPrepared ks/80- dtid0 0",
+		},
+	}, {
+		dtid:  "dtid1",
+		state: dtStateCommit,
+		want: []string{
+			"CommitPrepared ks/-80 dtid1",
+			"CommitPrepared ks/80- dtid1",
+		},
+	}}
+	for _, tcase := range testCases {
+		calls = nil
+		db.AddQuery(buildQuery(sqlTransitionDTState, dtStateRollback, tcase.dtid, dtStatePrepare), rowsAffected(1))
+		db.AddQuery(buildQuery(sqlReadDTParticipants, tcase.dtid), participants)
+		deletes := []string{
+			buildQuery(sqlDeleteDTState, tcase.dtid),
+			buildQuery(sqlDeleteDTParticipants, tcase.dtid),
+		}
+		for _, query := range deletes {
+			db.AddQuery(query, rowsAffected(1))
+		}
+		if err := tpc.resolve(ctx, tcase.dtid, tcase.state); err != nil {
+			t.Errorf("resolve(%s, %d): %v", tcase.dtid, tcase.state, err)
+			continue
+		}
+		if !reflect.DeepEqual(calls, tcase.want) {
+			t.Errorf("resolve(%s, %d) calls: %v, want %v", tcase.dtid, tcase.state, calls, tcase.want)
+		}
+		for _, query := range deletes {
+			if got := db.GetQueryCalledNum(query); got != 1 {
+				t.Errorf("%s was executed %d times, want 1", query, got)
+			}
+		}
+	}
+	if transition := buildQuery(sqlTransitionDTState, dtStateRollback, "dtid1", dtStatePrepare); db.GetQueryCalledNum(transition) != 0 {
+		t.Errorf("resolve of a committed transaction executed %s", transition)
+	}
+
+	// If StartCommit wins the race, the resolver leaves the
+	// transaction alone.
+	calls = nil
+	db.AddQuery(buildQuery(sqlTransitionDTState, dtStateRollback, "dtid2", dtStatePrepare), rowsAffected(0))
+	readParticipants := buildQuery(sqlReadDTParticipants, "dtid2")
+	db.AddQuery(readParticipants, participants)
+	if err := tpc.resolve(ctx, "dtid2", dtStatePrepare); err != nil {
+		t.Errorf("resolve(dtid2): %v", err)
+	}
+	if calls != nil || db.GetQueryCalledNum(readParticipants) != 0 {
+		t.Errorf("resolve(dtid2) resolved a transaction that was committed: %v", calls)
+	}
+}
+
+func TestTwoPCStartCommit(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	sqlQuery, tpc := newTestTwoPC(db, &mproto.QueryResult{}, &mproto.QueryResult{})
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	db.AddQuery(buildQuery(sqlTransitionDTState, dtStateCommit, "dtid0", dtStatePrepare), rowsAffected(1))
+	commits := db.GetQueryCalledNum("commit")
+	tpc.StartCommit(ctx, newSqlQueryStats("StartCommit", ctx), newTransaction(sqlQuery), "dtid0")
+	if got := db.GetQueryCalledNum("commit"); got != commits+1 {
+		t.Errorf("StartCommit committed %d transactions, want 1", got-commits)
+	}
+
+	// The resolver already rolled back dtid1.
+	db.AddQuery(buildQuery(sqlTransitionDTState, dtStateCommit, "dtid1", dtStatePrepare), rowsAffected(0))
+	transactionID := newTransaction(sqlQuery)
+	testUtils := newTestUtils()
+	func() {
+		defer testUtils.checkTabletErrorWithRecover(t, ErrFail, "could not commit transaction dtid1: it was not found or already resolved")
+		tpc.StartCommit(ctx, newSqlQueryStats("StartCommit", ctx), transactionID, "dtid1")
+	}()
+	sqlQuery.qe.txPool.Rollback(ctx, transactionID)
+}
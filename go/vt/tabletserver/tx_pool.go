@@ -179,7 +179,20 @@ func (axp *TxPool) SafeCommit(ctx context.Context, transactionID int64) (invalid
 
 // Rollback rolls back the specified transaction.
 func (axp *TxPool) Rollback(ctx context.Context, transactionID int64) {
-	conn := axp.Get(transactionID)
+	axp.rollback(ctx, axp.Get(transactionID))
+}
+
+// RollbackIfActive rolls back the specified transaction if it's
+// still in the pool. Otherwise, it's a no-op.
+func (axp *TxPool) RollbackIfActive(ctx context.Context, transactionID int64) {
+	v, err := axp.activePool.Get(transactionID, "for rollback")
+	if err != nil {
+		return
+	}
+	axp.rollback(ctx, v.(*TxConnection))
+}
+
+func (axp *TxPool) rollback(ctx context.Context, conn *TxConnection) {
 	defer conn.discard(TxRollback)
 	axp.txStats.Add("Aborted", time.Now().Sub(conn.StartTime))
 	if _, err := conn.Exec(ctx, "rollback", 1, false); err != nil {
@@ -198,6 +211,24 @@ func (axp *TxPool) Get(transactionID int64) (conn *TxConnection) {
 	return v.(*TxConnection)
 }
 
+// Detach removes the transaction from the active pool without
+// ending it. The transaction killer and WaitForEmpty ignore
+// detached transactions. The caller takes ownership of the
+// connection, and must Attach it back before committing or
+// rolling it back.
+func (axp *TxPool) Detach(transactionID int64) *TxConnection {
+	conn := axp.Get(transactionID)
+	axp.activePool.Unregister(transactionID)
+	return conn
+}
+
+// Attach puts a detached transaction back in the active pool.
+func (axp *TxPool) Attach(conn *TxConnection) {
+	if err := axp.activePool.Register(conn.TransactionID, conn); err != nil {
+		panic(NewTabletError(ErrFail, "Transaction %d: %v", conn.TransactionID, err))
+	}
+}
+
 // LogActive causes all existing transactions to be logged when they complete.
 // The logging is throttled to no more than once every txLogInterval.
 func (axp *TxPool) LogActive() {
@@ -248,8 +279,11 @@ type TxConnection struct {
 	EndTime       time.Time
 	dirtyTables   map[string]DirtyKeys
 	Queries       []string
-	Conclusion    string
-	LogToFile     sync2.AtomicInt32
+	// RedoStatements are the DMLs of the transaction. They're saved
+	// in the redo log if the transaction gets prepared.
+	RedoStatements []string
+	Conclusion     string
+	LogToFile      sync2.AtomicInt32
 }
 
 func newTxConnection(conn *DBConn, transactionID int64, pool *TxPool) *TxConnection {
@@ -302,6 +336,12 @@ func (txc *TxConnection) RecordQuery(query string) {
 	txc.Queries = append(txc.Queries, query)
 }
 
+// RecordRedo records a DML that must be replayed if
+// the transaction is recovered after being prepared.
+func (txc *TxConnection) RecordRedo(query string) {
+	txc.RedoStatements = append(txc.RedoStatements, query)
+}
+
 func (txc *TxConnection) discard(conclusion string) {
 	txc.Conclusion = conclusion
 	txc.EndTime = time.Now()
@@ -312,8 +312,30 @@ type Server interface {
 type Schemafier interface {
 	SaveVSchema(context.Context, string) error
 	GetVSchema(ctx context.Context) (string, error)
+
+	// WatchVSchema returns a channel that receives notifications
+	// every time the vschema changes. It should receive a notification
+	// with the initial value fairly quickly after this is set. If the
+	// vschema doesn't exist, the notification contains "{}". To stop
+	// watching, close the stopWatching channel. Like WatchEndPoints,
+	// the implementation should retry on a regular basis if it
+	// encounters an error watching the node.
+	WatchVSchema(ctx context.Context) (notifications <-chan *VSchemaInfo, stopWatching chan<- struct{}, err error)
+}
+
+// VSchemaInfo is a JSON vschema, along with the version of
+// the topo node it was read from.
+type VSchemaInfo struct {
+	VSchema string
+	// Version is NoVSchemaVersion if the vschema doesn't exist.
+	Version int64
 }
 
+// NoVSchemaVersion is the Version of a VSchemaInfo for a vschema
+// that doesn't exist. It's distinct from the version of any node
+// that exists, so that its creation is seen as a change.
+const NoVSchemaVersion = -1
+
 // Registry for Server implementations.
 var serverImpls = make(map[string]Server)
 
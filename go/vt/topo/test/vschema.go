@@ -62,3 +62,73 @@ func CheckVSchema(ctx context.Context, t *testing.T, ts topo.Server) {
 		t.Errorf("SaveVSchema: %v, must start with %s", err, want)
 	}
 }
+
+// CheckWatchVSchema makes sure WatchVSchema works as expected
+func CheckWatchVSchema(ctx context.Context, t *testing.T, ts topo.Server) {
+	schemafier, ok := ts.(topo.Schemafier)
+	if !ok {
+		t.Errorf("%T is not a Schemafier", ts)
+		return
+	}
+
+	// start watching, should get the empty vschema first
+	notifications, stopWatching, err := schemafier.WatchVSchema(ctx)
+	if err != nil {
+		t.Fatalf("WatchVSchema failed: %v", err)
+	}
+	info, ok := <-notifications
+	if !ok || info.VSchema != "{}" {
+		t.Fatalf("first value is wrong: %v %v", info, ok)
+	}
+
+	// save the vschema, should get a notification
+	want := `{ "Keyspaces": { "aa": { "Sharded": false}}}`
+	if err := schemafier.SaveVSchema(ctx, want); err != nil {
+		t.Fatalf("SaveVSchema failed: %v", err)
+	}
+	var version int64
+	for {
+		info, ok := <-notifications
+		if !ok {
+			t.Fatalf("watch channel is closed???")
+		}
+		if info.VSchema == "{}" {
+			// duplicate notification of the first value, that's OK
+			continue
+		}
+		if info.VSchema != want {
+			t.Fatalf("value after save is wrong: %v", info)
+		}
+		version = info.Version
+		break
+	}
+
+	// save it again, a bit different, should get a new version
+	want = `{ "Keyspaces": { "bb": { "Sharded": false}}}`
+	if err := schemafier.SaveVSchema(ctx, want); err != nil {
+		t.Fatalf("SaveVSchema failed: %v", err)
+	}
+	for {
+		info, ok := <-notifications
+		if !ok {
+			t.Fatalf("watch channel is closed???")
+		}
+		if info.Version == version {
+			// duplicate notification of the previous value, that's OK
+			continue
+		}
+		if info.VSchema != want {
+			t.Fatalf("value after second save is wrong: %v", info)
+		}
+		break
+	}
+
+	// close the stopWatching channel, should eventually get a closed
+	// notifications channel too
+	close(stopWatching)
+	for {
+		if _, ok := <-notifications; !ok {
+			break
+		}
+	}
+}
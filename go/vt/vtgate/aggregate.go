@@ -0,0 +1,689 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/youtube/vitess/go/mysql/collation"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// avgScaleIncrement is the number of decimal digits added
+// to the scale of a decimal sum when computing an average.
+// This is MySQL's default div_precision_increment.
+const avgScaleIncrement = 4
+
+// aggregator combines the partial aggregates returned by
+// the shards for a SelectAggregate plan.
+type aggregator struct {
+	plan     *planbuilder.Plan
+	bindVars map[string]interface{}
+	// offset and count are the resolved LIMIT values.
+	// A negative count means no limit.
+	offset, count int64
+	// fields are the fields returned by the shards.
+	fields []mproto.Field
+	// ops contains the function used to combine
+	// each column returned by the shards.
+	ops    []planbuilder.AggregateOpcode
+	groups map[string][]sqltypes.Value
+	// keys preserves the order in which the groups were seen.
+	keys []string
+}
+
+func newAggregator(plan *planbuilder.Plan, bindVars map[string]interface{}) (*aggregator, error) {
+	ag := &aggregator{
+		plan:     plan,
+		bindVars: bindVars,
+		count:    -1,
+		groups:   make(map[string][]sqltypes.Value),
+	}
+	var err error
+	if plan.Limit != nil {
+		if ag.count, err = resolveLimit(plan.Limit, bindVars); err != nil {
+			return nil, err
+		}
+	}
+	if plan.Offset != nil {
+		if ag.offset, err = resolveLimit(plan.Offset, bindVars); err != nil {
+			return nil, err
+		}
+	}
+	return ag, nil
+}
+
+// Add combines the rows of a shard result with
+// the ones already received.
+func (ag *aggregator) Add(qr *mproto.QueryResult) error {
+	if ag.fields == nil && len(qr.Fields) != 0 {
+		if err := ag.setFields(qr.Fields); err != nil {
+			return err
+		}
+	}
+	for _, row := range qr.Rows {
+		if len(row) != len(ag.fields) {
+			return fmt.Errorf("unexpected number of columns in row: %d, want %d", len(row), len(ag.fields))
+		}
+		key := ag.groupKey(row)
+		group, ok := ag.groups[key]
+		if !ok {
+			group = make([]sqltypes.Value, len(row))
+			copy(group, row)
+			ag.groups[key] = group
+			ag.keys = append(ag.keys, key)
+			continue
+		}
+		for i, op := range ag.ops {
+			var err error
+			if group[i], err = combineValues(op, ag.fields[i], group[i], row[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ag *aggregator) setFields(fields []mproto.Field) error {
+	ops := make([]planbuilder.AggregateOpcode, len(fields))
+	for _, aggr := range ag.plan.Aggregates {
+		last := aggr.Col
+		if aggr.Opcode == planbuilder.AggregateAvg {
+			last++
+		}
+		if last >= len(fields) {
+			return fmt.Errorf("column %s not found in result", aggr.Name)
+		}
+		if aggr.Opcode == planbuilder.AggregateAvg {
+			ops[aggr.Col] = planbuilder.AggregateSum
+			ops[aggr.Col+1] = planbuilder.AggregateCount
+			continue
+		}
+		ops[aggr.Col] = aggr.Opcode
+	}
+	ag.fields = fields
+	ag.ops = ops
+	return nil
+}
+
+// groupKey builds a key that uniquely identifies the
+// group a row belongs to.
+func (ag *aggregator) groupKey(row []sqltypes.Value) string {
+	var buf bytes.Buffer
+	var lenbuf [binary.MaxVarintLen64]byte
+	for _, col := range ag.plan.GroupBy {
+		if row[col].IsNull() {
+			buf.WriteByte(0)
+			continue
+		}
+		buf.WriteByte(1)
+		key := valueKey(ag.fields[col], row[col])
+		buf.Write(lenbuf[:binary.PutUvarint(lenbuf[:], uint64(len(key)))])
+		buf.Write(key)
+	}
+	return buf.String()
+}
+
+// valueKey returns a key for a non-NULL value of field. Two values
+// have the same key if MySQL considers them equal: numbers are
+// compared by value, and strings with their collation.
+func valueKey(field mproto.Field, val sqltypes.Value) []byte {
+	switch field.Type {
+	case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG, mproto.VT_INT24, mproto.VT_LONGLONG, mproto.VT_YEAR,
+		mproto.VT_FLOAT, mproto.VT_DOUBLE, mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		if r, ok := new(big.Rat).SetString(val.String()); ok {
+			return []byte(r.RatString())
+		}
+	}
+	if isCollated(field) {
+		return collation.Key(val.Raw())
+	}
+	return val.Raw()
+}
+
+// Result returns the final result after applying
+// HAVING, ORDER BY and LIMIT to the aggregated rows.
+func (ag *aggregator) Result() (*mproto.QueryResult, error) {
+	if ag.fields == nil {
+		return &mproto.QueryResult{}, nil
+	}
+	fields := make([]mproto.Field, len(ag.plan.Aggregates))
+	for i, aggr := range ag.plan.Aggregates {
+		fields[i] = ag.fields[aggr.Col]
+		fields[i].Name = aggr.Name
+		if aggr.Opcode == planbuilder.AggregateAvg {
+			fields[i].Type = avgType(ag.fields[aggr.Col])
+		}
+	}
+	rows := make([][]sqltypes.Value, 0, len(ag.keys))
+	for _, key := range ag.keys {
+		row, err := ag.finalRow(ag.groups[key])
+		if err != nil {
+			return nil, err
+		}
+		if ag.plan.Having != nil {
+			match, err := ag.evalHaving(fields, row)
+			if err != nil {
+				return nil, err
+			}
+			if !match {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := ag.sort(fields, rows); err != nil {
+		return nil, err
+	}
+	if ag.offset >= int64(len(rows)) {
+		rows = nil
+	} else {
+		rows = rows[ag.offset:]
+	}
+	if ag.count >= 0 && ag.count < int64(len(rows)) {
+		rows = rows[:ag.count]
+	}
+	for i, row := range rows {
+		rows[i] = row[:ag.plan.ResultColumns]
+	}
+	return &mproto.QueryResult{
+		Fields:       fields[:ag.plan.ResultColumns],
+		Rows:         rows,
+		RowsAffected: uint64(len(rows)),
+	}, nil
+}
+
+func (ag *aggregator) finalRow(group []sqltypes.Value) ([]sqltypes.Value, error) {
+	row := make([]sqltypes.Value, len(ag.plan.Aggregates))
+	for i, aggr := range ag.plan.Aggregates {
+		if aggr.Opcode != planbuilder.AggregateAvg {
+			row[i] = group[aggr.Col]
+			continue
+		}
+		var err error
+		row[i], err = divideValues(ag.fields[aggr.Col], group[aggr.Col], ag.fields[aggr.Col+1], group[aggr.Col+1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// sort sorts the rows by the order by columns. Like MySQL,
+// rows are implicitly sorted by the group by columns.
+func (ag *aggregator) sort(fields []mproto.Field, rows [][]sqltypes.Value) error {
+	var cols []int
+	var desc []bool
+	for _, groupCol := range ag.plan.GroupBy {
+		for i, aggr := range ag.plan.Aggregates {
+			if aggr.Opcode == planbuilder.AggregateGroupBy && aggr.Col == groupCol {
+				cols = append(cols, i)
+				desc = append(desc, false)
+				break
+			}
+		}
+	}
+	if len(ag.plan.OrderBy) != 0 {
+		cols = cols[:0]
+		desc = desc[:0]
+	}
+	for _, order := range ag.plan.OrderBy {
+		col := -1
+		for i, aggr := range ag.plan.Aggregates {
+			if aggr.Name == order.Col {
+				col = i
+				break
+			}
+		}
+		if col == -1 {
+			return fmt.Errorf("order by column %s not found in result", order.Col)
+		}
+		cols = append(cols, col)
+		desc = append(desc, order.Desc)
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	sort.Stable(&rowSorter{
+		rows: rows,
+		less: func(row1, row2 []sqltypes.Value) bool {
+			for i, col := range cols {
+				cmp := compareValues(fields[col], row1[col], row2[col])
+				if cmp == 0 {
+					continue
+				}
+				if desc[i] {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+			return false
+		},
+	})
+	return nil
+}
+
+type rowSorter struct {
+	rows [][]sqltypes.Value
+	less func(row1, row2 []sqltypes.Value) bool
+}
+
+func (rs *rowSorter) Len() int           { return len(rs.rows) }
+func (rs *rowSorter) Swap(i, j int)      { rs.rows[i], rs.rows[j] = rs.rows[j], rs.rows[i] }
+func (rs *rowSorter) Less(i, j int) bool { return rs.less(rs.rows[i], rs.rows[j]) }
+
+// combineValues combines two partial aggregates of a column.
+func combineValues(op planbuilder.AggregateOpcode, field mproto.Field, v1, v2 sqltypes.Value) (sqltypes.Value, error) {
+	switch op {
+	case planbuilder.AggregateCount, planbuilder.AggregateSum:
+		return addValues(field, v1, v2)
+	case planbuilder.AggregateMin:
+		if compareNonNull(field, v2, v1) < 0 {
+			return v2, nil
+		}
+	case planbuilder.AggregateMax:
+		if compareNonNull(field, v2, v1) > 0 {
+			return v2, nil
+		}
+	}
+	return v1, nil
+}
+
+// compareNonNull is like compareValues, except that NULL
+// values sort last. This makes MIN and MAX ignore them.
+func compareNonNull(field mproto.Field, v1, v2 sqltypes.Value) int {
+	switch {
+	case v1.IsNull() && v2.IsNull():
+		return 0
+	case v1.IsNull():
+		return 1
+	case v2.IsNull():
+		return -1
+	}
+	return compareValues(field, v1, v2)
+}
+
+// addValues adds two numeric values of the specified field.
+// NULL values are ignored.
+func addValues(field mproto.Field, v1, v2 sqltypes.Value) (sqltypes.Value, error) {
+	switch {
+	case v1.IsNull():
+		return v2, nil
+	case v2.IsNull():
+		return v1, nil
+	}
+	switch field.Type {
+	case mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		return addDecimals(v1, v2)
+	}
+	n1, err := mproto.Convert(field, v1)
+	if err != nil {
+		return sqltypes.Value{}, err
+	}
+	n2, err := mproto.Convert(field, v2)
+	if err != nil {
+		return sqltypes.Value{}, err
+	}
+	switch n1 := n1.(type) {
+	case int64:
+		return sqltypes.MakeNumeric(strconv.AppendInt(nil, n1+n2.(int64), 10)), nil
+	case uint64:
+		return sqltypes.MakeNumeric(strconv.AppendUint(nil, n1+n2.(uint64), 10)), nil
+	case float64:
+		return sqltypes.MakeFractional(strconv.AppendFloat(nil, n1+n2.(float64), 'g', -1, 64)), nil
+	}
+	return sqltypes.Value{}, fmt.Errorf("cannot add non-numeric values: %s, %s", v1, v2)
+}
+
+func addDecimals(v1, v2 sqltypes.Value) (sqltypes.Value, error) {
+	r1, ok := new(big.Rat).SetString(v1.String())
+	if !ok {
+		return sqltypes.Value{}, fmt.Errorf("invalid decimal value: %s", v1)
+	}
+	r2, ok := new(big.Rat).SetString(v2.String())
+	if !ok {
+		return sqltypes.Value{}, fmt.Errorf("invalid decimal value: %s", v2)
+	}
+	scale := decimalScale(v1.String())
+	if s := decimalScale(v2.String()); s > scale {
+		scale = s
+	}
+	return sqltypes.MakeFractional([]byte(r1.Add(r1, r2).FloatString(scale))), nil
+}
+
+// decimalScale returns the number of digits after
+// the decimal point.
+func decimalScale(s string) int {
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// avgType returns the type of an average computed
+// from a sum of the specified field.
+func avgType(sumField mproto.Field) int64 {
+	switch sumField.Type {
+	case mproto.VT_FLOAT, mproto.VT_DOUBLE:
+		return mproto.VT_DOUBLE
+	}
+	return mproto.VT_NEWDECIMAL
+}
+
+// divideValues computes the average from a sum and a count.
+// The result is NULL if there were no values.
+func divideValues(sumField mproto.Field, sum sqltypes.Value, countField mproto.Field, count sqltypes.Value) (sqltypes.Value, error) {
+	if sum.IsNull() || count.IsNull() {
+		return sqltypes.NULL, nil
+	}
+	rcount, ok := new(big.Rat).SetString(count.String())
+	if !ok {
+		return sqltypes.Value{}, fmt.Errorf("invalid count value: %s", count)
+	}
+	if rcount.Sign() == 0 {
+		return sqltypes.NULL, nil
+	}
+	if avgType(sumField) == mproto.VT_DOUBLE {
+		f, err := strconv.ParseFloat(sum.String(), 64)
+		if err != nil {
+			return sqltypes.Value{}, err
+		}
+		c, _ := rcount.Float64()
+		return sqltypes.MakeFractional(strconv.AppendFloat(nil, f/c, 'g', -1, 64)), nil
+	}
+	rsum, ok := new(big.Rat).SetString(sum.String())
+	if !ok {
+		return sqltypes.Value{}, fmt.Errorf("invalid sum value: %s", sum)
+	}
+	avg := rsum.Quo(rsum, rcount)
+	return sqltypes.MakeFractional([]byte(avg.FloatString(decimalScale(sum.String()) + avgScaleIncrement))), nil
+}
+
+// evalHaving evaluates the Having clause of the plan against
+// an aggregated row. A NULL result is treated as false.
+func (ag *aggregator) evalHaving(fields []mproto.Field, row []sqltypes.Value) (bool, error) {
+	bindVars := make(map[string]interface{}, len(ag.bindVars)+len(row))
+	for k, v := range ag.bindVars {
+		bindVars[k] = v
+	}
+	for i, val := range row {
+		v, err := convertValue(fields[i], val)
+		if err != nil {
+			return false, err
+		}
+		bindVars[planbuilder.HavingVarPrefix+strconv.Itoa(i)] = v
+	}
+	result, err := evalBool(ag.plan.Having, bindVars)
+	if err != nil {
+		return false, fmt.Errorf("having: %v", err)
+	}
+	return result == boolTrue, nil
+}
+
+// boolResult is the result of evaluating a boolean
+// expression using SQL's three-valued logic.
+type boolResult int
+
+const (
+	boolFalse = boolResult(iota)
+	boolTrue
+	boolNull
+)
+
+func evalBool(node sqlparser.BoolExpr, bindVars map[string]interface{}) (boolResult, error) {
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		left, err := evalBool(node.Left, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		right, err := evalBool(node.Right, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		switch {
+		case left == boolFalse || right == boolFalse:
+			return boolFalse, nil
+		case left == boolNull || right == boolNull:
+			return boolNull, nil
+		}
+		return boolTrue, nil
+	case *sqlparser.OrExpr:
+		left, err := evalBool(node.Left, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		right, err := evalBool(node.Right, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		switch {
+		case left == boolTrue || right == boolTrue:
+			return boolTrue, nil
+		case left == boolNull || right == boolNull:
+			return boolNull, nil
+		}
+		return boolFalse, nil
+	case *sqlparser.NotExpr:
+		result, err := evalBool(node.Expr, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		switch result {
+		case boolTrue:
+			return boolFalse, nil
+		case boolFalse:
+			return boolTrue, nil
+		}
+		return boolNull, nil
+	case *sqlparser.ParenBoolExpr:
+		return evalBool(node.Expr, bindVars)
+	case *sqlparser.NullCheck:
+		val, err := evalValue(node.Expr, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		if (val == nil) == (node.Operator == sqlparser.AST_IS_NULL) {
+			return boolTrue, nil
+		}
+		return boolFalse, nil
+	case *sqlparser.ComparisonExpr:
+		left, err := evalValue(node.Left, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		right, err := evalValue(node.Right, bindVars)
+		if err != nil {
+			return boolNull, err
+		}
+		if node.Operator == sqlparser.AST_NSE {
+			if left == nil || right == nil {
+				return toBoolResult(left == nil && right == nil), nil
+			}
+			return toBoolResult(compareInterfaces(left, right) == 0), nil
+		}
+		if left == nil || right == nil {
+			return boolNull, nil
+		}
+		cmp := compareInterfaces(left, right)
+		switch node.Operator {
+		case sqlparser.AST_EQ:
+			return toBoolResult(cmp == 0), nil
+		case sqlparser.AST_NE:
+			return toBoolResult(cmp != 0), nil
+		case sqlparser.AST_LT:
+			return toBoolResult(cmp < 0), nil
+		case sqlparser.AST_LE:
+			return toBoolResult(cmp <= 0), nil
+		case sqlparser.AST_GT:
+			return toBoolResult(cmp > 0), nil
+		case sqlparser.AST_GE:
+			return toBoolResult(cmp >= 0), nil
+		}
+		return boolNull, fmt.Errorf("unsupported operator: %s", node.Operator)
+	}
+	return boolNull, fmt.Errorf("unsupported construct: %s", sqlparser.String(node))
+}
+
+func toBoolResult(b bool) boolResult {
+	if b {
+		return boolTrue
+	}
+	return boolFalse
+}
+
+// evalValue returns the value of an expression as nil,
+// int64, uint64, float64 or []byte.
+func evalValue(node sqlparser.ValExpr, bindVars map[string]interface{}) (interface{}, error) {
+	switch node := node.(type) {
+	case sqlparser.StrVal:
+		return []byte(node), nil
+	case sqlparser.NumVal:
+		return parseNumber(string(node))
+	case *sqlparser.NullVal:
+		return nil, nil
+	case sqlparser.ValArg:
+		name := string(node[1:])
+		val, ok := bindVars[name]
+		if !ok {
+			return nil, fmt.Errorf("could not find bind var %s", node)
+		}
+		switch val := val.(type) {
+		case nil, int64, uint64, float64, []byte:
+			return val, nil
+		case int:
+			return int64(val), nil
+		case int32:
+			return int64(val), nil
+		case uint32:
+			return uint64(val), nil
+		case float32:
+			return float64(val), nil
+		case string:
+			return []byte(val), nil
+		case sqltypes.Value:
+			if val.IsNull() {
+				return nil, nil
+			}
+			if val.IsNumeric() || val.IsFractional() {
+				return parseNumber(val.String())
+			}
+			return val.Raw(), nil
+		}
+		return nil, fmt.Errorf("unexpected type for bind var %s: %T", name, val)
+	}
+	return nil, fmt.Errorf("unsupported construct: %s", sqlparser.String(node))
+}
+
+// convertValue converts a result value to the
+// representation used by evalValue.
+func convertValue(field mproto.Field, val sqltypes.Value) (interface{}, error) {
+	switch field.Type {
+	case mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		if val.IsNull() {
+			return nil, nil
+		}
+		return strconv.ParseFloat(val.String(), 64)
+	}
+	return mproto.Convert(field, val)
+}
+
+func parseNumber(s string) (interface{}, error) {
+	if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return n, nil
+	}
+	if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return n, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// compareInterfaces compares two non-nil values returned by evalValue.
+// Numbers are compared numerically, and strings are compared byte-wise.
+// When a string is compared with a number, it's converted to a number.
+func compareInterfaces(v1, v2 interface{}) int {
+	b1, ok1 := v1.([]byte)
+	b2, ok2 := v2.([]byte)
+	switch {
+	case ok1 && ok2:
+		return bytes.Compare(b1, b2)
+	case ok1:
+		v1 = stringToNumber(b1)
+	case ok2:
+		v2 = stringToNumber(b2)
+	}
+	switch n1 := v1.(type) {
+	case int64:
+		switch n2 := v2.(type) {
+		case int64:
+			switch {
+			case n1 < n2:
+				return -1
+			case n1 > n2:
+				return 1
+			}
+			return 0
+		case uint64:
+			if n1 < 0 {
+				return -1
+			}
+			return compareUints(uint64(n1), n2)
+		}
+	case uint64:
+		switch n2 := v2.(type) {
+		case int64:
+			if n2 < 0 {
+				return 1
+			}
+			return compareUints(n1, uint64(n2))
+		case uint64:
+			return compareUints(n1, n2)
+		}
+	}
+	return compareFloats(toFloat(v1), toFloat(v2))
+}
+
+func compareUints(n1, n2 uint64) int {
+	switch {
+	case n1 < n2:
+		return -1
+	case n1 > n2:
+		return 1
+	}
+	return 0
+}
+
+// stringToNumber converts a string to a number
+// like MySQL does: invalid values become 0.
+func stringToNumber(b []byte) interface{} {
+	n, err := parseNumber(strings.TrimSpace(string(b)))
+	if err != nil {
+		return float64(0)
+	}
+	return n
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
@@ -32,12 +32,16 @@ type Balancer struct {
 	getEndPoints       GetEndPointsFunc
 	retryDelay         time.Duration
 	resetDownConnDelay time.Duration
+	// health is nil unless the health policy is used.
+	health *healthPolicy
 }
 
 type addressStatus struct {
 	endPoint  *pb.EndPoint
 	timeRetry time.Time
 	balancer  *Balancer
+	// health is only set by the health policy.
+	health *endPointHealth
 }
 
 // NewBalancer creates a Balancer. getAddresses is the function
@@ -113,6 +117,9 @@ func (blc *Balancer) refresh() error {
 					endPoint: endPoint,
 					balancer: blc,
 				}
+				if blc.health != nil {
+					addrNode.health = blc.health.watch(endPoint)
+				}
 				blc.addressNodes = append(blc.addressNodes, addrNode)
 			} else {
 				blc.addressNodes[index].endPoint = endPoint
@@ -123,6 +130,7 @@ func (blc *Balancer) refresh() error {
 	i := 0
 	for i < len(blc.addressNodes) {
 		if index := findAddress(endPoints, blc.addressNodes[i].endPoint.Uid); index == -1 {
+			blc.addressNodes[i].health.stop()
 			blc.addressNodes = delAddrNode(blc.addressNodes, i)
 			continue
 		}
@@ -134,7 +142,12 @@ func (blc *Balancer) refresh() error {
 	// Sort endpoints by timeRetry (from ZERO to largest)
 	sort.Sort(AddressList(blc.addressNodes))
 	// Randomize endpoints with ZERO timeRetry
-	shuffle(blc.addressNodes, findFirstAddrNodeNonZeroTimeRetry(blc.addressNodes))
+	available := findFirstAddrNodeNonZeroTimeRetry(blc.addressNodes)
+	if blc.health != nil {
+		blc.health.order(blc.addressNodes[:available])
+	} else {
+		shuffle(blc.addressNodes, available)
+	}
 	return nil
 }
 
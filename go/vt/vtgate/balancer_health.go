@@ -0,0 +1,397 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+// This file implements the health policy of the Balancer.
+// Each end point is watched through its StreamHealth stream,
+// or through the HealthCheck that discovered it, and the
+// latency of the queries sent to it is measured.
+// Healthy end points are used in a random order weighted
+// by their latency and cpu usage. End points that lag too
+// much, and unhealthy ones, are only used as a last resort.
+
+const (
+	// balancerPolicyShuffle uses the end points in a random order.
+	balancerPolicyShuffle = "shuffle"
+	// balancerPolicyHealth prefers the fastest and least loaded end points.
+	balancerPolicyHealth = "health"
+)
+
+var (
+	balancerPolicy      = flag.String("balancer_policy", balancerPolicyShuffle, "policy used to pick replica and rdonly tablets: shuffle uses them in a random order, health prefers the tablets with the lowest latency and cpu usage, and avoids the ones that lag")
+	balancerMaxLag      = flag.Duration("balancer_max_lag", 30*time.Second, "with -balancer_policy health, tablets that are more than this far behind the master are only used if no other tablet is available")
+	balancerHealthRetry = flag.Duration("balancer_health_retry", 5*time.Second, "with -balancer_policy health, delay before reopening a failed StreamHealth stream")
+)
+
+// latencyDecay is the weight of the previous value
+// in the moving average of the latency.
+const latencyDecay = 0.8
+
+// healthPolicy contains the settings of the health policy of a Balancer.
+type healthPolicy struct {
+	keyspace    string
+	shard       string
+	maxLag      time.Duration
+	retryDelay  time.Duration
+	connTimeout time.Duration
+
+	// healthCheck is set if it watches the end points of cell.
+	// Their health is then read from it, instead of from
+	// streams of their own.
+	healthCheck *HealthCheck
+	cell        string
+}
+
+// endPointHealth is the health of an end point, as reported
+// by its StreamHealth stream and measured by the queries.
+type endPointHealth struct {
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// stats is nil until the first health response.
+	stats     *pbq.RealtimeStats
+	lastError error
+	// latency is zero until the first query.
+	latency time.Duration
+	// source is set if the health is read from a HealthCheck.
+	// It returns the stats and the stream error of the end
+	// point, which replace stats and lastError.
+	source func() (*pbq.RealtimeStats, error)
+}
+
+// UseHealth switches the Balancer to the health policy. It must
+// be called before the first Get. If serv is a HealthCheck that
+// watches cell, the health of the end points is read from it.
+func (blc *Balancer) UseHealth(serv SrvTopoServer, cell, keyspace, shard string, connTimeout time.Duration) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	blc.health = &healthPolicy{
+		keyspace:    keyspace,
+		shard:       shard,
+		maxLag:      *balancerMaxLag,
+		retryDelay:  *balancerHealthRetry,
+		connTimeout: connTimeout,
+	}
+	if hc, ok := serv.(*HealthCheck); ok && hc.cells[cell] {
+		blc.health.healthCheck = hc
+		blc.health.cell = cell
+	}
+}
+
+// UsesHealth returns true if the Balancer uses the health policy.
+func (blc *Balancer) UsesHealth() bool {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	return blc.health != nil
+}
+
+// RecordLatency records the latency of a query sent to an end point.
+// It's only used by the health policy.
+func (blc *Balancer) RecordLatency(uid uint32, latency time.Duration) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	if blc.health == nil {
+		return
+	}
+	if index := findAddrNode(blc.addressNodes, uid); index != -1 {
+		blc.addressNodes[index].health.recordLatency(latency)
+	}
+}
+
+// ShouldReplace returns true if the end point is lagging or unhealthy,
+// and a healthy end point is available instead.
+func (blc *Balancer) ShouldReplace(uid uint32) bool {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	if blc.health == nil {
+		return false
+	}
+	index := findAddrNode(blc.addressNodes, uid)
+	if index == -1 || blc.health.isHealthy(blc.addressNodes[index].health) {
+		return false
+	}
+	for _, addrNode := range blc.addressNodes {
+		if addrNode.timeRetry.IsZero() && blc.health.isHealthy(addrNode.health) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops watching the health of the end points. The
+// Balancer falls back to the shuffle policy afterwards.
+func (blc *Balancer) Close() {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	for _, addrNode := range blc.addressNodes {
+		addrNode.health.stop()
+		addrNode.health = nil
+	}
+	blc.health = nil
+}
+
+// watch starts watching the health of endPoint.
+func (hp *healthPolicy) watch(endPoint *pb.EndPoint) *endPointHealth {
+	if hp.healthCheck != nil {
+		return &endPointHealth{
+			cancel: func() {},
+			source: func() (*pbq.RealtimeStats, error) {
+				return hp.healthCheck.realtimeStats(hp.cell, endPoint)
+			},
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	eph := &endPointHealth{cancel: cancel}
+	go func() {
+		for {
+			eph.setError(hp.stream(ctx, endPoint, eph))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(hp.retryDelay):
+			}
+		}
+	}()
+	return eph
+}
+
+// stream reads the StreamHealth stream of endPoint until it fails.
+func (hp *healthPolicy) stream(ctx context.Context, endPoint *pb.EndPoint, eph *endPointHealth) error {
+	conn, err := tabletconn.GetDialer()(ctx, endPoint, hp.keyspace, hp.shard, pb.TabletType_UNKNOWN, hp.connTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	responses, errFunc, err := conn.StreamHealth(ctx)
+	if err != nil {
+		return err
+	}
+	for response := range responses {
+		eph.setStats(response.RealtimeStats)
+	}
+	if err := errFunc(); err != nil {
+		return err
+	}
+	return fmt.Errorf("health stream closed")
+}
+
+// isHealthy returns true if the end point is healthy, and doesn't lag.
+// End points without health information yet are considered healthy.
+func (hp *healthPolicy) isHealthy(eph *endPointHealth) bool {
+	status := eph.status()
+	return status.HealthError == "" && status.Lag <= hp.maxLag
+}
+
+// order sorts the available addrNodes: healthy ones first, in a
+// random order weighted by their latency and cpu usage, then the
+// lagging ones by increasing lag, then the unhealthy ones.
+func (hp *healthPolicy) order(addrNodes []*addressStatus) {
+	var healthy, lagging, unhealthy []*addressStatus
+	statuses := make(map[*addressStatus]*EndPointStatus, len(addrNodes))
+	for _, addrNode := range addrNodes {
+		status := addrNode.health.status()
+		statuses[addrNode] = status
+		switch {
+		case status.HealthError != "":
+			unhealthy = append(unhealthy, addrNode)
+		case status.Lag > hp.maxLag:
+			lagging = append(lagging, addrNode)
+		default:
+			healthy = append(healthy, addrNode)
+		}
+	}
+	weights := hp.weights(healthy, statuses)
+	weightedShuffle(healthy, weights)
+	sort.Sort(byLag{lagging, statuses})
+	n := copy(addrNodes, healthy)
+	n += copy(addrNodes[n:], lagging)
+	copy(addrNodes[n:], unhealthy)
+}
+
+// weights returns the weights of the healthy addrNodes, normalized
+// so that they add up to 1. The weight of an end point is inversely
+// proportional to its latency and to its cpu usage. End points that
+// were not measured yet get the average latency.
+func (hp *healthPolicy) weights(healthy []*addressStatus, statuses map[*addressStatus]*EndPointStatus) []float64 {
+	var total time.Duration
+	measured := 0
+	for _, addrNode := range healthy {
+		if latency := statuses[addrNode].Latency; latency != 0 {
+			total += latency
+			measured++
+		}
+	}
+	defaultLatency := time.Millisecond
+	if measured != 0 {
+		defaultLatency = total / time.Duration(measured)
+	}
+	weights := make([]float64, len(healthy))
+	sum := 0.0
+	for i, addrNode := range healthy {
+		status := statuses[addrNode]
+		latency := status.Latency
+		if latency == 0 {
+			latency = defaultLatency
+		}
+		weights[i] = 1 / (latency.Seconds() * (1 + status.CPUUsage))
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return weights
+}
+
+// weightedShuffle shuffles addrNodes so that the probability for
+// an addrNode to come first is its weight.
+func weightedShuffle(addrNodes []*addressStatus, weights []float64) {
+	for i := range addrNodes {
+		remaining := 0.0
+		for _, w := range weights[i:] {
+			remaining += w
+		}
+		pick := len(addrNodes) - 1
+		r := rand.Float64() * remaining
+		for j := i; j < len(addrNodes); j++ {
+			r -= weights[j]
+			if r < 0 {
+				pick = j
+				break
+			}
+		}
+		addrNodes[i], addrNodes[pick] = addrNodes[pick], addrNodes[i]
+		weights[i], weights[pick] = weights[pick], weights[i]
+	}
+}
+
+// byLag sorts addressStatus by increasing lag.
+type byLag struct {
+	addrNodes []*addressStatus
+	statuses  map[*addressStatus]*EndPointStatus
+}
+
+func (bl byLag) Len() int {
+	return len(bl.addrNodes)
+}
+
+func (bl byLag) Swap(i, j int) {
+	bl.addrNodes[i], bl.addrNodes[j] = bl.addrNodes[j], bl.addrNodes[i]
+}
+
+func (bl byLag) Less(i, j int) bool {
+	return bl.statuses[bl.addrNodes[i]].Lag < bl.statuses[bl.addrNodes[j]].Lag
+}
+
+func (eph *endPointHealth) setStats(stats *pbq.RealtimeStats) {
+	eph.mu.Lock()
+	defer eph.mu.Unlock()
+	eph.stats = stats
+	eph.lastError = nil
+}
+
+func (eph *endPointHealth) setError(err error) {
+	eph.mu.Lock()
+	defer eph.mu.Unlock()
+	eph.stats = nil
+	eph.lastError = err
+}
+
+func (eph *endPointHealth) recordLatency(latency time.Duration) {
+	eph.mu.Lock()
+	defer eph.mu.Unlock()
+	if eph.latency == 0 {
+		eph.latency = latency
+		return
+	}
+	eph.latency = time.Duration(latencyDecay*float64(eph.latency) + (1-latencyDecay)*float64(latency))
+}
+
+// status returns the health of the end point. It's safe
+// to call on a nil endPointHealth.
+func (eph *endPointHealth) status() *EndPointStatus {
+	status := &EndPointStatus{}
+	if eph == nil {
+		return status
+	}
+	eph.mu.Lock()
+	defer eph.mu.Unlock()
+	status.Latency = eph.latency
+	stats, lastError := eph.stats, eph.lastError
+	if eph.source != nil {
+		stats, lastError = eph.source()
+	}
+	if lastError != nil {
+		status.HealthError = fmt.Sprintf("health stream: %v", lastError)
+	}
+	if stats != nil {
+		if stats.HealthError != "" {
+			status.HealthError = stats.HealthError
+		}
+		status.Lag = time.Duration(stats.SecondsBehindMaster) * time.Second
+		status.CPUUsage = stats.CpuUsage
+	}
+	return status
+}
+
+func (eph *endPointHealth) stop() {
+	if eph != nil {
+		eph.cancel()
+	}
+}
+
+// EndPointStatus is the status of an end point in a Balancer.
+// It's used to display the balancing on the status page.
+type EndPointStatus struct {
+	EndPoint    *pb.EndPoint
+	MarkedDown  bool
+	HealthError string
+	Lag         time.Duration
+	CPUUsage    float64
+	Latency     time.Duration
+	// Weight is the share of the connections that go to this end point.
+	Weight float64
+}
+
+// Status returns the status of the end points of the Balancer, in
+// the order of its last Get. The weights are only computed by the
+// health policy.
+func (blc *Balancer) Status() []*EndPointStatus {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	result := make([]*EndPointStatus, 0, len(blc.addressNodes))
+	var healthy []*addressStatus
+	statuses := make(map[*addressStatus]*EndPointStatus, len(blc.addressNodes))
+	for _, addrNode := range blc.addressNodes {
+		status := addrNode.health.status()
+		status.EndPoint = addrNode.endPoint
+		status.MarkedDown = !addrNode.timeRetry.IsZero()
+		statuses[addrNode] = status
+		result = append(result, status)
+		if blc.health != nil && !status.MarkedDown && blc.health.isHealthy(addrNode.health) {
+			healthy = append(healthy, addrNode)
+		}
+	}
+	if len(healthy) != 0 {
+		for i, w := range blc.health.weights(healthy, statuses) {
+			statuses[healthy[i]].Weight = w
+		}
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+func healthAddrNode(uid uint32, stats *pbq.RealtimeStats, latency time.Duration) *addressStatus {
+	return &addressStatus{
+		endPoint: &pb.EndPoint{Uid: uid},
+		health: &endPointHealth{
+			stats:   stats,
+			latency: latency,
+		},
+	}
+}
+
+func TestHealthOrder(t *testing.T) {
+	hp := &healthPolicy{maxLag: 30 * time.Second}
+	for i := 0; i < 10; i++ {
+		addrNodes := []*addressStatus{
+			healthAddrNode(0, &pbq.RealtimeStats{HealthError: "bad"}, 0),
+			healthAddrNode(1, &pbq.RealtimeStats{SecondsBehindMaster: 100}, 0),
+			healthAddrNode(2, &pbq.RealtimeStats{SecondsBehindMaster: 1}, 0),
+			healthAddrNode(3, &pbq.RealtimeStats{SecondsBehindMaster: 40}, 0),
+			healthAddrNode(4, nil, 0),
+		}
+		hp.order(addrNodes)
+		got := make([]uint32, 0, len(addrNodes))
+		for _, addrNode := range addrNodes {
+			got = append(got, addrNode.endPoint.Uid)
+		}
+		// 2 and 4 are healthy, in any order.
+		if (got[0] != 2 || got[1] != 4) && (got[0] != 4 || got[1] != 2) {
+			t.Errorf("order: %v, want 2 and 4 first", got)
+		}
+		if got[2] != 3 || got[3] != 1 || got[4] != 0 {
+			t.Errorf("order: %v, want [... 3 1 0]", got)
+		}
+	}
+}
+
+func TestHealthWeights(t *testing.T) {
+	hp := &healthPolicy{maxLag: 30 * time.Second}
+	healthy := []*addressStatus{
+		healthAddrNode(0, nil, 1*time.Millisecond),
+		healthAddrNode(1, nil, 3*time.Millisecond),
+		healthAddrNode(2, &pbq.RealtimeStats{CpuUsage: 1}, 1*time.Millisecond),
+		// Not measured yet: gets the average latency.
+		healthAddrNode(3, nil, 0),
+	}
+	statuses := make(map[*addressStatus]*EndPointStatus)
+	for _, addrNode := range healthy {
+		statuses[addrNode] = addrNode.health.status()
+	}
+	got := hp.weights(healthy, statuses)
+	// Inverse latencies: 1, 1/3, 1/2 and 3/5.
+	sum := 1 + 1.0/3 + 1.0/2 + 3.0/5
+	want := []float64{1 / sum, 1.0 / 3 / sum, 1.0 / 2 / sum, 3.0 / 5 / sum}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-6 {
+			t.Errorf("weights: %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestHealthWeightedShuffle(t *testing.T) {
+	first := make(map[uint32]int)
+	for i := 0; i < 1000; i++ {
+		addrNodes := []*addressStatus{
+			{endPoint: &pb.EndPoint{Uid: 0}},
+			{endPoint: &pb.EndPoint{Uid: 1}},
+		}
+		weightedShuffle(addrNodes, []float64{0.9, 0.1})
+		first[addrNodes[0].endPoint.Uid]++
+	}
+	if first[0] < 800 || first[1] < 50 {
+		t.Errorf("first: %v, want about 900 and 100", first)
+	}
+}
+
+func TestHealthRecordLatency(t *testing.T) {
+	eph := &endPointHealth{}
+	eph.recordLatency(10 * time.Millisecond)
+	if eph.latency != 10*time.Millisecond {
+		t.Errorf("latency: %v, want 10ms", eph.latency)
+	}
+	eph.recordLatency(20 * time.Millisecond)
+	if eph.latency != 12*time.Millisecond {
+		t.Errorf("latency: %v, want 12ms", eph.latency)
+	}
+}
+
+func TestShardConnHealthPolicy(t *testing.T) {
+	*balancerPolicy = balancerPolicyHealth
+	defer func() { *balancerPolicy = balancerPolicyShuffle }()
+	s := createSandbox("TestShardConnHealthPolicy")
+	sbcGood := &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+	sbcLagging := &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+	s.MapTestConn("0", sbcGood)
+	s.MapTestConn("0", sbcLagging)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnHealthPolicy", "0", topo.TYPE_REPLICA, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+
+	// The first query starts the health watchers.
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	sbcGood.healthResponses <- &pbq.StreamHealthResponse{RealtimeStats: &pbq.RealtimeStats{SecondsBehindMaster: 1}}
+	sbcLagging.healthResponses <- &pbq.StreamHealthResponse{RealtimeStats: &pbq.RealtimeStats{SecondsBehindMaster: 3600}}
+	lagging := sbcLagging.EndPoint().Uid
+	for start := time.Now(); ; {
+		if sdc.balancer.ShouldReplace(lagging) {
+			break
+		}
+		if time.Now().Sub(start) > 5*time.Second {
+			t.Fatalf("health of %v was not received", lagging)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// This query may still go to the lagging end point,
+	// but then the connection is replaced.
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	execCount := sbcLagging.ExecCount.Get()
+	for i := 0; i < 10; i++ {
+		if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sbcLagging.ExecCount.Get(); got != execCount {
+		t.Errorf("lagging end point got %v queries, want 0", got-execCount)
+	}
+
+	statuses := sdc.balancer.Status()
+	if len(statuses) != 2 {
+		t.Fatalf("Status: %v, want 2 end points", statuses)
+	}
+	for _, status := range statuses {
+		wantWeight := 1.0
+		if status.EndPoint.Uid == lagging {
+			wantWeight = 0
+		}
+		if status.Weight != wantWeight {
+			t.Errorf("Weight of %v: %v, want %v", status.EndPoint.Uid, status.Weight, wantWeight)
+		}
+	}
+}
+
+func TestShardConnHealthPolicyHealthCheck(t *testing.T) {
+	*balancerPolicy = balancerPolicyHealth
+	defer func() { *balancerPolicy = balancerPolicyShuffle }()
+	keyspace := "TestShardConnHealthPolicyHealthCheck"
+	s := createSandbox(keyspace)
+	sbcGood := &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+	sbcLagging := &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+	s.MapTestConn("0", sbcGood)
+	s.MapTestConn("0", sbcLagging)
+	ht := &healthCheckTopo{tablets: []*pb.Tablet{
+		healthCheckTablet(keyspace, sbcGood),
+		healthCheckTablet(keyspace, sbcLagging),
+	}}
+	hc := NewHealthCheck(new(sandboxTopo), ht, []string{"aa"}, time.Hour, time.Hour, connTimeoutPerConn)
+	defer func() {
+		hc.Close()
+		close(sbcGood.healthResponses)
+		close(sbcLagging.healthResponses)
+	}()
+	good := healthResponse(keyspace, pb.TabletType_REPLICA, "", 0)
+	good.RealtimeStats.SecondsBehindMaster = 1
+	sbcGood.healthResponses <- good
+	lagging := healthResponse(keyspace, pb.TabletType_REPLICA, "", 0)
+	lagging.RealtimeStats.SecondsBehindMaster = 3600
+	sbcLagging.healthResponses <- lagging
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_REPLICA, []uint32{sbcGood.EndPoint().Uid, sbcLagging.EndPoint().Uid}); err != nil {
+		t.Fatal(err)
+	}
+
+	sdc := NewShardConn(context.Background(), hc, "aa", keyspace, "0", topo.TYPE_REPLICA, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The lag is read from the streams of the HealthCheck.
+	if !sdc.balancer.ShouldReplace(sbcLagging.EndPoint().Uid) {
+		t.Errorf("ShouldReplace(%v): false, want true", sbcLagging.EndPoint().Uid)
+	}
+	if sdc.balancer.ShouldReplace(sbcGood.EndPoint().Uid) {
+		t.Errorf("ShouldReplace(%v): true, want false", sbcGood.EndPoint().Uid)
+	}
+	for _, sbc := range []*sandboxConn{sbcGood, sbcLagging} {
+		if got := sbc.StreamHealthCount.Get(); got != 1 {
+			t.Errorf("StreamHealth of %v was called %d times, want 1", sbc.EndPoint().Uid, got)
+		}
+	}
+}
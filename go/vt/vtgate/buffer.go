@@ -0,0 +1,163 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+var (
+	enableBuffer              = flag.Bool("enable_buffer", false, "hold the master requests of a shard while its master fails over, and replay them on the new master")
+	bufferWindow              = flag.Duration("buffer_window", 10*time.Second, "with -enable_buffer, maximum time a request is held before it fails")
+	bufferSize                = flag.Int("buffer_size", 10, "with -enable_buffer, maximum number of requests held per shard. Requests beyond this fail right away")
+	bufferMaxFailoverDuration = flag.Duration("buffer_max_failover_duration", 20*time.Second, "with -enable_buffer, stop holding requests if a failover lasts longer than this")
+
+	// bufferFailovers counts the failovers detected per shard.
+	bufferFailovers = stats.NewMultiCounters("VtgateBufferFailovers", []string{"Keyspace", "ShardName"})
+	// bufferRequests counts the outcome of the buffered requests.
+	bufferRequests = stats.NewMultiCounters("VtgateBufferRequests", []string{"Keyspace", "ShardName", "Outcome"})
+)
+
+// bufferProbeInterval is how often masterBuffer checks
+// if the new master of the shard is available.
+const bufferProbeInterval = 100 * time.Millisecond
+
+// masterBuffer holds the master requests of a shard while its master
+// fails over. A failover starts when the master stops serving, and
+// ends when probe finds a new master. The requests that arrive in
+// between wait until the failover ends, and are replayed.
+type masterBuffer struct {
+	keyspace            string
+	shard               string
+	size                int
+	window              time.Duration
+	maxFailoverDuration time.Duration
+	probeInterval       time.Duration
+	// probe returns true if the shard has a master other than oldMaster.
+	// oldMaster is nil if it's unknown.
+	probe func(oldMaster *pb.EndPoint) bool
+	// onEnd is called when a new master was found.
+	onEnd func()
+
+	mu sync.Mutex
+	// failoverStart is zero if there is no failover in progress.
+	failoverStart time.Time
+	oldMaster     *pb.EndPoint
+	// done is closed when the failover ends.
+	done     chan struct{}
+	buffered int
+}
+
+func newMasterBuffer(keyspace, shard string, probe func(*pb.EndPoint) bool, onEnd func()) *masterBuffer {
+	return &masterBuffer{
+		keyspace:            keyspace,
+		shard:               shard,
+		size:                *bufferSize,
+		window:              *bufferWindow,
+		maxFailoverDuration: *bufferMaxFailoverDuration,
+		probeInterval:       bufferProbeInterval,
+		probe:               probe,
+		onEnd:               onEnd,
+	}
+}
+
+// startFailover records that oldMaster stopped serving.
+// It does nothing if a failover is already in progress.
+func (mb *masterBuffer) startFailover(oldMaster *pb.EndPoint, reason error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if !mb.failoverStart.IsZero() {
+		return
+	}
+	log.Infof("Master failover detected for %v/%v, buffering requests: %v", mb.keyspace, mb.shard, reason)
+	bufferFailovers.Add([]string{mb.keyspace, mb.shard}, 1)
+	mb.failoverStart = time.Now()
+	mb.oldMaster = oldMaster
+	mb.done = make(chan struct{})
+	go mb.watchFailover(mb.failoverStart, oldMaster)
+}
+
+// watchFailover probes the shard until a new master is found,
+// or the failover lasts longer than maxFailoverDuration.
+func (mb *masterBuffer) watchFailover(failoverStart time.Time, oldMaster *pb.EndPoint) {
+	ticker := time.NewTicker(mb.probeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if mb.probe(oldMaster) {
+			log.Infof("Master failover of %v/%v ended after %v, replaying requests", mb.keyspace, mb.shard, time.Now().Sub(failoverStart))
+			mb.onEnd()
+			mb.endFailover()
+			return
+		}
+		if time.Now().Sub(failoverStart) > mb.maxFailoverDuration {
+			log.Warningf("Master failover of %v/%v lasts longer than %v, not buffering anymore", mb.keyspace, mb.shard, mb.maxFailoverDuration)
+			mb.endFailover()
+			return
+		}
+	}
+}
+
+func (mb *masterBuffer) endFailover() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	close(mb.done)
+	mb.failoverStart = time.Time{}
+	mb.oldMaster = nil
+	mb.done = nil
+}
+
+// inFailover returns true if a failover is in progress.
+func (mb *masterBuffer) inFailover() bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return !mb.failoverStart.IsZero()
+}
+
+// wait blocks while a failover is in progress. It returns nil if
+// there is no failover, or when it ended. It returns an error if
+// the buffer is full, or the failover didn't end within the window.
+func (mb *masterBuffer) wait(ctx context.Context) error {
+	mb.mu.Lock()
+	if mb.failoverStart.IsZero() {
+		mb.mu.Unlock()
+		return nil
+	}
+	if mb.buffered >= mb.size {
+		mb.mu.Unlock()
+		bufferRequests.Add([]string{mb.keyspace, mb.shard, "BufferFull"}, 1)
+		return fmt.Errorf("master failover in progress for %v/%v, and the buffer is full", mb.keyspace, mb.shard)
+	}
+	mb.buffered++
+	done := mb.done
+	mb.mu.Unlock()
+	defer func() {
+		mb.mu.Lock()
+		mb.buffered--
+		mb.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(mb.window)
+	defer timer.Stop()
+	select {
+	case <-done:
+		bufferRequests.Add([]string{mb.keyspace, mb.shard, "Replayed"}, 1)
+		return nil
+	case <-timer.C:
+		bufferRequests.Add([]string{mb.keyspace, mb.shard, "WindowExceeded"}, 1)
+		return fmt.Errorf("master failover in progress for %v/%v did not end within %v", mb.keyspace, mb.shard, mb.window)
+	case <-ctx.Done():
+		bufferRequests.Add([]string{mb.keyspace, mb.shard, "ContextDone"}, 1)
+		return fmt.Errorf("master failover in progress for %v/%v: %v", mb.keyspace, mb.shard, ctx.Err())
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+func waitForFailover(mb *masterBuffer, want bool) error {
+	for start := time.Now(); time.Now().Sub(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if mb.inFailover() == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("inFailover: %v, want %v", !want, want)
+}
+
+func TestMasterBuffer(t *testing.T) {
+	newMaster := make(chan bool, 1)
+	ended := false
+	mb := newMasterBuffer("ks", "0", func(*pb.EndPoint) bool {
+		select {
+		case <-newMaster:
+			return true
+		default:
+			return false
+		}
+	}, func() { ended = true })
+	mb.size = 1
+	mb.probeInterval = time.Millisecond
+
+	// No failover: nothing is buffered.
+	if err := mb.wait(context.Background()); err != nil {
+		t.Errorf("wait: %v, want nil", err)
+	}
+
+	mb.startFailover(&pb.EndPoint{Uid: 1}, fmt.Errorf("retry: err"))
+	result := make(chan error)
+	go func() {
+		result <- mb.wait(context.Background())
+	}()
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		mb.mu.Lock()
+		buffered := mb.buffered
+		mb.mu.Unlock()
+		if buffered == 1 {
+			break
+		}
+		if time.Now().Sub(start) > 5*time.Second {
+			t.Fatalf("request was not buffered")
+		}
+	}
+
+	// The buffer is full.
+	err := mb.wait(context.Background())
+	want := "master failover in progress for ks/0, and the buffer is full"
+	if err == nil || err.Error() != want {
+		t.Errorf("wait: %v, want %v", err, want)
+	}
+
+	newMaster <- true
+	if err := <-result; err != nil {
+		t.Errorf("wait: %v, want nil", err)
+	}
+	if err := waitForFailover(mb, false); err != nil {
+		t.Error(err)
+	}
+	if !ended {
+		t.Errorf("onEnd was not called")
+	}
+}
+
+func TestMasterBufferWindow(t *testing.T) {
+	mb := newMasterBuffer("ks", "0", func(*pb.EndPoint) bool { return false }, func() {})
+	mb.window = 10 * time.Millisecond
+	mb.maxFailoverDuration = 50 * time.Millisecond
+	mb.probeInterval = time.Millisecond
+
+	mb.startFailover(nil, fmt.Errorf("no valid endpoint"))
+	err := mb.wait(context.Background())
+	want := "master failover in progress for ks/0 did not end within 10ms"
+	if err == nil || err.Error() != want {
+		t.Errorf("wait: %v, want %v", err, want)
+	}
+
+	// The failover is abandoned after maxFailoverDuration.
+	if err := waitForFailover(mb, false); err != nil {
+		t.Error(err)
+	}
+	if err := mb.wait(context.Background()); err != nil {
+		t.Errorf("wait: %v, want nil", err)
+	}
+}
+
+func TestShardConnBufferFailover(t *testing.T) {
+	*enableBuffer = true
+	defer func() { *enableBuffer = false }()
+	s := createSandbox("TestShardConnBufferFailover")
+	oldMaster := &sandboxConn{mustFailRetry: 1000}
+	s.MapTestConn("0", oldMaster)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnBufferFailover", "0", topo.TYPE_MASTER, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+	sdc.buffer.probeInterval = time.Millisecond
+
+	result := make(chan error)
+	go func() {
+		_, err := sdc.Execute(context.Background(), "query", nil, 0)
+		result <- err
+	}()
+	if err := waitForFailover(sdc.buffer, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new master appears in the serving graph.
+	newMaster := &sandboxConn{}
+	s.MapTestConn("0", newMaster)
+	s.DeleteTestConn("0", oldMaster)
+	if err := <-result; err != nil {
+		t.Errorf("Execute: %v, want nil", err)
+	}
+	if execCount := newMaster.ExecCount.Get(); execCount != 1 {
+		t.Errorf("new master ExecCount: %v, want 1", execCount)
+	}
+}
+
+func TestShardConnBufferTimeout(t *testing.T) {
+	*enableBuffer = true
+	defer func() { *enableBuffer = false }()
+	s := createSandbox("TestShardConnBufferTimeout")
+	s.MapTestConn("0", &sandboxConn{mustFailRetry: 1000})
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnBufferTimeout", "0", topo.TYPE_MASTER, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+	sdc.buffer.window = 10 * time.Millisecond
+	sdc.buffer.probeInterval = time.Millisecond
+
+	_, err := sdc.Execute(context.Background(), "query", nil, 0)
+	want := "master failover in progress for TestShardConnBufferTimeout/0 did not end within 10ms"
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("Execute: %v, want suffix %v", err, want)
+	}
+
+	// Transactions are not buffered.
+	if _, err := sdc.Execute(context.Background(), "query", nil, 1); err == nil || !strings.HasSuffix(err.Error(), "retry: err") {
+		t.Errorf("Execute in transaction: %v, want retry: err", err)
+	}
+}
+
+func TestShardConnBufferOperationalError(t *testing.T) {
+	*enableBuffer = true
+	defer func() { *enableBuffer = false }()
+	s := createSandbox("TestShardConnBufferOperationalError")
+	sbc := &sandboxConn{mustFailConn: 1}
+	s.MapTestConn("0", sbc)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnBufferOperationalError", "0", topo.TYPE_MASTER, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+
+	// The statement may have been executed before the connection
+	// failed: it must not be buffered and replayed.
+	_, err := sdc.Execute(context.Background(), "query", nil, 0)
+	if err == nil || !strings.HasSuffix(err.Error(), "error: conn") {
+		t.Errorf("Execute: %v, want error: conn", err)
+	}
+	if sdc.buffer.inFailover() {
+		t.Errorf("inFailover: true, want false")
+	}
+	if execCount := sbc.ExecCount.Get(); execCount != 1 {
+		t.Errorf("ExecCount: %v, want 1", execCount)
+	}
+}
+
+func TestIsFailoverError(t *testing.T) {
+	ctx := context.Background()
+	testcases := []struct {
+		err           error
+		connectFailed bool
+		want          bool
+	}{
+		{nil, false, false},
+		{&tabletconn.ServerError{Code: tabletconn.ERR_RETRY, Err: "retry"}, false, true},
+		{&tabletconn.ServerError{Code: tabletconn.ERR_FATAL, Err: "fatal"}, false, true},
+		{&tabletconn.ServerError{Code: tabletconn.ERR_NORMAL, Err: "error"}, false, false},
+		{&tabletconn.ServerError{Code: tabletconn.ERR_TX_POOL_FULL, Err: "tx_pool_full"}, false, false},
+		{tabletconn.OperationalError("conn"), false, false},
+		{fmt.Errorf("no valid endpoint"), true, true},
+	}
+	for _, tcase := range testcases {
+		if got := isFailoverError(ctx, tcase.err, tcase.connectFailed); got != tcase.want {
+			t.Errorf("isFailoverError(%v, %v): %v, want %v", tcase.err, tcase.connectFailed, got, tcase.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/ratelimiter"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vterrors"
+	"golang.org/x/net/context"
+
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+// This file implements the per-caller quotas of vtgate. Unlike
+// -max-in-flight, which is shared by everyone, a quota limits the
+// requests of each effective caller separately, so that a single
+// caller can't starve the others.
+
+var (
+	callerQuotasFile = flag.String("caller_quotas_file", "", "JSON file containing the per-caller quotas of vtgate")
+
+	// quotaRejections counts the requests rejected by the
+	// quotas, by caller, tablet type and exceeded limit.
+	quotaRejections = stats.NewMultiCounters("VtgateQuotaRejections", []string{"Caller", "TabletType", "Limit"})
+)
+
+// CallerQuota limits the requests of the effective callers that
+// match its Principal, Component and TabletType. An empty value
+// matches anything. Every matching caller gets its own bucket for
+// each tablet type, with its own limits. A limit of 0 means no limit.
+type CallerQuota struct {
+	Principal  string
+	Component  string
+	TabletType topo.TabletType
+
+	// MaxQPS is the number of requests a caller can
+	// start in a second.
+	MaxQPS int
+	// MaxConcurrency is the number of requests of a
+	// caller that can run at the same time.
+	MaxConcurrency int64
+}
+
+func (cq *CallerQuota) match(principal, component string, tabletType topo.TabletType) bool {
+	return (cq.Principal == "" || cq.Principal == principal) &&
+		(cq.Component == "" || cq.Component == component) &&
+		(cq.TabletType == "" || cq.TabletType == tabletType)
+}
+
+// quotaBucket holds the usage of a caller on a tablet type.
+type quotaBucket struct {
+	quota       *CallerQuota
+	rateLimiter *ratelimiter.RateLimiter
+	inFlight    sync2.AtomicInt64
+	// lastUsed is protected by the mutex of callerQuotas.
+	lastUsed time.Time
+}
+
+// defaultQuotaIdleTime is how long a bucket is kept
+// after its last request.
+const defaultQuotaIdleTime = 1 * time.Minute
+
+// callerQuotas applies a list of quotas. The first quota that
+// matches a request is the one that applies to it.
+type callerQuotas struct {
+	quotas []*CallerQuota
+	// idleTime is how long a bucket is kept after its last
+	// request. The buckets are created again when needed, so
+	// the callers that come and go don't accumulate.
+	idleTime time.Duration
+
+	mu           sync.Mutex
+	buckets      map[string]*quotaBucket
+	lastEviction time.Time
+}
+
+func newCallerQuotas(quotas []*CallerQuota) *callerQuotas {
+	return &callerQuotas{
+		quotas:   quotas,
+		idleTime: defaultQuotaIdleTime,
+		buckets:  make(map[string]*quotaBucket),
+	}
+}
+
+// loadCallerQuotas reads the quotas from a JSON file. It returns
+// empty quotas if filename is empty.
+func loadCallerQuotas(filename string) (*callerQuotas, error) {
+	if filename == "" {
+		return newCallerQuotas(nil), nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("loadCallerQuotas: %v", err)
+	}
+	var quotas []*CallerQuota
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return nil, fmt.Errorf("loadCallerQuotas: %v: %v", filename, err)
+	}
+	for _, cq := range quotas {
+		if cq.MaxQPS < 0 || cq.MaxConcurrency < 0 {
+			return nil, fmt.Errorf("loadCallerQuotas: %v: negative limit for %+v", filename, *cq)
+		}
+	}
+	return newCallerQuotas(quotas), nil
+}
+
+// acquire checks the quota of the effective caller of ctx for a
+// request on tabletType. If the request is over the quota, it returns
+// a THROTTLED_ERROR. Otherwise, it returns a function that must be
+// called when the request is done.
+func (cqs *callerQuotas) acquire(ctx context.Context, tabletType topo.TabletType) (release func(), err error) {
+	ef := callerid.EffectiveCallerIDFromContext(ctx)
+	principal := callerid.GetPrincipal(ef)
+	component := callerid.GetComponent(ef)
+	bucket := cqs.bucket(principal, component, tabletType)
+	if bucket == nil {
+		return func() {}, nil
+	}
+	limit := ""
+	if bucket.quota.MaxConcurrency != 0 && bucket.inFlight.Add(1) > bucket.quota.MaxConcurrency {
+		bucket.inFlight.Add(-1)
+		limit = "Concurrency"
+	} else if bucket.rateLimiter != nil && !bucket.rateLimiter.Allow() {
+		if bucket.quota.MaxConcurrency != 0 {
+			bucket.inFlight.Add(-1)
+		}
+		limit = "QPS"
+	}
+	if limit != "" {
+		caller := principal + "/" + component
+		quotaRejections.Add([]string{caller, string(tabletType), limit}, 1)
+		return nil, vterrors.FromError(
+			int64(pbv.ErrorCode_THROTTLED_ERROR),
+			fmt.Errorf("caller %s exceeded its %s quota for %s", caller, limit, tabletType),
+		)
+	}
+	return func() {
+		if bucket.quota.MaxConcurrency != 0 {
+			bucket.inFlight.Add(-1)
+		}
+	}, nil
+}
+
+// bucket returns the bucket of a caller on tabletType,
+// or nil if no quota applies.
+func (cqs *callerQuotas) bucket(principal, component string, tabletType topo.TabletType) *quotaBucket {
+	for i, cq := range cqs.quotas {
+		if !cq.match(principal, component, tabletType) {
+			continue
+		}
+		key := fmt.Sprintf("%d/%s/%s/%s", i, principal, component, tabletType)
+		cqs.mu.Lock()
+		defer cqs.mu.Unlock()
+		now := time.Now()
+		cqs.evictIdle(now)
+		bucket, ok := cqs.buckets[key]
+		if !ok {
+			bucket = &quotaBucket{quota: cq}
+			if cq.MaxQPS != 0 {
+				bucket.rateLimiter = ratelimiter.NewRateLimiter(cq.MaxQPS, time.Second)
+			}
+			cqs.buckets[key] = bucket
+		}
+		bucket.lastUsed = now
+		return bucket
+	}
+	return nil
+}
+
+// evictIdle removes the buckets that were not used for idleTime,
+// and have no request in flight. It does nothing if it already
+// ran in the last idleTime. cqs.mu must be held.
+func (cqs *callerQuotas) evictIdle(now time.Time) {
+	if now.Sub(cqs.lastEviction) < cqs.idleTime {
+		return
+	}
+	cqs.lastEviction = now
+	for key, bucket := range cqs.buckets {
+		if now.Sub(bucket.lastUsed) >= cqs.idleTime && bucket.inFlight.Get() == 0 {
+			delete(cqs.buckets, key)
+		}
+	}
+}
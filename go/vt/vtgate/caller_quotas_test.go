@@ -0,0 +1,206 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+func callerContext(principal, component string) context.Context {
+	return callerid.NewContext(context.Background(), callerid.NewEffectiveCallerID(principal, component, ""), nil)
+}
+
+func TestCallerQuotasConcurrency(t *testing.T) {
+	cqs := newCallerQuotas([]*CallerQuota{{
+		Principal:      "batch",
+		TabletType:     topo.TYPE_RDONLY,
+		MaxConcurrency: 1,
+	}, {
+		MaxConcurrency: 2,
+	}})
+	batch := callerContext("batch", "c1")
+
+	release, err := cqs.acquire(batch, topo.TYPE_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cqs.acquire(batch, topo.TYPE_RDONLY)
+	if code := vtgateErrorCode(err); code != int64(pbv.ErrorCode_THROTTLED_ERROR) {
+		t.Errorf("acquire: %v, want throttled error", err)
+	}
+	if want := "caller batch/c1 exceeded its Concurrency quota for rdonly"; err == nil || err.Error() != want {
+		t.Errorf("acquire: %v, want %s", err, want)
+	}
+	if got := quotaRejections.Counts()["batch/c1.rdonly.Concurrency"]; got == 0 {
+		t.Errorf("batch/c1.rdonly.Concurrency rejections: 0, want > 0")
+	}
+
+	// The requests of batch on other tablet types, and the
+	// requests of other callers, use the default quota.
+	for _, ctx := range []context.Context{batch, callerContext("app", "c1")} {
+		for i := 0; i < 2; i++ {
+			if _, err := cqs.acquire(ctx, topo.TYPE_REPLICA); err != nil {
+				t.Errorf("acquire %d: %v", i, err)
+			}
+		}
+		if _, err := cqs.acquire(ctx, topo.TYPE_REPLICA); err == nil {
+			t.Errorf("acquire: nil, want throttled error")
+		}
+	}
+
+	release()
+	release, err = cqs.acquire(batch, topo.TYPE_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestCallerQuotasQPS(t *testing.T) {
+	cqs := newCallerQuotas([]*CallerQuota{{
+		Principal: "app",
+		MaxQPS:    2,
+	}})
+	app := callerContext("app", "")
+	for i := 0; i < 2; i++ {
+		if _, err := cqs.acquire(app, topo.TYPE_MASTER); err != nil {
+			t.Errorf("acquire %d: %v", i, err)
+		}
+	}
+	_, err := cqs.acquire(app, topo.TYPE_MASTER)
+	if want := "caller app/ exceeded its QPS quota for master"; err == nil || err.Error() != want {
+		t.Errorf("acquire: %v, want %s", err, want)
+	}
+	// Callers without a quota are not limited.
+	for i := 0; i < 10; i++ {
+		if _, err := cqs.acquire(context.Background(), topo.TYPE_MASTER); err != nil {
+			t.Errorf("acquire %d: %v", i, err)
+		}
+	}
+}
+
+func TestCallerQuotasTabletTypes(t *testing.T) {
+	cqs := newCallerQuotas([]*CallerQuota{{
+		Principal:      "app",
+		MaxConcurrency: 1,
+	}})
+	app := callerContext("app", "")
+
+	// Each tablet type has its own bucket.
+	for _, tabletType := range []topo.TabletType{topo.TYPE_MASTER, topo.TYPE_REPLICA} {
+		if _, err := cqs.acquire(app, tabletType); err != nil {
+			t.Errorf("acquire %v: %v", tabletType, err)
+		}
+		if _, err := cqs.acquire(app, tabletType); err == nil {
+			t.Errorf("acquire %v: nil, want throttled error", tabletType)
+		}
+	}
+}
+
+func TestCallerQuotasEviction(t *testing.T) {
+	cqs := newCallerQuotas([]*CallerQuota{{
+		MaxConcurrency: 1,
+	}})
+	cqs.idleTime = 10 * time.Millisecond
+	busy, err := cqs.acquire(callerContext("busy", ""), topo.TYPE_MASTER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, principal := range []string{"c1", "c2", "c3"} {
+		release, err := cqs.acquire(callerContext(principal, ""), topo.TYPE_MASTER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if got := len(cqs.buckets); got != 4 {
+		t.Errorf("buckets: %v, want 4", got)
+	}
+
+	// The idle buckets are evicted, but the bucket of
+	// busy is kept, as it has a request in flight.
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cqs.acquire(callerContext("c4", ""), topo.TYPE_MASTER); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(cqs.buckets); got != 2 {
+		t.Errorf("buckets: %v, want 2", got)
+	}
+	if _, err := cqs.acquire(callerContext("busy", ""), topo.TYPE_MASTER); err == nil {
+		t.Errorf("acquire: nil, want throttled error")
+	}
+	busy()
+}
+
+func TestLoadCallerQuotas(t *testing.T) {
+	f, err := ioutil.TempFile("", "caller_quotas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`[{"Principal": "batch", "TabletType": "rdonly", "MaxQPS": 10, "MaxConcurrency": 5}]`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	cqs, err := loadCallerQuotas(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CallerQuota{Principal: "batch", TabletType: topo.TYPE_RDONLY, MaxQPS: 10, MaxConcurrency: 5}
+	if len(cqs.quotas) != 1 || *cqs.quotas[0] != want {
+		t.Errorf("quotas: %+v, want [%+v]", cqs.quotas, want)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte(`[{"MaxConcurrency": -1}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCallerQuotas(f.Name()); err == nil {
+		t.Errorf("loadCallerQuotas: nil, want error for negative limit")
+	}
+}
+
+func TestVTGateCallerQuotas(t *testing.T) {
+	keyspace := "TestVTGateCallerQuotas"
+	sandbox := createSandbox(keyspace)
+	sbc := &sandboxConn{}
+	sandbox.MapTestConn("0", sbc)
+	defer func(quotas *callerQuotas) { rpcVTGate.quotas = quotas }(rpcVTGate.quotas)
+	rpcVTGate.quotas = newCallerQuotas([]*CallerQuota{{
+		Principal: "app",
+		MaxQPS:    1,
+	}})
+
+	q := proto.QueryShard{
+		Sql:        "query",
+		Keyspace:   keyspace,
+		Shards:     []string{"0"},
+		TabletType: topo.TYPE_MASTER,
+	}
+	app := callerContext("app", "")
+	if err := rpcVTGate.ExecuteShard(app, &q, new(proto.QueryResult)); err != nil {
+		t.Fatal(err)
+	}
+	err := rpcVTGate.ExecuteShard(app, &q, new(proto.QueryResult))
+	if code := vtgateErrorCode(err); code != int64(pbv.ErrorCode_THROTTLED_ERROR) {
+		t.Errorf("ExecuteShard: %v, want throttled error", err)
+	}
+	err = rpcVTGate.StreamExecuteShard(app, &q, func(*proto.QueryResult) error { return nil })
+	if code := vtgateErrorCode(err); code != int64(pbv.ErrorCode_THROTTLED_ERROR) {
+		t.Errorf("StreamExecuteShard: %v, want throttled error", err)
+	}
+	if execCount := sbc.ExecCount.Get(); execCount != 1 {
+		t.Errorf("ExecCount: %v, want 1", execCount)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var maxDMLRows = flag.Int("max_dml_rows", 10000, "maximum number of rows a multi-shard update or delete can affect")
+
+// execMultiShardDML executes the IN and Scatter variants of Update
+// and Delete. It first fetches the affected rows with the Subquery
+// of the plan to enforce the row limit and, for deletes, to delete
+// the entries of the owned vindexes. The Subquery fetches at most
+// one row more than the limit from each shard. Then it sends the
+// DML to the same shards.
+func (rtr *Router) execMultiShardDML(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	var params *scatterParams
+	var err error
+	switch plan.ID {
+	case planbuilder.UpdateIN, planbuilder.DeleteIN:
+		params, err = rtr.paramsSelectIN(vcursor, plan)
+	default:
+		params, err = rtr.paramsSelectScatter(vcursor, plan)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("execMultiShardDML: %v", err)
+	}
+	subqueryVars := make(map[string]map[string]interface{}, len(params.shardVars))
+	for shard, bv := range params.shardVars {
+		newbv := make(map[string]interface{}, len(bv)+1)
+		for k, v := range bv {
+			newbv[k] = v
+		}
+		newbv[planbuilder.LimitVarName] = int64(*maxDMLRows + 1)
+		subqueryVars[shard] = newbv
+	}
+	result, err := rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		plan.Subquery,
+		params.ks,
+		subqueryVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("execMultiShardDML: %v", err)
+	}
+	if len(result.Rows) > *maxDMLRows {
+		return nil, fmt.Errorf("execMultiShardDML: multi-shard dml affects %d rows, which exceeds the limit of %d", len(result.Rows), *maxDMLRows)
+	}
+	switch plan.ID {
+	case planbuilder.DeleteIN, planbuilder.DeleteScatter:
+		if err := rtr.deleteMultiShardVindexEntries(vcursor, plan, result); err != nil {
+			return nil, fmt.Errorf("execMultiShardDML: %v", err)
+		}
+	}
+	return rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction,
+	)
+}
+
+// deleteMultiShardVindexEntries deletes the entries of the owned
+// vindexes for the rows returned by the Subquery of a multi-shard
+// delete. The first column of the rows is the primary vindex column,
+// which is used to compute their keyspace ids. The rest are the
+// columns of the owned vindexes.
+func (rtr *Router) deleteMultiShardVindexEntries(vcursor *requestContext, plan *planbuilder.Plan, result *mproto.QueryResult) error {
+	if len(result.Rows) == 0 || len(plan.Table.Owned) == 0 {
+		return nil
+	}
+	primaryKeys := make([]interface{}, len(result.Rows))
+	for i, row := range result.Rows {
+		k, err := convertVindexKey(result.Fields[0], row[0])
+		if err != nil {
+			return err
+		}
+		primaryKeys[i] = k
+	}
+	ksids, err := plan.Table.ColVindexes[0].Vindex.(planbuilder.Unique).Map(vcursor, primaryKeys)
+	if err != nil {
+		return err
+	}
+	for i, colVindex := range plan.Table.Owned {
+		// Group the distinct values of the column by keyspace id,
+		// preserving the order in which they were returned.
+		var order []key.KeyspaceId
+		seen := make(map[key.KeyspaceId]map[interface{}]bool)
+		ids := make(map[key.KeyspaceId][]interface{})
+		for rownum, row := range result.Rows {
+			k, err := convertVindexKey(result.Fields[i+1], row[i+1])
+			if err != nil {
+				return err
+			}
+			ksid := ksids[rownum]
+			if seen[ksid] == nil {
+				seen[ksid] = make(map[interface{}]bool)
+				order = append(order, ksid)
+			}
+			if seen[ksid][k] {
+				continue
+			}
+			seen[ksid][k] = true
+			ids[ksid] = append(ids[ksid], k)
+		}
+		for _, ksid := range order {
+			if err := deleteVindexValues(vcursor, colVindex, ids[ksid], ksid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func convertVindexKey(field mproto.Field, val sqltypes.Value) (interface{}, error) {
+	k, err := mproto.Convert(field, val)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := k.([]byte); ok {
+		return string(b), nil
+	}
+	return k, nil
+}
+
+// deleteVindexValues deletes the entries of the owned colVindex
+// that map ids to ksid.
+func deleteVindexValues(vcursor *requestContext, colVindex *planbuilder.ColVindex, ids []interface{}, ksid key.KeyspaceId) error {
+	switch vindex := colVindex.Vindex.(type) {
+	case planbuilder.Functional:
+		return vindex.Delete(vcursor, ids, ksid)
+	case planbuilder.Lookup:
+		return vindex.Delete(vcursor, ids, ksid)
+	}
+	panic("unexpected")
+}
+
+// updateVindexEntries updates the vindex entries for an UpdateEqual
+// that changes non-primary ColVindex columns. The Subquery of the plan
+// returns the old values of those columns. The entries of the owned
+// vindexes are deleted for the old values and created for the new ones.
+// The new values of the other vindexes are verified.
+func (rtr *Router) updateVindexEntries(vcursor *requestContext, plan *planbuilder.Plan, ks, shard string, ksid key.KeyspaceId) error {
+	result, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.Subquery,
+		vcursor.query.BindVariables,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return err
+	}
+	if len(result.Rows) == 0 {
+		return nil
+	}
+	col := 0
+	for _, colVindex := range plan.Table.ColVindexes[1:] {
+		val, ok := plan.SetValues[colVindex.Col]
+		if !ok {
+			continue
+		}
+		// The Subquery returns every changed column,
+		// including those of the unowned vindexes.
+		oldCol := col
+		col++
+		keys, err := rtr.resolveKeys([]interface{}{val}, vcursor.query.BindVariables)
+		if err != nil {
+			return err
+		}
+		newKey := keys[0]
+		if !colVindex.Owned {
+			if newKey == nil {
+				continue
+			}
+			ok, err := colVindex.Vindex.Verify(vcursor, newKey, ksid)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("value %v for column %s does not map to keyspace id %v", newKey, colVindex.Col, ksid)
+			}
+			continue
+		}
+		lookup, ok := colVindex.Vindex.(planbuilder.Lookup)
+		if !ok {
+			return fmt.Errorf("cannot change the value of column %s", colVindex.Col)
+		}
+		seen := make(map[interface{}]bool)
+		var oldKeys []interface{}
+		for _, row := range result.Rows {
+			k, err := convertVindexKey(result.Fields[oldCol], row[oldCol])
+			if err != nil {
+				return err
+			}
+			if k == nil || seen[k] {
+				continue
+			}
+			seen[k] = true
+			oldKeys = append(oldKeys, k)
+		}
+		if len(oldKeys) != 0 {
+			if err := lookup.Delete(vcursor, oldKeys, ksid); err != nil {
+				return err
+			}
+		}
+		if newKey != nil {
+			if err := lookup.Create(vcursor, newKey, ksid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveRows executes an UpdateEqual that changes the primary ColVindex
+// column. The rows are read and deleted from their current shard along
+// with their vindex entries. They're then inserted with the new values
+// of the SET clause into their new shard, which creates the new vindex
+// entries. This must be done inside a transaction.
+func (rtr *Router) moveRows(vcursor *requestContext, plan *planbuilder.Plan, ks, shard string, ksid key.KeyspaceId) (*mproto.QueryResult, error) {
+	if vcursor.query.Session == nil || !vcursor.query.Session.InTransaction {
+		return nil, fmt.Errorf("changing the value of column %s requires a transaction", plan.Table.ColVindexes[0].Col)
+	}
+	bv := vcursor.query.BindVariables
+	result, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.Subquery,
+		bv,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Rows) == 0 {
+		return &mproto.QueryResult{}, nil
+	}
+
+	// Compute the new rows.
+	colIndexes := make(map[string]int, len(result.Fields))
+	for i, field := range result.Fields {
+		colIndexes[field.Name] = i
+	}
+	rows := make([][]interface{}, len(result.Rows))
+	for rownum, row := range result.Rows {
+		rows[rownum] = make([]interface{}, len(row))
+		for i, v := range row {
+			rows[rownum][i], err = mproto.Convert(result.Fields[i], v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for col, val := range plan.SetValues {
+		i, ok := colIndexes[col]
+		if !ok {
+			return nil, fmt.Errorf("column %s not found in %s", col, plan.Table.Name)
+		}
+		if s, ok := val.(string); ok {
+			// val is a bind var.
+			v, ok := bv[s[1:]]
+			if !ok {
+				return nil, fmt.Errorf("could not find bind var %s", s)
+			}
+			val = v
+		}
+		for _, row := range rows {
+			row[i] = val
+		}
+	}
+
+	// Delete the rows and their vindex entries.
+	for _, colVindex := range plan.Table.Owned {
+		seen := make(map[interface{}]bool)
+		var oldKeys []interface{}
+		for _, row := range result.Rows {
+			i := colIndexes[colVindex.Col]
+			k, err := convertVindexKey(result.Fields[i], row[i])
+			if err != nil {
+				return nil, err
+			}
+			if k == nil || seen[k] {
+				continue
+			}
+			seen[k] = true
+			oldKeys = append(oldKeys, k)
+		}
+		if len(oldKeys) == 0 {
+			continue
+		}
+		if err := deleteVindexValues(vcursor, colVindex, oldKeys, ksid); err != nil {
+			return nil, err
+		}
+	}
+	bv[ksidName] = string(ksid)
+	_, err = rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.DeleteQuery+fmt.Sprintf(dmlPostfix, ksid),
+		bv,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// Insert the new rows.
+	var newKsid key.KeyspaceId
+	tuples := make([]string, len(rows))
+	for rownum, row := range rows {
+		vindexValues := make([]interface{}, len(plan.Table.ColVindexes))
+		for i, colVindex := range plan.Table.ColVindexes {
+			v := row[colIndexes[colVindex.Col]]
+			if s, ok := v.(string); ok {
+				// Strings are treated as bind vars by handleInsertRow.
+				v = []byte(s)
+			}
+			vindexValues[i] = v
+		}
+		rowKsid, _, err := rtr.handleInsertRow(vcursor, plan, vindexValues, rownum, len(rows))
+		if err != nil {
+			return nil, err
+		}
+		if rownum == 0 {
+			newKsid = rowKsid
+		} else if rowKsid != newKsid {
+			return nil, fmt.Errorf("rows of %s moved to different keyspace ids: %v, %v", plan.Table.Name, newKsid, rowKsid)
+		}
+		vars := make([]string, len(row))
+		for i, v := range row {
+			name := planbuilder.InsertVarName(result.Fields[i].Name, rownum, len(rows))
+			if _, ok := bv[name]; !ok {
+				bv[name] = v
+			}
+			vars[i] = ":" + name
+		}
+		tuples[rownum] = "(" + strings.Join(vars, ", ") + ")"
+	}
+	newKs, newShard, err := rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, newKsid)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(result.Fields))
+	for i, field := range result.Fields {
+		cols[i] = field.Name
+	}
+	insert := fmt.Sprintf("insert into %s(%s) values %s", plan.Table.Name, strings.Join(cols, ", "), strings.Join(tuples, ", "))
+	bv[ksidName] = string(newKsid)
+	_, err = rtr.scatterConn.Execute(
+		vcursor.ctx,
+		insert+fmt.Sprintf(dmlPostfix, newKsid),
+		bv,
+		newKs,
+		[]string{newShard},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, err
+	}
+	return &mproto.QueryResult{RowsAffected: uint64(len(rows))}, nil
+}
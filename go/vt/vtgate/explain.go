@@ -0,0 +1,127 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// Explain returns the description of the plan of a V3 query,
+// along with the shards it would be sent to for the specified
+// bind vars. The query is not executed. However, resolving the
+// shards may require reading lookup vindexes. A query that
+// can't be routed is described by a NoPlan with its Reason.
+func (rtr *Router) Explain(ctx context.Context, query *proto.Query) *proto.PlanDescription {
+	if query.BindVariables == nil {
+		query.BindVariables = make(map[string]interface{})
+	}
+	vcursor := newRequestContext(ctx, query, rtr)
+	plan := rtr.planner.GetPlan(string(query.Sql))
+	return rtr.describePlan(vcursor, plan.Plan)
+}
+
+// describePlan describes a plan and its sub-plans.
+func (rtr *Router) describePlan(vcursor *requestContext, plan *planbuilder.Plan) *proto.PlanDescription {
+	pd := &proto.PlanDescription{
+		PlanID:    plan.ID.String(),
+		Reason:    plan.Reason,
+		Original:  plan.Original,
+		Rewritten: plan.Rewritten,
+		Subquery:  plan.Subquery,
+	}
+	if plan.Table != nil {
+		pd.Table = plan.Table.Name
+		if plan.Table.Keyspace != nil {
+			pd.Keyspace = plan.Table.Keyspace.Name
+		}
+	}
+	if plan.ColVindex != nil {
+		pd.Vindex = plan.ColVindex.Name
+	}
+	if plan.Route != planbuilder.NoPlan {
+		pd.Route = plan.Route.String()
+	}
+	if plan.Left != nil {
+		pd.Left = rtr.describePlan(vcursor, plan.Left)
+	}
+	if plan.BatchRight != nil {
+		// The batched version is the one that is executed.
+		pd.Right = rtr.describePlan(vcursor, plan.BatchRight)
+	} else if plan.Right != nil {
+		pd.Right = rtr.describePlan(vcursor, plan.Right)
+	}
+	ks, shards, err := rtr.explainShards(vcursor, plan)
+	if err != nil {
+		pd.ShardsError = err.Error()
+		return pd
+	}
+	if ks != "" {
+		// The keyspace may be redirected by ServedFrom.
+		pd.Keyspace = ks
+	}
+	sort.Strings(shards)
+	pd.Shards = shards
+	return pd
+}
+
+// explainShards returns the keyspace and shards a plan would be
+// sent to. Plans that don't send a query of their own, like
+// SelectJoin, return no shards.
+func (rtr *Router) explainShards(vcursor *requestContext, plan *planbuilder.Plan) (ks string, shards []string, err error) {
+	var params *scatterParams
+	switch plan.ID {
+	case planbuilder.SelectUnsharded, planbuilder.UpdateUnsharded,
+		planbuilder.DeleteUnsharded, planbuilder.InsertUnsharded:
+		params, err = rtr.paramsUnsharded(vcursor, plan)
+	case planbuilder.SelectEqual:
+		params, err = rtr.paramsSelectEqual(vcursor, plan)
+	case planbuilder.SelectIN, planbuilder.UpdateIN, planbuilder.DeleteIN:
+		params, err = rtr.paramsSelectIN(vcursor, plan)
+	case planbuilder.SelectKeyrange:
+		params, err = rtr.paramsSelectKeyrange(vcursor, plan)
+	case planbuilder.SelectScatter, planbuilder.UpdateScatter, planbuilder.DeleteScatter:
+		params, err = rtr.paramsSelectScatter(vcursor, plan)
+	case planbuilder.SelectMerge, planbuilder.SelectAggregate:
+		params, err = rtr.paramsRoute(vcursor, plan)
+	case planbuilder.UpdateEqual, planbuilder.DeleteEqual:
+		return rtr.explainSingleShard(vcursor, plan)
+	case planbuilder.InsertSharded:
+		return "", nil, fmt.Errorf("the shards of %v are resolved when the rows are inserted", plan.ID)
+	default:
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	for shard := range params.shardVars {
+		shards = append(shards, shard)
+	}
+	return params.ks, shards, nil
+}
+
+// explainSingleShard returns the shard of an UpdateEqual or
+// DeleteEqual. It returns no shard if the row doesn't exist.
+func (rtr *Router) explainSingleShard(vcursor *requestContext, plan *planbuilder.Plan) (ks string, shards []string, err error) {
+	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
+	if err != nil {
+		return "", nil, fmt.Errorf("explainSingleShard: %v", err)
+	}
+	ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
+	if err != nil {
+		return "", nil, fmt.Errorf("explainSingleShard: %v", err)
+	}
+	if ksid == key.MinKey {
+		return "", nil, nil
+	}
+	return ks, []string{shard}, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func routerExplain(router *Router, sql string, bv map[string]interface{}) *proto.PlanDescription {
+	return router.Explain(context.Background(), &proto.Query{
+		Sql:           sql,
+		BindVariables: bv,
+		TabletType:    topo.TYPE_MASTER,
+	})
+}
+
+func TestExplainSelect(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	got := routerExplain(router, "select * from user where id = :id", map[string]interface{}{"id": 3})
+	want := &proto.PlanDescription{
+		PlanID:    "SelectEqual",
+		Table:     "user",
+		Keyspace:  "TestRouter",
+		Vindex:    "user_index",
+		Original:  "select * from user where id = :id",
+		Rewritten: "select * from user where id = :id",
+		Shards:    []string{"40-60"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Explain:\n%+v, want\n%+v", got, want)
+	}
+
+	got = routerExplain(router, "select * from user where id in (1, 3)", nil)
+	if want := []string{"-20", "40-60"}; !reflect.DeepEqual(got.Shards, want) {
+		t.Errorf("SelectIN shards: %v, want %v", got.Shards, want)
+	}
+
+	got = routerExplain(router, "select count(*) from user", nil)
+	if got.PlanID != "SelectAggregate" || got.Route != "SelectScatter" || len(got.Shards) != 8 {
+		t.Errorf("SelectAggregate: %+v, want a scatter to 8 shards", got)
+	}
+
+	got = routerExplain(router, "select * from user where id = :id", nil)
+	if want := "paramsSelectEqual: could not find bind var :id"; got.ShardsError != want || got.Shards != nil {
+		t.Errorf("ShardsError: %q, want %q", got.ShardsError, want)
+	}
+
+	got = routerExplain(router, "select * from nonexistent", nil)
+	if got.PlanID != "NoPlan" || got.Reason != "table nonexistent not found" {
+		t.Errorf("NoPlan: %+v", got)
+	}
+
+	// Nothing is sent to the shards.
+	if sbc1.ExecCount.Get() != 0 || sbc2.ExecCount.Get() != 0 {
+		t.Errorf("ExecCount: %v, %v, want 0, 0", sbc1.ExecCount.Get(), sbc2.ExecCount.Get())
+	}
+}
+
+func TestExplainJoin(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+
+	got := routerExplain(router, "select u1.id, u2.id from user u1 join user u2 on u2.id = u1.col where u1.id = 1", nil)
+	if got.PlanID != "SelectJoin" || got.Shards != nil {
+		t.Errorf("SelectJoin: %+v", got)
+	}
+	if got.Left == nil || got.Left.PlanID != "SelectEqual" || !reflect.DeepEqual(got.Left.Shards, []string{"-20"}) {
+		t.Errorf("Left: %+v, want SelectEqual on -20", got.Left)
+	}
+	// Right is batched, and its shards depend on the rows returned by Left.
+	if got.Right == nil || got.Right.PlanID != "SelectIN" || got.Right.ShardsError == "" {
+		t.Errorf("Right: %+v, want SelectIN with a ShardsError", got.Right)
+	}
+}
+
+func TestExplainDML(t *testing.T) {
+	router, _, _, sbclookup := createRouterEnv()
+
+	got := routerExplain(router, "update user set a = 2 where id = 1", nil)
+	if got.PlanID != "UpdateEqual" || !reflect.DeepEqual(got.Shards, []string{"-20"}) {
+		t.Errorf("UpdateEqual: %+v, want -20", got)
+	}
+
+	got = routerExplain(router, "delete /* multi_shard */ from user", nil)
+	if got.PlanID != "DeleteScatter" || len(got.Shards) != 8 {
+		t.Errorf("DeleteScatter: %+v, want 8 shards", got)
+	}
+
+	got = routerExplain(router, "insert into user_extra(user_id) values (1)", nil)
+	if want := "the shards of InsertSharded are resolved when the rows are inserted"; got.PlanID != "InsertSharded" || got.ShardsError != want {
+		t.Errorf("InsertSharded: %+v, want ShardsError %q", got, want)
+	}
+
+	got = routerExplain(router, "insert into music_user_map(music_id, user_id) values (1, 1)", nil)
+	if got.PlanID != "InsertUnsharded" || !reflect.DeepEqual(got.Shards, []string{"0"}) {
+		t.Errorf("InsertUnsharded: %+v, want 0", got)
+	}
+	if execCount := sbclookup.ExecCount.Get(); execCount != 0 {
+		t.Errorf("sbclookup ExecCount: %v, want 0", execCount)
+	}
+}
@@ -286,6 +286,30 @@ func (conn *FakeVTGateConn) Rollback2(ctx context.Context, session interface{})
 	return conn.Rollback(ctx, session)
 }
 
+// BeginSingleShard please see vtgateconn.Impl.BeginSingleShard
+func (conn *FakeVTGateConn) BeginSingleShard(ctx context.Context) (interface{}, error) {
+	return &proto.Session{
+		InTransaction: true,
+		SingleShard:   true,
+	}, nil
+}
+
+// SetReadYourWrites please see vtgateconn.Impl.SetReadYourWrites
+func (conn *FakeVTGateConn) SetReadYourWrites(session interface{}, positions []*proto.ShardPosition) interface{} {
+	s, _ := session.(*proto.Session)
+	if s == nil {
+		s = &proto.Session{}
+	}
+	s.ReadYourWrites = true
+	s.ShardPositions = positions
+	return s
+}
+
+// ShardPositions please see vtgateconn.Impl.ShardPositions
+func (conn *FakeVTGateConn) ShardPositions(session interface{}) []*proto.ShardPosition {
+	return session.(*proto.Session).ShardPositions
+}
+
 // SplitQuery please see vtgateconn.Impl.SplitQuery
 func (conn *FakeVTGateConn) SplitQuery(ctx context.Context, keyspace string, query tproto.BoundQuery, splitColumn string, splitCount int) ([]proto.SplitQueryPart, error) {
 	response, ok := conn.splitQueryMap[getSplitQueryKey(keyspace, &query, splitColumn, splitCount)]
@@ -304,6 +328,11 @@ func (conn *FakeVTGateConn) GetSrvKeyspace(ctx context.Context, keyspace string)
 	return nil, fmt.Errorf("NYI")
 }
 
+// Explain please see vtgateconn.Impl.Explain
+func (conn *FakeVTGateConn) Explain(ctx context.Context, query string, bindVars map[string]interface{}, tabletType pb.TabletType) (*proto.PlanDescription, error) {
+	return nil, fmt.Errorf("NYI")
+}
+
 // Close please see vtgateconn.Impl.Close
 func (conn *FakeVTGateConn) Close() {
 }
@@ -74,12 +74,12 @@ func (conn *vtgateConn) Execute(ctx context.Context, query string, bindVars map[
 	if err := conn.rpcConn.Call(ctx, "VTGate.Execute", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.Result, result.Session, nil
 }
 
@@ -102,12 +102,12 @@ func (conn *vtgateConn) ExecuteShard(ctx context.Context, query string, keyspace
 	if err := conn.rpcConn.Call(ctx, "VTGate.ExecuteShard", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.Result, result.Session, nil
 }
 
@@ -130,12 +130,12 @@ func (conn *vtgateConn) ExecuteKeyspaceIds(ctx context.Context, query string, ke
 	if err := conn.rpcConn.Call(ctx, "VTGate.ExecuteKeyspaceIds", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.Result, result.Session, nil
 }
 
@@ -158,12 +158,12 @@ func (conn *vtgateConn) ExecuteKeyRanges(ctx context.Context, query string, keys
 	if err := conn.rpcConn.Call(ctx, "VTGate.ExecuteKeyRanges", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.Result, result.Session, nil
 }
 
@@ -187,12 +187,12 @@ func (conn *vtgateConn) ExecuteEntityIds(ctx context.Context, query string, keys
 	if err := conn.rpcConn.Call(ctx, "VTGate.ExecuteEntityIds", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.Result, result.Session, nil
 }
 
@@ -212,12 +212,12 @@ func (conn *vtgateConn) ExecuteBatchShard(ctx context.Context, queries []proto.B
 	if err := conn.rpcConn.Call(ctx, "VTGate.ExecuteBatchShard", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.List, result.Session, nil
 }
 
@@ -237,12 +237,12 @@ func (conn *vtgateConn) ExecuteBatchKeyspaceIds(ctx context.Context, queries []p
 	if err := conn.rpcConn.Call(ctx, "VTGate.ExecuteBatchKeyspaceIds", request, &result); err != nil {
 		return nil, session, err
 	}
-	if result.Error != "" {
-		return nil, result.Session, errors.New(result.Error)
-	}
 	if err := vterrors.FromRPCError(result.Err); err != nil {
 		return nil, result.Session, err
 	}
+	if result.Error != "" {
+		return nil, result.Session, errors.New(result.Error)
+	}
 	return result.List, result.Session, nil
 }
 
@@ -399,6 +399,10 @@ func (conn *vtgateConn) Begin(ctx context.Context) (interface{}, error) {
 
 func (conn *vtgateConn) Commit(ctx context.Context, session interface{}) error {
 	s := session.(*proto.Session)
+	if s.ReadYourWrites {
+		// Only Commit2 returns the replication positions.
+		return conn.Commit2(ctx, session)
+	}
 	return conn.rpcConn.Call(ctx, "VTGate.Commit", s, &rpc.Unused{})
 }
 
@@ -408,8 +412,17 @@ func (conn *vtgateConn) Rollback(ctx context.Context, session interface{}) error
 }
 
 func (conn *vtgateConn) Begin2(ctx context.Context) (interface{}, error) {
+	return conn.begin2(ctx, false)
+}
+
+func (conn *vtgateConn) BeginSingleShard(ctx context.Context) (interface{}, error) {
+	return conn.begin2(ctx, true)
+}
+
+func (conn *vtgateConn) begin2(ctx context.Context, singleShard bool) (interface{}, error) {
 	request := &proto.BeginRequest{
-		CallerID: getEffectiveCallerID(ctx),
+		CallerID:    getEffectiveCallerID(ctx),
+		SingleShard: singleShard,
 	}
 	reply := new(proto.BeginResponse)
 	if err := conn.rpcConn.Call(ctx, "VTGate.Begin2", request, reply); err != nil {
@@ -436,7 +449,27 @@ func (conn *vtgateConn) Commit2(ctx context.Context, session interface{}) error
 	if err := conn.rpcConn.Call(ctx, "VTGate.Commit2", request, reply); err != nil {
 		return err
 	}
-	return vterrors.FromRPCError(reply.Err)
+	if err := vterrors.FromRPCError(reply.Err); err != nil {
+		return err
+	}
+	if reply.Session != nil {
+		s.ShardPositions = reply.Session.ShardPositions
+	}
+	return nil
+}
+
+func (conn *vtgateConn) SetReadYourWrites(session interface{}, positions []*proto.ShardPosition) interface{} {
+	s, _ := session.(*proto.Session)
+	if s == nil {
+		s = &proto.Session{}
+	}
+	s.ReadYourWrites = true
+	s.ShardPositions = positions
+	return s
+}
+
+func (conn *vtgateConn) ShardPositions(session interface{}) []*proto.ShardPosition {
+	return session.(*proto.Session).ShardPositions
 }
 
 func (conn *vtgateConn) Rollback2(ctx context.Context, session interface{}) error {
@@ -481,6 +514,38 @@ func (conn *vtgateConn) GetSrvKeyspace(ctx context.Context, keyspace string) (*t
 	return result, nil
 }
 
+func (conn *vtgateConn) Explain(ctx context.Context, query string, bindVars map[string]interface{}, tabletType pb.TabletType) (*proto.PlanDescription, error) {
+	request := &proto.ExplainRequest{
+		CallerID:      getEffectiveCallerID(ctx),
+		Sql:           query,
+		BindVariables: bindVars,
+		TabletType:    topo.ProtoToTabletType(tabletType),
+	}
+	result := &proto.ExplainResult{}
+	if err := conn.rpcConn.Call(ctx, "VTGate.Explain", request, result); err != nil {
+		return nil, err
+	}
+	if err := vterrors.FromRPCError(result.Err); err != nil {
+		return nil, err
+	}
+	clearEmptyShards(result.Plan)
+	return result.Plan, nil
+}
+
+// clearEmptyShards resets the empty Shards lists of a plan
+// description to nil, because bson decodes nil lists as empty
+// ones. This makes the result the same as with the other protocols.
+func clearEmptyShards(pd *proto.PlanDescription) {
+	if pd == nil {
+		return
+	}
+	if len(pd.Shards) == 0 {
+		pd.Shards = nil
+	}
+	clearEmptyShards(pd.Left)
+	clearEmptyShards(pd.Right)
+}
+
 func (conn *vtgateConn) Close() {
 	conn.rpcConn.Close()
 }
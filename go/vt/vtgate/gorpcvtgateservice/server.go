@@ -326,7 +326,7 @@ func (vtg *VTGate) Begin2(ctx context.Context, request *proto.BeginRequest, repl
 		callerid.GoRPCEffectiveCallerID(request.CallerID),
 		callerid.NewImmediateCallerID("gorpc client"))
 	// Don't pass in a nil pointer
-	reply.Session = &proto.Session{}
+	reply.Session = &proto.Session{SingleShard: request.SingleShard}
 	vtgErr := vtg.server.Begin(ctx, reply.Session)
 	vtgate.AddVtGateErrorToBeginResponse(vtgErr, reply)
 	if *vtgate.RPCErrorOnlyInReply {
@@ -344,6 +344,9 @@ func (vtg *VTGate) Commit2(ctx context.Context, request *proto.CommitRequest, re
 		callerid.GoRPCEffectiveCallerID(request.CallerID),
 		callerid.NewImmediateCallerID("gorpc client"))
 	vtgErr := vtg.server.Commit(ctx, request.Session)
+	if request.Session != nil && request.Session.ReadYourWrites {
+		reply.Session = request.Session
+	}
 	vtgate.AddVtGateErrorToCommitResponse(vtgErr, reply)
 	if *vtgate.RPCErrorOnlyInReply {
 		return nil
@@ -396,6 +399,22 @@ func (vtg *VTGate) GetSrvKeyspace(ctx context.Context, request *proto.GetSrvKeys
 	return nil
 }
 
+// Explain is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) Explain(ctx context.Context, request *proto.ExplainRequest, reply *proto.ExplainResult) (err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(*rpcTimeout))
+	defer cancel()
+	ctx = callerid.NewContext(ctx,
+		callerid.GoRPCEffectiveCallerID(request.CallerID),
+		callerid.NewImmediateCallerID("gorpc client"))
+	vtgErr := vtg.server.Explain(ctx, request, reply)
+	vtgate.AddVtGateErrorToExplainResult(vtgErr, reply)
+	if *vtgate.RPCErrorOnlyInReply {
+		return nil
+	}
+	return vtgErr
+}
+
 // New returns a new VTGate service
 func New(vtGate vtgateservice.VTGateService) *VTGate {
 	return &VTGate{vtGate}
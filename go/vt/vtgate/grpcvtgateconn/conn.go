@@ -372,8 +372,17 @@ func (conn *vtgateConn) StreamExecuteKeyspaceIds2(ctx context.Context, query str
 }
 
 func (conn *vtgateConn) Begin(ctx context.Context) (interface{}, error) {
+	return conn.begin(ctx, false)
+}
+
+func (conn *vtgateConn) BeginSingleShard(ctx context.Context) (interface{}, error) {
+	return conn.begin(ctx, true)
+}
+
+func (conn *vtgateConn) begin(ctx context.Context, singleShard bool) (interface{}, error) {
 	request := &pb.BeginRequest{
-		CallerId: callerid.EffectiveCallerIDFromContext(ctx),
+		CallerId:    callerid.EffectiveCallerIDFromContext(ctx),
+		SingleShard: singleShard,
 	}
 	response, err := conn.c.Begin(ctx, request)
 	if err != nil {
@@ -386,9 +395,10 @@ func (conn *vtgateConn) Begin(ctx context.Context) (interface{}, error) {
 }
 
 func (conn *vtgateConn) Commit(ctx context.Context, session interface{}) error {
+	s := session.(*pb.Session)
 	request := &pb.CommitRequest{
 		CallerId: callerid.EffectiveCallerIDFromContext(ctx),
-		Session:  session.(*pb.Session),
+		Session:  s,
 	}
 	response, err := conn.c.Commit(ctx, request)
 	if err != nil {
@@ -397,6 +407,9 @@ func (conn *vtgateConn) Commit(ctx context.Context, session interface{}) error {
 	if response.Error != nil {
 		return vterrors.FromVtRPCError(response.Error)
 	}
+	if response.Session != nil {
+		s.ShardPositions = response.Session.ShardPositions
+	}
 	return nil
 }
 
@@ -415,6 +428,36 @@ func (conn *vtgateConn) Rollback(ctx context.Context, session interface{}) error
 	return nil
 }
 
+func (conn *vtgateConn) SetReadYourWrites(session interface{}, positions []*proto.ShardPosition) interface{} {
+	s, _ := session.(*pb.Session)
+	if s == nil {
+		s = &pb.Session{}
+	}
+	s.ReadYourWrites = true
+	s.ShardPositions = make([]*pb.Session_ShardPosition, len(positions))
+	for i, position := range positions {
+		s.ShardPositions[i] = &pb.Session_ShardPosition{
+			Keyspace: position.Keyspace,
+			Shard:    position.Shard,
+			Position: position.Position,
+		}
+	}
+	return s
+}
+
+func (conn *vtgateConn) ShardPositions(session interface{}) []*proto.ShardPosition {
+	s := session.(*pb.Session)
+	positions := make([]*proto.ShardPosition, len(s.ShardPositions))
+	for i, position := range s.ShardPositions {
+		positions[i] = &proto.ShardPosition{
+			Keyspace: position.Keyspace,
+			Shard:    position.Shard,
+			Position: position.Position,
+		}
+	}
+	return positions
+}
+
 func (conn *vtgateConn) Begin2(ctx context.Context) (interface{}, error) {
 	return conn.Begin(ctx)
 }
@@ -453,6 +496,22 @@ func (conn *vtgateConn) GetSrvKeyspace(ctx context.Context, keyspace string) (*t
 	return topo.ProtoToSrvKeyspace(response.SrvKeyspace), nil
 }
 
+func (conn *vtgateConn) Explain(ctx context.Context, query string, bindVars map[string]interface{}, tabletType pbt.TabletType) (*proto.PlanDescription, error) {
+	request := &pb.ExplainRequest{
+		CallerId:   callerid.EffectiveCallerIDFromContext(ctx),
+		Query:      tproto.BoundQueryToProto3(query, bindVars),
+		TabletType: tabletType,
+	}
+	response, err := conn.c.Explain(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, vterrors.FromVtRPCError(response.Error)
+	}
+	return proto.ProtoToPlanDescription(response.Plan), nil
+}
+
 func (conn *vtgateConn) Close() {
 	conn.cc.Close()
 }
@@ -45,7 +45,7 @@ func (vtg *VTGate) Execute(ctx context.Context, request *pb.ExecuteRequest) (res
 	reply := new(proto.QueryResult)
 	executeErr := vtg.server.Execute(ctx, query, reply)
 	response = &pb.ExecuteResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Result = mproto.QueryResultToProto3(reply.Result)
@@ -76,7 +76,7 @@ func (vtg *VTGate) ExecuteShards(ctx context.Context, request *pb.ExecuteShardsR
 	reply := new(proto.QueryResult)
 	executeErr := vtg.server.ExecuteShard(ctx, query, reply)
 	response = &pb.ExecuteShardsResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Result = mproto.QueryResultToProto3(reply.Result)
@@ -107,7 +107,7 @@ func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, request *pb.ExecuteKe
 	reply := new(proto.QueryResult)
 	executeErr := vtg.server.ExecuteKeyspaceIds(ctx, query, reply)
 	response = &pb.ExecuteKeyspaceIdsResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Result = mproto.QueryResultToProto3(reply.Result)
@@ -138,7 +138,7 @@ func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, request *pb.ExecuteKeyR
 	reply := new(proto.QueryResult)
 	executeErr := vtg.server.ExecuteKeyRanges(ctx, query, reply)
 	response = &pb.ExecuteKeyRangesResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Result = mproto.QueryResultToProto3(reply.Result)
@@ -170,7 +170,7 @@ func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, request *pb.ExecuteEnti
 	reply := new(proto.QueryResult)
 	executeErr := vtg.server.ExecuteEntityIds(ctx, query, reply)
 	response = &pb.ExecuteEntityIdsResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Result = mproto.QueryResultToProto3(reply.Result)
@@ -198,7 +198,7 @@ func (vtg *VTGate) ExecuteBatchShards(ctx context.Context, request *pb.ExecuteBa
 	reply := new(proto.QueryResultList)
 	executeErr := vtg.server.ExecuteBatchShard(ctx, query, reply)
 	response = &pb.ExecuteBatchShardsResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Results = tproto.QueryResultListToProto3(reply.List)
@@ -227,7 +227,7 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, request *pb.Exec
 	reply := new(proto.QueryResultList)
 	executeErr := vtg.server.ExecuteBatchKeyspaceIds(ctx, query, reply)
 	response = &pb.ExecuteBatchKeyspaceIdsResponse{
-		Error: vtgate.VtGateErrorToVtRPCError(executeErr, reply.Error),
+		Error: vtgate.ExecuteErrorToVtRPCError(executeErr, reply.Error, reply.Err),
 	}
 	if executeErr == nil {
 		response.Results = tproto.QueryResultListToProto3(reply.List)
@@ -326,7 +326,7 @@ func (vtg *VTGate) Begin(ctx context.Context, request *pb.BeginRequest) (respons
 	ctx = callerid.NewContext(callinfo.GRPCCallInfo(ctx),
 		request.CallerId,
 		callerid.NewImmediateCallerID("grpc client"))
-	outSession := new(proto.Session)
+	outSession := &proto.Session{SingleShard: request.SingleShard}
 	beginErr := vtg.server.Begin(ctx, outSession)
 	response = &pb.BeginResponse{
 		Error: vtgate.VtGateErrorToVtRPCError(beginErr, ""),
@@ -347,10 +347,14 @@ func (vtg *VTGate) Commit(ctx context.Context, request *pb.CommitRequest) (respo
 	ctx = callerid.NewContext(callinfo.GRPCCallInfo(ctx),
 		request.CallerId,
 		callerid.NewImmediateCallerID("grpc client"))
-	commitErr := vtg.server.Commit(ctx, proto.ProtoToSession(request.Session))
+	session := proto.ProtoToSession(request.Session)
+	commitErr := vtg.server.Commit(ctx, session)
 	response = &pb.CommitResponse{
 		Error: vtgate.VtGateErrorToVtRPCError(commitErr, ""),
 	}
+	if session != nil && session.ReadYourWrites {
+		response.Session = proto.SessionToProto(session)
+	}
 	if commitErr == nil {
 		return response, nil
 	}
@@ -414,6 +418,32 @@ func (vtg *VTGate) GetSrvKeyspace(ctx context.Context, request *pb.GetSrvKeyspac
 	}, nil
 }
 
+// Explain is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) Explain(ctx context.Context, request *pb.ExplainRequest) (response *pb.ExplainResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callerid.NewContext(callinfo.GRPCCallInfo(ctx),
+		request.CallerId,
+		callerid.NewImmediateCallerID("grpc client"))
+	req := &proto.ExplainRequest{
+		Sql:           string(request.Query.Sql),
+		BindVariables: tproto.Proto3ToBindVariables(request.Query.BindVariables),
+		TabletType:    topo.ProtoToTabletType(request.TabletType),
+	}
+	reply := new(proto.ExplainResult)
+	explainErr := vtg.server.Explain(ctx, req, reply)
+	response = &pb.ExplainResponse{
+		Error: vtgate.VtGateErrorToVtRPCError(explainErr, ""),
+	}
+	if explainErr == nil {
+		response.Plan = proto.PlanDescriptionToProto(reply.Plan)
+		return response, nil
+	}
+	if *vtgate.RPCErrorOnlyInReply {
+		return response, nil
+	}
+	return nil, explainErr
+}
+
 func init() {
 	vtgate.RegisterVTGates = append(vtgate.RegisterVTGates, func(vtGate vtgateservice.VTGateService) {
 		if servenv.GRPCCheckServiceMap("vtgateservice") {
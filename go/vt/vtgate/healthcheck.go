@@ -0,0 +1,369 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+// HealthCheck discovers the tablets of some cells through their
+// StreamHealth stream, instead of reading the serving graph.
+// It reads the list of tablets of the cells from the topo, and
+// keeps a stream open to each of them. The end points of a
+// keyspace/shard/type are the tablets that currently report this
+// target, and are healthy.
+//
+// HealthCheck is a SrvTopoServer: GetEndPoints is answered from
+// the streams for the watched cells, and everything else is
+// forwarded to the underlying SrvTopoServer. The watched cells
+// don't fall back to the cells of -cell_preferences, which only
+// applies to the cells read from the serving graph.
+type HealthCheck struct {
+	SrvTopoServer
+
+	ts              topo.Server
+	cells           map[string]bool
+	retryDelay      time.Duration
+	refreshInterval time.Duration
+	connTimeout     time.Duration
+
+	// ctx is cancelled by Close, which stops the
+	// refresh loop and all the streams.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu protects all the fields below, and the fields of the tabletHealth.
+	mu          sync.Mutex
+	tablets     map[pb.TabletAlias]*tabletHealth
+	lastRefresh time.Time
+	// refreshErrors contains the last error for each cell, if any.
+	refreshErrors map[string]error
+}
+
+// tabletHealth is the state of the stream of a tablet.
+type tabletHealth struct {
+	alias    *pb.TabletAlias
+	endPoint *pb.EndPoint
+	cancel   context.CancelFunc
+
+	// target is nil until the first response.
+	target               *pbq.Target
+	stats                *pbq.RealtimeStats
+	externallyReparented int64
+	lastResponse         time.Time
+	lastError            error
+}
+
+var healthCheckErrors = stats.NewCounters("VtgateHealthCheckErrors")
+
+// NewHealthCheck creates a HealthCheck for cells, and starts
+// watching their tablets. The list of tablets is read from ts
+// every refreshInterval. A failed stream is reopened after
+// retryDelay. Requests that are not about the end points of
+// the cells are sent to serv.
+func NewHealthCheck(serv SrvTopoServer, ts topo.Server, cells []string, retryDelay, refreshInterval, connTimeout time.Duration) *HealthCheck {
+	ctx, cancel := context.WithCancel(context.Background())
+	hc := &HealthCheck{
+		SrvTopoServer:   serv,
+		ts:              ts,
+		cells:           make(map[string]bool),
+		retryDelay:      retryDelay,
+		refreshInterval: refreshInterval,
+		connTimeout:     connTimeout,
+		ctx:             ctx,
+		cancel:          cancel,
+		tablets:         make(map[pb.TabletAlias]*tabletHealth),
+		refreshErrors:   make(map[string]error),
+	}
+	for _, cell := range cells {
+		hc.cells[cell] = true
+	}
+	hc.refresh()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(refreshInterval):
+				hc.refresh()
+			}
+		}
+	}()
+	return hc
+}
+
+// Close stops watching the tablets.
+func (hc *HealthCheck) Close() {
+	hc.cancel()
+}
+
+// GetEndPoints is part of the SrvTopoServer interface. For the
+// watched cells, it returns the tablets that report the target,
+// and are healthy. If several masters are reported, only the one
+// that was reparented last is returned.
+func (hc *HealthCheck) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType pb.TabletType) (*pb.EndPoints, int64, error) {
+	if !hc.cells[cell] {
+		return hc.SrvTopoServer.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	var matches []*tabletHealth
+	for _, th := range hc.tablets {
+		if th.alias.Cell != cell || !th.isServing() {
+			continue
+		}
+		if th.target.Keyspace != keyspace || th.target.Shard != shard || th.target.TabletType != tabletType {
+			continue
+		}
+		matches = append(matches, th)
+	}
+	if tabletType == pb.TabletType_MASTER && len(matches) > 1 {
+		latest := matches[0]
+		for _, th := range matches[1:] {
+			if th.externallyReparented > latest.externallyReparented {
+				latest = th
+			}
+		}
+		matches = []*tabletHealth{latest}
+	}
+	result := &pb.EndPoints{}
+	for _, th := range matches {
+		result.Entries = append(result.Entries, th.endPoint)
+	}
+	return result, -1, nil
+}
+
+// FallbackCell returns the cell the end points of a cell that is not
+// watched are served from, if the underlying SrvTopoServer falls
+// back to other cells. The watched cells never fall back.
+func (hc *HealthCheck) FallbackCell(cell, keyspace, shard string, tabletType pb.TabletType) string {
+	if hc.cells[cell] {
+		return ""
+	}
+	if fcs, ok := hc.SrvTopoServer.(fallbackCellServer); ok {
+		return fcs.FallbackCell(cell, keyspace, shard, tabletType)
+	}
+	return ""
+}
+
+// refresh reads the list of tablets of the cells, starts
+// watching the new ones, and stops watching the ones that
+// went away or changed address.
+func (hc *HealthCheck) refresh() {
+	ctx, cancel := context.WithTimeout(hc.ctx, hc.refreshInterval)
+	defer cancel()
+	seen := make(map[pb.TabletAlias]bool)
+	refreshErrors := make(map[string]error)
+	failedCells := make(map[string]bool)
+	for cell := range hc.cells {
+		aliases, err := hc.ts.GetTabletsByCell(ctx, cell)
+		if err != nil {
+			refreshErrors[cell] = err
+			failedCells[cell] = true
+			healthCheckErrors.Add("Topo", 1)
+			continue
+		}
+		tablets, err := topo.GetTabletMap(ctx, hc.ts, aliases)
+		if err != nil {
+			// The map is partial: keep watching the tablets we couldn't read.
+			refreshErrors[cell] = err
+			failedCells[cell] = true
+			healthCheckErrors.Add("Topo", 1)
+		}
+		for alias, ti := range tablets {
+			endPoint, err := topo.TabletEndPoint(ti.Tablet)
+			if err != nil {
+				// No vt port: this tablet doesn't serve queries.
+				continue
+			}
+			seen[alias] = true
+			hc.watch(ti.Tablet, endPoint)
+		}
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for alias, th := range hc.tablets {
+		if !seen[alias] && !failedCells[alias.Cell] {
+			th.cancel()
+			delete(hc.tablets, alias)
+		}
+	}
+	hc.lastRefresh = time.Now()
+	hc.refreshErrors = refreshErrors
+}
+
+// watch starts the stream of a tablet if needed.
+func (hc *HealthCheck) watch(tablet *pb.Tablet, endPoint *pb.EndPoint) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	alias := *tablet.Alias
+	if th, ok := hc.tablets[alias]; ok {
+		if th.endPoint.Host == endPoint.Host && th.endPoint.PortMap["vt"] == endPoint.PortMap["vt"] {
+			return
+		}
+		// The tablet moved.
+		th.cancel()
+	}
+	ctx, cancel := context.WithCancel(hc.ctx)
+	th := &tabletHealth{
+		alias:    tablet.Alias,
+		endPoint: endPoint,
+		cancel:   cancel,
+	}
+	hc.tablets[alias] = th
+	go func() {
+		for {
+			err := hc.stream(ctx, tablet, th)
+			hc.mu.Lock()
+			th.lastError = err
+			hc.mu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			healthCheckErrors.Add("Stream", 1)
+			log.Warningf("Health stream of %v failed, retrying in %v: %v", topo.TabletAliasString(tablet.Alias), hc.retryDelay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(hc.retryDelay):
+			}
+		}
+	}()
+}
+
+// stream reads the health stream of a tablet until it fails.
+func (hc *HealthCheck) stream(ctx context.Context, tablet *pb.Tablet, th *tabletHealth) error {
+	conn, err := tabletconn.GetDialer()(ctx, th.endPoint, tablet.Keyspace, tablet.Shard, pb.TabletType_UNKNOWN, hc.connTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	responses, errFunc, err := conn.StreamHealth(ctx)
+	if err != nil {
+		return err
+	}
+	for response := range responses {
+		hc.mu.Lock()
+		th.target = response.Target
+		th.stats = response.RealtimeStats
+		th.externallyReparented = response.TabletExternallyReparentedTimestamp
+		th.lastResponse = time.Now()
+		th.lastError = nil
+		hc.mu.Unlock()
+	}
+	if err := errFunc(); err != nil {
+		return err
+	}
+	return fmt.Errorf("health stream closed")
+}
+
+// realtimeStats returns the last RealtimeStats reported by the
+// tablet of endPoint in cell, and the error of its stream. It
+// lets the health policy of the Balancer share the streams of
+// the HealthCheck.
+func (hc *HealthCheck) realtimeStats(cell string, endPoint *pb.EndPoint) (*pbq.RealtimeStats, error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	th, ok := hc.tablets[pb.TabletAlias{Cell: cell, Uid: endPoint.Uid}]
+	if !ok || th.endPoint.Host != endPoint.Host {
+		return nil, fmt.Errorf("tablet is not watched")
+	}
+	if th.lastError != nil {
+		return nil, th.lastError
+	}
+	return th.stats, nil
+}
+
+// isServing returns true if the tablet reported a target, is
+// healthy, and its stream is up. hc.mu must be held.
+func (th *tabletHealth) isServing() bool {
+	if th.target == nil || th.lastError != nil {
+		return false
+	}
+	return th.stats == nil || th.stats.HealthError == ""
+}
+
+// TabletHealthStatus is the status of a tablet watched by a HealthCheck.
+type TabletHealthStatus struct {
+	Alias        string
+	Host         string
+	Target       *pbq.Target
+	Serving      bool
+	HealthError  string
+	LastResponse time.Time
+}
+
+// HealthCheckStatus is the status of a HealthCheck.
+// It's used to display the discovered tablets on the status page.
+type HealthCheckStatus struct {
+	Cells         []string
+	LastRefresh   time.Time
+	RefreshErrors []string
+	Tablets       []*TabletHealthStatus
+}
+
+// Status returns the current status of the HealthCheck.
+// The tablets are sorted by alias.
+func (hc *HealthCheck) Status() *HealthCheckStatus {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	status := &HealthCheckStatus{
+		LastRefresh: hc.lastRefresh,
+	}
+	for cell := range hc.cells {
+		status.Cells = append(status.Cells, cell)
+		if err := hc.refreshErrors[cell]; err != nil {
+			status.RefreshErrors = append(status.RefreshErrors, fmt.Sprintf("%v: %v", cell, err))
+		}
+	}
+	sort.Strings(status.Cells)
+	sort.Strings(status.RefreshErrors)
+	for _, th := range hc.tablets {
+		ths := &TabletHealthStatus{
+			Alias:        topo.TabletAliasString(th.alias),
+			Host:         th.endPoint.Host,
+			Target:       th.target,
+			Serving:      th.isServing(),
+			LastResponse: th.lastResponse,
+		}
+		switch {
+		case th.lastError != nil:
+			ths.HealthError = fmt.Sprintf("health stream: %v", th.lastError)
+		case th.stats != nil:
+			ths.HealthError = th.stats.HealthError
+		}
+		status.Tablets = append(status.Tablets, ths)
+	}
+	sort.Sort(byAlias(status.Tablets))
+	return status
+}
+
+// byAlias sorts TabletHealthStatus by alias.
+type byAlias []*TabletHealthStatus
+
+func (ba byAlias) Len() int {
+	return len(ba)
+}
+
+func (ba byAlias) Swap(i, j int) {
+	ba[i], ba[j] = ba[j], ba[i]
+}
+
+func (ba byAlias) Less(i, j int) bool {
+	return ba[i].Alias < ba[j].Alias
+}
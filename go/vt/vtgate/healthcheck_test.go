@@ -0,0 +1,205 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/topo/test/faketopo"
+	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+// healthCheckTopo is a topo.Server that only knows about tablets.
+type healthCheckTopo struct {
+	faketopo.FakeTopo
+	tablets []*pb.Tablet
+}
+
+func (ht *healthCheckTopo) GetTabletsByCell(ctx context.Context, cell string) ([]*pb.TabletAlias, error) {
+	var result []*pb.TabletAlias
+	for _, tablet := range ht.tablets {
+		if tablet.Alias.Cell == cell {
+			result = append(result, tablet.Alias)
+		}
+	}
+	return result, nil
+}
+
+func (ht *healthCheckTopo) GetTablet(ctx context.Context, alias *pb.TabletAlias) (*topo.TabletInfo, error) {
+	for _, tablet := range ht.tablets {
+		if *tablet.Alias == *alias {
+			return topo.NewTabletInfo(tablet, 0), nil
+		}
+	}
+	return nil, topo.ErrNoNode
+}
+
+func healthCheckTablet(keyspace string, sbc *sandboxConn) *pb.Tablet {
+	return &pb.Tablet{
+		Alias:    &pb.TabletAlias{Cell: "aa", Uid: sbc.EndPoint().Uid},
+		Hostname: sbc.EndPoint().Host,
+		PortMap:  map[string]int32{"vt": 1},
+		Keyspace: keyspace,
+		Shard:    "0",
+	}
+}
+
+func healthResponse(keyspace string, tabletType pb.TabletType, healthError string, reparented int64) *pbq.StreamHealthResponse {
+	return &pbq.StreamHealthResponse{
+		Target: &pbq.Target{
+			Keyspace:   keyspace,
+			Shard:      "0",
+			TabletType: tabletType,
+		},
+		TabletExternallyReparentedTimestamp: reparented,
+		RealtimeStats:                       &pbq.RealtimeStats{HealthError: healthError},
+	}
+}
+
+// waitForEndPoints waits until GetEndPoints returns the want uids.
+func waitForEndPoints(hc *HealthCheck, keyspace string, tabletType pb.TabletType, want []uint32) error {
+	var got []uint32
+	for start := time.Now(); time.Now().Sub(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		endPoints, _, err := hc.GetEndPoints(context.Background(), "aa", keyspace, "0", tabletType)
+		if err != nil {
+			return err
+		}
+		got = []uint32{}
+		for _, endPoint := range endPoints.Entries {
+			got = append(got, endPoint.Uid)
+		}
+		sort.Sort(uint32Slice(got))
+		if reflect.DeepEqual(got, want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("GetEndPoints(%v): %v, want %v", tabletType, got, want)
+}
+
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+
+func TestHealthCheck(t *testing.T) {
+	keyspace := "TestHealthCheck"
+	s := createSandbox(keyspace)
+	sbcs := make([]*sandboxConn, 4)
+	ht := &healthCheckTopo{}
+	for i := range sbcs {
+		sbcs[i] = &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+		s.MapTestConn("0", sbcs[i])
+		ht.tablets = append(ht.tablets, healthCheckTablet(keyspace, sbcs[i]))
+	}
+	hc := NewHealthCheck(new(sandboxTopo), ht, []string{"aa"}, time.Hour, time.Hour, connTimeoutPerConn)
+	defer func() {
+		hc.Close()
+		for _, sbc := range sbcs {
+			close(sbc.healthResponses)
+		}
+	}()
+
+	// No tablet reported its target yet.
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_REPLICA, []uint32{}); err != nil {
+		t.Error(err)
+	}
+
+	sbcs[0].healthResponses <- healthResponse(keyspace, pb.TabletType_REPLICA, "", 0)
+	sbcs[1].healthResponses <- healthResponse(keyspace, pb.TabletType_REPLICA, "too far behind", 0)
+	sbcs[2].healthResponses <- healthResponse(keyspace, pb.TabletType_MASTER, "", 10)
+	sbcs[3].healthResponses <- healthResponse(keyspace, pb.TabletType_MASTER, "", 20)
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_REPLICA, []uint32{0}); err != nil {
+		t.Error(err)
+	}
+	// Only the latest master is used.
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_MASTER, []uint32{3}); err != nil {
+		t.Error(err)
+	}
+
+	// Type changes and health changes are picked up.
+	sbcs[1].healthResponses <- healthResponse(keyspace, pb.TabletType_REPLICA, "", 0)
+	sbcs[3].healthResponses <- healthResponse(keyspace, pb.TabletType_SPARE, "", 20)
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_REPLICA, []uint32{0, 1}); err != nil {
+		t.Error(err)
+	}
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_MASTER, []uint32{2}); err != nil {
+		t.Error(err)
+	}
+
+	// Other cells are read from the serving graph.
+	endPoints, _, err := hc.GetEndPoints(context.Background(), "bb", keyspace, "0", pb.TabletType_REPLICA)
+	if err != nil || len(endPoints.Entries) != 4 {
+		t.Errorf("GetEndPoints(bb): %v, %v, want the 4 sandbox end points", endPoints, err)
+	}
+
+	status := hc.Status()
+	if len(status.Tablets) != 4 || !status.Tablets[0].Serving || status.Tablets[0].Alias != "aa-0000000000" {
+		t.Errorf("Status: %+v", status)
+	}
+}
+
+func TestHealthCheckStreamError(t *testing.T) {
+	keyspace := "TestHealthCheckStreamError"
+	s := createSandbox(keyspace)
+	sbc := &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+	s.MapTestConn("0", sbc)
+	ht := &healthCheckTopo{tablets: []*pb.Tablet{healthCheckTablet(keyspace, sbc)}}
+	hc := NewHealthCheck(new(sandboxTopo), ht, []string{"aa"}, time.Hour, time.Hour, connTimeoutPerConn)
+	defer hc.Close()
+
+	sbc.healthResponses <- healthResponse(keyspace, pb.TabletType_REPLICA, "", 0)
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_REPLICA, []uint32{0}); err != nil {
+		t.Error(err)
+	}
+	// A broken stream removes the tablet.
+	close(sbc.healthResponses)
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_REPLICA, []uint32{}); err != nil {
+		t.Error(err)
+	}
+	want := "health stream: health stream closed"
+	if status := hc.Status(); len(status.Tablets) != 1 || status.Tablets[0].HealthError != want {
+		t.Errorf("Status: %+v, want HealthError %v", status, want)
+	}
+}
+
+func TestHealthCheckRefresh(t *testing.T) {
+	keyspace := "TestHealthCheckRefresh"
+	s := createSandbox(keyspace)
+	sbc := &sandboxConn{healthResponses: make(chan *pbq.StreamHealthResponse, 1)}
+	s.MapTestConn("0", sbc)
+	ht := &healthCheckTopo{}
+	hc := NewHealthCheck(new(sandboxTopo), ht, []string{"aa"}, time.Hour, time.Hour, connTimeoutPerConn)
+	defer func() {
+		hc.Close()
+		close(sbc.healthResponses)
+	}()
+
+	// New tablets are watched after a refresh.
+	ht.tablets = []*pb.Tablet{healthCheckTablet(keyspace, sbc)}
+	hc.refresh()
+	sbc.healthResponses <- healthResponse(keyspace, pb.TabletType_RDONLY, "", 0)
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_RDONLY, []uint32{0}); err != nil {
+		t.Error(err)
+	}
+
+	// Deleted tablets are forgotten.
+	ht.tablets = nil
+	hc.refresh()
+	if err := waitForEndPoints(hc, keyspace, pb.TabletType_RDONLY, []uint32{}); err != nil {
+		t.Error(err)
+	}
+	if status := hc.Status(); len(status.Tablets) != 0 {
+		t.Errorf("Status: %+v, want no tablet", status)
+	}
+}
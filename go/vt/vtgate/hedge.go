@@ -0,0 +1,254 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"golang.org/x/net/context"
+
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+// This file implements hedged requests: if a replica read doesn't
+// answer within the usual latency of the shard, a second copy is
+// sent to another end point, and the first answer is used.
+
+var (
+	hedgingPercentile = flag.Float64("hedging_percentile", 0, "if set, replica and rdonly reads that take longer than this percentile of the recent latencies of their shard (for instance 95) are also sent to another tablet. 0 disables hedging")
+	hedgingMinDelay   = flag.Duration("hedging_min_delay", 5*time.Millisecond, "with -hedging_percentile, minimum delay before a hedged request is sent")
+
+	// hedgedRequests counts the hedged requests, by outcome.
+	hedgedRequests = stats.NewMultiCounters("VtgateHedgedRequests", []string{"Keyspace", "ShardName", "Outcome"})
+)
+
+const (
+	// hedgeSamples is the number of latencies kept per shard.
+	hedgeSamples = 1000
+	// hedgeMinSamples is the number of latencies needed before hedging starts.
+	hedgeMinSamples = 100
+	// hedgeRecompute is the number of new latencies after which
+	// the delay is computed again.
+	hedgeRecompute = 100
+)
+
+// latencyTracker keeps the recent latencies of a shard, and
+// computes the delay after which a request is hedged.
+type latencyTracker struct {
+	percentile float64
+	minDelay   time.Duration
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	// fresh is the number of samples added since delay was computed.
+	fresh int
+	delay time.Duration
+}
+
+func newLatencyTracker(percentile float64, minDelay time.Duration) *latencyTracker {
+	return &latencyTracker{
+		percentile: percentile,
+		minDelay:   minDelay,
+		samples:    make([]time.Duration, 0, hedgeSamples),
+	}
+}
+
+// record adds a latency. The oldest one is dropped if needed.
+func (lt *latencyTracker) record(latency time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if len(lt.samples) < hedgeSamples {
+		lt.samples = append(lt.samples, latency)
+	} else {
+		lt.samples[lt.next] = latency
+		lt.next = (lt.next + 1) % hedgeSamples
+	}
+	lt.fresh++
+	if len(lt.samples) >= hedgeMinSamples && (lt.delay == 0 || lt.fresh >= hedgeRecompute) {
+		sorted := make([]time.Duration, len(lt.samples))
+		copy(sorted, lt.samples)
+		sort.Sort(durations(sorted))
+		index := int(float64(len(sorted)) * lt.percentile / 100)
+		if index >= len(sorted) {
+			index = len(sorted) - 1
+		}
+		lt.delay = sorted[index]
+		if lt.delay < lt.minDelay {
+			lt.delay = lt.minDelay
+		}
+		lt.fresh = 0
+	}
+}
+
+// hedgeDelay returns the delay after which a request is hedged,
+// or 0 if there are not enough samples yet.
+func (lt *latencyTracker) hedgeDelay() time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.delay
+}
+
+// durations sorts time.Duration.
+type durations []time.Duration
+
+func (d durations) Len() int {
+	return len(d)
+}
+
+func (d durations) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+}
+
+func (d durations) Less(i, j int) bool {
+	return d[i] < d[j]
+}
+
+// isSelect returns true if querySQL is a select.
+func isSelect(querySQL string) bool {
+	sqlKW := strings.TrimSpace(querySQL)
+	if i := strings.IndexAny(sqlKW, " \t\n("); i >= 0 {
+		sqlKW = sqlKW[:i]
+	}
+	return strings.ToLower(sqlKW) == "select"
+}
+
+// executeResult is the result of one of the copies of a hedged request.
+type executeResult struct {
+	qr    *mproto.QueryResult
+	err   error
+	hedge bool
+}
+
+// hedgedExecute executes a read. If it doesn't answer within the
+// hedge delay, it sends a copy to another end point, and returns
+// the first successful result. The other copy is cancelled.
+func (sdc *ShardConn) hedgedExecute(ctx context.Context, query string, bindVars map[string]interface{}) (*mproto.QueryResult, error) {
+	delay := sdc.latencies.hedgeDelay()
+	if delay == 0 {
+		// Not enough samples yet.
+		startTime := time.Now()
+		qr, err := sdc.execute(ctx, query, bindVars, 0)
+		if err == nil {
+			sdc.latencies.record(time.Now().Sub(startTime))
+		}
+		return qr, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan executeResult, 2)
+	go func() {
+		startTime := time.Now()
+		qr, err := sdc.execute(ctx, query, bindVars, 0)
+		if err == nil {
+			sdc.latencies.record(time.Now().Sub(startTime))
+		}
+		results <- executeResult{qr: qr, err: err}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.qr, r.err
+	case <-timer.C:
+	}
+
+	conn, err := sdc.getHedgeConn(ctx)
+	if err != nil {
+		// No other end point: wait for the first copy.
+		hedgedRequests.Add([]string{sdc.keyspace, sdc.shard, "NoEndPoint"}, 1)
+		r := <-results
+		return r.qr, r.err
+	}
+	hedgedRequests.Add([]string{sdc.keyspace, sdc.shard, "Sent"}, 1)
+	go func() {
+		startTime := time.Now()
+		qr, err := conn.Execute2(ctx, query, bindVars, 0)
+		sdc.recordLatency(conn, startTime, err)
+		sdc.recordCrossCell(conn, err)
+		if err == nil {
+			sdc.latencies.record(time.Now().Sub(startTime))
+		} else if ctx.Err() == nil {
+			sdc.closeHedgeConn(conn)
+		}
+		results <- executeResult{qr: qr, err: sdc.WrapError(err, conn.EndPoint(), false), hedge: true}
+	}()
+
+	r := <-results
+	if r.err != nil {
+		// Give a chance to the other copy.
+		if other := <-results; other.err == nil {
+			r = other
+		}
+	}
+	if r.err == nil {
+		if r.hedge {
+			hedgedRequests.Add([]string{sdc.keyspace, sdc.shard, "HedgeWon"}, 1)
+		} else {
+			hedgedRequests.Add([]string{sdc.keyspace, sdc.shard, "OriginalWon"}, 1)
+		}
+	}
+	return r.qr, r.err
+}
+
+// getHedgeConn returns a connection to an end point other than
+// the current one. The connection is kept for the next hedges.
+func (sdc *ShardConn) getHedgeConn(ctx context.Context) (tabletconn.TabletConn, error) {
+	sdc.mu.Lock()
+	var current *pb.EndPoint
+	if sdc.conn != nil {
+		current = sdc.conn.EndPoint()
+	}
+	if sdc.hedgeConn != nil && (current == nil || sdc.hedgeConn.EndPoint().Uid != current.Uid) {
+		conn := sdc.hedgeConn
+		sdc.mu.Unlock()
+		return conn, nil
+	}
+	sdc.mu.Unlock()
+
+	endPoints, err := sdc.balancer.Get()
+	if err != nil {
+		return nil, err
+	}
+	for _, endPoint := range endPoints {
+		if current != nil && endPoint.Uid == current.Uid {
+			continue
+		}
+		conn, err := tabletconn.GetDialer()(ctx, endPoint, sdc.keyspace, sdc.shard, pb.TabletType_UNKNOWN, sdc.connTimeoutPerConn)
+		if err != nil {
+			sdc.balancer.MarkDown(endPoint.Uid, err.Error())
+			continue
+		}
+		sdc.mu.Lock()
+		old := sdc.hedgeConn
+		sdc.hedgeConn = conn
+		sdc.mu.Unlock()
+		if old != nil {
+			go old.Close()
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("no other end point")
+}
+
+// closeHedgeConn closes conn if it's still the hedge connection.
+func (sdc *ShardConn) closeHedgeConn(conn tabletconn.TabletConn) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if sdc.hedgeConn != conn {
+		return
+	}
+	go conn.Close()
+	sdc.hedgeConn = nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	lt := newLatencyTracker(95, time.Millisecond)
+	for i := 1; i < hedgeMinSamples; i++ {
+		lt.record(time.Duration(i) * time.Millisecond)
+	}
+	if delay := lt.hedgeDelay(); delay != 0 {
+		t.Errorf("hedgeDelay with %v samples: %v, want 0", hedgeMinSamples-1, delay)
+	}
+	lt.record(hedgeMinSamples * time.Millisecond)
+	if delay := lt.hedgeDelay(); delay != 96*time.Millisecond {
+		t.Errorf("hedgeDelay: %v, want 96ms", delay)
+	}
+
+	// The delay is not recomputed at every sample.
+	lt.record(time.Hour)
+	if delay := lt.hedgeDelay(); delay != 96*time.Millisecond {
+		t.Errorf("hedgeDelay: %v, want 96ms", delay)
+	}
+
+	// Old samples are dropped, and minDelay is enforced.
+	for i := 0; i < hedgeSamples; i++ {
+		lt.record(time.Microsecond)
+	}
+	if delay := lt.hedgeDelay(); delay != time.Millisecond {
+		t.Errorf("hedgeDelay: %v, want 1ms", delay)
+	}
+}
+
+func TestIsSelect(t *testing.T) {
+	cases := map[string]bool{
+		"select * from t":         true,
+		"  SELECT 1":              true,
+		"select(1)":               true,
+		"insert into t values(1)": false,
+		"selected":                false,
+		"":                        false,
+	}
+	for query, want := range cases {
+		if got := isSelect(query); got != want {
+			t.Errorf("isSelect(%q): %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestShardConnHedge(t *testing.T) {
+	*hedgingPercentile = 50
+	defer func() { *hedgingPercentile = 0 }()
+	s := createSandbox("TestShardConnHedge")
+	sbc0 := &sandboxConn{}
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	s.MapTestConn("0", sbc1)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnHedge", "0", topo.TYPE_REPLICA, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+	sdc.latencies.minDelay = time.Millisecond
+	for i := 0; i < hedgeMinSamples; i++ {
+		sdc.latencies.record(time.Millisecond)
+	}
+
+	// The first query is fast: no hedge.
+	if _, err := sdc.Execute(context.Background(), "select 1", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	slow, fast := sbc0, sbc1
+	if sbc1.ExecCount.Get() == 1 {
+		slow, fast = sbc1, sbc0
+	}
+	if fast.ExecCount.Get() != 0 {
+		t.Errorf("ExecCount: %v, want 0", fast.ExecCount.Get())
+	}
+
+	// The current end point becomes slow: the hedge wins.
+	slow.mustDelay = 200 * time.Millisecond
+	hedgeWon := hedgedRequests.Counts()["TestShardConnHedge.0.HedgeWon"]
+	startTime := time.Now()
+	if _, err := sdc.Execute(context.Background(), "select 1", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Now().Sub(startTime); elapsed >= slow.mustDelay {
+		t.Errorf("Execute took %v, want less than %v", elapsed, slow.mustDelay)
+	}
+	if fast.ExecCount.Get() != 1 {
+		t.Errorf("ExecCount: %v, want 1", fast.ExecCount.Get())
+	}
+	if got := hedgedRequests.Counts()["TestShardConnHedge.0.HedgeWon"]; got != hedgeWon+1 {
+		t.Errorf("HedgeWon: %v, want %v", got, hedgeWon+1)
+	}
+}
+
+func TestShardConnNoHedge(t *testing.T) {
+	*hedgingPercentile = 50
+	defer func() { *hedgingPercentile = 0 }()
+	s := createSandbox("TestShardConnNoHedge")
+	sbc0 := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	sbc1 := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	s.MapTestConn("0", sbc0)
+	s.MapTestConn("0", sbc1)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnNoHedge", "0", topo.TYPE_REPLICA, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+	sdc.latencies.minDelay = time.Millisecond
+	for i := 0; i < hedgeMinSamples; i++ {
+		sdc.latencies.record(time.Millisecond)
+	}
+
+	// Transactions and DMLs are not hedged.
+	if _, err := sdc.Execute(context.Background(), "select 1", nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sdc.Execute(context.Background(), "insert into t values(1)", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if execCount := sbc0.ExecCount.Get() + sbc1.ExecCount.Get(); execCount != 2 {
+		t.Errorf("ExecCount: %v, want 2", execCount)
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+var (
+	maxJoinRows   = flag.Int("max_join_rows", 100000, "maximum number of rows VTGate will hold in memory for a cross-shard join")
+	joinBatchSize = flag.Int("join_batch_size", 100, "maximum number of join var values VTGate sends in a single batched query for the right side of a cross-shard join")
+)
+
+// joiner executes a SelectJoin plan. The Right plan is executed
+// once for each distinct set of join var values of the Left rows,
+// and its results are reused for the Left rows that have the
+// same values. If the plan has a BatchRight, it's executed instead
+// for up to joinBatchSize distinct values at a time.
+type joiner struct {
+	rtr     *Router
+	vcursor *requestContext
+	plan    *planbuilder.Plan
+	// varNames is the sorted list of the join var names.
+	varNames []string
+	// results caches the Right results by join var values.
+	results map[string]*mproto.QueryResult
+	// rows is the number of rows held in memory.
+	rows        int
+	rightFields []mproto.Field
+}
+
+func newJoiner(rtr *Router, vcursor *requestContext, plan *planbuilder.Plan) *joiner {
+	jn := &joiner{
+		rtr:     rtr,
+		vcursor: vcursor,
+		plan:    plan,
+		results: make(map[string]*mproto.QueryResult),
+	}
+	for name := range plan.JoinVars {
+		jn.varNames = append(jn.varNames, name)
+	}
+	sort.Strings(jn.varNames)
+	return jn
+}
+
+// Execute executes the join and returns the combined result.
+func (jn *joiner) Execute() (*mproto.QueryResult, error) {
+	left, err := jn.rtr.execSubplan(jn.vcursor, jn.plan.Left, jn.vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	if err := jn.addRows(len(left.Rows)); err != nil {
+		return nil, err
+	}
+	if jn.plan.BatchRight != nil {
+		if err := jn.batchLookup(left.Fields, left.Rows); err != nil {
+			return nil, err
+		}
+	}
+	result := &mproto.QueryResult{}
+	for _, leftRow := range left.Rows {
+		right, err := jn.lookup(left.Fields, leftRow)
+		if err != nil {
+			return nil, err
+		}
+		if len(right.Rows) == 0 {
+			if jn.plan.IsLeftJoin {
+				if err := jn.addRows(1); err != nil {
+					return nil, err
+				}
+				result.Rows = append(result.Rows, jn.joinRows(leftRow, nil))
+			}
+			continue
+		}
+		if err := jn.addRows(len(right.Rows)); err != nil {
+			return nil, err
+		}
+		for _, rightRow := range right.Rows {
+			result.Rows = append(result.Rows, jn.joinRows(leftRow, rightRow))
+		}
+	}
+	if jn.rightFields == nil {
+		if err := jn.getRightFields(); err != nil {
+			return nil, err
+		}
+	}
+	result.Fields = jn.joinFields(left.Fields)
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+// addRows accounts for rows held in memory, and fails
+// if their number exceeds maxJoinRows.
+func (jn *joiner) addRows(count int) error {
+	jn.rows += count
+	if jn.rows > *maxJoinRows {
+		return fmt.Errorf("cross-shard join exceeded the limit of %d rows", *maxJoinRows)
+	}
+	return nil
+}
+
+// lookupKey returns the key of the Right result for the join var
+// values of the Left row. It returns false if one of the values is
+// NULL, because it cannot match any Right row.
+func (jn *joiner) lookupKey(leftRow []sqltypes.Value) (string, bool) {
+	key := &bytes.Buffer{}
+	for _, name := range jn.varNames {
+		val := leftRow[jn.plan.JoinVars[name]]
+		if val.IsNull() {
+			return "", false
+		}
+		fmt.Fprintf(key, "%d:", len(val.Raw()))
+		key.Write(val.Raw())
+	}
+	return key.String(), true
+}
+
+// lookup returns the Right result for the join var values of the
+// Left row. Right is not executed if a join var is NULL.
+func (jn *joiner) lookup(leftFields []mproto.Field, leftRow []sqltypes.Value) (*mproto.QueryResult, error) {
+	key, ok := jn.lookupKey(leftRow)
+	if !ok {
+		return &mproto.QueryResult{}, nil
+	}
+	if result, ok := jn.results[key]; ok {
+		return result, nil
+	}
+	bindVars := make(map[string]interface{}, len(jn.vcursor.query.BindVariables)+len(jn.varNames))
+	for k, v := range jn.vcursor.query.BindVariables {
+		bindVars[k] = v
+	}
+	for _, name := range jn.varNames {
+		index := jn.plan.JoinVars[name]
+		v, err := mproto.Convert(leftFields[index], leftRow[index])
+		if err != nil {
+			return nil, fmt.Errorf("lookup: %v", err)
+		}
+		bindVars[name] = v
+	}
+	result, err := jn.rtr.execSubplan(jn.vcursor, jn.plan.Right, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	if err := jn.addRows(len(result.Rows)); err != nil {
+		return nil, err
+	}
+	if jn.rightFields == nil && len(result.Fields) != 0 {
+		jn.rightFields = result.Fields
+	}
+	jn.results[key] = result
+	return result, nil
+}
+
+// batchLookup executes BatchRight for the distinct values of the join
+// var of the Left rows, joinBatchSize values at a time, and saves the
+// Right result of each value for lookup. If the Right rows cannot be
+// matched exactly to the values, the remaining values are left to
+// lookup, which executes Right for each of them.
+func (jn *joiner) batchLookup(leftFields []mproto.Field, leftRows [][]sqltypes.Value) error {
+	name := jn.varNames[0]
+	index := jn.plan.JoinVars[name]
+	if len(leftRows) == 0 || isCollated(leftFields[index]) {
+		return nil
+	}
+	var keys []string
+	var vals []sqltypes.Value
+	seen := make(map[string]bool)
+	for _, leftRow := range leftRows {
+		key, ok := jn.lookupKey(leftRow)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+		vals = append(vals, leftRow[index])
+	}
+	for start := 0; start < len(keys); start += *joinBatchSize {
+		end := start + *joinBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		matched, err := jn.executeBatch(leftFields[index], keys[start:end], vals[start:end])
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return nil
+		}
+	}
+	return nil
+}
+
+// executeBatch executes BatchRight for a list of join var values, and
+// matches the returned rows to the values through their last column.
+// It returns false if the rows could not be matched.
+func (jn *joiner) executeBatch(leftField mproto.Field, keys []string, vals []sqltypes.Value) (bool, error) {
+	name := jn.varNames[0]
+	list := make([]interface{}, len(vals))
+	for i, val := range vals {
+		v, err := mproto.Convert(leftField, val)
+		if err != nil {
+			return false, fmt.Errorf("executeBatch: %v", err)
+		}
+		list[i] = v
+	}
+	bindVars := make(map[string]interface{}, len(jn.vcursor.query.BindVariables)+1)
+	for k, v := range jn.vcursor.query.BindVariables {
+		bindVars[k] = v
+	}
+	bindVars[name] = list
+	result, err := jn.rtr.execSubplan(jn.vcursor, jn.plan.BatchRight, bindVars)
+	if err != nil {
+		return false, err
+	}
+	if err := jn.addRows(len(result.Rows)); err != nil {
+		return false, err
+	}
+	var fields []mproto.Field
+	matches := make(map[string][][]sqltypes.Value)
+	if last := len(result.Fields) - 1; last >= 0 {
+		if !canMatch(leftField, result.Fields[last]) {
+			return false, nil
+		}
+		fields = result.Fields[:last]
+		if jn.rightFields == nil {
+			jn.rightFields = fields
+		}
+		for _, row := range result.Rows {
+			key := string(valueKey(result.Fields[last], row[last]))
+			matches[key] = append(matches[key], row[:last])
+		}
+		for i, val := range vals {
+			rows := matches[string(valueKey(leftField, val))]
+			jn.results[keys[i]] = &mproto.QueryResult{
+				Fields:       fields,
+				Rows:         rows,
+				RowsAffected: uint64(len(rows)),
+			}
+		}
+		return true, nil
+	}
+	for _, key := range keys {
+		jn.results[key] = &mproto.QueryResult{}
+	}
+	return true, nil
+}
+
+// isNumeric returns true if the values of field are numbers.
+func isNumeric(field mproto.Field) bool {
+	switch field.Type {
+	case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG, mproto.VT_INT24, mproto.VT_LONGLONG, mproto.VT_YEAR,
+		mproto.VT_FLOAT, mproto.VT_DOUBLE, mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		return true
+	}
+	return false
+}
+
+// canMatch returns true if the rows of a batch can be matched exactly
+// to the join var values through their keys: both sides must be numbers,
+// or both must be binary strings. Other values are compared by MySQL
+// with a collation or a conversion that VTGate can only approximate.
+func canMatch(leftField, rightField mproto.Field) bool {
+	if isCollated(leftField) || isCollated(rightField) {
+		return false
+	}
+	return isNumeric(leftField) == isNumeric(rightField)
+}
+
+// getRightFields fetches the fields of the Right result
+// by sending the FieldQuery to the first shard of its table.
+func (jn *joiner) getRightFields() error {
+	vcursor := jn.vcursor
+	ks, _, allShards, err := getKeyspaceShards(vcursor.ctx, jn.rtr.serv, jn.rtr.cell, jn.plan.Right.Table.Keyspace.Name, vcursor.query.TabletType)
+	if err != nil {
+		return fmt.Errorf("getRightFields: %v", err)
+	}
+	result, err := jn.rtr.scatterConn.Execute(
+		vcursor.ctx,
+		jn.plan.FieldQuery,
+		vcursor.query.BindVariables,
+		ks,
+		[]string{allShards[0].Name},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return err
+	}
+	jn.rightFields = result.Fields
+	return nil
+}
+
+func (jn *joiner) joinFields(leftFields []mproto.Field) []mproto.Field {
+	fields := make([]mproto.Field, len(jn.plan.JoinCols))
+	for i, col := range jn.plan.JoinCols {
+		if col < 0 {
+			fields[i] = leftFields[-col-1]
+			continue
+		}
+		fields[i] = jn.rightFields[col-1]
+	}
+	return fields
+}
+
+// joinRows builds a result row from a Left row and a Right row.
+// If rightRow is nil, the Right columns are NULL.
+func (jn *joiner) joinRows(leftRow, rightRow []sqltypes.Value) []sqltypes.Value {
+	row := make([]sqltypes.Value, len(jn.plan.JoinCols))
+	for i, col := range jn.plan.JoinCols {
+		if col < 0 {
+			row[i] = leftRow[-col-1]
+			continue
+		}
+		if rightRow != nil {
+			row[i] = rightRow[col-1]
+		}
+	}
+	return row
+}
+
+// execSubplan executes a plan that is part of a SelectJoin
+// or SelectUnion using the specified bind vars.
+func (rtr *Router) execSubplan(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}) (*mproto.QueryResult, error) {
+	return rtr.execPlan(newSubplanContext(vcursor, plan, bindVars), plan)
+}
+
+// newSubplanContext returns the requestContext for executing
+// a subplan within the context of the original request.
+func newSubplanContext(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}) *requestContext {
+	query := &proto.Query{
+		Sql:              plan.Original,
+		BindVariables:    bindVars,
+		TabletType:       vcursor.query.TabletType,
+		Session:          vcursor.query.Session,
+		NotInTransaction: vcursor.query.NotInTransaction,
+	}
+	return newRequestContext(vcursor.ctx, query, vcursor.router)
+}
@@ -0,0 +1,244 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/streamlog"
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	// The flag has its own name, so that it doesn't collide
+	// with the query log flag of vttablet.
+	queryLogHandler = flag.String("vtgate-query-log-stream-handler", "/debug/querylog", "URL handler for streaming the vtgate queries log")
+
+	// QueryLogger is the stream logger of the requests served by vtgate.
+	QueryLogger = streamlog.New("VTGate", 50)
+)
+
+// LogStats records the stats of a single vtgate request.
+// It travels with the context of the request, so that the
+// router and the scatter conn can fill it in.
+type LogStats struct {
+	Method        string
+	Sql           string
+	BindVariables map[string]interface{}
+	TabletType    topo.TabletType
+	// Keyspace is the keyspace of the request. For V3 queries,
+	// it's the keyspace of the table of the plan.
+	Keyspace string
+	// PlanID is the plan of V3 queries. It's empty
+	// for the other requests.
+	PlanID       string
+	StartTime    time.Time
+	EndTime      time.Time
+	RowsAffected uint64
+	RowsReturned int
+	Error        error
+	ctx          context.Context
+
+	mu     sync.Mutex
+	shards []string
+}
+
+type logStatsKey int
+
+func newLogStats(ctx context.Context, method, sql string, bindVariables map[string]interface{}, keyspace string, tabletType topo.TabletType) (*LogStats, context.Context) {
+	logStats := &LogStats{
+		Method:        method,
+		Sql:           sql,
+		BindVariables: bindVariables,
+		Keyspace:      keyspace,
+		TabletType:    tabletType,
+		StartTime:     time.Now(),
+		ctx:           ctx,
+	}
+	return logStats, context.WithValue(ctx, logStatsKey(0), logStats)
+}
+
+// batchSql returns the queries of a batch, separated by semicolons.
+func batchSql(queries []proto.BoundShardQuery) string {
+	sqls := make([]string, len(queries))
+	for i, query := range queries {
+		sqls[i] = query.Sql
+	}
+	return strings.Join(sqls, "; ")
+}
+
+// batchKeyspaceIdSql is like batchSql for keyspace id queries.
+func batchKeyspaceIdSql(queries []proto.BoundKeyspaceIdQuery) string {
+	sqls := make([]string, len(queries))
+	for i, query := range queries {
+		sqls[i] = query.Sql
+	}
+	return strings.Join(sqls, "; ")
+}
+
+// logStatsFromContext returns the LogStats of the request, or nil.
+func logStatsFromContext(ctx context.Context) *LogStats {
+	logStats, _ := ctx.Value(logStatsKey(0)).(*LogStats)
+	return logStats
+}
+
+// setPlan records the plan of a V3 query. Only the first plan is
+// recorded, so that the queries sent to the lookup vindexes don't
+// replace the plan of the request.
+func (stats *LogStats) setPlan(plan *planbuilder.Plan) {
+	if stats == nil || stats.PlanID != "" {
+		return
+	}
+	stats.PlanID = plan.ID.String()
+	if plan.Table != nil && plan.Table.Keyspace != nil {
+		stats.Keyspace = plan.Table.Keyspace.Name
+	}
+}
+
+// addShards records the shards the request was sent to.
+func (stats *LogStats) addShards(keyspace string, shards []string) {
+	if stats == nil {
+		return
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	for _, shard := range shards {
+		name := keyspace + "/" + shard
+		if !containsString(stats.shards, name) {
+			stats.shards = append(stats.shards, name)
+		}
+	}
+}
+
+// Shards returns the keyspace/shard names the request was sent to.
+func (stats *LogStats) Shards() []string {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.shards
+}
+
+// Send finalizes a record and sends it
+func (stats *LogStats) Send() {
+	stats.EndTime = time.Now()
+	QueryLogger.Send(stats)
+}
+
+// TotalTime returns how long this request has been running
+func (stats *LogStats) TotalTime() time.Duration {
+	return stats.EndTime.Sub(stats.StartTime)
+}
+
+// FmtBindVariables returns the map of bind variables as JSON. For
+// values that are strings or byte slices it only reports their type
+// and length.
+func (stats *LogStats) FmtBindVariables(full bool) string {
+	var out map[string]interface{}
+	if full {
+		out = stats.BindVariables
+	} else {
+		out = make(map[string]interface{})
+		for k, v := range stats.BindVariables {
+			switch val := v.(type) {
+			case string:
+				out[k] = fmt.Sprintf("string %v", len(val))
+			case []byte:
+				out[k] = fmt.Sprintf("bytes %v", len(val))
+			default:
+				out[k] = v
+			}
+		}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		log.Warningf("could not marshal %q", stats.BindVariables)
+		return ""
+	}
+	return string(b)
+}
+
+// ContextHTML returns the HTML version of the context that was used, or "".
+func (stats *LogStats) ContextHTML() template.HTML {
+	return callinfo.HTMLFromContext(stats.ctx)
+}
+
+// ErrorStr returns the error string or ""
+func (stats *LogStats) ErrorStr() string {
+	if stats.Error != nil {
+		return stats.Error.Error()
+	}
+	return ""
+}
+
+// RemoteAddr returns the address of the client, if known.
+func (stats *LogStats) RemoteAddr() string {
+	ci, ok := callinfo.FromContext(stats.ctx)
+	if !ok {
+		return ""
+	}
+	return ci.RemoteAddr()
+}
+
+// EffectiveCaller returns the principal of the effective caller.
+func (stats *LogStats) EffectiveCaller() string {
+	return callerid.GetPrincipal(callerid.EffectiveCallerIDFromContext(stats.ctx))
+}
+
+// ImmediateCaller returns the username of the immediate caller.
+func (stats *LogStats) ImmediateCaller() string {
+	return callerid.GetUsername(callerid.ImmediateCallerIDFromContext(stats.ctx))
+}
+
+// Format returns a tab separated list of logged fields.
+func (stats *LogStats) Format(params url.Values) string {
+	_, fullBindParams := params["full"]
+	return fmt.Sprintf(
+		"%v\t%v\t%v\t%v\t%v\t%v\t%.6f\t%v\t%q\t%v\t%v\t%v\t%v\t%v\t%v\t%q\t\n",
+		stats.Method,
+		stats.RemoteAddr(),
+		stats.EffectiveCaller(),
+		stats.ImmediateCaller(),
+		stats.StartTime.Format(time.StampMicro),
+		stats.EndTime.Format(time.StampMicro),
+		stats.TotalTime().Seconds(),
+		stats.PlanID,
+		stats.Sql,
+		stats.FmtBindVariables(fullBindParams),
+		stats.TabletType,
+		stats.Keyspace,
+		strings.Join(stats.Shards(), ","),
+		stats.RowsAffected,
+		stats.RowsReturned,
+		stats.ErrorStr(),
+	)
+}
+
+// buildFmter returns the formatter of the messages of logger
+// for its HTTP handler.
+func buildFmter(logger *streamlog.StreamLogger) func(url.Values, interface{}) string {
+	type formatter interface {
+		Format(url.Values) string
+	}
+
+	return func(params url.Values, val interface{}) string {
+		fmter, ok := val.(formatter)
+		if !ok {
+			return fmt.Sprintf("Error: unexpected value of type %T in %s!", val, logger.Name())
+		}
+		return fmter.Format(params)
+	}
+}
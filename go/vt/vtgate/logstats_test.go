@@ -0,0 +1,105 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestLogStatsFormat(t *testing.T) {
+	ctx := callerid.NewContext(context.Background(), callerid.NewEffectiveCallerID("app", "", ""), callerid.NewImmediateCallerID("user1"))
+	logStats, _ := newLogStats(ctx, "Execute", "select * from user where name = :name", map[string]interface{}{"name": "abcd", "id": 1}, "", topo.TYPE_MASTER)
+	logStats.addShards("ks", []string{"-80", "80-"})
+	logStats.addShards("ks", []string{"-80"})
+	logStats.PlanID = "SelectScatter"
+	logStats.Error = errors.New("err")
+	logStats.EndTime = logStats.StartTime.Add(time.Second)
+
+	fields := strings.Split(logStats.Format(url.Values{}), "\t")
+	want := map[int]string{
+		0:  "Execute",
+		2:  "app",
+		3:  "user1",
+		6:  "1.000000",
+		7:  "SelectScatter",
+		8:  `"select * from user where name = :name"`,
+		9:  `{"id":1,"name":"string 4"}`,
+		10: "master",
+		12: "ks/-80,ks/80-",
+		15: `"err"`,
+	}
+	for i, w := range want {
+		if fields[i] != w {
+			t.Errorf("field %d: %s, want %s", i, fields[i], w)
+		}
+	}
+
+	fields = strings.Split(logStats.Format(url.Values{"full": nil}), "\t")
+	if want := `{"id":1,"name":"abcd"}`; fields[9] != want {
+		t.Errorf("full bind vars: %s, want %s", fields[9], want)
+	}
+}
+
+func TestLogStatsRouter(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+
+	logStats, ctx := newLogStats(context.Background(), "Execute", "", nil, "", topo.TYPE_MASTER)
+	_, err := router.Execute(ctx, &proto.Query{
+		Sql:        "select * from music where id in (1, 2)",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plan of the lookup queries is not recorded,
+	// but their shards are.
+	if logStats.PlanID != "SelectIN" || logStats.Keyspace != "TestRouter" {
+		t.Errorf("PlanID, Keyspace: %s, %s, want SelectIN, TestRouter", logStats.PlanID, logStats.Keyspace)
+	}
+	if want := []string{"TestUnsharded/0", "TestRouter/-20"}; !reflect.DeepEqual(logStats.Shards(), want) {
+		t.Errorf("Shards: %v, want %v", logStats.Shards(), want)
+	}
+}
+
+func TestVTGateQueryLog(t *testing.T) {
+	keyspace := "TestVTGateQueryLog"
+	sandbox := createSandbox(keyspace)
+	sandbox.MapTestConn("0", &sandboxConn{})
+	ch := QueryLogger.Subscribe("test")
+	defer QueryLogger.Unsubscribe(ch)
+
+	q := proto.QueryShard{
+		Sql:        "query",
+		Keyspace:   keyspace,
+		Shards:     []string{"0"},
+		TabletType: topo.TYPE_RDONLY,
+	}
+	if err := rpcVTGate.ExecuteShard(context.Background(), &q, new(proto.QueryResult)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		logStats := (<-ch).(*LogStats)
+		if logStats.Keyspace != keyspace {
+			continue
+		}
+		if logStats.Method != "ExecuteShard" || logStats.RowsReturned != 1 || logStats.Error != nil {
+			t.Errorf("logStats: %+v", logStats)
+		}
+		if want := []string{keyspace + "/0"}; !reflect.DeepEqual(logStats.Shards(), want) {
+			t.Errorf("Shards: %v, want %v", logStats.Shards(), want)
+		}
+		break
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/youtube/vitess/go/mysql/collation"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// mergeParams specifies how the results of a multi-shard
+// query must be merged by VTGate.
+type mergeParams struct {
+	orderBy []planbuilder.OrderByParams
+	// offset is the number of merged rows to skip.
+	offset int64
+	// count is the maximum number of rows to return after
+	// skipping offset rows. A negative value means no limit.
+	count int64
+	// shardLimit is the value of LimitVarName for the
+	// rewritten query, or -1 if it doesn't use it.
+	shardLimit int64
+}
+
+// newMergeParams builds the mergeParams for a SelectMerge plan.
+func newMergeParams(plan *planbuilder.Plan, bindVars map[string]interface{}) (*mergeParams, error) {
+	merge := &mergeParams{
+		orderBy:    plan.OrderBy,
+		count:      -1,
+		shardLimit: -1,
+	}
+	if plan.Limit == nil {
+		return merge, nil
+	}
+	var err error
+	merge.count, err = resolveLimit(plan.Limit, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Offset == nil {
+		return merge, nil
+	}
+	merge.offset, err = resolveLimit(plan.Offset, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	_, limitIsVar := plan.Limit.(string)
+	_, offsetIsVar := plan.Offset.(string)
+	if limitIsVar || offsetIsVar {
+		merge.shardLimit = merge.offset + merge.count
+	}
+	return merge, nil
+}
+
+// addShardLimit adds the value of LimitVarName to the bind vars
+// of every shard, if the rewritten query uses it. The bind vars
+// are copied, so that the ones of the client are not modified.
+func (merge *mergeParams) addShardLimit(shardVars map[string]map[string]interface{}) {
+	if merge.shardLimit < 0 {
+		return
+	}
+	for shard, bv := range shardVars {
+		newbv := make(map[string]interface{}, len(bv)+1)
+		for k, v := range bv {
+			newbv[k] = v
+		}
+		newbv[planbuilder.LimitVarName] = merge.shardLimit
+		shardVars[shard] = newbv
+	}
+}
+
+// resolveLimit returns the value of a LIMIT clause parameter,
+// which can be an int64 or the name of a bind var.
+func resolveLimit(val interface{}, bindVars map[string]interface{}) (int64, error) {
+	if name, ok := val.(string); ok {
+		v, ok := bindVars[name[1:]]
+		if !ok {
+			return 0, fmt.Errorf("could not find bind var %s", name)
+		}
+		val = v
+	}
+	var num int64
+	switch val := val.(type) {
+	case int:
+		num = int64(val)
+	case int32:
+		num = int64(val)
+	case int64:
+		num = val
+	case uint32:
+		num = int64(val)
+	case uint64:
+		num = int64(val)
+	default:
+		return 0, fmt.Errorf("unexpected type for limit: %T", val)
+	}
+	if num < 0 {
+		return 0, fmt.Errorf("negative limit: %d", num)
+	}
+	return num, nil
+}
+
+// shardResult is a result sent by a shard to a resultMerger.
+// A nil qr means that the shard has no more results.
+type shardResult struct {
+	shard string
+	qr    *mproto.QueryResult
+}
+
+// resultMerger performs a k-way merge of the rows returned
+// by multiple shards. Every shard is expected to return its
+// rows sorted by the order by columns. A row is released only
+// after every shard that's still streaming has contributed
+// at least one candidate row.
+type resultMerger struct {
+	params *mergeParams
+	fields []mproto.Field
+	cols   []int
+	queues map[string][][]sqltypes.Value
+	done   map[string]bool
+	// skipped and sent track the offset and count.
+	skipped, sent int64
+}
+
+func newResultMerger(params *mergeParams, shards []string) *resultMerger {
+	rm := &resultMerger{
+		params: params,
+		queues: make(map[string][][]sqltypes.Value),
+		done:   make(map[string]bool),
+	}
+	for shard := range unique(shards) {
+		rm.queues[shard] = nil
+	}
+	return rm
+}
+
+// Fields returns the fields of the result, or nil if
+// they haven't been received yet.
+func (rm *resultMerger) Fields() []mproto.Field {
+	return rm.fields
+}
+
+// Add adds a shard result to the merger and returns the rows
+// that can be released in sorted order.
+func (rm *resultMerger) Add(sr *shardResult) ([][]sqltypes.Value, error) {
+	if sr.qr == nil {
+		rm.done[sr.shard] = true
+		return rm.release(), nil
+	}
+	if rm.fields == nil && len(sr.qr.Fields) != 0 {
+		if err := rm.setFields(sr.qr.Fields); err != nil {
+			return nil, err
+		}
+	}
+	if len(sr.qr.Rows) == 0 {
+		return nil, nil
+	}
+	rm.queues[sr.shard] = append(rm.queues[sr.shard], sr.qr.Rows...)
+	return rm.release(), nil
+}
+
+// Finish marks all shards as done and returns
+// the remaining rows.
+func (rm *resultMerger) Finish() [][]sqltypes.Value {
+	for shard := range rm.queues {
+		rm.done[shard] = true
+	}
+	return rm.release()
+}
+
+func (rm *resultMerger) setFields(fields []mproto.Field) error {
+	cols := make([]int, 0, len(rm.params.orderBy))
+	for _, order := range rm.params.orderBy {
+		col := -1
+		for i, field := range fields {
+			if field.Name == order.Col {
+				col = i
+				break
+			}
+		}
+		if col == -1 {
+			return fmt.Errorf("order by column %s not found in result", order.Col)
+		}
+		cols = append(cols, col)
+	}
+	rm.fields = fields
+	rm.cols = cols
+	return nil
+}
+
+func (rm *resultMerger) limitReached() bool {
+	return rm.params.count >= 0 && rm.sent >= rm.params.count
+}
+
+func (rm *resultMerger) release() (rows [][]sqltypes.Value) {
+	for !rm.limitReached() {
+		next := ""
+		for shard, queue := range rm.queues {
+			if len(queue) == 0 {
+				if !rm.done[shard] {
+					// We can't release anything until this
+					// shard sends more rows.
+					return rows
+				}
+				continue
+			}
+			if next == "" || rm.less(queue[0], rm.queues[next][0]) {
+				next = shard
+			}
+		}
+		if next == "" {
+			return rows
+		}
+		row := rm.queues[next][0]
+		rm.queues[next] = rm.queues[next][1:]
+		if rm.skipped < rm.params.offset {
+			rm.skipped++
+			continue
+		}
+		rows = append(rows, row)
+		rm.sent++
+	}
+	return rows
+}
+
+func (rm *resultMerger) less(row1, row2 []sqltypes.Value) bool {
+	for i, col := range rm.cols {
+		cmp := compareValues(rm.fields[col], row1[col], row2[col])
+		if cmp == 0 {
+			continue
+		}
+		if rm.params.orderBy[i].Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return false
+}
+
+// compareValues compares two values of the specified field
+// the way MySQL would sort them. NULL values sort first.
+func compareValues(field mproto.Field, v1, v2 sqltypes.Value) int {
+	switch {
+	case v1.IsNull() && v2.IsNull():
+		return 0
+	case v1.IsNull():
+		return -1
+	case v2.IsNull():
+		return 1
+	}
+	if isCollated(field) {
+		return collation.Compare(v1.Raw(), v2.Raw())
+	}
+	switch field.Type {
+	case mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		f1, err1 := strconv.ParseFloat(v1.String(), 64)
+		f2, err2 := strconv.ParseFloat(v2.String(), 64)
+		if err1 == nil && err2 == nil {
+			return compareFloats(f1, f2)
+		}
+		return bytes.Compare(v1.Raw(), v2.Raw())
+	}
+	n1, err1 := mproto.Convert(field, v1)
+	n2, err2 := mproto.Convert(field, v2)
+	if err1 != nil || err2 != nil {
+		return bytes.Compare(v1.Raw(), v2.Raw())
+	}
+	switch n1 := n1.(type) {
+	case int64:
+		n2 := n2.(int64)
+		switch {
+		case n1 < n2:
+			return -1
+		case n1 > n2:
+			return 1
+		}
+		return 0
+	case uint64:
+		n2 := n2.(uint64)
+		switch {
+		case n1 < n2:
+			return -1
+		case n1 > n2:
+			return 1
+		}
+		return 0
+	case float64:
+		return compareFloats(n1, n2.(float64))
+	}
+	return bytes.Compare(v1.Raw(), v2.Raw())
+}
+
+// isCollated returns true if the values of field are strings that
+// MySQL compares with a collation. VTGate doesn't know the collation
+// of the column, so it assumes utf8_general_ci, the default one.
+// The binary collations set the binary flag.
+func isCollated(field mproto.Field) bool {
+	switch field.Type {
+	case mproto.VT_VARCHAR, mproto.VT_VAR_STRING, mproto.VT_STRING,
+		mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB:
+		return field.Flags&mproto.VT_BINARY_FLAG == 0
+	}
+	return false
+}
+
+func compareFloats(f1, f2 float64) int {
+	switch {
+	case f1 < f2:
+		return -1
+	case f1 > f2:
+		return 1
+	}
+	return 0
+}
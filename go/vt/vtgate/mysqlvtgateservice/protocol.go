@@ -0,0 +1,502 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlvtgateservice
+
+// This file contains the encoding of the MySQL client/server
+// protocol: packets, handshake, and the OK, ERR, EOF and
+// text resultset responses.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+)
+
+const (
+	// maxPacketSize is the largest payload of a single packet.
+	// Bigger payloads are split.
+	maxPacketSize = 1<<24 - 1
+
+	// maxHandshakePayloadSize is the largest payload accepted
+	// before the client is authenticated.
+	maxHandshakePayloadSize = 64 * 1024
+
+	protocolVersion = 10
+	serverVersion   = "5.5.10-Vitess"
+
+	// mysqlNativePassword is the only supported auth method.
+	mysqlNativePassword = "mysql_native_password"
+)
+
+// Capability flags.
+const (
+	clientLongPassword               = 0x00000001
+	clientLongFlag                   = 0x00000004
+	clientConnectWithDB              = 0x00000008
+	clientProtocol41                 = 0x00000200
+	clientTransactions               = 0x00002000
+	clientSecureConnection           = 0x00008000
+	clientPluginAuth                 = 0x00080000
+	clientPluginAuthLenencClientData = 0x00200000
+
+	serverCapabilities = clientLongPassword | clientLongFlag | clientConnectWithDB | clientProtocol41 |
+		clientTransactions | clientSecureConnection | clientPluginAuth | clientPluginAuthLenencClientData
+)
+
+// Status flags.
+const (
+	serverStatusInTrans    = 0x0001
+	serverStatusAutocommit = 0x0002
+)
+
+// Commands.
+const (
+	comQuit   = 0x01
+	comInitDB = 0x02
+	comQuery  = 0x03
+	comPing   = 0x0e
+)
+
+// Packet headers.
+const (
+	okPacket  = 0x00
+	eofPacket = 0xfe
+	errPacket = 0xff
+)
+
+// Error codes, and their SQL state.
+const (
+	erUnknownComError   = 1047
+	erBadDbError        = 1049
+	erAccessDenied      = 1045
+	erUnknownError      = 1105
+	erHandshakeError    = 1043
+	erNetPacketTooLarge = 1153
+
+	ssUnknownComError = "08S01"
+	ssBadDbError      = "42000"
+	ssAccessDenied    = "28000"
+	ssUnknownError    = "HY000"
+)
+
+// Character sets used in the column definitions.
+const (
+	charsetUtf8   = 33
+	charsetBinary = 63
+)
+
+// packetConn reads and writes MySQL packets on a connection.
+// Each packet carries a sequence number, which is reset to 0
+// at the beginning of every command.
+type packetConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	sequence uint8
+	// maxPayloadSize is the largest payload readPacket accepts.
+	// 0 means no limit.
+	maxPayloadSize int
+}
+
+func newPacketConn(conn net.Conn) *packetConn {
+	return &packetConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+// errPacketTooLarge is returned by readPacket for
+// payloads bigger than maxPayloadSize.
+var errPacketTooLarge = errors.New("readPacket: payload too large")
+
+// readPacket reads a full payload, joining the packets
+// of payloads that don't fit in one.
+func (pc *packetConn) readPacket() ([]byte, error) {
+	var result []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(pc.reader, header[:]); err != nil {
+			return nil, err
+		}
+		length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		if header[3] != pc.sequence {
+			return nil, fmt.Errorf("readPacket: invalid sequence %v, expected %v", header[3], pc.sequence)
+		}
+		pc.sequence++
+		if pc.maxPayloadSize > 0 && len(result)+length > pc.maxPayloadSize {
+			return nil, errPacketTooLarge
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(pc.reader, data); err != nil {
+			return nil, fmt.Errorf("readPacket: %v", err)
+		}
+		result = append(result, data...)
+		if length < maxPacketSize {
+			return result, nil
+		}
+	}
+}
+
+// writePacket writes a payload, splitting it if needed.
+// Data is only sent by flush.
+func (pc *packetConn) writePacket(data []byte) error {
+	for {
+		length := len(data)
+		if length > maxPacketSize {
+			length = maxPacketSize
+		}
+		header := []byte{byte(length), byte(length >> 8), byte(length >> 16), pc.sequence}
+		pc.sequence++
+		if _, err := pc.writer.Write(header); err != nil {
+			return err
+		}
+		if _, err := pc.writer.Write(data[:length]); err != nil {
+			return err
+		}
+		data = data[length:]
+		// A payload of exactly maxPacketSize is followed by an empty packet.
+		if length < maxPacketSize {
+			return nil
+		}
+	}
+}
+
+func (pc *packetConn) flush() error {
+	return pc.writer.Flush()
+}
+
+// newSalt returns the random data used to scramble the password.
+// It doesn't contain any 0, as it's sent null-terminated.
+func newSalt() ([]byte, error) {
+	salt := make([]byte, 20)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	for i := range salt {
+		salt[i] &= 0x7f
+		if salt[i] == 0 || salt[i] == '$' {
+			salt[i]++
+		}
+	}
+	return salt, nil
+}
+
+// scramblePassword computes the mysql_native_password auth response:
+// SHA1(password) XOR SHA1(salt + SHA1(SHA1(password))).
+func scramblePassword(salt []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	hash := sha1.New()
+	hash.Write(salt)
+	hash.Write(stage2[:])
+	scramble := hash.Sum(nil)
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
+
+// writeHandshake writes the initial handshake packet (protocol 10).
+func (pc *packetConn) writeHandshake(connectionID uint32, salt []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(protocolVersion)
+	writeNullString(&buf, serverVersion)
+	binary.Write(&buf, binary.LittleEndian, connectionID)
+	buf.Write(salt[:8])
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.LittleEndian, uint16(serverCapabilities&0xffff))
+	buf.WriteByte(charsetUtf8)
+	binary.Write(&buf, binary.LittleEndian, uint16(serverStatusAutocommit))
+	binary.Write(&buf, binary.LittleEndian, uint16(serverCapabilities>>16))
+	buf.WriteByte(byte(len(salt) + 1))
+	buf.Write(make([]byte, 10))
+	buf.Write(salt[8:])
+	buf.WriteByte(0)
+	writeNullString(&buf, mysqlNativePassword)
+	return pc.writePacket(buf.Bytes())
+}
+
+// handshakeResponse is the content of the client handshake response.
+type handshakeResponse struct {
+	capabilities uint32
+	user         string
+	authResponse []byte
+	db           string
+	authPlugin   string
+}
+
+// parseHandshakeResponse parses a HandshakeResponse41 packet.
+func parseHandshakeResponse(data []byte) (*handshakeResponse, error) {
+	r := &packetReader{data: data}
+	capabilities := r.readUint32()
+	if capabilities&clientProtocol41 == 0 {
+		return nil, fmt.Errorf("parseHandshakeResponse: client doesn't support protocol 4.1")
+	}
+	// max packet size, character set, reserved.
+	r.skip(4 + 1 + 23)
+	hr := &handshakeResponse{
+		capabilities: capabilities,
+		user:         r.readNullString(),
+	}
+	switch {
+	case capabilities&clientPluginAuthLenencClientData != 0:
+		hr.authResponse = r.readLenencBytes()
+	case capabilities&clientSecureConnection != 0:
+		hr.authResponse = r.readBytes(int(r.readByte()))
+	default:
+		hr.authResponse = []byte(r.readNullString())
+	}
+	if capabilities&clientConnectWithDB != 0 {
+		hr.db = r.readNullString()
+	}
+	if capabilities&clientPluginAuth != 0 {
+		hr.authPlugin = r.readNullString()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("parseHandshakeResponse: %v", r.err)
+	}
+	return hr, nil
+}
+
+// writeAuthSwitchRequest asks the client to use mysql_native_password.
+func (pc *packetConn) writeAuthSwitchRequest(salt []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(eofPacket)
+	writeNullString(&buf, mysqlNativePassword)
+	buf.Write(salt)
+	buf.WriteByte(0)
+	return pc.writePacket(buf.Bytes())
+}
+
+// writeOK writes an OK packet.
+func (pc *packetConn) writeOK(rowsAffected, insertID uint64, status uint16) error {
+	var buf bytes.Buffer
+	buf.WriteByte(okPacket)
+	writeLenencInt(&buf, rowsAffected)
+	writeLenencInt(&buf, insertID)
+	binary.Write(&buf, binary.LittleEndian, status)
+	// warnings
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	return pc.writePacket(buf.Bytes())
+}
+
+// writeEOF writes an EOF packet.
+func (pc *packetConn) writeEOF(status uint16) error {
+	var buf bytes.Buffer
+	buf.WriteByte(eofPacket)
+	// warnings
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	binary.Write(&buf, binary.LittleEndian, status)
+	return pc.writePacket(buf.Bytes())
+}
+
+// writeError writes an ERR packet.
+func (pc *packetConn) writeError(code uint16, sqlState string, format string, args ...interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteByte(errPacket)
+	binary.Write(&buf, binary.LittleEndian, code)
+	buf.WriteByte('#')
+	buf.WriteString(sqlState)
+	fmt.Fprintf(&buf, format, args...)
+	return pc.writePacket(buf.Bytes())
+}
+
+// writeResult writes a query result: an OK packet if it has no
+// fields, and a text resultset otherwise.
+func (pc *packetConn) writeResult(qr *mproto.QueryResult, status uint16) error {
+	if len(qr.Fields) == 0 {
+		return pc.writeOK(qr.RowsAffected, qr.InsertId, status)
+	}
+	var buf bytes.Buffer
+	writeLenencInt(&buf, uint64(len(qr.Fields)))
+	if err := pc.writePacket(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, field := range qr.Fields {
+		if err := pc.writePacket(columnDefinition(field)); err != nil {
+			return err
+		}
+	}
+	if err := pc.writeEOF(status); err != nil {
+		return err
+	}
+	for _, row := range qr.Rows {
+		buf.Reset()
+		for _, value := range row {
+			if value.IsNull() {
+				buf.WriteByte(0xfb)
+				continue
+			}
+			writeLenencBytes(&buf, value.Raw())
+		}
+		if err := pc.writePacket(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return pc.writeEOF(status)
+}
+
+// columnDefinition returns a ColumnDefinition41 packet for field.
+func columnDefinition(field mproto.Field) []byte {
+	var buf bytes.Buffer
+	// catalog, schema, table, org_table
+	writeLenencBytes(&buf, []byte("def"))
+	writeLenencBytes(&buf, nil)
+	writeLenencBytes(&buf, nil)
+	writeLenencBytes(&buf, nil)
+	// name, org_name
+	writeLenencBytes(&buf, []byte(field.Name))
+	writeLenencBytes(&buf, []byte(field.Name))
+	// length of the fixed fields
+	buf.WriteByte(0x0c)
+	charset := uint16(charsetUtf8)
+	switch field.Type {
+	case mproto.VT_VARCHAR, mproto.VT_VAR_STRING, mproto.VT_STRING, mproto.VT_ENUM, mproto.VT_SET,
+		mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB:
+		if field.Flags&mproto.VT_BINARY_FLAG != 0 {
+			charset = charsetBinary
+		}
+	default:
+		charset = charsetBinary
+	}
+	binary.Write(&buf, binary.LittleEndian, charset)
+	// column length
+	binary.Write(&buf, binary.LittleEndian, uint32(255))
+	buf.WriteByte(byte(field.Type))
+	binary.Write(&buf, binary.LittleEndian, uint16(field.Flags))
+	// decimals, filler
+	buf.Write([]byte{0, 0, 0})
+	return buf.Bytes()
+}
+
+func writeNullString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.WriteByte(0)
+}
+
+func writeLenencInt(buf *bytes.Buffer, i uint64) {
+	switch {
+	case i < 251:
+		buf.WriteByte(byte(i))
+	case i < 1<<16:
+		buf.WriteByte(0xfc)
+		binary.Write(buf, binary.LittleEndian, uint16(i))
+	case i < 1<<24:
+		buf.WriteByte(0xfd)
+		buf.Write([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+	default:
+		buf.WriteByte(0xfe)
+		binary.Write(buf, binary.LittleEndian, i)
+	}
+}
+
+func writeLenencBytes(buf *bytes.Buffer, b []byte) {
+	writeLenencInt(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+// packetReader decodes the fields of a packet. The first error
+// is kept in err, and all the reads after it return zero values.
+type packetReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *packetReader) readBytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("packet too short: %v bytes, need %v", len(r.data), r.pos+n)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *packetReader) skip(n int) {
+	r.readBytes(n)
+}
+
+func (r *packetReader) readByte() byte {
+	b := r.readBytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *packetReader) readUint32() uint32 {
+	b := r.readBytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// readNullString reads a null-terminated string. The terminating
+// 0 is optional at the end of the packet.
+func (r *packetReader) readNullString() string {
+	if r.err != nil || r.pos >= len(r.data) {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+	if end < 0 {
+		s := string(r.data[r.pos:])
+		r.pos = len(r.data)
+		return s
+	}
+	s := string(r.data[r.pos : r.pos+end])
+	r.pos += end + 1
+	return s
+}
+
+func (r *packetReader) readLenencInt() uint64 {
+	switch first := r.readByte(); first {
+	case 0xfc:
+		b := r.readBytes(2)
+		if b == nil {
+			return 0
+		}
+		return uint64(binary.LittleEndian.Uint16(b))
+	case 0xfd:
+		b := r.readBytes(3)
+		if b == nil {
+			return 0
+		}
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+	case 0xfe:
+		b := r.readBytes(8)
+		if b == nil {
+			return 0
+		}
+		return binary.LittleEndian.Uint64(b)
+	default:
+		return uint64(first)
+	}
+}
+
+func (r *packetReader) readLenencBytes() []byte {
+	n := r.readLenencInt()
+	if n > uint64(len(r.data)) {
+		r.err = fmt.Errorf("invalid length %v", n)
+		return nil
+	}
+	return r.readBytes(int(n))
+}
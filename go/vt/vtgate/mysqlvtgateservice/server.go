@@ -0,0 +1,419 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mysqlvtgateservice exposes vtgate through the MySQL
+// client/server protocol, so the stock MySQL clients and drivers
+// can send queries to vtgate.
+package mysqlvtgateservice
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/servenv"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateservice"
+	"golang.org/x/net/context"
+)
+
+var (
+	mysqlServerPort             = flag.Int("mysql_server_port", 0, "if set, vtgate also listens for MySQL protocol connections on this port")
+	mysqlServerCredentialsFile  = flag.String("mysql_server_credentials_file", "", "JSON file that maps the users allowed to connect with the MySQL protocol to a list of passwords. Required with -mysql_server_port")
+	mysqlServerReadYourWrites   = flag.Bool("mysql_server_read_your_writes", false, "if set, the replica reads of MySQL protocol connections see the transactions they committed before")
+	mysqlServerMaxPacketSize    = flag.Int("mysql_server_max_packet_size", 16*1024*1024, "largest command accepted from an authenticated MySQL protocol client, in bytes, like max_allowed_packet")
+	mysqlServerHandshakeTimeout = flag.Duration("mysql_server_handshake_timeout", 10*time.Second, "time a MySQL protocol client has to authenticate, before its connection is closed")
+
+	connCount     = stats.NewInt("MysqlServerConnections")
+	connAccepted  = stats.NewInt("MysqlServerConnectionsAccepted")
+	authFailures  = stats.NewInt("MysqlServerAuthFailures")
+	errnoRegexp   = regexp.MustCompile(`\(errno (\d+)\)`)
+	errUnknownDB  = errors.New("unknown database")
+	connectionIDs uint32
+)
+
+// server accepts MySQL protocol connections, and runs
+// their commands on a VTGateService.
+type server struct {
+	service vtgateservice.VTGateService
+	// credentials maps a user to its valid passwords.
+	credentials map[string][]string
+}
+
+// serve accepts connections until the listener is closed.
+func (s *server) serve(listener net.Listener) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			log.Infof("MySQL server stopped accepting connections: %v", err)
+			return
+		}
+		connAccepted.Add(1)
+		go s.handle(c)
+	}
+}
+
+// mysqlConn is the state of a client connection.
+type mysqlConn struct {
+	*packetConn
+	server       *server
+	connectionID uint32
+	user         string
+	// keyspace and tabletType are set by COM_INIT_DB.
+	keyspace   string
+	tabletType topo.TabletType
+	session    *proto.Session
+}
+
+// RemoteAddr is part of the callinfo.CallInfo interface.
+func (mc *mysqlConn) RemoteAddr() string {
+	return mc.conn.RemoteAddr().String()
+}
+
+// Username is part of the callinfo.CallInfo interface.
+func (mc *mysqlConn) Username() string {
+	return mc.user
+}
+
+// Text is part of the callinfo.CallInfo interface.
+func (mc *mysqlConn) Text() string {
+	return fmt.Sprintf("%s@%s (mysql connection %v)", mc.user, mc.RemoteAddr(), mc.connectionID)
+}
+
+// HTML is part of the callinfo.CallInfo interface.
+func (mc *mysqlConn) HTML() template.HTML {
+	return template.HTML(template.HTMLEscapeString(mc.Text()))
+}
+
+// handle runs the handshake, and then the commands of a connection.
+func (s *server) handle(c net.Conn) {
+	connCount.Add(1)
+	defer connCount.Add(-1)
+	defer c.Close()
+
+	mc := &mysqlConn{
+		packetConn:   newPacketConn(c),
+		server:       s,
+		connectionID: atomic.AddUint32(&connectionIDs, 1),
+		tabletType:   topo.TYPE_MASTER,
+		session:      &proto.Session{ReadYourWrites: *mysqlServerReadYourWrites},
+	}
+	// Until it's authenticated, the client can only send small
+	// packets, and has a limited time to do it.
+	mc.maxPayloadSize = maxHandshakePayloadSize
+	c.SetDeadline(time.Now().Add(*mysqlServerHandshakeTimeout))
+	if err := mc.handshake(); err != nil {
+		log.Warningf("MySQL handshake with %v failed: %v", c.RemoteAddr(), err)
+		return
+	}
+	c.SetDeadline(time.Time{})
+	mc.maxPayloadSize = *mysqlServerMaxPacketSize
+	defer mc.rollback()
+	for {
+		mc.sequence = 0
+		data, err := mc.readPacket()
+		if err == errPacketTooLarge {
+			mc.writeError(erNetPacketTooLarge, ssUnknownComError, "Got a packet bigger than %v bytes", mc.maxPayloadSize)
+			mc.flush()
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Warningf("MySQL connection %v: %v", mc.Text(), err)
+			}
+			return
+		}
+		if len(data) == 0 {
+			log.Warningf("MySQL connection %v: empty command", mc.Text())
+			return
+		}
+		switch data[0] {
+		case comQuit:
+			return
+		case comPing:
+			err = mc.writeOK(0, 0, mc.status())
+		case comInitDB:
+			err = mc.initDB(string(data[1:]))
+		case comQuery:
+			err = mc.query(string(data[1:]))
+		default:
+			err = mc.writeError(erUnknownComError, ssUnknownComError, "command %v not supported", data[0])
+		}
+		if err == nil {
+			err = mc.flush()
+		}
+		if err != nil {
+			log.Warningf("MySQL connection %v: %v", mc.Text(), err)
+			return
+		}
+	}
+}
+
+// handshake authenticates the client.
+func (mc *mysqlConn) handshake() error {
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	if err := mc.writeHandshake(mc.connectionID, salt); err != nil {
+		return err
+	}
+	if err := mc.flush(); err != nil {
+		return err
+	}
+	data, err := mc.readPacket()
+	if err != nil {
+		return err
+	}
+	hr, err := parseHandshakeResponse(data)
+	if err != nil {
+		mc.writeError(erHandshakeError, ssUnknownComError, "Bad handshake")
+		mc.flush()
+		return err
+	}
+	mc.user = hr.user
+
+	// Clients that default to another auth method are asked to switch.
+	authResponse := hr.authResponse
+	if hr.capabilities&clientPluginAuth != 0 && hr.authPlugin != mysqlNativePassword {
+		if err := mc.writeAuthSwitchRequest(salt); err != nil {
+			return err
+		}
+		if err := mc.flush(); err != nil {
+			return err
+		}
+		if authResponse, err = mc.readPacket(); err != nil {
+			return err
+		}
+	}
+
+	if !mc.server.authenticate(hr.user, salt, authResponse) {
+		authFailures.Add(1)
+		mc.writeError(erAccessDenied, ssAccessDenied, "Access denied for user '%v'", hr.user)
+		mc.flush()
+		return fmt.Errorf("access denied for user %v", hr.user)
+	}
+	if hr.db != "" {
+		if err := mc.useDB(hr.db); err != nil {
+			mc.writeError(erBadDbError, ssBadDbError, "Unknown database '%v'", hr.db)
+			mc.flush()
+			return err
+		}
+	}
+	if err := mc.writeOK(0, 0, mc.status()); err != nil {
+		return err
+	}
+	return mc.flush()
+}
+
+// authenticate checks the mysql_native_password auth response of
+// user against all its passwords.
+func (s *server) authenticate(user string, salt, authResponse []byte) bool {
+	passwords, ok := s.credentials[user]
+	if !ok {
+		return false
+	}
+	for _, password := range passwords {
+		if subtle.ConstantTimeCompare(scramblePassword(salt, password), authResponse) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the status flags of the OK and EOF packets.
+func (mc *mysqlConn) status() uint16 {
+	if mc.session.InTransaction {
+		return serverStatusInTrans
+	}
+	return serverStatusAutocommit
+}
+
+// context returns the context of a command. It carries the
+// user as both the immediate and effective caller.
+func (mc *mysqlConn) context() context.Context {
+	return callerid.NewContext(callinfo.NewContext(context.Background(), mc),
+		callerid.NewEffectiveCallerID(mc.user, "mysql client", ""),
+		callerid.NewImmediateCallerID(mc.user))
+}
+
+// initDB handles COM_INIT_DB.
+func (mc *mysqlConn) initDB(db string) error {
+	if err := mc.useDB(db); err != nil {
+		if err == errUnknownDB {
+			return mc.writeError(erBadDbError, ssBadDbError, "Unknown database '%v'", db)
+		}
+		return mc.writeError(erUnknownError, ssUnknownError, "%v", err)
+	}
+	return mc.writeOK(0, 0, mc.status())
+}
+
+// useDB selects the keyspace and the tablet type of the next
+// queries. db is keyspace, keyspace@tablet_type, or @tablet_type.
+// The keyspace must exist, but isn't used to route the
+// queries: the V3 API finds it from the VSchema.
+func (mc *mysqlConn) useDB(db string) error {
+	keyspace := db
+	tabletType := topo.TYPE_MASTER
+	if i := strings.LastIndex(db, "@"); i >= 0 {
+		keyspace = db[:i]
+		tabletType = topo.TabletType(db[i+1:])
+		if tabletType != topo.TYPE_MASTER && tabletType != topo.TYPE_REPLICA && tabletType != topo.TYPE_RDONLY {
+			return errUnknownDB
+		}
+	}
+	if tabletType != mc.tabletType && mc.session.InTransaction {
+		return fmt.Errorf("cannot change the tablet type in a transaction")
+	}
+	if keyspace != "" {
+		if _, err := mc.server.service.GetSrvKeyspace(mc.context(), keyspace); err != nil {
+			return errUnknownDB
+		}
+	}
+	mc.keyspace = keyspace
+	mc.tabletType = tabletType
+	return nil
+}
+
+// query handles COM_QUERY. Transaction statements are run
+// with Begin, Commit and Rollback, and the others with Execute.
+func (mc *mysqlConn) query(sql string) error {
+	var err error
+	switch strings.ToLower(strings.Join(strings.Fields(strings.TrimRight(strings.TrimSpace(sql), ";")), " ")) {
+	case "begin", "start transaction":
+		// Like MySQL, an open transaction is committed first.
+		if err = mc.commit(); err == nil {
+			err = mc.begin()
+		}
+	case "commit":
+		err = mc.commit()
+	case "rollback":
+		err = mc.rollback()
+	default:
+		return mc.execute(sql)
+	}
+	if err != nil {
+		return mc.writeExecuteError(err.Error())
+	}
+	return mc.writeOK(0, 0, mc.status())
+}
+
+func (mc *mysqlConn) execute(sql string) error {
+	query := &proto.Query{
+		Sql:        sql,
+		TabletType: mc.tabletType,
+		Session:    mc.session,
+	}
+	reply := new(proto.QueryResult)
+	executeErr := func() (err error) {
+		defer mc.server.service.HandlePanic(&err)
+		return mc.server.service.Execute(mc.context(), query, reply)
+	}()
+	if reply.Session != nil {
+		mc.session = reply.Session
+	}
+	if executeErr != nil {
+		return mc.writeExecuteError(executeErr.Error())
+	}
+	if reply.Error != "" {
+		return mc.writeExecuteError(reply.Error)
+	}
+	return mc.writeResult(reply.Result, mc.status())
+}
+
+func (mc *mysqlConn) begin() (err error) {
+	defer mc.server.service.HandlePanic(&err)
+	session := nextSession(mc.session)
+	if err := mc.server.service.Begin(mc.context(), session); err != nil {
+		return err
+	}
+	mc.session = session
+	return nil
+}
+
+// commit commits the transaction in progress, if any.
+func (mc *mysqlConn) commit() (err error) {
+	if !mc.session.InTransaction {
+		return nil
+	}
+	defer mc.server.service.HandlePanic(&err)
+	session := mc.session
+	defer func() { mc.session = nextSession(session) }()
+	return mc.server.service.Commit(mc.context(), session)
+}
+
+// rollback rolls back the transaction in progress, if any.
+func (mc *mysqlConn) rollback() (err error) {
+	if !mc.session.InTransaction {
+		return nil
+	}
+	defer mc.server.service.HandlePanic(&err)
+	session := mc.session
+	defer func() { mc.session = nextSession(session) }()
+	return mc.server.service.Rollback(mc.context(), session)
+}
+
+// nextSession returns the session that follows the transaction of
+// session. It keeps the read-your-writes state of the connection.
+func nextSession(session *proto.Session) *proto.Session {
+	return &proto.Session{
+		ReadYourWrites: session.ReadYourWrites,
+		ShardPositions: session.ShardPositions,
+	}
+}
+
+// writeExecuteError writes an ERR packet for a vtgate error. The
+// MySQL error code is kept if the error comes from MySQL.
+func (mc *mysqlConn) writeExecuteError(message string) error {
+	code := uint16(erUnknownError)
+	if match := errnoRegexp.FindStringSubmatch(message); match != nil {
+		if errno, err := strconv.ParseUint(match[1], 10, 16); err == nil {
+			code = uint16(errno)
+		}
+	}
+	return mc.writeError(code, ssUnknownError, "%v", message)
+}
+
+func init() {
+	vtgate.RegisterVTGates = append(vtgate.RegisterVTGates, func(vtGate vtgateservice.VTGateService) {
+		if *mysqlServerPort == 0 {
+			return
+		}
+		if *mysqlServerCredentialsFile == "" {
+			log.Fatalf("-mysql_server_port requires -mysql_server_credentials_file")
+		}
+		credentials := make(map[string][]string)
+		if err := jscfg.ReadJSON(*mysqlServerCredentialsFile, &credentials); err != nil {
+			log.Fatalf("Cannot read the MySQL server credentials: %v", err)
+		}
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%v", *mysqlServerPort))
+		if err != nil {
+			log.Fatalf("Cannot listen on the MySQL server port: %v", err)
+		}
+		servenv.OnTerm(func() {
+			listener.Close()
+		})
+		s := &server{
+			service:     vtGate,
+			credentials: credentials,
+		}
+		log.Infof("Listening for MySQL connections on port %v", *mysqlServerPort)
+		go s.serve(listener)
+	})
+}
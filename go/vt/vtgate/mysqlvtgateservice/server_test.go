@@ -0,0 +1,483 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlvtgateservice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateservice"
+	"golang.org/x/net/context"
+)
+
+// fakeVTGateService implements the calls used by the MySQL server.
+type fakeVTGateService struct {
+	vtgateservice.VTGateService
+
+	mu        sync.Mutex
+	queries   []*proto.Query
+	callers   []string
+	commits   int
+	rollbacks int
+}
+
+func (f *fakeVTGateService) Execute(ctx context.Context, query *proto.Query, reply *proto.QueryResult) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+	f.callers = append(f.callers, callerid.GetPrincipal(callerid.EffectiveCallerIDFromContext(ctx)))
+	reply.Session = query.Session
+	switch query.Sql {
+	case "select * from t":
+		reply.Result = &mproto.QueryResult{
+			Fields: []mproto.Field{
+				{Name: "id", Type: mproto.VT_LONGLONG},
+				{Name: "name", Type: mproto.VT_VARCHAR},
+			},
+			Rows: [][]sqltypes.Value{
+				{sqltypes.MakeString([]byte("1")), sqltypes.MakeString([]byte("foo"))},
+				{sqltypes.MakeString([]byte("2")), sqltypes.NULL},
+			},
+		}
+	case "insert into t values(3)":
+		reply.Result = &mproto.QueryResult{
+			RowsAffected: 1,
+			InsertId:     3,
+		}
+	case "panic":
+		panic("test panic")
+	default:
+		reply.Error = "vttablet: Duplicate entry (errno 1062) during query: " + query.Sql
+	}
+	return nil
+}
+
+// lastQuery returns the last query, and the principal that sent it.
+func (f *fakeVTGateService) lastQuery() (*proto.Query, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries[len(f.queries)-1], f.callers[len(f.callers)-1]
+}
+
+func (f *fakeVTGateService) Begin(ctx context.Context, outSession *proto.Session) error {
+	outSession.InTransaction = true
+	return nil
+}
+
+func (f *fakeVTGateService) Commit(ctx context.Context, inSession *proto.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commits++
+	return nil
+}
+
+func (f *fakeVTGateService) Rollback(ctx context.Context, inSession *proto.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rollbacks++
+	return nil
+}
+
+func (f *fakeVTGateService) GetSrvKeyspace(ctx context.Context, keyspace string) (*topo.SrvKeyspace, error) {
+	if keyspace != "ks" {
+		return nil, fmt.Errorf("no keyspace %v", keyspace)
+	}
+	return &topo.SrvKeyspace{}, nil
+}
+
+func (f *fakeVTGateService) HandlePanic(err *error) {
+	if x := recover(); x != nil {
+		*err = fmt.Errorf("uncaught panic: %v", x)
+	}
+}
+
+// startServer starts a server for fake on a local port.
+func startServer(t *testing.T, fake *fakeVTGateService) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := &server{
+		service: fake,
+		credentials: map[string][]string{
+			"user1": []string{"password1", "password2"},
+		},
+	}
+	go s.serve(listener)
+	return listener
+}
+
+// mysqlError is an ERR packet received by the test client.
+type mysqlError struct {
+	code    uint16
+	message string
+}
+
+func (e *mysqlError) Error() string {
+	return fmt.Sprintf("%v: %v", e.code, e.message)
+}
+
+func parseError(data []byte) error {
+	r := &packetReader{data: data}
+	r.skip(1)
+	code := r.readBytes(2)
+	// '#' and SQL state
+	r.skip(6)
+	if r.err != nil {
+		return r.err
+	}
+	return &mysqlError{code: uint16(code[0]) | uint16(code[1])<<8, message: string(data[r.pos:])}
+}
+
+// connect runs the client side of the handshake.
+func connect(addr, user, password, db, authPlugin string) (*packetConn, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc := newPacketConn(c)
+	data, err := pc.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	r := &packetReader{data: data}
+	if version := r.readByte(); version != protocolVersion {
+		return nil, fmt.Errorf("protocol version %v", version)
+	}
+	r.readNullString()
+	r.skip(4)
+	salt := append([]byte{}, r.readBytes(8)...)
+	r.skip(1 + 2 + 1 + 2 + 2 + 1 + 10)
+	salt = append(salt, r.readBytes(12)...)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var buf bytes.Buffer
+	capabilities := uint32(clientProtocol41 | clientSecureConnection | clientPluginAuth)
+	if db != "" {
+		capabilities |= clientConnectWithDB
+	}
+	buf.Write([]byte{byte(capabilities), byte(capabilities >> 8), byte(capabilities >> 16), byte(capabilities >> 24)})
+	buf.Write(make([]byte, 4+1+23))
+	writeNullString(&buf, user)
+	scramble := scramblePassword(salt, password)
+	if authPlugin != mysqlNativePassword {
+		scramble = []byte("other scramble")
+	}
+	buf.WriteByte(byte(len(scramble)))
+	buf.Write(scramble)
+	if db != "" {
+		writeNullString(&buf, db)
+	}
+	writeNullString(&buf, authPlugin)
+	if err := pc.writePacket(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := pc.flush(); err != nil {
+		return nil, err
+	}
+	if data, err = pc.readPacket(); err != nil {
+		return nil, err
+	}
+	if data[0] == eofPacket {
+		// auth switch request
+		r := &packetReader{data: data[1:]}
+		if plugin := r.readNullString(); plugin != mysqlNativePassword {
+			return nil, fmt.Errorf("auth switch to %v", plugin)
+		}
+		if err := pc.writePacket(scramblePassword(r.readBytes(20), password)); err != nil {
+			return nil, err
+		}
+		if err := pc.flush(); err != nil {
+			return nil, err
+		}
+		if data, err = pc.readPacket(); err != nil {
+			return nil, err
+		}
+	}
+	if data[0] == errPacket {
+		return nil, parseError(data)
+	}
+	return pc, nil
+}
+
+// command sends a command, and reads the result. It returns the
+// OK packet fields for statements, and the rows for resultsets.
+func command(pc *packetConn, cmd byte, arg string) (rowsAffected, insertID uint64, rows [][]string, err error) {
+	pc.sequence = 0
+	if err = pc.writePacket(append([]byte{cmd}, arg...)); err != nil {
+		return
+	}
+	if err = pc.flush(); err != nil {
+		return
+	}
+	data, err := pc.readPacket()
+	if err != nil {
+		return
+	}
+	switch data[0] {
+	case okPacket:
+		r := &packetReader{data: data[1:]}
+		return r.readLenencInt(), r.readLenencInt(), nil, r.err
+	case errPacket:
+		return 0, 0, nil, parseError(data)
+	}
+	// resultset: skip the column definitions.
+	for {
+		if data, err = pc.readPacket(); err != nil {
+			return
+		}
+		if data[0] == eofPacket {
+			break
+		}
+	}
+	for {
+		if data, err = pc.readPacket(); err != nil {
+			return
+		}
+		if data[0] == eofPacket {
+			return
+		}
+		r := &packetReader{data: data}
+		var row []string
+		for r.pos < len(data) {
+			if data[r.pos] == 0xfb {
+				r.skip(1)
+				row = append(row, "NULL")
+				continue
+			}
+			row = append(row, string(r.readLenencBytes()))
+		}
+		rows = append(rows, row)
+	}
+}
+
+func checkError(t *testing.T, err error, code uint16) {
+	mysqlErr, ok := err.(*mysqlError)
+	if !ok || mysqlErr.code != code {
+		t.Errorf("got error %v, want code %v", err, code)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	listener := startServer(t, &fakeVTGateService{})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	for _, password := range []string{"password1", "password2"} {
+		pc, err := connect(addr, "user1", password, "", mysqlNativePassword)
+		if err != nil {
+			t.Fatalf("connect failed: %v", err)
+		}
+		if _, _, _, err := command(pc, comPing, ""); err != nil {
+			t.Errorf("ping failed: %v", err)
+		}
+		pc.conn.Close()
+	}
+
+	_, err := connect(addr, "user1", "bad", "", mysqlNativePassword)
+	checkError(t, err, erAccessDenied)
+	_, err = connect(addr, "user2", "password1", "", mysqlNativePassword)
+	checkError(t, err, erAccessDenied)
+	_, err = connect(addr, "user1", "password1", "unknown", mysqlNativePassword)
+	checkError(t, err, erBadDbError)
+
+	// clients that default to another auth method switch to ours.
+	pc, err := connect(addr, "user1", "password1", "", "caching_sha2_password")
+	if err != nil {
+		t.Fatalf("connect with auth switch failed: %v", err)
+	}
+	pc.conn.Close()
+}
+
+func TestQuery(t *testing.T) {
+	fake := &fakeVTGateService{}
+	listener := startServer(t, fake)
+	defer listener.Close()
+	pc, err := connect(listener.Addr().String(), "user1", "password1", "ks@replica", mysqlNativePassword)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer pc.conn.Close()
+
+	_, _, rows, err := command(pc, comQuery, "select * from t")
+	if err != nil {
+		t.Fatalf("select failed: %v", err)
+	}
+	want := [][]string{{"1", "foo"}, {"2", "NULL"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("select got %v, want %v", rows, want)
+	}
+	query, caller := fake.lastQuery()
+	if query.TabletType != topo.TYPE_REPLICA {
+		t.Errorf("TabletType got %v, want replica", query.TabletType)
+	}
+	if caller != "user1" {
+		t.Errorf("caller got %v, want user1", caller)
+	}
+
+	_, _, _, err = command(pc, comQuery, "delete from t")
+	checkError(t, err, 1062)
+	_, _, _, err = command(pc, comQuery, "panic")
+	checkError(t, err, erUnknownError)
+	_, _, _, err = command(pc, 0x16, "")
+	checkError(t, err, erUnknownComError)
+
+	// USE sends COM_INIT_DB.
+	_, _, _, err = command(pc, comInitDB, "other_ks")
+	checkError(t, err, erBadDbError)
+	_, _, _, err = command(pc, comInitDB, "ks@spare")
+	checkError(t, err, erBadDbError)
+	if _, _, _, err := command(pc, comInitDB, "@master"); err != nil {
+		t.Fatalf("COM_INIT_DB failed: %v", err)
+	}
+	if _, _, _, err := command(pc, comQuery, "select * from t"); err != nil {
+		t.Fatalf("select failed: %v", err)
+	}
+	if query, _ := fake.lastQuery(); query.TabletType != topo.TYPE_MASTER {
+		t.Errorf("TabletType got %v, want master", query.TabletType)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	fake := &fakeVTGateService{}
+	listener := startServer(t, fake)
+	defer listener.Close()
+	pc, err := connect(listener.Addr().String(), "user1", "password1", "", mysqlNativePassword)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+
+	if _, _, _, err := command(pc, comQuery, "begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	rowsAffected, insertID, _, err := command(pc, comQuery, "insert into t values(3)")
+	if err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if rowsAffected != 1 || insertID != 3 {
+		t.Errorf("insert got %v, %v, want 1, 3", rowsAffected, insertID)
+	}
+	if query, _ := fake.lastQuery(); !query.Session.InTransaction {
+		t.Errorf("insert was not in a transaction")
+	}
+	if _, _, _, err := command(pc, comQuery, "Commit;"); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	fake.mu.Lock()
+	commits := fake.commits
+	fake.mu.Unlock()
+	if commits != 1 {
+		t.Errorf("commits got %v, want 1", commits)
+	}
+
+	// A transaction in progress is rolled back when the client leaves.
+	if _, _, _, err := command(pc, comQuery, "start transaction"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	command(pc, comQuit, "")
+	pc.conn.Close()
+	for i := 0; ; i++ {
+		fake.mu.Lock()
+		rollbacks := fake.rollbacks
+		fake.mu.Unlock()
+		if rollbacks == 1 {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("transaction was not rolled back")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLargePacket(t *testing.T) {
+	client, server := net.Pipe()
+	payload := bytes.Repeat([]byte{'a'}, maxPacketSize+10)
+	go func() {
+		pc := newPacketConn(client)
+		pc.writePacket(payload)
+		pc.flush()
+	}()
+	pc := newPacketConn(server)
+	data, err := pc.readPacket()
+	if err != nil {
+		t.Fatalf("readPacket failed: %v", err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Errorf("readPacket got %v bytes, want %v", len(data), len(payload))
+	}
+	if pc.sequence != 2 {
+		t.Errorf("sequence got %v, want 2", pc.sequence)
+	}
+}
+
+func TestPacketTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		pc := newPacketConn(client)
+		pc.writePacket(make([]byte, 100))
+		pc.flush()
+	}()
+	pc := newPacketConn(server)
+	pc.maxPayloadSize = 99
+	if _, err := pc.readPacket(); err != errPacketTooLarge {
+		t.Errorf("readPacket got %v, want %v", err, errPacketTooLarge)
+	}
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	defer func(size int) { *mysqlServerMaxPacketSize = size }(*mysqlServerMaxPacketSize)
+	*mysqlServerMaxPacketSize = 100
+	fake := &fakeVTGateService{}
+	listener := startServer(t, fake)
+	defer listener.Close()
+
+	pc, err := connect(listener.Addr().String(), "user1", "password1", "", mysqlNativePassword)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	if _, _, _, err := command(pc, comQuery, "select * from t"); err != nil {
+		t.Errorf("select failed: %v", err)
+	}
+	_, _, _, err = command(pc, comQuery, "select * from t where name = '"+strings.Repeat("a", 100)+"'")
+	checkError(t, err, erNetPacketTooLarge)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { *mysqlServerHandshakeTimeout = timeout }(*mysqlServerHandshakeTimeout)
+	*mysqlServerHandshakeTimeout = 10 * time.Millisecond
+	fake := &fakeVTGateService{}
+	listener := startServer(t, fake)
+	defer listener.Close()
+
+	// The client reads the handshake, and never answers.
+	c, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+	pc := newPacketConn(c)
+	if _, err := pc.readPacket(); err != nil {
+		t.Fatalf("readPacket failed: %v", err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := pc.readPacket(); err != io.EOF {
+		t.Errorf("readPacket got %v, want %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// AggregateOpcode is the function used by SelectAggregate
+// to combine the values returned by the shards.
+type AggregateOpcode int
+
+// The following constants define all the AggregateOpcode values.
+const (
+	AggregateGroupBy = AggregateOpcode(iota)
+	AggregateCount
+	AggregateSum
+	AggregateMin
+	AggregateMax
+	AggregateAvg
+)
+
+var aggregateName = map[AggregateOpcode]string{
+	AggregateGroupBy: "group_by",
+	AggregateCount:   "count",
+	AggregateSum:     "sum",
+	AggregateMin:     "min",
+	AggregateMax:     "max",
+	AggregateAvg:     "avg",
+}
+
+func (code AggregateOpcode) String() string {
+	return aggregateName[code]
+}
+
+// MarshalJSON serializes the AggregateOpcode as a JSON string.
+func (code AggregateOpcode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(code.String())
+}
+
+// AggregateParams specifies how SelectAggregate computes
+// a column of its result from the columns returned by the shards.
+type AggregateParams struct {
+	Opcode AggregateOpcode
+	// Col is the index of the input column in the shard results.
+	// For AggregateAvg, Col is the sum and Col+1 is the count.
+	Col int
+	// Name is the name of the column in the result.
+	Name string
+}
+
+// HavingVarPrefix is the prefix of the bind var names used
+// by the Having clause of a SelectAggregate plan to refer to
+// the aggregated columns. For example, :_col2 refers to the
+// third column.
+const HavingVarPrefix = "_col"
+
+// aggregateBuilder accumulates the state needed for
+// converting a multi-shard select into a SelectAggregate plan.
+type aggregateBuilder struct {
+	groupBy    []string
+	shardExprs sqlparser.SelectExprs
+	aggrs      []AggregateParams
+	// texts contains the original expression of each aggregate.
+	texts []string
+	// visible is the number of aggregates that are returned.
+	visible int
+}
+
+// buildAggregatePlan converts a multi-shard plan into a SelectAggregate
+// plan. The shards compute partial aggregates for each group, which
+// VTGate combines. HAVING, ORDER BY and LIMIT are removed from the query
+// sent to the shards because they can only be applied to the combined rows.
+func buildAggregatePlan(sel *sqlparser.Select, plan *Plan) error {
+	if sel.Distinct != "" {
+		return errors.New("distinct is not supported for multi-shard aggregates")
+	}
+	ab := &aggregateBuilder{}
+	for _, expr := range sel.GroupBy {
+		colname, ok := expr.(*sqlparser.ColName)
+		if !ok {
+			return fmt.Errorf("complex group by expression: %s", sqlparser.String(expr))
+		}
+		ab.groupBy = append(ab.groupBy, string(colname.Name))
+	}
+	for _, expr := range sel.SelectExprs {
+		nonStar, ok := expr.(*sqlparser.NonStarExpr)
+		if !ok {
+			return errors.New("* is not supported for multi-shard aggregates")
+		}
+		name := string(nonStar.As)
+		if name == "" {
+			name = sqlparser.String(nonStar.Expr)
+		}
+		if _, err := ab.addColumn(nonStar.Expr, name); err != nil {
+			return err
+		}
+	}
+	ab.visible = len(ab.aggrs)
+	var groupCols []int
+	for _, name := range ab.groupBy {
+		groupCols = append(groupCols, ab.aggrs[ab.findGroupColumn(name)].Col)
+	}
+	var having sqlparser.BoolExpr
+	if sel.Having != nil {
+		var err error
+		having, err = ab.rewriteHaving(sel.Having.Expr)
+		if err != nil {
+			return err
+		}
+	}
+	orderBy, err := ab.getOrderBy(sel.OrderBy)
+	if err != nil {
+		return err
+	}
+	offset, rowcount, err := sel.Limit.Limits()
+	if err != nil {
+		return fmt.Errorf("invalid limit: %v", err)
+	}
+
+	sel.SelectExprs = ab.shardExprs
+	sel.Having = nil
+	sel.OrderBy = nil
+	sel.Limit = nil
+
+	plan.Route = plan.ID
+	plan.ID = SelectAggregate
+	plan.Aggregates = ab.aggrs
+	plan.ResultColumns = ab.visible
+	plan.GroupBy = groupCols
+	plan.Having = having
+	plan.OrderBy = orderBy
+	plan.Limit = rowcount
+	plan.Offset = offset
+	return nil
+}
+
+// addColumn adds a column to the aggregated result and
+// returns its index.
+func (ab *aggregateBuilder) addColumn(expr sqlparser.Expr, name string) (int, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		if !sqlparser.StringIn(string(expr.Name), ab.groupBy...) {
+			return 0, fmt.Errorf("column %s must be in the group by clause", sqlparser.String(expr))
+		}
+		return ab.add(AggregateGroupBy, name, sqlparser.String(expr), expr), nil
+	case *sqlparser.FuncExpr:
+		if sqlparser.Aggregates[strings.ToLower(expr.Name)] {
+			return ab.addAggregate(expr, name)
+		}
+	}
+	if exprHasAggregates(expr) {
+		return 0, fmt.Errorf("complex aggregate expression: %s", sqlparser.String(expr))
+	}
+	return 0, fmt.Errorf("unsupported expression for multi-shard aggregates: %s", sqlparser.String(expr))
+}
+
+func (ab *aggregateBuilder) addAggregate(expr *sqlparser.FuncExpr, name string) (int, error) {
+	if expr.Distinct {
+		return 0, fmt.Errorf("distinct aggregates are not supported for multi-shard queries: %s", sqlparser.String(expr))
+	}
+	text := sqlparser.String(expr)
+	switch strings.ToLower(expr.Name) {
+	case "count":
+		return ab.add(AggregateCount, name, text, expr), nil
+	case "sum":
+		return ab.add(AggregateSum, name, text, expr), nil
+	case "min":
+		return ab.add(AggregateMin, name, text, expr), nil
+	case "max":
+		return ab.add(AggregateMax, name, text, expr), nil
+	case "avg":
+		// AVG is computed as SUM/COUNT.
+		index := ab.add(AggregateAvg, name, text, &sqlparser.FuncExpr{Name: "sum", Exprs: expr.Exprs})
+		ab.shardExprs = append(ab.shardExprs, &sqlparser.NonStarExpr{
+			Expr: &sqlparser.FuncExpr{Name: "count", Exprs: expr.Exprs},
+		})
+		return index, nil
+	}
+	return 0, fmt.Errorf("unsupported aggregate function for multi-shard queries: %s", text)
+}
+
+func (ab *aggregateBuilder) add(opcode AggregateOpcode, name, text string, shardExpr sqlparser.Expr) int {
+	ab.aggrs = append(ab.aggrs, AggregateParams{
+		Opcode: opcode,
+		Col:    len(ab.shardExprs),
+		Name:   name,
+	})
+	ab.texts = append(ab.texts, text)
+	ab.shardExprs = append(ab.shardExprs, &sqlparser.NonStarExpr{Expr: shardExpr})
+	return len(ab.aggrs) - 1
+}
+
+// findGroupColumn returns the index of the aggregated column for
+// the specified group by column. If there's none, a hidden one
+// is added.
+func (ab *aggregateBuilder) findGroupColumn(name string) int {
+	for i, aggr := range ab.aggrs {
+		if aggr.Opcode == AggregateGroupBy && ab.texts[i] == name {
+			return i
+		}
+	}
+	colname := &sqlparser.ColName{Name: sqlparser.SQLName(name)}
+	return ab.add(AggregateGroupBy, name, name, colname)
+}
+
+// findColumn returns the index of the aggregated column that the
+// expression refers to. A column name can refer to the alias of a
+// returned column or to a group by column. An aggregate function
+// that's not already computed gets added as a hidden column.
+func (ab *aggregateBuilder) findColumn(expr sqlparser.ValExpr) (int, error) {
+	text := sqlparser.String(expr)
+	if colname, ok := expr.(*sqlparser.ColName); ok && colname.Qualifier == "" {
+		for i := 0; i < ab.visible; i++ {
+			if ab.aggrs[i].Name == text {
+				return i, nil
+			}
+		}
+	}
+	for i, t := range ab.texts {
+		if t == text {
+			return i, nil
+		}
+	}
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		if !sqlparser.StringIn(string(expr.Name), ab.groupBy...) {
+			return 0, fmt.Errorf("column %s must be in the group by clause", text)
+		}
+		return ab.findGroupColumn(string(expr.Name)), nil
+	case *sqlparser.FuncExpr:
+		return ab.addColumn(expr, text)
+	}
+	return 0, fmt.Errorf("unsupported expression for multi-shard aggregates: %s", text)
+}
+
+func (ab *aggregateBuilder) rewriteHaving(node sqlparser.BoolExpr) (sqlparser.BoolExpr, error) {
+	var err error
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		left, err := ab.rewriteHaving(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := ab.rewriteHaving(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.AndExpr{Left: left, Right: right}, nil
+	case *sqlparser.OrExpr:
+		left, err := ab.rewriteHaving(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := ab.rewriteHaving(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.OrExpr{Left: left, Right: right}, nil
+	case *sqlparser.NotExpr:
+		expr, err := ab.rewriteHaving(node.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.NotExpr{Expr: expr}, nil
+	case *sqlparser.ParenBoolExpr:
+		expr, err := ab.rewriteHaving(node.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.ParenBoolExpr{Expr: expr}, nil
+	case *sqlparser.ComparisonExpr:
+		switch node.Operator {
+		case sqlparser.AST_EQ, sqlparser.AST_LT, sqlparser.AST_GT, sqlparser.AST_LE,
+			sqlparser.AST_GE, sqlparser.AST_NE, sqlparser.AST_NSE:
+		default:
+			return nil, fmt.Errorf("unsupported operator in having clause: %s", node.Operator)
+		}
+		newnode := &sqlparser.ComparisonExpr{Operator: node.Operator}
+		if newnode.Left, err = ab.rewriteHavingValue(node.Left); err != nil {
+			return nil, err
+		}
+		if newnode.Right, err = ab.rewriteHavingValue(node.Right); err != nil {
+			return nil, err
+		}
+		return newnode, nil
+	case *sqlparser.NullCheck:
+		newnode := &sqlparser.NullCheck{Operator: node.Operator}
+		if newnode.Expr, err = ab.rewriteHavingValue(node.Expr); err != nil {
+			return nil, err
+		}
+		return newnode, nil
+	}
+	return nil, fmt.Errorf("unsupported construct in having clause: %s", sqlparser.String(node))
+}
+
+func (ab *aggregateBuilder) rewriteHavingValue(node sqlparser.ValExpr) (sqlparser.ValExpr, error) {
+	switch node := node.(type) {
+	case sqlparser.StrVal, sqlparser.NumVal, sqlparser.ValArg, *sqlparser.NullVal:
+		return node, nil
+	case *sqlparser.ColName, *sqlparser.FuncExpr:
+		index, err := ab.findColumn(node)
+		if err != nil {
+			return nil, err
+		}
+		return sqlparser.ValArg(fmt.Sprintf(":%s%d", HavingVarPrefix, index)), nil
+	}
+	return nil, fmt.Errorf("unsupported construct in having clause: %s", sqlparser.String(node))
+}
+
+func (ab *aggregateBuilder) getOrderBy(orders sqlparser.OrderBy) ([]OrderByParams, error) {
+	var orderBy []OrderByParams
+	for _, order := range orders {
+		switch order.Expr.(type) {
+		case *sqlparser.ColName, *sqlparser.FuncExpr:
+		default:
+			return nil, fmt.Errorf("complex order by expression: %s", sqlparser.String(order.Expr))
+		}
+		index, err := ab.findColumn(order.Expr)
+		if err != nil {
+			return nil, err
+		}
+		orderBy = append(orderBy, OrderByParams{
+			Col:  ab.aggrs[index].Name,
+			Desc: order.Direction == sqlparser.AST_DESC,
+		})
+	}
+	return orderBy, nil
+}
@@ -6,6 +6,7 @@ package planbuilder
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
 	"github.com/youtube/vitess/go/vt/sqlparser"
@@ -30,7 +31,25 @@ func buildUpdatePlan(upd *sqlparser.Update, schema *Schema) *Plan {
 	switch plan.ID {
 	case SelectEqual:
 		plan.ID = UpdateEqual
-	case SelectIN, SelectScatter, SelectKeyrange:
+	case SelectIN, SelectScatter:
+		if !isMultiShardAllowed(upd.Comments) {
+			plan.ID = NoPlan
+			plan.Reason = "update has multi-shard where clause"
+			return plan
+		}
+		if upd.Limit != nil {
+			plan.ID = NoPlan
+			plan.Reason = "multi-shard update cannot have a limit"
+			return plan
+		}
+		if plan.ID == SelectIN {
+			plan.ID = UpdateIN
+		} else {
+			plan.ID = UpdateScatter
+		}
+		plan.Rewritten = generateQuery(upd)
+		plan.Subquery = generateMultiShardSubquery(upd.Where, plan.Table, nil)
+	case SelectKeyrange:
 		plan.ID = NoPlan
 		plan.Reason = "update has multi-shard where clause"
 		return plan
@@ -38,12 +57,94 @@ func buildUpdatePlan(upd *sqlparser.Update, schema *Schema) *Plan {
 		panic("unexpected")
 	}
 	if isIndexChanging(upd.Exprs, plan.Table.ColVindexes) {
-		plan.ID = NoPlan
-		plan.Reason = "index is changing"
+		if plan.ID != UpdateEqual {
+			plan.ID = NoPlan
+			plan.Reason = "index is changing"
+			plan.Subquery = ""
+			return plan
+		}
+		if err := buildIndexChangePlan(upd, plan); err != nil {
+			plan.ID = NoPlan
+			plan.Reason = err.Error()
+		}
 	}
 	return plan
 }
 
+// buildIndexChangePlan completes an UpdateEqual plan that changes
+// ColVindex columns. If only non-primary columns change, the Subquery
+// fetches their old values so that VTGate can update the vindex entries.
+// If the primary column changes, the rows must move to another shard:
+// the Subquery fetches the entire rows, which VTGate deletes with the
+// DeleteQuery, and reinserts with the new values on the new shard.
+func buildIndexChangePlan(upd *sqlparser.Update, plan *Plan) error {
+	if upd.Limit != nil {
+		return errors.New("update of vindex column cannot have a limit")
+	}
+	colVindexes := plan.Table.ColVindexes
+	primaryChanging := false
+	for _, assignment := range upd.Exprs {
+		if string(assignment.Name.Name) == colVindexes[0].Col {
+			primaryChanging = true
+		}
+	}
+	setValues := make(map[string]interface{})
+	for _, assignment := range upd.Exprs {
+		col := string(assignment.Name.Name)
+		if !primaryChanging && !isVindexCol(col, colVindexes) {
+			continue
+		}
+		val, err := asInterface(assignment.Expr)
+		if err != nil {
+			return fmt.Errorf("could not convert val for column %s: %v", col, err)
+		}
+		setValues[col] = val
+	}
+	plan.SetValues = setValues
+	where := sqlparser.String(upd.Where)
+	if primaryChanging {
+		plan.Subquery = fmt.Sprintf("select * from %s%s for update", plan.Table.Name, where)
+		plan.DeleteQuery = fmt.Sprintf("delete from %s%s", plan.Table.Name, where)
+		return nil
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("select ")
+	prefix := ""
+	for _, cv := range colVindexes[1:] {
+		if _, ok := plan.SetValues[cv.Col]; !ok {
+			continue
+		}
+		buf.WriteString(prefix)
+		buf.WriteString(cv.Col)
+		prefix = ", "
+	}
+	fmt.Fprintf(buf, " from %s%s for update", plan.Table.Name, where)
+	plan.Subquery = buf.String()
+	return nil
+}
+
+func isVindexCol(col string, colVindexes []*ColVindex) bool {
+	for _, cv := range colVindexes {
+		if cv.Col == col {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiShardComment is the comment that an update or delete
+// must have for VTGate to send it to more than one shard.
+const MultiShardComment = "/* multi_shard */"
+
+func isMultiShardAllowed(comments sqlparser.Comments) bool {
+	for _, comment := range comments {
+		if string(comment) == MultiShardComment {
+			return true
+		}
+	}
+	return false
+}
+
 func isIndexChanging(setClauses sqlparser.UpdateExprs, colVindexes []*ColVindex) bool {
 	vindexCols := make([]string, len(colVindexes))
 	for i, index := range colVindexes {
@@ -77,7 +178,25 @@ func buildDeletePlan(del *sqlparser.Delete, schema *Schema) *Plan {
 	case SelectEqual:
 		plan.ID = DeleteEqual
 		plan.Subquery = generateDeleteSubquery(del, plan.Table)
-	case SelectIN, SelectScatter, SelectKeyrange:
+	case SelectIN, SelectScatter:
+		if !isMultiShardAllowed(del.Comments) {
+			plan.ID = NoPlan
+			plan.Reason = "delete has multi-shard where clause"
+			return plan
+		}
+		if del.Limit != nil {
+			plan.ID = NoPlan
+			plan.Reason = "multi-shard delete cannot have a limit"
+			return plan
+		}
+		if plan.ID == SelectIN {
+			plan.ID = DeleteIN
+		} else {
+			plan.ID = DeleteScatter
+		}
+		plan.Rewritten = generateQuery(del)
+		plan.Subquery = generateMultiShardSubquery(del.Where, plan.Table, plan.Table.Owned)
+	case SelectKeyrange:
 		plan.ID = NoPlan
 		plan.Reason = "delete has multi-shard where clause"
 	default:
@@ -103,3 +222,22 @@ func generateDeleteSubquery(del *sqlparser.Delete, table *Table) string {
 	buf.WriteString(" for update")
 	return buf.String()
 }
+
+// generateMultiShardSubquery generates the query that fetches the rows
+// affected by a multi-shard update or delete. It selects the primary
+// vindex column followed by the columns of owned. Its row count is
+// limited by LimitVarName, so that VTGate doesn't lock or fetch more
+// rows than needed to enforce its maximum.
+func generateMultiShardSubquery(where *sqlparser.Where, table *Table, owned []*ColVindex) string {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("select ")
+	buf.WriteString(table.ColVindexes[0].Col)
+	for _, cv := range owned {
+		buf.WriteString(", ")
+		buf.WriteString(cv.Col)
+	}
+	fmt.Fprintf(buf, " from %s", table.Name)
+	buf.WriteString(sqlparser.String(where))
+	fmt.Fprintf(buf, " limit :%s for update", LimitVarName)
+	return buf.String()
+}
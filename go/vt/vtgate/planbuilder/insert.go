@@ -6,6 +6,7 @@ package planbuilder
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/youtube/vitess/go/vt/sqlparser"
 )
@@ -39,23 +40,35 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 	default:
 		panic("unexpected")
 	}
-	if len(values) != 1 {
-		plan.Reason = "multi-row inserts not supported"
-		return plan
-	}
-	switch values[0].(type) {
-	case *sqlparser.Subquery:
-		plan.Reason = "subqueries not allowed"
-		return plan
-	}
-	row := values[0].(sqlparser.ValTuple)
-	if len(ins.Columns) != len(row) {
-		plan.Reason = "column list doesn't match values"
-		return plan
+	for _, row := range values {
+		switch row.(type) {
+		case *sqlparser.Subquery:
+			plan.Reason = "subqueries not allowed"
+			return plan
+		}
+		if len(ins.Columns) != len(row.(sqlparser.ValTuple)) {
+			plan.Reason = "column list doesn't match values"
+			return plan
+		}
 	}
 	colVindexes := schema.Tables[tablename].ColVindexes
 	plan.ID = InsertSharded
-	plan.Values = make([]interface{}, 0, len(colVindexes))
+	if len(values) == 1 {
+		plan.Values = make([]interface{}, 0, len(colVindexes))
+	} else {
+		rowValues := make([]interface{}, len(values))
+		for i := range rowValues {
+			rowValues[i] = make([]interface{}, 0, len(colVindexes))
+		}
+		plan.Values = rowValues
+	}
+	if plan.Table.Autoinc != nil {
+		if err := buildAutoincPlan(ins, plan.Table.Autoinc, plan); err != nil {
+			plan.ID = NoPlan
+			plan.Reason = err.Error()
+			return plan
+		}
+	}
 	for _, index := range colVindexes {
 		if err := buildIndexPlan(ins, tablename, index, plan); err != nil {
 			plan.ID = NoPlan
@@ -64,28 +77,99 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 		}
 	}
 	plan.Rewritten = generateQuery(ins)
+	if len(values) != 1 {
+		buildMultiRowPlan(ins, plan)
+	}
 	return plan
 }
 
+// buildIndexPlan replaces the values of colVindex in every row of ins
+// with bind vars, and adds them to plan.Values. For a single-row insert,
+// plan.Values is the list of values of each vindex. For a multi-row
+// insert, it is the list of such lists for each row.
 func buildIndexPlan(ins *sqlparser.Insert, tablename string, colVindex *ColVindex, plan *Plan) error {
-	pos := -1
-	for i, column := range ins.Columns {
-		if colVindex.Col == sqlparser.GetColName(column.(*sqlparser.NonStarExpr).Expr) {
-			pos = i
-			break
+	pos := findOrAddColumn(ins, colVindex.Col)
+	values := ins.Rows.(sqlparser.Values)
+	for rownum := range values {
+		row := values[rownum].(sqlparser.ValTuple)
+		val, err := asInterface(row[pos])
+		if err != nil {
+			return fmt.Errorf("could not convert val: %s, pos: %d: %v", sqlparser.String(row[pos]), pos, err)
+		}
+		if len(values) == 1 {
+			plan.Values = append(plan.Values.([]interface{}), val)
+		} else {
+			rowValues := plan.Values.([]interface{})
+			rowValues[rownum] = append(rowValues[rownum].([]interface{}), val)
 		}
+		row[pos] = sqlparser.ValArg([]byte(":" + InsertVarName(colVindex.Col, rownum, len(values))))
 	}
-	if pos == -1 {
-		pos = len(ins.Columns)
-		ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: sqlparser.SQLName(colVindex.Col)}})
-		ins.Rows.(sqlparser.Values)[0] = append(ins.Rows.(sqlparser.Values)[0].(sqlparser.ValTuple), &sqlparser.NullVal{})
+	return nil
+}
+
+// buildAutoincPlan replaces the values of the autoinc column in every
+// row of ins with bind vars, and sets plan.Generate to those values.
+// Like plan.Values, it's a single value for a single-row insert, and
+// a list of values for a multi-row insert.
+func buildAutoincPlan(ins *sqlparser.Insert, autoinc *Autoinc, plan *Plan) error {
+	pos := findOrAddColumn(ins, autoinc.Col)
+	values := ins.Rows.(sqlparser.Values)
+	generate := make([]interface{}, len(values))
+	for rownum := range values {
+		row := values[rownum].(sqlparser.ValTuple)
+		val, err := asInterface(row[pos])
+		if err != nil {
+			return fmt.Errorf("could not convert val: %s, pos: %d: %v", sqlparser.String(row[pos]), pos, err)
+		}
+		generate[rownum] = val
+		row[pos] = sqlparser.ValArg([]byte(":" + InsertVarName(autoinc.Col, rownum, len(values))))
 	}
-	row := ins.Rows.(sqlparser.Values)[0].(sqlparser.ValTuple)
-	val, err := asInterface(row[pos])
-	if err != nil {
-		return fmt.Errorf("could not convert val: %s, pos: %d: %v", sqlparser.String(row[pos]), pos, err)
+	if len(values) == 1 {
+		plan.Generate = generate[0]
+	} else {
+		plan.Generate = generate
 	}
-	plan.Values = append(plan.Values.([]interface{}), val)
-	row[pos] = sqlparser.ValArg([]byte(fmt.Sprintf(":_%s", colVindex.Col)))
 	return nil
 }
+
+// findOrAddColumn returns the position of col in the column list
+// of ins. If it's absent, it's added with null values.
+func findOrAddColumn(ins *sqlparser.Insert, col string) int {
+	for i, column := range ins.Columns {
+		if col == sqlparser.GetColName(column.(*sqlparser.NonStarExpr).Expr) {
+			return i
+		}
+	}
+	ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: sqlparser.SQLName(col)}})
+	values := ins.Rows.(sqlparser.Values)
+	for i := range values {
+		values[i] = append(values[i].(sqlparser.ValTuple), &sqlparser.NullVal{})
+	}
+	return len(ins.Columns) - 1
+}
+
+// InsertVarName returns the name of the bind var that InsertSharded
+// uses for the value of col in row rownum of an insert of numrows rows.
+// The row number is separated by an underscore, so that a column
+// whose name ends with a digit can't collide with another column.
+func InsertVarName(col string, rownum, numrows int) string {
+	if numrows == 1 {
+		return "_" + col
+	}
+	return "_" + col + "_" + strconv.Itoa(rownum)
+}
+
+// buildMultiRowPlan splits the rewritten multi-row insert into
+// Prefix, Mid and Suffix, so that VTGate can build an insert
+// for each shard that contains only the rows that go there.
+func buildMultiRowPlan(ins *sqlparser.Insert, plan *Plan) {
+	buf := sqlparser.NewTrackedBuffer(nil)
+	buf.Myprintf("insert %vinto %v%v values ", ins.Comments, ins.Table, ins.Columns)
+	plan.Prefix = buf.String()
+	values := ins.Rows.(sqlparser.Values)
+	plan.Mid = make([]string, len(values))
+	for i, row := range values {
+		plan.Mid[i] = sqlparser.String(row)
+	}
+	plan.Suffix = sqlparser.String(ins.OnDup)
+}
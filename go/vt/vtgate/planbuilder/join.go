@@ -0,0 +1,721 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// tableAlias is a table referenced in the FROM clause of a join.
+type tableAlias struct {
+	// Name is the alias of the table if specified,
+	// or the table name otherwise.
+	Name  string
+	Table *Table
+}
+
+// isJoin returns true if the FROM clause references more than one table.
+func isJoin(tableExprs sqlparser.TableExprs) bool {
+	if len(tableExprs) > 1 {
+		return true
+	}
+	_, ok := tableExprs[0].(*sqlparser.JoinTableExpr)
+	return ok
+}
+
+// getJoinRouting fills the plan fields for a join. The join can be
+// sent as is to the shards only if all the tables are co-located,
+// which is guaranteed if they're joined on their primary vindex columns
+// and those vindexes map the same values to the same keyspace ids.
+// In that case, the join is routed using the primary vindex of one of
+// the tables: to a single shard (SelectEqual) if the where clause
+// specifies its value, or to all shards (SelectScatter) otherwise.
+// If the tables are not co-located, VTGate performs the join itself
+// using a SelectJoin plan.
+func getJoinRouting(sel *sqlparser.Select, plan *Plan, schema *Schema) {
+	tables, conditions, err := getJoinTables(sel.From, schema)
+	if err != nil {
+		plan.Reason = err.Error()
+		return
+	}
+	keyspace := tables[0].Table.Keyspace
+	for _, ta := range tables[1:] {
+		if ta.Table.Keyspace != keyspace {
+			buildJoinPlan(sel, plan, schema)
+			return
+		}
+	}
+	if !keyspace.Sharded {
+		plan.ID = SelectUnsharded
+		plan.Table = tables[0].Table
+		return
+	}
+	for _, cond := range conditions {
+		if hasSubquery(cond) {
+			plan.Reason = "has subquery"
+			return
+		}
+	}
+	// Only the where clause can restrict the rows of all tables.
+	// For example, the ON clause of a LEFT JOIN doesn't restrict
+	// the rows of the left table.
+	var filters []sqlparser.BoolExpr
+	if sel.Where != nil {
+		if hasSubquery(sel.Where.Expr) {
+			plan.Reason = "has subquery"
+			return
+		}
+		filters = splitAnd(sel.Where.Expr, nil)
+	}
+	if !isColocated(tables, append(conditions, filters...)) {
+		buildJoinPlan(sel, plan, schema)
+		return
+	}
+	plan.ID = SelectScatter
+	plan.Table = tables[0].Table
+	for _, filter := range filters {
+		for _, ta := range tables {
+			primary := ta.Table.ColVindexes[0]
+			if value, ok := getJoinMatch(filter, ta.Name, primary.Col); ok {
+				plan.ID = SelectEqual
+				plan.Table = ta.Table
+				plan.ColVindex = primary
+				plan.Values = value
+				return
+			}
+		}
+	}
+}
+
+// getJoinTables returns the tables referenced by the FROM clause, along
+// with the conditions of the ON clauses, split by AND.
+func getJoinTables(tableExprs sqlparser.TableExprs, schema *Schema) (tables []*tableAlias, conditions []sqlparser.BoolExpr, err error) {
+	for _, tableExpr := range tableExprs {
+		tables, conditions, err = addJoinTables(tableExpr, schema, tables, conditions)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return tables, conditions, nil
+}
+
+func addJoinTables(tableExpr sqlparser.TableExpr, schema *Schema, tables []*tableAlias, conditions []sqlparser.BoolExpr) ([]*tableAlias, []sqlparser.BoolExpr, error) {
+	switch tableExpr := tableExpr.(type) {
+	case *sqlparser.AliasedTableExpr:
+		tablename := sqlparser.GetTableName(tableExpr.Expr)
+		table, reason := schema.FindTable(tablename)
+		if reason != "" {
+			return nil, nil, errors.New(reason)
+		}
+		name := string(tableExpr.As)
+		if name == "" {
+			name = tablename
+		}
+		for _, ta := range tables {
+			if ta.Name == name {
+				return nil, nil, fmt.Errorf("duplicate table alias: %s", name)
+			}
+		}
+		return append(tables, &tableAlias{Name: name, Table: table}), conditions, nil
+	case *sqlparser.ParenTableExpr:
+		return addJoinTables(tableExpr.Expr, schema, tables, conditions)
+	case *sqlparser.JoinTableExpr:
+		var err error
+		tables, conditions, err = addJoinTables(tableExpr.LeftExpr, schema, tables, conditions)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables, conditions, err = addJoinTables(tableExpr.RightExpr, schema, tables, conditions)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tableExpr.On != nil {
+			conditions = splitAnd(tableExpr.On, conditions)
+		}
+		return tables, conditions, nil
+	}
+	return nil, nil, errors.New("complex table expression")
+}
+
+// splitAnd breaks up the BoolExpr into AND-separated conditions
+// and appends them to filters, which can be shuffled and recombined
+// as needed.
+func splitAnd(node sqlparser.BoolExpr, filters []sqlparser.BoolExpr) []sqlparser.BoolExpr {
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		filters = splitAnd(node.Left, filters)
+		return splitAnd(node.Right, filters)
+	case *sqlparser.ParenBoolExpr:
+		if and, ok := node.Expr.(*sqlparser.AndExpr); ok {
+			return splitAnd(and, filters)
+		}
+	}
+	return append(filters, node)
+}
+
+// isColocated returns true if the conditions join every
+// table on its primary vindex column with another table
+// whose primary vindex is compatible.
+func isColocated(tables []*tableAlias, conditions []sqlparser.BoolExpr) bool {
+	// groups maps a table alias to the index of its co-located group.
+	groups := make(map[string]int, len(tables))
+	for i, ta := range tables {
+		groups[ta.Name] = i
+	}
+	for _, cond := range conditions {
+		comparison, ok := cond.(*sqlparser.ComparisonExpr)
+		if !ok || comparison.Operator != sqlparser.AST_EQ {
+			continue
+		}
+		left := findPrimaryColumn(comparison.Left, tables)
+		right := findPrimaryColumn(comparison.Right, tables)
+		if left == nil || right == nil || left == right {
+			continue
+		}
+		if !isSameVindex(left.Table.ColVindexes[0], right.Table.ColVindexes[0]) {
+			continue
+		}
+		from, to := groups[left.Name], groups[right.Name]
+		for name, group := range groups {
+			if group == from {
+				groups[name] = to
+			}
+		}
+	}
+	for _, group := range groups {
+		if group != groups[tables[0].Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// findPrimaryColumn returns the table whose primary vindex column
+// is referenced by node. The column name must be qualified.
+func findPrimaryColumn(node sqlparser.ValExpr, tables []*tableAlias) *tableAlias {
+	colname, ok := node.(*sqlparser.ColName)
+	if !ok {
+		return nil
+	}
+	for _, ta := range tables {
+		if string(colname.Qualifier) == ta.Name && string(colname.Name) == ta.Table.ColVindexes[0].Col {
+			return ta
+		}
+	}
+	return nil
+}
+
+// isSameVindex returns true if the two vindexes are guaranteed
+// to map a value to the same keyspace id. This is true if they're
+// the same vindex, or if they're of the same type and the mapping
+// is purely functional.
+func isSameVindex(cv1, cv2 *ColVindex) bool {
+	if cv1.Name == cv2.Name {
+		return true
+	}
+	if cv1.Type != cv2.Type {
+		return false
+	}
+	_, ok1 := cv1.Vindex.(Functional)
+	_, ok2 := cv2.Vindex.(Functional)
+	return ok1 && ok2
+}
+
+// getJoinMatch returns the value of the column if the condition
+// equates the qualified column to a value.
+func getJoinMatch(node sqlparser.BoolExpr, qualifier, col string) (interface{}, bool) {
+	comparison, ok := node.(*sqlparser.ComparisonExpr)
+	if !ok || comparison.Operator != sqlparser.AST_EQ {
+		return nil, false
+	}
+	colname, ok := comparison.Left.(*sqlparser.ColName)
+	if !ok || string(colname.Qualifier) != qualifier || string(colname.Name) != col {
+		return nil, false
+	}
+	if !sqlparser.IsValue(comparison.Right) {
+		return nil, false
+	}
+	value, err := asInterface(comparison.Right)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// JoinVarPrefix is the prefix of the bind var names used by the
+// Right plan of a SelectJoin to refer to the columns of the current
+// Left row. For example, :_u.id refers to the id column of the table
+// aliased as u. The qualifier and the column name are escaped by
+// joinVarEscape, so that the dot can't be part of them.
+const JoinVarPrefix = "_"
+
+// joinVarName returns the name of the join var for
+// the column of the table aliased as qualifier.
+func joinVarName(qualifier, col string) string {
+	return JoinVarPrefix + joinVarEscape(qualifier) + "." + joinVarEscape(col)
+}
+
+// joinVarEscape escapes the characters of name that can't be
+// used in a bind var name, or that are used for separating or
+// escaping, as @ followed by their hex code.
+func joinVarEscape(name string) string {
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9', ch == '_':
+			buf.WriteByte(ch)
+		default:
+			fmt.Fprintf(buf, "@%02x", ch)
+		}
+	}
+	return buf.String()
+}
+
+// joinSide is a bit set of the sides of a join
+// referenced by an expression.
+type joinSide int
+
+const (
+	sideNone  = joinSide(0)
+	sideLeft  = joinSide(1)
+	sideRight = joinSide(2)
+	sideBoth  = sideLeft | sideRight
+)
+
+// joinBuilder accumulates the state needed for
+// converting a select into a SelectJoin plan.
+type joinBuilder struct {
+	leftFrom, rightFrom sqlparser.TableExprs
+	left, right         []*tableAlias
+	isLeftJoin          bool
+	// on contains the conditions of the ON clause, split by AND.
+	on                    []sqlparser.BoolExpr
+	leftExprs, rightExprs sqlparser.SelectExprs
+	leftFilters           []sqlparser.BoolExpr
+	rightFilters          []sqlparser.BoolExpr
+	joinVars              map[string]int
+	// joinVarUses counts the references to each join var.
+	joinVarUses map[string]int
+	cols        []int
+}
+
+// buildJoinPlan converts the plan into a SelectJoin plan. The select
+// is split into a query for the left side of the join, which can itself
+// be a join, and a query for the right side, which must be a single table.
+// The right query references the columns of the left side through bind
+// vars. VTGate executes the left query, and then the right query for
+// each of the left rows. If the right query can be batched, VTGate
+// executes its BatchRight version instead for many left rows at a time.
+func buildJoinPlan(sel *sqlparser.Select, plan *Plan, schema *Schema) {
+	plan.ID = NoPlan
+	plan.Table = nil
+	if err := checkJoinConstructs(sel); err != nil {
+		plan.Reason = err.Error()
+		return
+	}
+	jb, err := newJoinBuilder(sel.From, schema)
+	if err != nil {
+		plan.Reason = err.Error()
+		return
+	}
+	if err := jb.addSelectExprs(sel.SelectExprs); err != nil {
+		plan.Reason = err.Error()
+		return
+	}
+	if err := jb.addFilters(sel.Where); err != nil {
+		plan.Reason = err.Error()
+		return
+	}
+	// The right query must be generated first because
+	// it adds the join vars to the select list of the left query.
+	rightQuery := jb.generateRightQuery(sel.Lock)
+	batchQuery := jb.generateBatchQuery(sel.Lock)
+	leftQuery := jb.generateLeftQuery(sel.Lock)
+	plan.Left = BuildPlan(leftQuery, schema)
+	if plan.Left.ID == NoPlan {
+		plan.Reason = plan.Left.Reason
+		plan.Left = nil
+		return
+	}
+	plan.Right = BuildPlan(rightQuery, schema)
+	if plan.Right.ID == NoPlan {
+		plan.Reason = plan.Right.Reason
+		plan.Left = nil
+		plan.Right = nil
+		return
+	}
+	if batchQuery != "" {
+		// The right query can still be executed row by row
+		// if the batch query can't be routed.
+		if batch := BuildPlan(batchQuery, schema); batch.ID != NoPlan {
+			plan.BatchRight = batch
+		}
+	}
+	plan.ID = SelectJoin
+	plan.IsLeftJoin = jb.isLeftJoin
+	plan.JoinVars = jb.joinVars
+	plan.JoinCols = jb.cols
+	plan.FieldQuery = jb.generateFieldQuery()
+}
+
+// checkJoinConstructs returns an error if the select
+// has constructs that SelectJoin cannot handle.
+func checkJoinConstructs(sel *sqlparser.Select) error {
+	switch {
+	case sel.Distinct != "" || sel.GroupBy != nil || sel.Having != nil || hasAggregates(sel.SelectExprs):
+		return errors.New("cross-shard join has post-processing constructs")
+	case sel.OrderBy != nil || sel.Limit != nil:
+		return errors.New("order by and limit are not supported for cross-shard joins")
+	}
+	return nil
+}
+
+func newJoinBuilder(tableExprs sqlparser.TableExprs, schema *Schema) (*joinBuilder, error) {
+	jb := &joinBuilder{
+		joinVars:    make(map[string]int),
+		joinVarUses: make(map[string]int),
+	}
+	if len(tableExprs) > 1 {
+		jb.leftFrom = tableExprs[:len(tableExprs)-1]
+		jb.rightFrom = tableExprs[len(tableExprs)-1:]
+	} else {
+		tableExpr := tableExprs[0]
+		for {
+			paren, ok := tableExpr.(*sqlparser.ParenTableExpr)
+			if !ok {
+				break
+			}
+			tableExpr = paren.Expr
+		}
+		join, ok := tableExpr.(*sqlparser.JoinTableExpr)
+		if !ok {
+			return nil, errors.New("complex table expression")
+		}
+		switch join.Join {
+		case sqlparser.AST_JOIN, sqlparser.AST_STRAIGHT_JOIN, sqlparser.AST_CROSS_JOIN:
+			jb.leftFrom = sqlparser.TableExprs{join.LeftExpr}
+			jb.rightFrom = sqlparser.TableExprs{join.RightExpr}
+		case sqlparser.AST_LEFT_JOIN:
+			jb.isLeftJoin = true
+			jb.leftFrom = sqlparser.TableExprs{join.LeftExpr}
+			jb.rightFrom = sqlparser.TableExprs{join.RightExpr}
+		case sqlparser.AST_RIGHT_JOIN:
+			// A right join is a left join with the sides swapped.
+			jb.isLeftJoin = true
+			jb.leftFrom = sqlparser.TableExprs{join.RightExpr}
+			jb.rightFrom = sqlparser.TableExprs{join.LeftExpr}
+		default:
+			return nil, fmt.Errorf("%s is not supported for cross-shard joins", join.Join)
+		}
+		if join.On != nil {
+			if hasSubquery(join.On) {
+				return nil, errors.New("has subquery")
+			}
+			jb.on = splitAnd(join.On, nil)
+		}
+	}
+	if _, ok := jb.rightFrom[0].(*sqlparser.AliasedTableExpr); !ok {
+		return nil, errors.New("the right side of a cross-shard join must be a single table")
+	}
+	var err error
+	if jb.left, _, err = getJoinTables(jb.leftFrom, schema); err != nil {
+		return nil, err
+	}
+	if jb.right, _, err = getJoinTables(jb.rightFrom, schema); err != nil {
+		return nil, err
+	}
+	for _, ta := range jb.left {
+		if ta.Name == jb.right[0].Name {
+			return nil, fmt.Errorf("duplicate table alias: %s", ta.Name)
+		}
+	}
+	return jb, nil
+}
+
+// addSelectExprs assigns each select expression to the query of the
+// side of the join it references. Expressions that don't reference any
+// table are evaluated by the left query.
+func (jb *joinBuilder) addSelectExprs(selectExprs sqlparser.SelectExprs) error {
+	for _, expr := range selectExprs {
+		nonStar, ok := expr.(*sqlparser.NonStarExpr)
+		if !ok {
+			return errors.New("* is not supported for cross-shard joins")
+		}
+		if hasSubquery(nonStar.Expr) {
+			return errors.New("has subquery")
+		}
+		side, err := jb.findSide(nonStar.Expr)
+		if err != nil {
+			return err
+		}
+		switch side {
+		case sideNone, sideLeft:
+			jb.leftExprs = append(jb.leftExprs, nonStar)
+			jb.cols = append(jb.cols, -len(jb.leftExprs))
+		case sideRight:
+			jb.rightExprs = append(jb.rightExprs, nonStar)
+			jb.cols = append(jb.cols, len(jb.rightExprs))
+		default:
+			return fmt.Errorf("select expression references both sides of a cross-shard join: %s", sqlparser.String(nonStar))
+		}
+	}
+	return nil
+}
+
+// addFilters assigns the conditions of the ON and WHERE clauses to the
+// left or right query. Conditions that reference the left side only are
+// applied by the left query. The others are applied by the right query,
+// where they can reference the left columns through join vars. For a
+// left join, the ON clause cannot filter out left rows, so all its
+// conditions are applied by the right query, and the WHERE clause cannot
+// reference the right side because the right query can't tell VTGate
+// which unmatched rows must be discarded.
+func (jb *joinBuilder) addFilters(where *sqlparser.Where) error {
+	var filters []sqlparser.BoolExpr
+	if where != nil {
+		if hasSubquery(where.Expr) {
+			return errors.New("has subquery")
+		}
+		filters = splitAnd(where.Expr, nil)
+	}
+	if jb.isLeftJoin {
+		jb.rightFilters = append(jb.rightFilters, jb.on...)
+	} else {
+		filters = append(jb.on, filters...)
+	}
+	for _, filter := range filters {
+		side, err := jb.findSide(filter)
+		if err != nil {
+			return err
+		}
+		switch {
+		case side == sideNone || side == sideLeft:
+			jb.leftFilters = append(jb.leftFilters, filter)
+		case jb.isLeftJoin:
+			return errors.New("where clause of a cross-shard left join cannot reference the right table")
+		default:
+			jb.rightFilters = append(jb.rightFilters, filter)
+		}
+	}
+	return nil
+}
+
+// findSide returns the sides of the join referenced by node.
+// Every column must be qualified by the name of its table.
+func (jb *joinBuilder) findSide(node sqlparser.SQLNode) (side joinSide, err error) {
+	// The formatter is used for visiting the nodes of the tree.
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		colname, ok := node.(*sqlparser.ColName)
+		if !ok {
+			node.Format(buf)
+			return
+		}
+		switch {
+		case colname.Qualifier == "":
+			if err == nil {
+				err = fmt.Errorf("column %s must be qualified in cross-shard joins", sqlparser.String(colname))
+			}
+		case findTableAlias(jb.left, string(colname.Qualifier)) != nil:
+			side |= sideLeft
+		case findTableAlias(jb.right, string(colname.Qualifier)) != nil:
+			side |= sideRight
+		default:
+			if err == nil {
+				err = fmt.Errorf("table %s not found", colname.Qualifier)
+			}
+		}
+	})
+	buf.Myprintf("%v", node)
+	return side, err
+}
+
+func findTableAlias(tables []*tableAlias, name string) *tableAlias {
+	for _, ta := range tables {
+		if ta.Name == name {
+			return ta
+		}
+	}
+	return nil
+}
+
+// isLeftColumn returns true if node is a column of the left side.
+func (jb *joinBuilder) isLeftColumn(node sqlparser.ValExpr) bool {
+	colname, ok := node.(*sqlparser.ColName)
+	return ok && findTableAlias(jb.left, string(colname.Qualifier)) != nil
+}
+
+// addJoinVar returns the name of the join var for the left column,
+// and adds the column to the left query if it's not already selected.
+func (jb *joinBuilder) addJoinVar(colname *sqlparser.ColName) string {
+	name := joinVarName(string(colname.Qualifier), string(colname.Name))
+	jb.joinVarUses[name]++
+	if _, ok := jb.joinVars[name]; ok {
+		return name
+	}
+	for i, expr := range jb.leftExprs {
+		selected, ok := expr.(*sqlparser.NonStarExpr).Expr.(*sqlparser.ColName)
+		if ok && selected.Qualifier == colname.Qualifier && selected.Name == colname.Name {
+			jb.joinVars[name] = i
+			return name
+		}
+	}
+	jb.leftExprs = append(jb.leftExprs, &sqlparser.NonStarExpr{Expr: colname})
+	jb.joinVars[name] = len(jb.leftExprs) - 1
+	return name
+}
+
+// formatRight formats the nodes of the right query. The columns
+// of the left side are replaced by join vars. Equality conditions
+// are written with the right column first so that they can be
+// used for routing the right query.
+func (jb *joinBuilder) formatRight(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	switch node := node.(type) {
+	case *sqlparser.ColName:
+		if jb.isLeftColumn(node) {
+			buf.Myprintf(":%s", jb.addJoinVar(node))
+			return
+		}
+	case *sqlparser.ComparisonExpr:
+		if node.Operator == sqlparser.AST_EQ && jb.isLeftColumn(node.Left) && !jb.isLeftColumn(node.Right) {
+			buf.Myprintf("%v = %v", node.Right, node.Left)
+			return
+		}
+	}
+	node.Format(buf)
+}
+
+func (jb *joinBuilder) generateRightQuery(lock string) string {
+	sel := &sqlparser.Select{
+		SelectExprs: jb.rightSelectExprs(),
+		From:        jb.rightFrom,
+		Where:       sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(jb.rightFilters)),
+		Lock:        lock,
+	}
+	buf := sqlparser.NewTrackedBuffer(jb.formatRight)
+	buf.Myprintf("%v", sel)
+	return buf.String()
+}
+
+// batchFilter returns the condition of the right query that
+// compares a column of the right table to the only join var, along
+// with the right column and the name of the join var, if the join
+// var is not used anywhere else. The right query can then be batched
+// by comparing the right column to a list of join var values.
+// It must be called after generateRightQuery.
+func (jb *joinBuilder) batchFilter() (filter *sqlparser.ComparisonExpr, rightCol *sqlparser.ColName, name string) {
+	if len(jb.joinVars) != 1 {
+		return nil, nil, ""
+	}
+	for name = range jb.joinVars {
+	}
+	if jb.joinVarUses[name] != 1 {
+		return nil, nil, ""
+	}
+	for _, filter := range jb.rightFilters {
+		comparison, ok := filter.(*sqlparser.ComparisonExpr)
+		if !ok || comparison.Operator != sqlparser.AST_EQ {
+			continue
+		}
+		left, right := comparison.Left, comparison.Right
+		if jb.isLeftColumn(right) {
+			left, right = right, left
+		}
+		if !jb.isLeftColumn(left) || jb.isLeftColumn(right) {
+			continue
+		}
+		if colname, ok := right.(*sqlparser.ColName); ok {
+			return comparison, colname, name
+		}
+	}
+	return nil, nil, ""
+}
+
+// generateBatchQuery returns the right query with the batch filter
+// changed to an IN on the list of join var values, and with the right
+// column of the filter added to the select list, so that VTGate can
+// match the returned rows to the left rows. It returns "" if the right
+// query can't be batched.
+func (jb *joinBuilder) generateBatchQuery(lock string) string {
+	batchFilter, rightCol, name := jb.batchFilter()
+	if batchFilter == nil {
+		return ""
+	}
+	selectExprs := append(sqlparser.SelectExprs(nil), jb.rightSelectExprs()...)
+	selectExprs = append(selectExprs, &sqlparser.NonStarExpr{Expr: rightCol})
+	sel := &sqlparser.Select{
+		SelectExprs: selectExprs,
+		From:        jb.rightFrom,
+		Where:       sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(jb.rightFilters)),
+		Lock:        lock,
+	}
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		if comparison, ok := node.(*sqlparser.ComparisonExpr); ok && comparison == batchFilter {
+			buf.Myprintf("%v in ::%s", rightCol, name)
+			return
+		}
+		jb.formatRight(buf, node)
+	})
+	buf.Myprintf("%v", sel)
+	return buf.String()
+}
+
+func (jb *joinBuilder) generateLeftQuery(lock string) string {
+	selectExprs := jb.leftExprs
+	if len(selectExprs) == 0 {
+		// The left query must return a column for each row.
+		selectExprs = sqlparser.SelectExprs{&sqlparser.NonStarExpr{Expr: sqlparser.NumVal("1")}}
+	}
+	return generateQuery(&sqlparser.Select{
+		SelectExprs: selectExprs,
+		From:        jb.leftFrom,
+		Where:       sqlparser.NewWhere(sqlparser.AST_WHERE, joinAnd(jb.leftFilters)),
+		Lock:        lock,
+	})
+}
+
+// generateFieldQuery returns a query that returns no rows, but
+// returns the fields of the right query. It's used when the right
+// query is never executed.
+func (jb *joinBuilder) generateFieldQuery() string {
+	return generateQuery(&sqlparser.Select{
+		SelectExprs: jb.rightSelectExprs(),
+		From:        jb.rightFrom,
+		Where: sqlparser.NewWhere(sqlparser.AST_WHERE, &sqlparser.ComparisonExpr{
+			Left:     sqlparser.NumVal("1"),
+			Operator: sqlparser.AST_NE,
+			Right:    sqlparser.NumVal("1"),
+		}),
+	})
+}
+
+func (jb *joinBuilder) rightSelectExprs() sqlparser.SelectExprs {
+	if len(jb.rightExprs) == 0 {
+		// The right query must return a column for each match.
+		return sqlparser.SelectExprs{&sqlparser.NonStarExpr{Expr: sqlparser.NumVal("1")}}
+	}
+	return jb.rightExprs
+}
+
+// joinAnd combines the conditions with AND. It returns nil
+// if there are no conditions.
+func joinAnd(filters []sqlparser.BoolExpr) sqlparser.BoolExpr {
+	var result sqlparser.BoolExpr
+	for _, filter := range filters {
+		if or, ok := filter.(*sqlparser.OrExpr); ok {
+			filter = &sqlparser.ParenBoolExpr{Expr: or}
+		}
+		if result == nil {
+			result = filter
+			continue
+		}
+		result = &sqlparser.AndExpr{Left: result, Right: filter}
+	}
+	return result
+}
@@ -22,10 +22,18 @@ const (
 	SelectIN
 	SelectKeyrange
 	SelectScatter
+	SelectMerge
+	SelectAggregate
+	SelectJoin
+	SelectUnion
 	UpdateUnsharded
 	UpdateEqual
+	UpdateIN
+	UpdateScatter
 	DeleteUnsharded
 	DeleteEqual
+	DeleteIN
+	DeleteScatter
 	InsertUnsharded
 	InsertSharded
 	NumPlans
@@ -39,10 +47,18 @@ var planName = [NumPlans]string{
 	"SelectIN",
 	"SelectKeyrange",
 	"SelectScatter",
+	"SelectMerge",
+	"SelectAggregate",
+	"SelectJoin",
+	"SelectUnion",
 	"UpdateUnsharded",
 	"UpdateEqual",
+	"UpdateIN",
+	"UpdateScatter",
 	"DeleteUnsharded",
 	"DeleteEqual",
+	"DeleteIN",
+	"DeleteScatter",
 	"InsertUnsharded",
 	"InsertSharded",
 }
@@ -59,13 +75,96 @@ type Plan struct {
 	// Rewritten is the rewritten query. This is empty for
 	// all Unsharded plans since the Original query is sufficient.
 	Rewritten string
-	// Subquery is used for DeleteUnsharded to fetch the column values
-	// for owned vindexes so they can be deleted.
+	// Subquery is used for DeleteEqual to fetch the column values
+	// for owned vindexes so they can be deleted. For the IN and Scatter
+	// variants of Update and Delete, it also fetches the primary vindex
+	// column first, and is used to enforce the row limit.
 	Subquery  string
 	ColVindex *ColVindex
 	// Values is a single or a list of values that are used
 	// for making routing decisions.
 	Values interface{}
+	// Route is the plan used for sending the query to the shards
+	// when VTGate needs to post-process the results, like for
+	// SelectMerge. It can be SelectEqual, SelectIN or SelectScatter.
+	Route PlanID
+	// Aggregates specifies how SelectAggregate computes each
+	// column of the result from the columns returned by the shards.
+	Aggregates []AggregateParams
+	// ResultColumns is the number of leading Aggregates returned
+	// to the client. The rest are only used by Having and OrderBy.
+	ResultColumns int
+	// GroupBy lists the shard result columns by which
+	// SelectAggregate groups the rows.
+	GroupBy []int
+	// Having is the HAVING clause that SelectAggregate applies
+	// to the aggregated rows. Aggregated columns are referenced
+	// through bind vars named with HavingVarPrefix.
+	Having sqlparser.BoolExpr
+	// OrderBy specifies the columns by which SelectMerge
+	// merge-sorts the results returned by the shards, or
+	// by which SelectAggregate sorts the aggregated rows.
+	OrderBy []OrderByParams
+	// Limit and Offset are the values of the LIMIT clause that
+	// SelectMerge and SelectAggregate apply to the final results.
+	// They are nil if absent, an int64 for a number, or a string
+	// for a bind var.
+	Limit  interface{}
+	Offset interface{}
+	// Left and Right are the plans of the two sides of a
+	// SelectJoin or SelectUnion. For SelectJoin, VTGate executes
+	// Left, and then Right for every row returned by Left.
+	Left, Right *Plan
+	// IsLeftJoin is true if SelectJoin must return the Left rows
+	// that have no match in Right, with nulls for the Right columns.
+	IsLeftJoin bool
+	// JoinVars maps the names of the bind vars used by Right
+	// to the index of the Left column that supplies their value.
+	JoinVars map[string]int
+	// JoinCols specifies the columns of the SelectJoin result.
+	// A negative value -n refers to column n-1 of the Left result,
+	// and a positive value n refers to column n-1 of the Right result.
+	JoinCols []int
+	// FieldQuery is sent by SelectJoin to a single shard of the Right
+	// table to get the fields of the Right result if Right was never
+	// executed.
+	FieldQuery string
+	// BatchRight is the version of Right that SelectJoin executes for
+	// many Left rows at a time, if Right uses a single join var in an
+	// equality with one of its columns. The join var is a list of
+	// values, and the last column of the result is the compared
+	// column, by which the rows are matched to the Left rows.
+	BatchRight *Plan
+	// IsDistinct is true if SelectUnion must remove duplicate rows.
+	IsDistinct bool
+	// Prefix, Mid and Suffix are the parts of a multi-row InsertSharded.
+	// Mid contains the rewritten values of each row. VTGate sends to
+	// each shard the Prefix, followed by the Mid of the rows that go
+	// there, and the Suffix.
+	Prefix string
+	Mid    []string
+	Suffix string
+	// SetValues contains the new values of the columns of an UpdateEqual
+	// that changes ColVindex columns. If the primary ColVindex column
+	// changes, it contains all the columns of the SET clause. Otherwise,
+	// it contains only the ColVindex columns.
+	SetValues map[string]interface{}
+	// DeleteQuery is used by an UpdateEqual that changes the primary
+	// ColVindex column to delete the rows from their current shard.
+	DeleteQuery string
+	// Generate is set for an insert into a table with an Autoinc column.
+	// It is the value supplied for that column, or a list of such values
+	// for a multi-row insert. A nil value must be generated from the
+	// table's sequence.
+	Generate interface{}
+}
+
+// OrderByParams specifies a column by which the results
+// of a SelectMerge or SelectAggregate plan must be sorted. The column is
+// identified by the name it has in the result.
+type OrderByParams struct {
+	Col  string
+	Desc bool
 }
 
 // Size is defined so that Plan can be given to an LRUCache.
@@ -75,7 +174,7 @@ func (pln *Plan) Size() int {
 
 // MarshalJSON serializes the Plan into a JSON representation.
 func (pln *Plan) MarshalJSON() ([]byte, error) {
-	var tname, vindexName, col string
+	var tname, vindexName, col, having string
 	if pln.Table != nil {
 		tname = pln.Table.Name
 	}
@@ -83,26 +182,73 @@ func (pln *Plan) MarshalJSON() ([]byte, error) {
 		vindexName = pln.ColVindex.Name
 		col = pln.ColVindex.Col
 	}
+	if pln.Having != nil {
+		having = sqlparser.String(pln.Having)
+	}
 	marshalPlan := struct {
-		ID        PlanID
-		Reason    string
-		Table     string
-		Original  string
-		Rewritten string
-		Subquery  string
-		Vindex    string
-		Col       string
-		Values    interface{}
+		ID            PlanID
+		Reason        string
+		Table         string
+		Original      string
+		Rewritten     string
+		Subquery      string
+		Vindex        string
+		Col           string
+		Values        interface{}
+		Route         PlanID                 `json:",omitempty"`
+		Aggregates    []AggregateParams      `json:",omitempty"`
+		ResultColumns int                    `json:",omitempty"`
+		GroupBy       []int                  `json:",omitempty"`
+		Having        string                 `json:",omitempty"`
+		OrderBy       []OrderByParams        `json:",omitempty"`
+		Limit         interface{}            `json:",omitempty"`
+		Offset        interface{}            `json:",omitempty"`
+		Left          *Plan                  `json:",omitempty"`
+		Right         *Plan                  `json:",omitempty"`
+		IsLeftJoin    bool                   `json:",omitempty"`
+		JoinVars      map[string]int         `json:",omitempty"`
+		JoinCols      []int                  `json:",omitempty"`
+		FieldQuery    string                 `json:",omitempty"`
+		BatchRight    *Plan                  `json:",omitempty"`
+		IsDistinct    bool                   `json:",omitempty"`
+		Prefix        string                 `json:",omitempty"`
+		Mid           []string               `json:",omitempty"`
+		Suffix        string                 `json:",omitempty"`
+		SetValues     map[string]interface{} `json:",omitempty"`
+		DeleteQuery   string                 `json:",omitempty"`
+		Generate      interface{}            `json:",omitempty"`
 	}{
-		ID:        pln.ID,
-		Reason:    pln.Reason,
-		Table:     tname,
-		Original:  pln.Original,
-		Rewritten: pln.Rewritten,
-		Subquery:  pln.Subquery,
-		Vindex:    vindexName,
-		Col:       col,
-		Values:    pln.Values,
+		ID:            pln.ID,
+		Reason:        pln.Reason,
+		Table:         tname,
+		Original:      pln.Original,
+		Rewritten:     pln.Rewritten,
+		Subquery:      pln.Subquery,
+		Vindex:        vindexName,
+		Col:           col,
+		Values:        pln.Values,
+		Route:         pln.Route,
+		Aggregates:    pln.Aggregates,
+		ResultColumns: pln.ResultColumns,
+		GroupBy:       pln.GroupBy,
+		Having:        having,
+		OrderBy:       pln.OrderBy,
+		Limit:         pln.Limit,
+		Offset:        pln.Offset,
+		Left:          pln.Left,
+		Right:         pln.Right,
+		IsLeftJoin:    pln.IsLeftJoin,
+		JoinVars:      pln.JoinVars,
+		JoinCols:      pln.JoinCols,
+		FieldQuery:    pln.FieldQuery,
+		BatchRight:    pln.BatchRight,
+		IsDistinct:    pln.IsDistinct,
+		Prefix:        pln.Prefix,
+		Mid:           pln.Mid,
+		Suffix:        pln.Suffix,
+		SetValues:     pln.SetValues,
+		DeleteQuery:   pln.DeleteQuery,
+		Generate:      pln.Generate,
 	}
 	return json.Marshal(marshalPlan)
 }
@@ -110,7 +256,7 @@ func (pln *Plan) MarshalJSON() ([]byte, error) {
 // IsMulti returns true if the SELECT query can potentially
 // be sent to more than one shard.
 func (pln *Plan) IsMulti() bool {
-	if pln.ID == SelectIN || pln.ID == SelectScatter {
+	if pln.ID == SelectIN || pln.ID == SelectScatter || pln.ID == SelectMerge || pln.ID == SelectAggregate {
 		return true
 	}
 	if pln.ID == SelectEqual && !IsUnique(pln.ColVindex.Vindex) {
@@ -167,7 +313,9 @@ func BuildPlan(query string, schema *Schema) *Plan {
 		plan = buildUpdatePlan(statement, schema)
 	case *sqlparser.Delete:
 		plan = buildDeletePlan(statement, schema)
-	case *sqlparser.Union, *sqlparser.Set, *sqlparser.DDL, *sqlparser.Other:
+	case *sqlparser.Union:
+		plan = buildUnionPlan(statement, schema)
+	case *sqlparser.Set, *sqlparser.DDL, *sqlparser.Other:
 		return noplan
 	default:
 		panic("unexpected")
@@ -24,6 +24,7 @@ type Table struct {
 	ColVindexes []*ColVindex
 	Ordered     []*ColVindex
 	Owned       []*ColVindex
+	Autoinc     *Autoinc
 }
 
 // Keyspace contains the keyspcae info for each Table.
@@ -32,6 +33,26 @@ type Keyspace struct {
 	Sharded bool
 }
 
+// Autoinc contains the auto-inc information for a table.
+// The values of Col are generated from Sequence.
+type Autoinc struct {
+	Col      string
+	Sequence *Sequence
+}
+
+// Sequence represents a sequence table in an unsharded keyspace.
+// Cache is the number of values vtgate reserves from the table
+// at a time.
+type Sequence struct {
+	Name     string
+	Keyspace *Keyspace
+	Cache    int64
+}
+
+// DefaultSequenceCache is the number of values reserved from
+// a sequence if the schema does not specify one.
+const DefaultSequenceCache = 1000
+
 // ColVindex contains the index info for each index of a table.
 type ColVindex struct {
 	Col    string
@@ -44,6 +65,8 @@ type ColVindex struct {
 // BuildSchema builds a Schema from a SchemaFormal.
 func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 	schema = &Schema{Tables: make(map[string]*Table)}
+	sequences := make(map[string]*Sequence)
+	autoincs := make(map[*Table]*AutoincFormal)
 	for ksname, ks := range source.Keyspaces {
 		keyspace := &Keyspace{
 			Name:    ksname,
@@ -63,6 +86,27 @@ func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 			}
 			vindexes[vname] = vindex
 		}
+		for sname, seqInfo := range ks.Sequences {
+			if keyspace.Sharded {
+				return nil, fmt.Errorf("sequence %s cannot be in sharded keyspace %s", sname, ksname)
+			}
+			if _, ok := schema.Tables[sname]; ok {
+				return nil, fmt.Errorf("table %s has multiple definitions", sname)
+			}
+			cache := seqInfo.Cache
+			if cache <= 0 {
+				cache = DefaultSequenceCache
+			}
+			sequences[sname] = &Sequence{
+				Name:     sname,
+				Keyspace: keyspace,
+				Cache:    cache,
+			}
+			schema.Tables[sname] = &Table{
+				Name:     sname,
+				Keyspace: keyspace,
+			}
+		}
 		for tname, cname := range ks.Tables {
 			if _, ok := schema.Tables[tname]; ok {
 				return nil, fmt.Errorf("table %s has multiple definitions", tname)
@@ -115,9 +159,24 @@ func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 				}
 			}
 			t.Ordered = colVindexSorted(t.ColVindexes)
+			if class.Autoinc != nil {
+				autoincs[t] = class.Autoinc
+			}
 			schema.Tables[tname] = t
 		}
 	}
+	// Sequences can be in any keyspace. So, they're resolved
+	// after all keyspaces are loaded.
+	for t, autoinc := range autoincs {
+		seq, ok := sequences[autoinc.Sequence]
+		if !ok {
+			return nil, fmt.Errorf("sequence %s not found for table %s", autoinc.Sequence, t.Name)
+		}
+		t.Autoinc = &Autoinc{
+			Col:      autoinc.Col,
+			Sequence: seq,
+		}
+	}
 	return schema, nil
 }
 
@@ -159,10 +218,17 @@ type SchemaFormal struct {
 // KeyspaceFormal is the keyspace info for each keyspace
 // as loaded from the source.
 type KeyspaceFormal struct {
-	Sharded  bool
-	Vindexes map[string]VindexFormal
-	Classes  map[string]ClassFormal
-	Tables   map[string]string
+	Sharded   bool
+	Vindexes  map[string]VindexFormal
+	Classes   map[string]ClassFormal
+	Tables    map[string]string
+	Sequences map[string]SequenceFormal
+}
+
+// SequenceFormal is the info for each sequence as loaded from
+// the source.
+type SequenceFormal struct {
+	Cache int64
 }
 
 // VindexFormal is the info for each index as loaded from
@@ -177,6 +243,14 @@ type VindexFormal struct {
 // the source.
 type ClassFormal struct {
 	ColVindexes []ColVindexFormal
+	Autoinc     *AutoincFormal
+}
+
+// AutoincFormal is the auto-inc info for a table class
+// as loaded from the source.
+type AutoincFormal struct {
+	Col      string
+	Sequence string
 }
 
 // ColVindexFormal is the info for each indexed column
@@ -568,3 +568,119 @@ func TestBuildSchemaNonPrimaryLookupFail(t *testing.T) {
 		t.Errorf("BuildSchema: %v, want %v", err, want)
 	}
 }
+
+func TestSequenceSchema(t *testing.T) {
+	good := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"unsharded": {
+				Sequences: map[string]SequenceFormal{
+					"seq1": {},
+					"seq2": {
+						Cache: 10,
+					},
+				},
+			},
+			"sharded": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"stfu": {
+						Type: "stfu",
+					},
+				},
+				Classes: map[string]ClassFormal{
+					"t1": {
+						ColVindexes: []ColVindexFormal{
+							{
+								Col:  "c1",
+								Name: "stfu",
+							},
+						},
+						Autoinc: &AutoincFormal{
+							Col:      "c2",
+							Sequence: "seq2",
+						},
+					},
+				},
+				Tables: map[string]string{
+					"t1": "t1",
+				},
+			},
+		},
+	}
+	got, err := BuildSchema(&good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsharded := &Keyspace{
+		Name: "unsharded",
+	}
+	if want := (&Table{Name: "seq1", Keyspace: unsharded}); !reflect.DeepEqual(got.Tables["seq1"], want) {
+		t.Errorf("BuildSchema: seq1: %+v, want %+v", got.Tables["seq1"], want)
+	}
+	want := &Autoinc{
+		Col: "c2",
+		Sequence: &Sequence{
+			Name:     "seq2",
+			Keyspace: unsharded,
+			Cache:    10,
+		},
+	}
+	if !reflect.DeepEqual(got.Tables["t1"].Autoinc, want) {
+		t.Errorf("BuildSchema: t1.Autoinc: %+v, want %+v", got.Tables["t1"].Autoinc, want)
+	}
+}
+
+func TestBuildSchemaSequenceShardedFail(t *testing.T) {
+	bad := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"sharded": {
+				Sharded: true,
+				Sequences: map[string]SequenceFormal{
+					"seq1": {},
+				},
+			},
+		},
+	}
+	_, err := BuildSchema(&bad)
+	want := "sequence seq1 cannot be in sharded keyspace sharded"
+	if err == nil || err.Error() != want {
+		t.Errorf("BuildSchema: %v, want %v", err, want)
+	}
+}
+
+func TestBuildSchemaSequenceNotFoundFail(t *testing.T) {
+	bad := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"sharded": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"stfu": {
+						Type: "stfu",
+					},
+				},
+				Classes: map[string]ClassFormal{
+					"t1": {
+						ColVindexes: []ColVindexFormal{
+							{
+								Col:  "c1",
+								Name: "stfu",
+							},
+						},
+						Autoinc: &AutoincFormal{
+							Col:      "c2",
+							Sequence: "noexist",
+						},
+					},
+				},
+				Tables: map[string]string{
+					"t1": "t1",
+				},
+			},
+		},
+	}
+	_, err := BuildSchema(&bad)
+	want := "sequence noexist not found for table t1"
+	if err == nil || err.Error() != want {
+		t.Errorf("BuildSchema: %v, want %v", err, want)
+	}
+}
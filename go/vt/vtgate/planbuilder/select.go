@@ -4,26 +4,53 @@
 
 package planbuilder
 
-import "github.com/youtube/vitess/go/vt/sqlparser"
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// LimitVarName is the bind var name used for the LIMIT
+// of multi-shard queries whose row count must be computed
+// by VTGate at execution time.
+const LimitVarName = "_limit"
 
 func buildSelectPlan(sel *sqlparser.Select, schema *Schema) *Plan {
 	plan := &Plan{ID: NoPlan}
-	tablename, _ := analyzeFrom(sel.From)
-	plan.Table, plan.Reason = schema.FindTable(tablename)
-	if plan.Reason != "" {
-		return plan
-	}
-	if !plan.Table.Keyspace.Sharded {
-		plan.ID = SelectUnsharded
-		return plan
+	if isJoin(sel.From) {
+		getJoinRouting(sel, plan, schema)
+		if plan.ID == NoPlan || plan.ID == SelectUnsharded || plan.ID == SelectJoin {
+			return plan
+		}
+	} else {
+		tablename, _ := analyzeFrom(sel.From)
+		plan.Table, plan.Reason = schema.FindTable(tablename)
+		if plan.Reason != "" {
+			return plan
+		}
+		if !plan.Table.Keyspace.Sharded {
+			plan.ID = SelectUnsharded
+			return plan
+		}
+		getWhereRouting(sel.Where, plan, false)
 	}
-
-	getWhereRouting(sel.Where, plan, false)
 	if plan.IsMulti() {
-		if hasPostProcessing(sel) {
+		var err error
+		switch {
+		case hasAggregates(sel.SelectExprs) || sel.GroupBy != nil:
+			err = buildAggregatePlan(sel, plan)
+		case hasPostProcessing(sel):
 			plan.ID = NoPlan
 			plan.Reason = "multi-shard query has post-processing constructs"
 			return plan
+		case sel.OrderBy != nil || sel.Limit != nil:
+			err = buildMergePlan(sel, plan)
+		}
+		if err != nil {
+			plan.ID = NoPlan
+			plan.Reason = err.Error()
+			return plan
 		}
 	}
 	// The where clause might have changed.
@@ -111,6 +138,86 @@ func exprHasAggregates(node sqlparser.Expr) bool {
 	}
 }
 
+// hasPostProcessing returns true if the query has constructs,
+// other than aggregates, that cannot be resolved by merging the
+// results of the shards.
 func hasPostProcessing(sel *sqlparser.Select) bool {
-	return hasAggregates(sel.SelectExprs) || sel.Distinct != "" || sel.GroupBy != nil || sel.Having != nil || sel.OrderBy != nil || sel.Limit != nil
+	return sel.Distinct != "" || sel.Having != nil
+}
+
+// buildMergePlan converts a multi-shard plan into a SelectMerge plan.
+// The ORDER BY clause is sent as is to the shards. If there is an
+// offset, it's folded into the row count, because the offset can only
+// be applied after the results are merged.
+func buildMergePlan(sel *sqlparser.Select, plan *Plan) error {
+	orderBy, err := getOrderBy(sel)
+	if err != nil {
+		return err
+	}
+	offset, rowcount, err := sel.Limit.Limits()
+	if err != nil {
+		return fmt.Errorf("invalid limit: %v", err)
+	}
+	if offset != nil {
+		o, ok1 := offset.(int64)
+		rc, ok2 := rowcount.(int64)
+		if ok1 && ok2 {
+			sel.Limit = &sqlparser.Limit{Rowcount: sqlparser.NumVal(strconv.FormatInt(o+rc, 10))}
+		} else {
+			sel.Limit = &sqlparser.Limit{Rowcount: sqlparser.ValArg(":" + LimitVarName)}
+		}
+	}
+	plan.Route = plan.ID
+	plan.ID = SelectMerge
+	plan.OrderBy = orderBy
+	plan.Limit = rowcount
+	plan.Offset = offset
+	return nil
+}
+
+func getOrderBy(sel *sqlparser.Select) ([]OrderByParams, error) {
+	var orderBy []OrderByParams
+	for _, order := range sel.OrderBy {
+		colname, ok := order.Expr.(*sqlparser.ColName)
+		if !ok {
+			return nil, fmt.Errorf("complex order by expression: %s", sqlparser.String(order.Expr))
+		}
+		col, err := findResultColumn(sel.SelectExprs, string(colname.Name))
+		if err != nil {
+			return nil, err
+		}
+		orderBy = append(orderBy, OrderByParams{
+			Col:  col,
+			Desc: order.Direction == sqlparser.AST_DESC,
+		})
+	}
+	return orderBy, nil
+}
+
+// findResultColumn returns the name under which the column
+// will be returned in the result of the query.
+func findResultColumn(selectExprs sqlparser.SelectExprs, name string) (string, error) {
+	hasStar := false
+	for _, node := range selectExprs {
+		switch node := node.(type) {
+		case *sqlparser.StarExpr:
+			hasStar = true
+		case *sqlparser.NonStarExpr:
+			if string(node.As) == name {
+				return name, nil
+			}
+			colname, ok := node.Expr.(*sqlparser.ColName)
+			if !ok || string(colname.Name) != name {
+				continue
+			}
+			if node.As != "" {
+				return string(node.As), nil
+			}
+			return name, nil
+		}
+	}
+	if hasStar {
+		return name, nil
+	}
+	return "", fmt.Errorf("order by column %s must be in the select list", name)
 }
@@ -0,0 +1,111 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// buildUnionPlan builds the plan for a UNION or UNION ALL. If both
+// sides are guaranteed to go to the same shard, the union is sent as
+// is to that shard. Otherwise, each side is routed independently by
+// its own plan, and VTGate concatenates the results in a SelectUnion
+// plan, removing the duplicate rows for a UNION.
+func buildUnionPlan(union *sqlparser.Union, schema *Schema) *Plan {
+	plan := &Plan{ID: NoPlan}
+	switch union.Type {
+	case sqlparser.AST_UNION, sqlparser.AST_UNION_ALL:
+	default:
+		plan.Reason = fmt.Sprintf("%s is not supported", union.Type)
+		return plan
+	}
+	left := buildSelectStatementPlan(union.Left, schema)
+	if left.ID == NoPlan {
+		plan.Reason = left.Reason
+		return plan
+	}
+	right := buildSelectStatementPlan(union.Right, schema)
+	if right.ID == NoPlan {
+		plan.Reason = right.Reason
+		return plan
+	}
+	if isSameRoute(left, right) {
+		plan.ID = left.ID
+		plan.Table = left.Table
+		plan.ColVindex = left.ColVindex
+		plan.Values = left.Values
+		if plan.ID != SelectUnsharded {
+			plan.Rewritten = generateQuery(union)
+		}
+		return plan
+	}
+	if hasUnionLimits(union) {
+		plan.Reason = "order by and limit are not supported for cross-shard unions"
+		return plan
+	}
+	plan.ID = SelectUnion
+	plan.Left = left
+	plan.Right = right
+	plan.IsDistinct = union.Type == sqlparser.AST_UNION
+	return plan
+}
+
+// buildSelectStatementPlan builds the plan for one side of a union.
+// The Original query of the plan is the one of that side.
+func buildSelectStatementPlan(stmt sqlparser.SelectStatement, schema *Schema) *Plan {
+	// Planning can rewrite the statement.
+	original := generateQuery(stmt)
+	var plan *Plan
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		plan = buildSelectPlan(stmt, schema)
+	case *sqlparser.Union:
+		plan = buildUnionPlan(stmt, schema)
+	default:
+		panic("unexpected")
+	}
+	plan.Original = original
+	return plan
+}
+
+// isSameRoute returns true if the two plans are guaranteed to send
+// their query to the same single shard. This is the case if they
+// are both unsharded and in the same keyspace, or if they use the
+// same unique vindex with the same value.
+func isSameRoute(left, right *Plan) bool {
+	if left.ID != right.ID || left.Table == nil || right.Table == nil {
+		return false
+	}
+	if left.Table.Keyspace != right.Table.Keyspace {
+		return false
+	}
+	switch left.ID {
+	case SelectUnsharded:
+		return true
+	case SelectEqual:
+		if !IsUnique(left.ColVindex.Vindex) || !isSameVindex(left.ColVindex, right.ColVindex) {
+			return false
+		}
+		return reflect.DeepEqual(left.Values, right.Values)
+	}
+	return false
+}
+
+// hasUnionLimits returns true if any of the selects of the union has
+// an ORDER BY or LIMIT clause. The parser attaches the clauses that
+// follow the last select to that select, while MySQL applies them to
+// the whole union. So, they can't be sent as is to the shards.
+func hasUnionLimits(stmt sqlparser.SelectStatement) bool {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return stmt.OrderBy != nil || stmt.Limit != nil
+	case *sqlparser.Union:
+		return hasUnionLimits(stmt.Left) || hasUnionLimits(stmt.Right)
+	}
+	return false
+}
@@ -11,6 +11,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/youtube/vitess/go/acl"
 	"github.com/youtube/vitess/go/cache"
@@ -22,7 +24,53 @@ var noPlan = &planbuilder.Plan{
 	Reason: "planbuiler not initialized",
 }
 
+// ExecPlan wraps a planbuilder.Plan with the execution
+// stats of the plan.
+type ExecPlan struct {
+	*planbuilder.Plan
+
+	mu         sync.Mutex
+	ExecCount  int64
+	Time       time.Duration
+	RowCount   int64
+	ErrorCount int64
+	ShardCount int64
+}
+
+// Size allows ExecPlan to be in cache.LRUCache.
+func (*ExecPlan) Size() int {
+	return 1
+}
+
+// AddStats updates the stats for the current ExecPlan. shardCount
+// is the number of shards the executions were sent to.
+func (ep *ExecPlan) AddStats(execCount int64, duration time.Duration, rowCount, errorCount, shardCount int64) {
+	ep.mu.Lock()
+	ep.ExecCount += execCount
+	ep.Time += duration
+	ep.RowCount += rowCount
+	ep.ErrorCount += errorCount
+	ep.ShardCount += shardCount
+	ep.mu.Unlock()
+}
+
+// Stats returns the current stats of ExecPlan.
+func (ep *ExecPlan) Stats() (execCount int64, duration time.Duration, rowCount, errorCount, shardCount int64) {
+	ep.mu.Lock()
+	execCount = ep.ExecCount
+	duration = ep.Time
+	rowCount = ep.RowCount
+	errorCount = ep.ErrorCount
+	shardCount = ep.ShardCount
+	ep.mu.Unlock()
+	return
+}
+
 type Planner struct {
+	// mu protects schema. It's held for reading while a plan
+	// is built, so that SetSchema can't leave behind plans built
+	// from the previous schema.
+	mu     sync.RWMutex
 	schema *planbuilder.Schema
 	plans  *cache.LRUCache
 }
@@ -38,14 +86,28 @@ func NewPlanner(schema *planbuilder.Schema, cacheSize int) *Planner {
 	return plr
 }
 
-func (plr *Planner) GetPlan(sql string) *planbuilder.Plan {
+// SetSchema replaces the schema of the planner, and
+// discards the plans built from the previous one.
+func (plr *Planner) SetSchema(schema *planbuilder.Schema) {
+	plr.mu.Lock()
+	defer plr.mu.Unlock()
+	plr.schema = schema
+	plr.plans.Clear()
+}
+
+// GetPlan returns the cached plan of sql, or builds it. Without
+// a schema, it returns a NoPlan that is not cached, so its stats
+// are not shared with other queries.
+func (plr *Planner) GetPlan(sql string) *ExecPlan {
+	plr.mu.RLock()
+	defer plr.mu.RUnlock()
 	if plr.schema == nil {
-		return noPlan
+		return &ExecPlan{Plan: noPlan}
 	}
 	if result, ok := plr.plans.Get(sql); ok {
-		return result.(*planbuilder.Plan)
+		return result.(*ExecPlan)
 	}
-	plan := planbuilder.BuildPlan(sql, plr.schema)
+	plan := &ExecPlan{Plan: planbuilder.BuildPlan(sql, plr.schema)}
 	plr.plans.Set(sql, plan)
 	return plan
 }
@@ -72,7 +134,9 @@ func (plr *Planner) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		}
 	} else if request.URL.Path == "/debug/schema" {
 		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		plr.mu.RLock()
 		b, err := json.MarshalIndent(plr.schema, "", " ")
+		plr.mu.RUnlock()
 		if err != nil {
 			response.Write([]byte(err.Error()))
 			return
@@ -21,7 +21,9 @@ func SessionToProto(s *Session) *pb.Session {
 		return nil
 	}
 	result := &pb.Session{
-		InTransaction: s.InTransaction,
+		InTransaction:  s.InTransaction,
+		SingleShard:    s.SingleShard,
+		ReadYourWrites: s.ReadYourWrites,
 	}
 	result.ShardSessions = make([]*pb.Session_ShardSession, len(s.ShardSessions))
 	for i, ss := range s.ShardSessions {
@@ -34,6 +36,14 @@ func SessionToProto(s *Session) *pb.Session {
 			TransactionId: ss.TransactionId,
 		}
 	}
+	result.ShardPositions = make([]*pb.Session_ShardPosition, len(s.ShardPositions))
+	for i, sp := range s.ShardPositions {
+		result.ShardPositions[i] = &pb.Session_ShardPosition{
+			Keyspace: sp.Keyspace,
+			Shard:    sp.Shard,
+			Position: sp.Position,
+		}
+	}
 	return result
 }
 
@@ -43,7 +53,9 @@ func ProtoToSession(s *pb.Session) *Session {
 		return nil
 	}
 	result := &Session{
-		InTransaction: s.InTransaction,
+		InTransaction:  s.InTransaction,
+		SingleShard:    s.SingleShard,
+		ReadYourWrites: s.ReadYourWrites,
 	}
 	result.ShardSessions = make([]*ShardSession, len(s.ShardSessions))
 	for i, ss := range s.ShardSessions {
@@ -54,6 +66,14 @@ func ProtoToSession(s *pb.Session) *Session {
 			TransactionId: ss.TransactionId,
 		}
 	}
+	result.ShardPositions = make([]*ShardPosition, len(s.ShardPositions))
+	for i, sp := range s.ShardPositions {
+		result.ShardPositions[i] = &ShardPosition{
+			Keyspace: sp.Keyspace,
+			Shard:    sp.Shard,
+			Position: sp.Position,
+		}
+	}
 	return result
 }
 
@@ -254,3 +274,47 @@ func ProtoToSplitQueryParts(sqr *pb.SplitQueryResponse) []SplitQueryPart {
 	}
 	return result
 }
+
+// PlanDescriptionToProto transforms a PlanDescription into its proto3 representation.
+func PlanDescriptionToProto(pd *PlanDescription) *pb.PlanDescription {
+	if pd == nil {
+		return nil
+	}
+	return &pb.PlanDescription{
+		PlanId:      pd.PlanID,
+		Reason:      pd.Reason,
+		Table:       pd.Table,
+		Keyspace:    pd.Keyspace,
+		Vindex:      pd.Vindex,
+		Original:    pd.Original,
+		Rewritten:   pd.Rewritten,
+		Subquery:    pd.Subquery,
+		Route:       pd.Route,
+		Shards:      pd.Shards,
+		ShardsError: pd.ShardsError,
+		Left:        PlanDescriptionToProto(pd.Left),
+		Right:       PlanDescriptionToProto(pd.Right),
+	}
+}
+
+// ProtoToPlanDescription transforms a proto3 PlanDescription into its native representation.
+func ProtoToPlanDescription(pd *pb.PlanDescription) *PlanDescription {
+	if pd == nil {
+		return nil
+	}
+	return &PlanDescription{
+		PlanID:      pd.PlanId,
+		Reason:      pd.Reason,
+		Table:       pd.Table,
+		Keyspace:    pd.Keyspace,
+		Vindex:      pd.Vindex,
+		Original:    pd.Original,
+		Rewritten:   pd.Rewritten,
+		Subquery:    pd.Subquery,
+		Route:       pd.Route,
+		Shards:      pd.Shards,
+		ShardsError: pd.ShardsError,
+		Left:        ProtoToPlanDescription(pd.Left),
+		Right:       ProtoToPlanDescription(pd.Right),
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proto
+
+// DO NOT EDIT.
+// FILE GENERATED BY BSONGEN.
+
+import (
+	"bytes"
+
+	"github.com/youtube/vitess/go/bson"
+	"github.com/youtube/vitess/go/bytes2"
+)
+
+// MarshalBson bson-encodes ShardPosition.
+func (shardPosition *ShardPosition) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
+	bson.EncodeOptionalPrefix(buf, bson.Object, key)
+	lenWriter := bson.NewLenWriter(buf)
+
+	bson.EncodeString(buf, "Keyspace", shardPosition.Keyspace)
+	bson.EncodeString(buf, "Shard", shardPosition.Shard)
+	bson.EncodeString(buf, "Position", shardPosition.Position)
+
+	lenWriter.Close()
+}
+
+// UnmarshalBson bson-decodes into ShardPosition.
+func (shardPosition *ShardPosition) UnmarshalBson(buf *bytes.Buffer, kind byte) {
+	switch kind {
+	case bson.EOO, bson.Object:
+		// valid
+	case bson.Null:
+		return
+	default:
+		panic(bson.NewBsonError("unexpected kind %v for ShardPosition", kind))
+	}
+	bson.Next(buf, 4)
+
+	for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+		switch bson.ReadCString(buf) {
+		case "Keyspace":
+			shardPosition.Keyspace = bson.DecodeString(buf, kind)
+		case "Shard":
+			shardPosition.Shard = bson.DecodeString(buf, kind)
+		case "Position":
+			shardPosition.Position = bson.DecodeString(buf, kind)
+		default:
+			bson.Skip(buf, kind)
+		}
+	}
+}
@@ -19,12 +19,21 @@ import (
 type Session struct {
 	InTransaction bool
 	ShardSessions []*ShardSession
+	// SingleShard rejects any statement that would add
+	// a second shard to the transaction.
+	SingleShard bool
+	// ReadYourWrites makes the replica reads of the session
+	// wait until the replicas have applied ShardPositions.
+	ReadYourWrites bool
+	// ShardPositions are the replication positions of the
+	// last commits of the session, by shard.
+	ShardPositions []*ShardPosition
 }
 
 //go:generate bsongen -file $GOFILE -type Session -o session_bson.go
 
 func (session *Session) String() string {
-	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v", session.InTransaction, session.ShardSessions)
+	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v, SingleShard: %v, ReadYourWrites: %v, ShardPositions: %+v", session.InTransaction, session.ShardSessions, session.SingleShard, session.ReadYourWrites, session.ShardPositions)
 }
 
 // ShardSession represents the session state for a shard.
@@ -41,6 +50,20 @@ func (shardSession *ShardSession) String() string {
 	return fmt.Sprintf("Keyspace: %v, Shard: %v, TabletType: %v, TransactionId: %v", shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId)
 }
 
+// ShardPosition is the replication position of the last
+// commit of a session on a shard.
+type ShardPosition struct {
+	Keyspace string
+	Shard    string
+	Position string
+}
+
+//go:generate bsongen -file $GOFILE -type ShardPosition -o shard_position_bson.go
+
+func (shardPosition *ShardPosition) String() string {
+	return fmt.Sprintf("Keyspace: %v, Shard: %v, Position: %v", shardPosition.Keyspace, shardPosition.Shard, shardPosition.Position)
+}
+
 // Query represents a keyspace agnostic query request.
 type Query struct {
 	CallerID         *tproto.CallerID // only used by BSON
@@ -210,7 +233,8 @@ type SplitQueryResult struct {
 
 // BeginRequest is the BSON implementation of the proto3 query.BeginRequest
 type BeginRequest struct {
-	CallerID *tproto.CallerID // only used by BSON
+	CallerID    *tproto.CallerID // only used by BSON
+	SingleShard bool
 }
 
 // BeginResponse is the BSON implementation of the proto3 vtgate.BeginResponse
@@ -232,6 +256,9 @@ type CommitResponse struct {
 	// Err is named 'Err' instead of 'Error' (as the proto3 version is) to remain
 	// consistent with other BSON structs.
 	Err *mproto.RPCError
+	// Session carries the replication positions of the
+	// commit, if the session asked for read-your-writes.
+	Session *Session
 }
 
 // RollbackRequest is the BSON implementation of the proto3 vtgate.RollbackRequest
@@ -246,3 +273,46 @@ type RollbackResponse struct {
 	// consistent with other BSON structs.
 	Err *mproto.RPCError
 }
+
+// ExplainRequest is the BSON implementation of the proto3 vtgate.ExplainRequest
+type ExplainRequest struct {
+	CallerID      *tproto.CallerID // only used by BSON
+	Sql           string
+	BindVariables map[string]interface{}
+	TabletType    topo.TabletType
+}
+
+// PlanDescription describes how vtgate routes a V3 query.
+type PlanDescription struct {
+	// PlanID is the name of the plan, like SelectEqual.
+	PlanID string
+	// Reason explains why the plan was chosen, or why
+	// the query can't be routed.
+	Reason string
+	// Table and Keyspace are the table of the plan, and
+	// its keyspace.
+	Table    string
+	Keyspace string
+	// Vindex is the name of the vindex used for routing.
+	Vindex    string
+	Original  string
+	Rewritten string
+	Subquery  string
+	// Route is the plan used to send the query to the
+	// shards, for SelectMerge and SelectAggregate.
+	Route string
+	// Shards are the shards the query would be sent to.
+	// If they can't be resolved before executing the
+	// query, ShardsError says why.
+	Shards      []string
+	ShardsError string
+	// Left and Right describe the two sides of a
+	// SelectJoin or SelectUnion.
+	Left, Right *PlanDescription
+}
+
+// ExplainResult is the BSON implementation of the proto3 vtgate.ExplainResponse
+type ExplainResult struct {
+	Plan *PlanDescription
+	Err  *mproto.RPCError
+}
@@ -29,11 +29,20 @@ var commonSession = Session{
 		TabletType:    topo.TabletType("master"),
 		TransactionId: 2,
 	}},
+	ReadYourWrites: true,
+	ShardPositions: []*ShardPosition{{
+		Keyspace: "a",
+		Shard:    "0",
+		Position: "MariaDB/0-1-42",
+	}},
 }
 
 type reflectSession struct {
-	InTransaction bool
-	ShardSessions []*ShardSession
+	InTransaction  bool
+	ShardSessions  []*ShardSession
+	SingleShard    bool
+	ReadYourWrites bool
+	ShardPositions []*ShardPosition
 }
 
 type extraSession struct {
@@ -56,6 +65,12 @@ func TestSession(t *testing.T) {
 			TabletType:    topo.TabletType("master"),
 			TransactionId: 2,
 		}},
+		ReadYourWrites: true,
+		ShardPositions: []*ShardPosition{{
+			Keyspace: "a",
+			Shard:    "0",
+			Position: "MariaDB/0-1-42",
+		}},
 	})
 	if err != nil {
 		t.Error(err)
@@ -167,7 +182,7 @@ func TestQueryShard(t *testing.T) {
 func TestQueryResult(t *testing.T) {
 	// We can't do the reflection test because bson
 	// doesn't do it correctly for embedded fields.
-	want := "\xc7\x01\x00\x00\x03Result\x00\x99\x00\x00\x00\x04Fields\x009\x00\x00\x00\x030\x001\x00\x00\x00\x05Name\x00\x04\x00\x00\x00\x00name\x12Type\x00\x01\x00\x00\x00\x00\x00\x00\x00\x12Flags\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00?RowsAffected\x00\x02\x00\x00\x00\x00\x00\x00\x00?InsertId\x00\x03\x00\x00\x00\x00\x00\x00\x00\x04Rows\x00 \x00\x00\x00\x040\x00\x18\x00\x00\x00\x050\x00\x01\x00\x00\x00\x001\x051\x00\x02\x00\x00\x00\x00aa\x00\x00\nErr\x00\x00\x03Session\x00\xd0\x00\x00\x00\bInTransaction\x00\x01\x04ShardSessions\x00\xac\x00\x00\x00\x030\x00Q\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00a\x05Shard\x00\x01\x00\x00\x00\x000\x05TabletType\x00\a\x00\x00\x00\x00replica\x12TransactionId\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x031\x00P\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00b\x05Shard\x00\x01\x00\x00\x00\x001\x05TabletType\x00\x06\x00\x00\x00\x00master\x12TransactionId\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05Error\x00\x05\x00\x00\x00\x00error\x03Err\x002\x00\x00\x00\x12Code\x00\xd0\a\x00\x00\x00\x00\x00\x00\x05Message\x00\x11\x00\x00\x00\x00failed due to err\x00\x00"
+	want := "=\x02\x00\x00\x03Result\x00\x99\x00\x00\x00\x04Fields\x009\x00\x00\x00\x030\x001\x00\x00\x00\x05Name\x00\x04\x00\x00\x00\x00name\x12Type\x00\x01\x00\x00\x00\x00\x00\x00\x00\x12Flags\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00?RowsAffected\x00\x02\x00\x00\x00\x00\x00\x00\x00?InsertId\x00\x03\x00\x00\x00\x00\x00\x00\x00\x04Rows\x00 \x00\x00\x00\x040\x00\x18\x00\x00\x00\x050\x00\x01\x00\x00\x00\x001\x051\x00\x02\x00\x00\x00\x00aa\x00\x00\nErr\x00\x00\x03Session\x00F\x01\x00\x00\bInTransaction\x00\x01\x04ShardSessions\x00\xac\x00\x00\x00\x030\x00Q\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00a\x05Shard\x00\x01\x00\x00\x00\x000\x05TabletType\x00\a\x00\x00\x00\x00replica\x12TransactionId\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x031\x00P\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00b\x05Shard\x00\x01\x00\x00\x00\x001\x05TabletType\x00\x06\x00\x00\x00\x00master\x12TransactionId\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\bSingleShard\x00\x00\bReadYourWrites\x00\x01\x04ShardPositions\x00G\x00\x00\x00\x030\x00?\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00a\x05Shard\x00\x01\x00\x00\x00\x000\x05Position\x00\x0e\x00\x00\x00\x00MariaDB/0-1-42\x00\x00\x00\x05Error\x00\x05\x00\x00\x00\x00error\x03Err\x002\x00\x00\x00\x12Code\x00\xd0\a\x00\x00\x00\x00\x00\x00\x05Message\x00\x11\x00\x00\x00\x00failed due to err\x00\x00"
 
 	custom := QueryResult{
 		Result: &mproto.QueryResult{
@@ -249,6 +264,12 @@ func TestBatchQueryShard(t *testing.T) {
 				TabletType:    topo.TabletType("master"),
 				TransactionId: 2,
 			}},
+			ReadYourWrites: true,
+			ShardPositions: []*ShardPosition{{
+				Keyspace: "a",
+				Shard:    "0",
+				Position: "MariaDB/0-1-42",
+			}},
 		},
 	})
 	if err != nil {
@@ -590,6 +611,12 @@ func TestKeyspaceIdBatchQuery(t *testing.T) {
 				TabletType:    topo.TabletType("master"),
 				TransactionId: 2,
 			}},
+			ReadYourWrites: true,
+			ShardPositions: []*ShardPosition{{
+				Keyspace: "a",
+				Shard:    "0",
+				Position: "MariaDB/0-1-42",
+			}},
 		},
 	})
 	if err != nil {
@@ -0,0 +1,447 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/vterrors"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+// This file implements the query rules of vtgate. They use the
+// format of the vttablet query rules, but are matched against the
+// V3 plan of the query and the immediate caller, before the query
+// is sent to any shard.
+
+const (
+	// FileQueryRuleSource is the source of the rules loaded
+	// from the query_rules_file flag.
+	FileQueryRuleSource = "FILE"
+	// VSchemaQueryRuleSource is the source of the rules stored
+	// with the vschema, under the QueryRules key.
+	VSchemaQueryRuleSource = "VSCHEMA"
+)
+
+var (
+	queryRulesFile = flag.String("query_rules_file", "", "JSON file containing the query rules of vtgate")
+
+	// queryRuleHits counts the queries matched by each rule,
+	// by whether they were rejected or let through.
+	queryRuleHits = stats.NewMultiCounters("VtgateQueryRuleHits", []string{"Rule", "Outcome"})
+)
+
+// QueryRules is an ordered list of vtgate query rules.
+type QueryRules struct {
+	rules []*QueryRule
+}
+
+// NewQueryRules creates a new QueryRules.
+func NewQueryRules() *QueryRules {
+	return &QueryRules{}
+}
+
+// Add adds a QueryRule to QueryRules. It does not check
+// for duplicates.
+func (qrs *QueryRules) Add(qr *QueryRule) {
+	qrs.rules = append(qrs.rules, qr)
+}
+
+// UnmarshalJSON builds the rules from a JSON list of rules.
+func (qrs *QueryRules) UnmarshalJSON(data []byte) error {
+	var rulesInfo []map[string]interface{}
+	if err := json.Unmarshal(data, &rulesInfo); err != nil {
+		return err
+	}
+	for _, ruleInfo := range rulesInfo {
+		qr, err := BuildQueryRule(ruleInfo)
+		if err != nil {
+			return err
+		}
+		qrs.Add(qr)
+	}
+	return nil
+}
+
+// NewVSchemaQueryRules returns the query rules stored in a vschema,
+// under the QueryRules key, or nil if there are none.
+func NewVSchemaQueryRules(vschema []byte) (*QueryRules, error) {
+	var source struct {
+		QueryRules json.RawMessage
+	}
+	if err := json.Unmarshal(vschema, &source); err != nil {
+		return nil, fmt.Errorf("NewVSchemaQueryRules: %v", err)
+	}
+	if len(source.QueryRules) == 0 {
+		return nil, nil
+	}
+	qrs := NewQueryRules()
+	if err := qrs.UnmarshalJSON(source.QueryRules); err != nil {
+		return nil, fmt.Errorf("invalid query rules: %v", err)
+	}
+	return qrs, nil
+}
+
+// LoadVSchemaQueryRulesFile reads the query rules
+// stored in a vschema JSON file.
+func LoadVSchemaQueryRulesFile(filename string) (*QueryRules, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("LoadVSchemaQueryRulesFile: %v", err)
+	}
+	return NewVSchemaQueryRules(data)
+}
+
+// LoadQueryRulesFile reads the query rules from a JSON file.
+func LoadQueryRulesFile(filename string) (*QueryRules, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("LoadQueryRulesFile: %v", err)
+	}
+	qrs := NewQueryRules()
+	if err := qrs.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("LoadQueryRulesFile: %v: %v", filename, err)
+	}
+	return qrs, nil
+}
+
+// QueryRule is a vtgate query rule. All its conditions must
+// match for its action to be applied. Conditions that are not
+// set always match, so an empty rule matches all the queries.
+type QueryRule struct {
+	Name        string
+	Description string
+
+	// Regexp conditions. nil conditions match.
+	requestIP, user, query *regexp.Regexp
+
+	// The plan, table and keyspace conditions match if any
+	// of the plans of the query, including the sub-plans of
+	// joins and unions, matches any of the listed values.
+	plans      []planbuilder.PlanID
+	tableNames []string
+	keyspaces  []string
+
+	act Action
+	// maxConcurrency is the number of matching queries that a
+	// QR_THROTTLE rule lets run at the same time.
+	maxConcurrency int64
+	inFlight       sync2.AtomicInt64
+}
+
+// NewQueryRule creates a new QueryRule.
+func NewQueryRule(description, name string, act Action) *QueryRule {
+	return &QueryRule{Description: description, Name: name, act: act}
+}
+
+// SetIPCond sets a regular expression condition for the client IP.
+// It has to be a full match.
+func (qr *QueryRule) SetIPCond(pattern string) (err error) {
+	qr.requestIP, err = regexp.Compile(makeExact(pattern))
+	return
+}
+
+// SetUserCond sets a regular expression condition for the
+// immediate caller. It has to be a full match.
+func (qr *QueryRule) SetUserCond(pattern string) (err error) {
+	qr.user, err = regexp.Compile(makeExact(pattern))
+	return
+}
+
+// SetQueryCond sets a regular expression condition for the query.
+// It has to be a full match.
+func (qr *QueryRule) SetQueryCond(pattern string) (err error) {
+	qr.query, err = regexp.Compile(makeExact(pattern))
+	return
+}
+
+// AddPlanCond adds to the list of plans that can be matched.
+func (qr *QueryRule) AddPlanCond(planID planbuilder.PlanID) {
+	qr.plans = append(qr.plans, planID)
+}
+
+// AddTableCond adds to the list of tables that can be matched.
+func (qr *QueryRule) AddTableCond(tableName string) {
+	qr.tableNames = append(qr.tableNames, tableName)
+}
+
+// AddKeyspaceCond adds to the list of keyspaces that can be matched.
+func (qr *QueryRule) AddKeyspaceCond(keyspace string) {
+	qr.keyspaces = append(qr.keyspaces, keyspace)
+}
+
+// SetThrottle makes the rule a QR_THROTTLE rule that lets at
+// most maxConcurrency matching queries run at the same time.
+func (qr *QueryRule) SetThrottle(maxConcurrency int64) {
+	qr.act = QR_THROTTLE
+	qr.maxConcurrency = maxConcurrency
+}
+
+func makeExact(pattern string) string {
+	return fmt.Sprintf("^%s$", pattern)
+}
+
+// match returns true if the rule matches the query.
+func (qr *QueryRule) match(ip, user, sql string, plan *planbuilder.Plan) bool {
+	if !reMatch(qr.requestIP, ip) || !reMatch(qr.user, user) || !reMatch(qr.query, sql) {
+		return false
+	}
+	if qr.plans != nil && !anyPlan(plan, qr.planMatch) {
+		return false
+	}
+	if qr.tableNames != nil && !anyPlan(plan, qr.tableMatch) {
+		return false
+	}
+	if qr.keyspaces != nil && !anyPlan(plan, qr.keyspaceMatch) {
+		return false
+	}
+	return true
+}
+
+func (qr *QueryRule) planMatch(plan *planbuilder.Plan) bool {
+	for _, id := range qr.plans {
+		if id == plan.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (qr *QueryRule) tableMatch(plan *planbuilder.Plan) bool {
+	if plan.Table == nil {
+		return false
+	}
+	return stringMatch(qr.tableNames, plan.Table.Name)
+}
+
+func (qr *QueryRule) keyspaceMatch(plan *planbuilder.Plan) bool {
+	if plan.Table == nil || plan.Table.Keyspace == nil {
+		return false
+	}
+	return stringMatch(qr.keyspaces, plan.Table.Keyspace.Name)
+}
+
+// anyPlan returns true if f is true for plan or one of its sub-plans.
+func anyPlan(plan *planbuilder.Plan, f func(*planbuilder.Plan) bool) bool {
+	if plan == nil {
+		return false
+	}
+	return f(plan) || anyPlan(plan.Left, f) || anyPlan(plan.Right, f)
+}
+
+func reMatch(re *regexp.Regexp, val string) bool {
+	return re == nil || re.MatchString(val)
+}
+
+func stringMatch(list []string, val string) bool {
+	for _, s := range list {
+		if s == val {
+			return true
+		}
+	}
+	return false
+}
+
+// Action is the action applied to the queries matched by a QueryRule.
+type Action int
+
+// The following constants define all the Action values.
+const (
+	// QR_FAIL rejects the query.
+	QR_FAIL = Action(iota)
+	// QR_FAIL_RETRY rejects the query with an error that
+	// tells the client it can retry it.
+	QR_FAIL_RETRY
+	// QR_THROTTLE rejects the query if too many matching
+	// queries are already running.
+	QR_THROTTLE
+)
+
+// BuildQueryRule builds a QueryRule from its JSON representation.
+// It accepts the keys of the vttablet query rules, except
+// BindVarConds, and the Keyspaces and MaxConcurrency keys.
+func BuildQueryRule(ruleInfo map[string]interface{}) (*QueryRule, error) {
+	qr := NewQueryRule("", "", QR_FAIL)
+	var maxConcurrency float64
+	for k, v := range ruleInfo {
+		var sv string
+		var lv []interface{}
+		var ok bool
+		switch k {
+		case "Name", "Description", "RequestIP", "User", "Query", "Action":
+			if sv, ok = v.(string); !ok {
+				return nil, fmt.Errorf("want string for %s", k)
+			}
+		case "Plans", "TableNames", "Keyspaces":
+			if lv, ok = v.([]interface{}); !ok {
+				return nil, fmt.Errorf("want list for %s", k)
+			}
+		case "MaxConcurrency":
+			if maxConcurrency, ok = v.(float64); !ok || maxConcurrency < 1 || maxConcurrency != float64(int64(maxConcurrency)) {
+				return nil, fmt.Errorf("want positive integer for %s", k)
+			}
+		case "BindVarConds":
+			return nil, fmt.Errorf("BindVarConds are not supported by vtgate")
+		default:
+			return nil, fmt.Errorf("unrecognized tag %s", k)
+		}
+		var err error
+		switch k {
+		case "Name":
+			qr.Name = sv
+		case "Description":
+			qr.Description = sv
+		case "RequestIP":
+			err = qr.SetIPCond(sv)
+		case "User":
+			err = qr.SetUserCond(sv)
+		case "Query":
+			err = qr.SetQueryCond(sv)
+		case "Plans":
+			for _, p := range lv {
+				pv, ok := p.(string)
+				if !ok {
+					return nil, fmt.Errorf("want string for Plans")
+				}
+				id, ok := planbuilder.PlanByName(pv)
+				if !ok {
+					return nil, fmt.Errorf("invalid plan name: %s", pv)
+				}
+				qr.AddPlanCond(id)
+			}
+		case "TableNames", "Keyspaces":
+			for _, s := range lv {
+				sv, ok := s.(string)
+				if !ok {
+					return nil, fmt.Errorf("want string for %s", k)
+				}
+				if k == "TableNames" {
+					qr.AddTableCond(sv)
+				} else {
+					qr.AddKeyspaceCond(sv)
+				}
+			}
+		case "Action":
+			switch sv {
+			case "FAIL":
+				qr.act = QR_FAIL
+			case "FAIL_RETRY":
+				qr.act = QR_FAIL_RETRY
+			case "THROTTLE":
+				qr.act = QR_THROTTLE
+			default:
+				return nil, fmt.Errorf("invalid Action %s", sv)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not set %s condition %s: %v", k, sv, err)
+		}
+	}
+	switch {
+	case qr.act == QR_THROTTLE && maxConcurrency == 0:
+		return nil, fmt.Errorf("rule %s: MaxConcurrency is required for THROTTLE", qr.Name)
+	case qr.act != QR_THROTTLE && maxConcurrency != 0:
+		return nil, fmt.Errorf("rule %s: MaxConcurrency is only valid for THROTTLE", qr.Name)
+	}
+	qr.maxConcurrency = int64(maxConcurrency)
+	return qr, nil
+}
+
+// queryRuleSources holds the rules of all the sources. The rules
+// are applied source by source, in the order of their names.
+type queryRuleSources struct {
+	mu      sync.RWMutex
+	sources map[string]*QueryRules
+	rules   []*QueryRule
+}
+
+func newQueryRuleSources() *queryRuleSources {
+	return &queryRuleSources{sources: make(map[string]*QueryRules)}
+}
+
+// set replaces the rules of source. A nil qrs removes them.
+func (qrss *queryRuleSources) set(source string, qrs *QueryRules) {
+	qrss.mu.Lock()
+	defer qrss.mu.Unlock()
+	if qrs == nil {
+		delete(qrss.sources, source)
+	} else {
+		qrss.sources[source] = qrs
+	}
+	names := make([]string, 0, len(qrss.sources))
+	for name := range qrss.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var rules []*QueryRule
+	for _, name := range names {
+		rules = append(rules, qrss.sources[name].rules...)
+	}
+	qrss.rules = rules
+}
+
+// check applies the rules to a query. If a rule rejects it, check
+// returns an error. Otherwise, it returns a function that must be
+// called when the query is done, to free the slots it holds in
+// the QR_THROTTLE rules it matched.
+func (qrss *queryRuleSources) check(ctx context.Context, sql string, plan *planbuilder.Plan) (release func(), err error) {
+	qrss.mu.RLock()
+	rules := qrss.rules
+	qrss.mu.RUnlock()
+
+	var throttled []*QueryRule
+	release = func() {
+		for _, qr := range throttled {
+			qr.inFlight.Add(-1)
+		}
+	}
+	if len(rules) == 0 {
+		return release, nil
+	}
+	var ip string
+	if ci, ok := callinfo.FromContext(ctx); ok {
+		ip = ci.RemoteAddr()
+	}
+	user := callerid.GetUsername(callerid.ImmediateCallerIDFromContext(ctx))
+	for _, qr := range rules {
+		if !qr.match(ip, user, sql, plan) {
+			continue
+		}
+		switch qr.act {
+		case QR_FAIL:
+			err = vterrors.FromError(int64(pbv.ErrorCode_BAD_INPUT), fmt.Errorf("query disallowed due to rule: %s", qr.Description))
+		case QR_FAIL_RETRY:
+			err = vterrors.FromError(int64(pbv.ErrorCode_QUERY_NOT_SERVED), fmt.Errorf("query disallowed due to rule: %s", qr.Description))
+		case QR_THROTTLE:
+			if qr.inFlight.Add(1) > qr.maxConcurrency {
+				qr.inFlight.Add(-1)
+				err = vterrors.FromError(int64(pbv.ErrorCode_THROTTLED_ERROR), fmt.Errorf("query throttled due to rule: %s", qr.Description))
+				break
+			}
+			throttled = append(throttled, qr)
+			queryRuleHits.Add([]string{qr.Name, "Admitted"}, 1)
+			continue
+		}
+		queryRuleHits.Add([]string{qr.Name, "Rejected"}, 1)
+		release()
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/callerid"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vterrors"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+func newTestQueryRules(t *testing.T, data string) *QueryRules {
+	qrs := NewQueryRules()
+	if err := qrs.UnmarshalJSON([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return qrs
+}
+
+func TestQueryRulesUnmarshal(t *testing.T) {
+	qrs := newTestQueryRules(t, `[{
+		"Name": "r1",
+		"Description": "desc",
+		"RequestIP": "123.*",
+		"User": "user",
+		"Query": "select.*",
+		"Plans": ["SelectEqual", "SelectJoin"],
+		"TableNames": ["user"],
+		"Keyspaces": ["TestRouter"],
+		"Action": "THROTTLE",
+		"MaxConcurrency": 2
+	}]`)
+	qr := qrs.rules[0]
+	if qr.Name != "r1" || qr.Description != "desc" {
+		t.Errorf("Name, Description: %s, %s", qr.Name, qr.Description)
+	}
+	if qr.act != QR_THROTTLE || qr.maxConcurrency != 2 {
+		t.Errorf("act, maxConcurrency: %v, %v", qr.act, qr.maxConcurrency)
+	}
+	if len(qr.plans) != 2 || qr.plans[0] != planbuilder.SelectEqual || qr.plans[1] != planbuilder.SelectJoin {
+		t.Errorf("plans: %v", qr.plans)
+	}
+
+	testcases := []struct {
+		in, want string
+	}{{
+		in:   `[{"Plans": ["PASS_SELECT"]}]`,
+		want: "invalid plan name: PASS_SELECT",
+	}, {
+		in:   `[{"BindVarConds": []}]`,
+		want: "BindVarConds are not supported by vtgate",
+	}, {
+		in:   `[{"Action": "BLOCK"}]`,
+		want: "invalid Action BLOCK",
+	}, {
+		in:   `[{"Query": "("}]`,
+		want: "could not set Query condition (: error parsing regexp: missing closing ): `^($`",
+	}, {
+		in:   `[{"Name": "r1", "Action": "THROTTLE"}]`,
+		want: "rule r1: MaxConcurrency is required for THROTTLE",
+	}, {
+		in:   `[{"Name": "r1", "MaxConcurrency": 2}]`,
+		want: "rule r1: MaxConcurrency is only valid for THROTTLE",
+	}, {
+		in:   `[{"Action": "THROTTLE", "MaxConcurrency": 0.5}]`,
+		want: "want positive integer for MaxConcurrency",
+	}, {
+		in:   `[{"Keyspaces": "ks"}]`,
+		want: "want list for Keyspaces",
+	}, {
+		in:   `[{"Cell": "aa"}]`,
+		want: "unrecognized tag Cell",
+	}}
+	for _, tcase := range testcases {
+		err := NewQueryRules().UnmarshalJSON([]byte(tcase.in))
+		if err == nil || err.Error() != tcase.want {
+			t.Errorf("UnmarshalJSON(%s): %v, want %s", tcase.in, err, tcase.want)
+		}
+	}
+}
+
+func TestRouterQueryRules(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+	router.SetQueryRules(FileQueryRuleSource, newTestQueryRules(t, `[{
+		"Name": "no_music",
+		"Description": "music is read-only",
+		"Plans": ["UpdateEqual", "DeleteEqual"],
+		"TableNames": ["music"],
+		"Action": "FAIL"
+	}, {
+		"Name": "no_unsharded_batch",
+		"Description": "batch can't use the unsharded keyspace",
+		"User": "batch",
+		"Keyspaces": ["TestUnsharded"],
+		"Action": "FAIL_RETRY"
+	}]`))
+
+	_, err := routerExec(router, "delete from music where id = 1", nil)
+	if want := "query disallowed due to rule: music is read-only"; err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %s", err, want)
+	}
+	if code := vtgateErrorCode(err); code != int64(pbv.ErrorCode_BAD_INPUT) {
+		t.Errorf("error code: %v, want %v", code, pbv.ErrorCode_BAD_INPUT)
+	}
+	if _, err := routerExec(router, "select * from music where id = 1", nil); err != nil {
+		t.Error(err)
+	}
+	if execCount := sbc1.ExecCount.Get(); execCount != 1 {
+		t.Errorf("sbc1 ExecCount: %v, want 1", execCount)
+	}
+
+	// The keyspace of the sub-plans of a join must match.
+	lookupCount := sbclookup.ExecCount.Get()
+	batchCtx := callerid.NewContext(context.Background(), nil, callerid.NewImmediateCallerID("batch"))
+	_, err = router.Execute(batchCtx, &proto.Query{
+		Sql:        "select u1.id from user u1 join music_user_map m on u1.id = m.music_id",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err == nil || !strings.Contains(err.Error(), "batch can't use the unsharded keyspace") {
+		t.Errorf("Execute: %v, want batch rejection", err)
+	}
+	if code := vtgateErrorCode(err); code != int64(pbv.ErrorCode_QUERY_NOT_SERVED) {
+		t.Errorf("error code: %v, want %v", code, pbv.ErrorCode_QUERY_NOT_SERVED)
+	}
+	err = router.StreamExecute(batchCtx, &proto.Query{
+		Sql:        "select * from music_user_map",
+		TabletType: topo.TYPE_MASTER,
+	}, func(*mproto.QueryResult) error { return nil })
+	if err == nil {
+		t.Errorf("StreamExecute: nil, want batch rejection")
+	}
+	if execCount := sbclookup.ExecCount.Get(); execCount != lookupCount {
+		t.Errorf("sbclookup ExecCount: %v, want %v", execCount, lookupCount)
+	}
+	if _, err := routerExec(router, "select * from music_user_map", nil); err != nil {
+		t.Error(err)
+	}
+
+	if hits := queryRuleHits.Counts()["no_music.Rejected"]; hits == 0 {
+		t.Errorf("no_music.Rejected hits: 0, want > 0")
+	}
+
+	// Removing the rules lets the queries through.
+	router.SetQueryRules(FileQueryRuleSource, nil)
+	if _, err := routerExec(router, "delete from music where id = 1", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryRulesThrottle(t *testing.T) {
+	qrss := newQueryRuleSources()
+	qrss.set(FileQueryRuleSource, newTestQueryRules(t, `[{
+		"Name": "throttle_scatter",
+		"Description": "too many scatters",
+		"Plans": ["SelectScatter"],
+		"Action": "THROTTLE",
+		"MaxConcurrency": 1
+	}]`))
+	scatter := &planbuilder.Plan{ID: planbuilder.SelectScatter}
+	equal := &planbuilder.Plan{ID: planbuilder.SelectEqual}
+
+	release, err := qrss.check(context.Background(), "select", scatter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = qrss.check(context.Background(), "select", scatter)
+	if code := vtgateErrorCode(err); code != int64(pbv.ErrorCode_THROTTLED_ERROR) {
+		t.Errorf("check: %v, want throttled error", err)
+	}
+	if _, ok := err.(*vterrors.VitessError); !ok {
+		t.Errorf("check: %T, want *vterrors.VitessError", err)
+	}
+	// Other plans are not throttled.
+	releaseEqual, err := qrss.check(context.Background(), "select", equal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseEqual()
+
+	release()
+	release, err = qrss.check(context.Background(), "select", scatter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestNewVSchemaQueryRules(t *testing.T) {
+	qrs, err := NewVSchemaQueryRules([]byte(`{"Keyspaces": {}, "QueryRules": [{"Name": "r1", "Query": "select.*"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qrs.rules) != 1 || qrs.rules[0].Name != "r1" {
+		t.Errorf("NewVSchemaQueryRules: %+v, want rule r1", qrs)
+	}
+	if qrs, err = NewVSchemaQueryRules([]byte(`{"Keyspaces": {}}`)); qrs != nil || err != nil {
+		t.Errorf("NewVSchemaQueryRules without rules: %v, %v, want nil, nil", qrs, err)
+	}
+	want := "invalid query rules: unrecognized tag Cell"
+	if _, err = NewVSchemaQueryRules([]byte(`{"QueryRules": [{"Cell": "aa"}]}`)); err == nil || err.Error() != want {
+		t.Errorf("NewVSchemaQueryRules: %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/acl"
+	"golang.org/x/net/context"
+)
+
+// shardCounter counts the shards the execution of a plan
+// is sent to. It travels with the context of the execution,
+// so that the scatter conn can increment it. The queries
+// sent to the lookup vindexes are counted by their own plan.
+type shardCounter struct {
+	count int64
+}
+
+type shardCounterKey int
+
+func newShardCounter(ctx context.Context) (*shardCounter, context.Context) {
+	counter := &shardCounter{}
+	return counter, context.WithValue(ctx, shardCounterKey(0), counter)
+}
+
+// shardCounterFromContext returns the shardCounter of the execution, or nil.
+func shardCounterFromContext(ctx context.Context) *shardCounter {
+	counter, _ := ctx.Value(shardCounterKey(0)).(*shardCounter)
+	return counter
+}
+
+func (counter *shardCounter) add(n int) {
+	if counter == nil {
+		return
+	}
+	atomic.AddInt64(&counter.count, int64(n))
+}
+
+func (counter *shardCounter) get() int64 {
+	return atomic.LoadInt64(&counter.count)
+}
+
+// addPlanStats records an execution of plan that started at startTime.
+func addPlanStats(plan *ExecPlan, startTime time.Time, rowCount int64, err error, shards *shardCounter) {
+	var errorCount int64
+	if err != nil {
+		errorCount = 1
+	}
+	plan.AddStats(1, time.Now().Sub(startTime), rowCount, errorCount, shards.get())
+}
+
+var (
+	queryzHeader = template.Must(template.New("header").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<style type="text/css">
+	table.gridtable {
+		font-family: verdana,arial,sans-serif;
+		font-size: 11px;
+		border-width: 1px;
+		border-collapse: collapse;
+	}
+	table.gridtable th {
+		border-width: 1px;
+		padding: 8px;
+		border-style: solid;
+		background-color: #dedede;
+		white-space: nowrap;
+	}
+	table.gridtable td {
+		border-width: 1px;
+		padding: 4px;
+		border-style: solid;
+	}
+	table.gridtable tr.low {
+		background-color: #f0f0f0;
+	}
+	table.gridtable tr.medium {
+		background-color: #ffcc00;
+	}
+	table.gridtable tr.high {
+		background-color: #ff3300;
+	}
+</style>
+</head>
+<body>
+<table class="gridtable">
+	<thead>
+		<tr>
+			<th>Query</th>
+			<th>Table</th>
+			<th>Plan</th>
+			<th>Reason</th>
+{{range .Columns}}			<th><a href="?sort={{.}}">{{index $.Titles .}}</a></th>
+{{end}}		</tr>
+	</thead>
+`))
+	queryzTmpl = template.Must(template.New("row").Parse(`
+		<tr class="{{.Color}}">
+			<td>{{.Query}}</td>
+			<td>{{.Table}}</td>
+			<td>{{.Plan}}</td>
+			<td>{{.Reason}}</td>
+			<td>{{.Count}}</td>
+			<td>{{.TimeStr}}</td>
+			<td>{{.Rows}}</td>
+			<td>{{.Errors}}</td>
+			<td>{{.Shards}}</td>
+			<td>{{.TimePQStr}}</td>
+			<td>{{.RowsPQStr}}</td>
+			<td>{{.ErrorsPQStr}}</td>
+			<td>{{.ShardsPQStr}}</td>
+		</tr>
+`))
+	queryzFooter = []byte(`</table>
+</body>
+</html>
+`)
+
+	// queryzColumns lists the sortable columns of /queryz,
+	// along with their titles.
+	queryzColumns = []string{"count", "time", "rows", "errors", "shards", "timepq", "rowspq", "errorspq", "shardspq"}
+	queryzTitles  = map[string]string{
+		"count":    "Count",
+		"time":     "Time",
+		"rows":     "Rows",
+		"errors":   "Errors",
+		"shards":   "Shards",
+		"timepq":   "Time per query",
+		"rowspq":   "Rows per query",
+		"errorspq": "Errors per query",
+		"shardspq": "Shards per query",
+	}
+)
+
+// queryzRow contains the stats of a plan. It's used
+// for rendering /queryz, and it's the JSON format
+// of /debug/query_stats.
+type queryzRow struct {
+	Query  string
+	Table  string
+	Plan   string
+	Reason string `json:",omitempty"`
+	Count  int64
+	Time   time.Duration
+	Rows   int64
+	Errors int64
+	Shards int64
+	Color  string `json:"-"`
+}
+
+// TimeStr returns the total time as a string.
+func (row *queryzRow) TimeStr() string {
+	return fmt.Sprintf("%.6f", row.Time.Seconds())
+}
+
+func (row *queryzRow) perQuery(val float64) float64 {
+	if row.Count == 0 {
+		return 0
+	}
+	return val / float64(row.Count)
+}
+
+// TimePQStr returns the time per query as a string.
+func (row *queryzRow) TimePQStr() string {
+	return fmt.Sprintf("%.6f", row.perQuery(row.Time.Seconds()))
+}
+
+// RowsPQStr returns the row count per query as a string.
+func (row *queryzRow) RowsPQStr() string {
+	return fmt.Sprintf("%.6f", row.perQuery(float64(row.Rows)))
+}
+
+// ErrorsPQStr returns the error count per query as a string.
+func (row *queryzRow) ErrorsPQStr() string {
+	return fmt.Sprintf("%.6f", row.perQuery(float64(row.Errors)))
+}
+
+// ShardsPQStr returns the shard count per query as a string.
+func (row *queryzRow) ShardsPQStr() string {
+	return fmt.Sprintf("%.6f", row.perQuery(float64(row.Shards)))
+}
+
+// sortValue returns the value of the column by which the rows
+// are sorted. Unknown columns sort by time per query.
+func (row *queryzRow) sortValue(column string) float64 {
+	switch column {
+	case "count":
+		return float64(row.Count)
+	case "time":
+		return row.Time.Seconds()
+	case "rows":
+		return float64(row.Rows)
+	case "errors":
+		return float64(row.Errors)
+	case "shards":
+		return float64(row.Shards)
+	case "rowspq":
+		return row.perQuery(float64(row.Rows))
+	case "errorspq":
+		return row.perQuery(float64(row.Errors))
+	case "shardspq":
+		return row.perQuery(float64(row.Shards))
+	}
+	return row.perQuery(row.Time.Seconds())
+}
+
+type queryzSorter struct {
+	rows   []*queryzRow
+	column string
+}
+
+func (sorter *queryzSorter) Len() int {
+	return len(sorter.rows)
+}
+
+func (sorter *queryzSorter) Swap(i, j int) {
+	sorter.rows[i], sorter.rows[j] = sorter.rows[j], sorter.rows[i]
+}
+
+func (sorter *queryzSorter) Less(i, j int) bool {
+	return sorter.rows[i].sortValue(sorter.column) > sorter.rows[j].sortValue(sorter.column)
+}
+
+// queryzRows returns the stats of the plans of the planner,
+// in descending order of column.
+func queryzRows(plr *Planner, column string) []*queryzRow {
+	items := plr.plans.Items()
+	sorter := queryzSorter{
+		rows:   make([]*queryzRow, 0, len(items)),
+		column: column,
+	}
+	for _, item := range items {
+		plan := item.Value.(*ExecPlan)
+		row := &queryzRow{
+			Query:  item.Key,
+			Plan:   plan.ID.String(),
+			Reason: plan.Reason,
+		}
+		if plan.Table != nil {
+			row.Table = plan.Table.Name
+		}
+		row.Count, row.Time, row.Rows, row.Errors, row.Shards = plan.Stats()
+		timepq := time.Duration(row.perQuery(float64(row.Time)))
+		if timepq < 10*time.Millisecond {
+			row.Color = "low"
+		} else if timepq < 100*time.Millisecond {
+			row.Color = "medium"
+		} else {
+			row.Color = "high"
+		}
+		sorter.rows = append(sorter.rows, row)
+	}
+	sort.Stable(&sorter)
+	return sorter.rows
+}
+
+// queryzHandler renders the stats of the plans as an HTML table.
+// The rows are sorted by the column specified by the sort
+// parameter, or by time per query.
+func queryzHandler(plr *Planner, w http.ResponseWriter, r *http.Request) {
+	if err := acl.CheckAccessHTTP(r, acl.DEBUGGING); err != nil {
+		acl.SendError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	header := struct {
+		Columns []string
+		Titles  map[string]string
+	}{queryzColumns, queryzTitles}
+	if err := queryzHeader.Execute(w, header); err != nil {
+		log.Errorf("queryz: couldn't execute template: %v", err)
+	}
+	for _, row := range queryzRows(plr, r.FormValue("sort")) {
+		if err := queryzTmpl.Execute(w, row); err != nil {
+			log.Errorf("queryz: couldn't execute template: %v", err)
+		}
+	}
+	w.Write(queryzFooter)
+}
+
+// queryStatsHandler returns the stats of the plans as JSON,
+// sorted like queryzHandler.
+func queryStatsHandler(plr *Planner, w http.ResponseWriter, r *http.Request) {
+	if err := acl.CheckAccessHTTP(r, acl.DEBUGGING); err != nil {
+		acl.SendError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	b, err := json.MarshalIndent(queryzRows(plr, r.FormValue("sort")), "", "  ")
+	if err != nil {
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write(b)
+}
@@ -0,0 +1,146 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func routerQueryz(router *Router, sql string) error {
+	_, err := router.Execute(context.Background(), &proto.Query{
+		Sql:        sql,
+		TabletType: topo.TYPE_MASTER,
+	})
+	return err
+}
+
+func findQueryzRow(rows []*queryzRow, query string) *queryzRow {
+	for _, row := range rows {
+		if row.Query == query {
+			return row
+		}
+	}
+	return nil
+}
+
+func TestQueryzStats(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+	s := getSandbox("TestRouter")
+	for _, shard := range []string{"20-40", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"} {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+
+	in := "select * from user where id in (1, 3)"
+	scatter := "select * from user"
+	for i := 0; i < 2; i++ {
+		if err := routerQueryz(router, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := routerQueryz(router, scatter); err != nil {
+		t.Fatal(err)
+	}
+	missing := "select * from user where id = :id"
+	if err := routerQueryz(router, missing); err == nil {
+		t.Errorf("%s: nil error", missing)
+	}
+	err := router.StreamExecute(context.Background(), &proto.Query{
+		Sql:        "select * from music where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}, func(*mproto.QueryResult) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := queryzRows(router.planner, "shards")
+	if rows[0].Query != scatter {
+		t.Errorf("first row sorted by shards: %s, want %s", rows[0].Query, scatter)
+	}
+	row := findQueryzRow(rows, in)
+	if row == nil || row.Plan != "SelectIN" || row.Table != "user" || row.Count != 2 || row.Shards != 4 || row.Rows != 4 || row.Errors != 0 {
+		t.Errorf("SelectIN: %+v, want 2 executions on 4 shards returning 4 rows", row)
+	}
+	row = findQueryzRow(rows, scatter)
+	if row == nil || row.Count != 1 || row.Shards != 8 {
+		t.Errorf("SelectScatter: %+v, want 1 execution on 8 shards", row)
+	}
+	row = findQueryzRow(rows, missing)
+	if row == nil || row.Count != 1 || row.Errors != 1 || row.Shards != 0 {
+		t.Errorf("SelectEqual: %+v, want 1 error and no shards", row)
+	}
+	// The lookup is counted by its own plan.
+	row = findQueryzRow(rows, "select * from music where id = 1")
+	if row == nil || row.Count != 1 || row.Shards != 1 || row.Rows != 1 {
+		t.Errorf("streaming SelectEqual: %+v, want 1 execution on 1 shard returning 1 row", row)
+	}
+	if row := findQueryzRow(rows, "select user_id from music_user_map where music_id = :music_id"); row == nil || row.Count != 1 {
+		t.Errorf("lookup: %+v, want 1 execution", row)
+	}
+
+	rows = queryzRows(router.planner, "count")
+	if rows[0].Query != in {
+		t.Errorf("first row sorted by count: %s, want %s", rows[0].Query, in)
+	}
+}
+
+func TestQueryzNoSchema(t *testing.T) {
+	plr := NewPlanner(nil, 10)
+	plan := plr.GetPlan("select * from user")
+	addPlanStats(plan, time.Now(), 1, nil, &shardCounter{})
+	if plan.Plan != noPlan {
+		t.Errorf("GetPlan without schema: %+v, want noPlan", plan.Plan)
+	}
+	if count, _, _, _, _ := plr.GetPlan("select * from music").Stats(); count != 0 {
+		t.Errorf("plan stats without schema: %d executions, want 0", count)
+	}
+	if rows := queryzRows(plr, "count"); len(rows) != 0 {
+		t.Errorf("queryzRows without schema: %+v, want none", rows)
+	}
+}
+
+func TestQueryzHandler(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+	if err := routerQueryz(router, "select * from user where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/queryz?sort=count", nil)
+	resp := httptest.NewRecorder()
+	queryzHandler(router.planner, resp, req)
+	body := resp.Body.String()
+	for _, want := range []string{
+		`<a href="?sort=shardspq">Shards per query</a>`,
+		`<tr class="low">`,
+		`<td>select * from user where id = 1</td>`,
+		`<td>user</td>`,
+		`<td>SelectEqual</td>`,
+		`<td>1.000000</td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("queryz doesn't contain %s:\n%s", want, body)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/query_stats", nil)
+	resp = httptest.NewRecorder()
+	queryStatsHandler(router.planner, resp, req)
+	var rows []queryzRow
+	if err := json.Unmarshal(resp.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Plan != "SelectEqual" || rows[0].Count != 1 || rows[0].Shards != 1 {
+		t.Errorf("query_stats: %+v, want one SelectEqual on 1 shard", rows)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// This file implements read-your-writes consistency: the commits of
+// a session that asks for it return the replication position of
+// their shards, and the later replica reads of that session wait
+// until the replica has applied that position. If it doesn't catch
+// up in time, the read is sent to the master instead.
+//
+// The tablet protocol may not return positions (gRPC doesn't yet):
+// such commits record unknownPosition, and the reads of the shard go
+// to the master until a commit returns a position.
+
+// unknownPosition is recorded by the commits that didn't
+// return the replication position of their shard.
+const unknownPosition = "unknown"
+
+var (
+	readYourWritesTimeout = flag.Duration("read_your_writes_timeout", 1*time.Second, "maximum time a replica read of a read-your-writes session waits for the replica to catch up with the last commit of the session, before it's sent to the master")
+
+	// readYourWritesReads counts the replica reads that had to
+	// wait for a position, by outcome.
+	readYourWritesReads = stats.NewMultiCounters("VtgateReadYourWritesReads", []string{"Keyspace", "ShardName", "Outcome"})
+)
+
+// readYourWritesConn returns the connection a read of session on
+// keyspace/shard should use. It's sdc, unless the session asked for
+// read-your-writes and the replica of sdc couldn't catch up with the
+// last commit of the session on that shard, in which case it's the
+// master connection of the shard. When the replica caught up, the
+// returned connection is pinned to it.
+func (stc *ScatterConn) readYourWritesConn(ctx context.Context, sdc *ShardConn, keyspace, shard string, tabletType topo.TabletType, session *SafeSession) *ShardConn {
+	if tabletType == topo.TYPE_MASTER || session.InTransaction() {
+		return sdc
+	}
+	position := session.FindPosition(keyspace, shard)
+	if position == "" {
+		return sdc
+	}
+	statsKey := []string{keyspace, shard, "CaughtUp"}
+	defer func() { readYourWritesReads.Add(statsKey, 1) }()
+	statsKey[2] = "Master"
+	if position == unknownPosition {
+		return stc.getConnection(ctx, keyspace, shard, topo.TYPE_MASTER)
+	}
+	pinned, err := sdc.WaitForPosition(ctx, position, *readYourWritesTimeout)
+	if err == nil {
+		statsKey[2] = "CaughtUp"
+		return pinned
+	}
+	log.Infof("replica of %v/%v did not catch up with %v, reading from master: %v", keyspace, shard, position, err)
+	return stc.getConnection(ctx, keyspace, shard, topo.TYPE_MASTER)
+}
@@ -0,0 +1,228 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestScatterConnReadYourWrites(t *testing.T) {
+	keyspace := "TestScatterConnReadYourWrites"
+	s := createSandbox(keyspace)
+	sbc0 := &sandboxConn{position: "pos0"}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{position: "pos1"}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	// Reads before any commit don't wait.
+	session := NewSafeSession(&proto.Session{ReadYourWrites: true})
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0", "1"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if count := sbc0.WaitForPositionCount.Get() + sbc1.WaitForPositionCount.Get(); count != 0 {
+		t.Errorf("WaitForPosition count: %v, want 0", count)
+	}
+
+	// The commit records the position of the shard it wrote to.
+	session.Session.InTransaction = true
+	if _, err := stc.Execute(context.Background(), "insert", nil, keyspace, []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	wantSession := proto.Session{
+		ReadYourWrites: true,
+		ShardPositions: []*proto.ShardPosition{{
+			Keyspace: keyspace,
+			Shard:    "0",
+			Position: "pos0",
+		}},
+	}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%+v, got\n%+v", wantSession, *session.Session)
+	}
+	if count := sbc0.CommitWithPositionCount.Get(); count != 1 {
+		t.Errorf("CommitWithPosition count: %v, want 1", count)
+	}
+
+	// Replica reads wait for the position of their shard only.
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0", "1"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if count := sbc0.WaitForPositionCount.Get(); count != 1 {
+		t.Errorf("sbc0 WaitForPosition count: %v, want 1", count)
+	}
+	if count := sbc1.WaitForPositionCount.Get(); count != 0 {
+		t.Errorf("sbc1 WaitForPosition count: %v, want 0", count)
+	}
+
+	// Master reads don't wait.
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if count := sbc0.WaitForPositionCount.Get(); count != 1 {
+		t.Errorf("sbc0 WaitForPosition count: %v, want 1", count)
+	}
+
+	// Sessions that didn't ask for it don't wait.
+	session.ReadYourWrites = false
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if count := sbc0.WaitForPositionCount.Get(); count != 1 {
+		t.Errorf("sbc0 WaitForPosition count: %v, want 1", count)
+	}
+}
+
+func TestScatterConnReadYourWritesFallback(t *testing.T) {
+	keyspace := "TestScatterConnReadYourWritesFallback"
+	s := createSandbox(keyspace)
+	sbc := &sandboxConn{mustFailWaitForPosition: true, caughtUpPosition: "pos0"}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{ReadYourWrites: true})
+	session.RecordPosition(keyspace, "0", "pos0")
+	statsKey := keyspace + ".0.Master"
+	master := readYourWritesReads.Counts()[statsKey]
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readYourWritesReads.Counts()[statsKey]; got != master {
+		t.Errorf("%v: %v, want %v", statsKey, got, master)
+	}
+
+	// The replica doesn't catch up: the read goes to the master.
+	session.RecordPosition(keyspace, "0", "pos1")
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readYourWritesReads.Counts()[statsKey]; got != master+1 {
+		t.Errorf("%v: %v, want %v", statsKey, got, master+1)
+	}
+	if _, ok := stc.shardConns[keyspace+".0.master"]; !ok {
+		t.Errorf("no master connection was used: %v", stc.shardConns)
+	}
+}
+
+func TestScatterConnReadYourWritesUnknownPosition(t *testing.T) {
+	keyspace := "TestScatterConnReadYourWritesUnknownPosition"
+	s := createSandbox(keyspace)
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	// The tablet doesn't return a position.
+	session := NewSafeSession(&proto.Session{ReadYourWrites: true, InTransaction: true})
+	if _, err := stc.Execute(context.Background(), "insert", nil, keyspace, []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.FindPosition(keyspace, "0"); got != unknownPosition {
+		t.Errorf("FindPosition: %q, want %q", got, unknownPosition)
+	}
+
+	// The replica reads go to the master without waiting.
+	statsKey := keyspace + ".0.Master"
+	master := readYourWritesReads.Counts()[statsKey]
+	if _, err := stc.Execute(context.Background(), "select 1", nil, keyspace, []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readYourWritesReads.Counts()[statsKey]; got != master+1 {
+		t.Errorf("%v: %v, want %v", statsKey, got, master+1)
+	}
+	if count := sbc.WaitForPositionCount.Get(); count != 0 {
+		t.Errorf("WaitForPosition count: %v, want 0", count)
+	}
+}
+
+func TestShardConnWaitForPositionPinned(t *testing.T) {
+	*hedgingPercentile = 50
+	defer func() { *hedgingPercentile = 0 }()
+	keyspace := "TestShardConnWaitForPositionPinned"
+	s := createSandbox(keyspace)
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("0", sbc1)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", keyspace, "0", topo.TYPE_REPLICA, 1*time.Millisecond, 3, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+
+	pinned, err := sdc.WaitForPosition(context.Background(), "pos0", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waited, other := sbc0, sbc1
+	if sbc1.WaitForPositionCount.Get() == 1 {
+		waited, other = sbc1, sbc0
+	}
+	if pinned.latencies != nil {
+		t.Errorf("pinned ShardConn hedges its reads")
+	}
+
+	// The read isn't retried on the other tablet,
+	// and the connection of sdc isn't closed.
+	waited.mustFailRetry = 1
+	if _, err := pinned.Execute(context.Background(), "select 1", nil, 0); err == nil {
+		t.Errorf("Execute: nil, want error")
+	}
+	if count := waited.ExecCount.Get(); count != 2 {
+		t.Errorf("ExecCount: %v, want 2", count)
+	}
+	if count := other.ExecCount.Get(); count != 0 {
+		t.Errorf("ExecCount of the other tablet: %v, want 0", count)
+	}
+	if count := waited.CloseCount.Get(); count != 0 {
+		t.Errorf("CloseCount: %v, want 0", count)
+	}
+	sdc.mu.Lock()
+	conn := sdc.conn
+	sdc.mu.Unlock()
+	if conn == nil {
+		t.Errorf("the connection of sdc was closed")
+	}
+
+	// The pinned ShardConn doesn't reconnect.
+	if _, err := pinned.Execute(context.Background(), "select 1", nil, 0); err == nil {
+		t.Errorf("Execute: nil, want error")
+	}
+	if count := other.ExecCount.Get(); count != 0 {
+		t.Errorf("ExecCount of the other tablet: %v, want 0", count)
+	}
+}
+
+func TestSafeSessionPositions(t *testing.T) {
+	session := NewSafeSession(&proto.Session{})
+	session.RecordPosition("ks", "0", "pos0")
+	if got := session.FindPosition("ks", "0"); got != "" {
+		t.Errorf("FindPosition without ReadYourWrites: %q, want empty", got)
+	}
+	session.ReadYourWrites = true
+	session.RecordPosition("ks", "0", "pos1")
+	session.RecordPosition("ks", "1", "pos2")
+	if got := session.FindPosition("ks", "0"); got != "pos1" {
+		t.Errorf("FindPosition: %q, want pos1", got)
+	}
+	if got := session.FindPosition("ks", "2"); got != "" {
+		t.Errorf("FindPosition: %q, want empty", got)
+	}
+	session.Reset()
+	if got := session.FindPosition("ks", "1"); got != "pos2" {
+		t.Errorf("FindPosition after Reset: %q, want pos2", got)
+	}
+	if got := NewSafeSession(nil).FindPosition("ks", "0"); got != "" {
+		t.Errorf("FindPosition on nil session: %q, want empty", got)
+	}
+}
@@ -8,6 +8,8 @@ package vtgate
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/key"
@@ -29,6 +31,8 @@ type Router struct {
 	cell        string
 	planner     *Planner
 	scatterConn *ScatterConn
+	sequences   *sequencer
+	queryRules  *queryRuleSources
 }
 
 type scatterParams struct {
@@ -50,12 +54,27 @@ func newScatterParams(query, ks string, bv map[string]interface{}, shards []stri
 
 // NewRouter creates a new Router.
 func NewRouter(serv SrvTopoServer, cell string, schema *planbuilder.Schema, statsName string, scatterConn *ScatterConn) *Router {
-	return &Router{
+	rtr := &Router{
 		serv:        serv,
 		cell:        cell,
 		planner:     NewPlanner(schema, 5000),
 		scatterConn: scatterConn,
+		sequences:   newSequencer(),
+		queryRules:  newQueryRuleSources(),
 	}
+	return rtr
+}
+
+// SetSchema replaces the schema used by the router. Plans
+// built from the previous schema are discarded.
+func (rtr *Router) SetSchema(schema *planbuilder.Schema) {
+	rtr.planner.SetSchema(schema)
+}
+
+// SetQueryRules replaces the query rules of source.
+// A nil qrs removes them.
+func (rtr *Router) SetQueryRules(source string, qrs *QueryRules) {
+	rtr.queryRules.set(source, qrs)
 }
 
 // Execute routes a non-streaming query.
@@ -63,16 +82,45 @@ func (rtr *Router) Execute(ctx context.Context, query *proto.Query) (*mproto.Que
 	if query.BindVariables == nil {
 		query.BindVariables = make(map[string]interface{})
 	}
-	vcursor := newRequestContext(ctx, query, rtr)
 	plan := rtr.planner.GetPlan(string(query.Sql))
+	logStatsFromContext(ctx).setPlan(plan.Plan)
+	release, err := rtr.queryRules.check(ctx, query.Sql, plan.Plan)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	startTime := time.Now()
+	shards, ctx := newShardCounter(ctx)
+	vcursor := newRequestContext(ctx, query, rtr)
+	qr, err := rtr.execPlan(vcursor, plan.Plan)
+	var rowCount int64
+	if qr != nil {
+		rowCount = int64(qr.RowsAffected)
+	}
+	addPlanStats(plan, startTime, rowCount, err, shards)
+	return qr, err
+}
 
+// execPlan executes a non-streaming plan.
+func (rtr *Router) execPlan(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
 	switch plan.ID {
 	case planbuilder.UpdateEqual:
 		return rtr.execUpdateEqual(vcursor, plan)
 	case planbuilder.DeleteEqual:
 		return rtr.execDeleteEqual(vcursor, plan)
+	case planbuilder.UpdateIN, planbuilder.UpdateScatter,
+		planbuilder.DeleteIN, planbuilder.DeleteScatter:
+		return rtr.execMultiShardDML(vcursor, plan)
 	case planbuilder.InsertSharded:
 		return rtr.execInsertSharded(vcursor, plan)
+	case planbuilder.SelectMerge:
+		return rtr.execSelectMerge(vcursor, plan)
+	case planbuilder.SelectAggregate:
+		return rtr.execSelectAggregate(vcursor, plan)
+	case planbuilder.SelectJoin:
+		return newJoiner(rtr, vcursor, plan).Execute()
+	case planbuilder.SelectUnion:
+		return rtr.execSelectUnion(vcursor, plan)
 	}
 
 	var err error
@@ -90,19 +138,19 @@ func (rtr *Router) Execute(ctx context.Context, query *proto.Query) (*mproto.Que
 	case planbuilder.SelectScatter:
 		params, err = rtr.paramsSelectScatter(vcursor, plan)
 	default:
-		return nil, fmt.Errorf("cannot route query: %s: %s", query.Sql, plan.Reason)
+		return nil, fmt.Errorf("cannot route query: %s: %s", vcursor.query.Sql, plan.Reason)
 	}
 	if err != nil {
 		return nil, err
 	}
 	return rtr.scatterConn.ExecuteMulti(
-		ctx,
+		vcursor.ctx,
 		params.query,
 		params.ks,
 		params.shardVars,
-		query.TabletType,
+		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session),
-		query.NotInTransaction,
+		vcursor.query.NotInTransaction,
 	)
 }
 
@@ -111,8 +159,37 @@ func (rtr *Router) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 	if query.BindVariables == nil {
 		query.BindVariables = make(map[string]interface{})
 	}
-	vcursor := newRequestContext(ctx, query, rtr)
 	plan := rtr.planner.GetPlan(string(query.Sql))
+	logStatsFromContext(ctx).setPlan(plan.Plan)
+	release, err := rtr.queryRules.check(ctx, query.Sql, plan.Plan)
+	if err != nil {
+		return err
+	}
+	defer release()
+	startTime := time.Now()
+	shards, ctx := newShardCounter(ctx)
+	vcursor := newRequestContext(ctx, query, rtr)
+	var rowCount int64
+	err = rtr.streamPlan(vcursor, plan.Plan, func(qr *mproto.QueryResult) error {
+		rowCount += int64(len(qr.Rows))
+		return sendReply(qr)
+	})
+	addPlanStats(plan, startTime, rowCount, err, shards)
+	return err
+}
+
+// streamPlan executes a streaming plan.
+func (rtr *Router) streamPlan(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	switch plan.ID {
+	case planbuilder.SelectMerge:
+		return rtr.streamSelectMerge(vcursor, plan, sendReply)
+	case planbuilder.SelectAggregate:
+		return rtr.streamSelectAggregate(vcursor, plan, sendReply)
+	case planbuilder.SelectJoin:
+		return rtr.streamSelectJoin(vcursor, plan, sendReply)
+	case planbuilder.SelectUnion:
+		return rtr.streamSelectUnion(vcursor, plan, sendReply)
+	}
 
 	var err error
 	var params *scatterParams
@@ -128,20 +205,20 @@ func (rtr *Router) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 	case planbuilder.SelectScatter:
 		params, err = rtr.paramsSelectScatter(vcursor, plan)
 	default:
-		return fmt.Errorf("query %q cannot be used for streaming", query.Sql)
+		return fmt.Errorf("query %q cannot be used for streaming", vcursor.query.Sql)
 	}
 	if err != nil {
 		return err
 	}
 	return rtr.scatterConn.StreamExecuteMulti(
-		ctx,
+		vcursor.ctx,
 		params.query,
 		params.ks,
 		params.shardVars,
-		query.TabletType,
+		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session),
 		sendReply,
-		query.NotInTransaction,
+		vcursor.query.NotInTransaction,
 	)
 }
 
@@ -169,7 +246,7 @@ func (rtr *Router) paramsSelectEqual(vcursor *requestContext, plan *planbuilder.
 }
 
 func (rtr *Router) paramsSelectIN(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
-	keys, err := rtr.resolveKeys(plan.Values.([]interface{}), vcursor.query.BindVariables)
+	keys, err := rtr.resolveList(plan.Values, vcursor.query.BindVariables)
 	if err != nil {
 		return nil, fmt.Errorf("paramsSelectIN: %v", err)
 	}
@@ -231,6 +308,156 @@ func (rtr *Router) paramsSelectScatter(vcursor *requestContext, plan *planbuilde
 	return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
 }
 
+func (rtr *Router) paramsSelectMerge(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, *mergeParams, error) {
+	merge, err := newMergeParams(plan, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, nil, fmt.Errorf("paramsSelectMerge: %v", err)
+	}
+	params, err := rtr.paramsRoute(vcursor, plan)
+	if err != nil {
+		return nil, nil, fmt.Errorf("paramsSelectMerge: %v", err)
+	}
+	merge.addShardLimit(params.shardVars)
+	return params, merge, nil
+}
+
+// paramsRoute returns the scatterParams for plans that
+// post-process the results, based on their Route.
+func (rtr *Router) paramsRoute(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
+	switch plan.Route {
+	case planbuilder.SelectEqual:
+		return rtr.paramsSelectEqual(vcursor, plan)
+	case planbuilder.SelectIN:
+		return rtr.paramsSelectIN(vcursor, plan)
+	case planbuilder.SelectScatter:
+		return rtr.paramsSelectScatter(vcursor, plan)
+	}
+	return nil, fmt.Errorf("unexpected route: %v", plan.Route)
+}
+
+func (rtr *Router) execSelectMerge(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, merge, err := rtr.paramsSelectMerge(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	return rtr.scatterConn.ExecuteMerge(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction,
+		merge,
+	)
+}
+
+func (rtr *Router) streamSelectMerge(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	params, merge, err := rtr.paramsSelectMerge(vcursor, plan)
+	if err != nil {
+		return err
+	}
+	return rtr.scatterConn.StreamExecuteMerge(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		merge,
+		sendReply,
+		vcursor.query.NotInTransaction,
+	)
+}
+
+func (rtr *Router) paramsSelectAggregate(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, *aggregator, error) {
+	ag, err := newAggregator(plan, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, nil, fmt.Errorf("paramsSelectAggregate: %v", err)
+	}
+	params, err := rtr.paramsRoute(vcursor, plan)
+	if err != nil {
+		return nil, nil, fmt.Errorf("paramsSelectAggregate: %v", err)
+	}
+	return params, ag, nil
+}
+
+func (rtr *Router) execSelectAggregate(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, ag, err := rtr.paramsSelectAggregate(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	qr, err := rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := ag.Add(qr); err != nil {
+		return nil, fmt.Errorf("execSelectAggregate: %v", err)
+	}
+	result, err := ag.Result()
+	if err != nil {
+		return nil, fmt.Errorf("execSelectAggregate: %v", err)
+	}
+	return result, nil
+}
+
+// streamSelectAggregate cannot send any row until all the shards
+// have returned their results. So, it's effectively non-streaming.
+func (rtr *Router) streamSelectAggregate(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	params, ag, err := rtr.paramsSelectAggregate(vcursor, plan)
+	if err != nil {
+		return err
+	}
+	err = rtr.scatterConn.StreamExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		ag.Add,
+		vcursor.query.NotInTransaction,
+	)
+	if err != nil {
+		return err
+	}
+	result, err := ag.Result()
+	if err != nil {
+		return fmt.Errorf("streamSelectAggregate: %v", err)
+	}
+	if err := sendReply(&mproto.QueryResult{Fields: result.Fields}); err != nil {
+		return err
+	}
+	if len(result.Rows) == 0 {
+		return nil
+	}
+	return sendReply(&mproto.QueryResult{Rows: result.Rows})
+}
+
+// streamSelectJoin cannot send any row until the join has been
+// computed. So, it's effectively non-streaming.
+func (rtr *Router) streamSelectJoin(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	result, err := newJoiner(rtr, vcursor, plan).Execute()
+	if err != nil {
+		return err
+	}
+	if err := sendReply(&mproto.QueryResult{Fields: result.Fields}); err != nil {
+		return err
+	}
+	if len(result.Rows) == 0 {
+		return nil
+	}
+	return sendReply(&mproto.QueryResult{Rows: result.Rows})
+}
+
 func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
 	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
 	if err != nil {
@@ -243,6 +470,19 @@ func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Pl
 	if ksid == key.MinKey {
 		return &mproto.QueryResult{}, nil
 	}
+	if plan.DeleteQuery != "" {
+		result, err := rtr.moveRows(vcursor, plan, ks, shard, ksid)
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateEqual: %v", err)
+		}
+		return result, nil
+	}
+	if plan.Subquery != "" {
+		err = rtr.updateVindexEntries(vcursor, plan, ks, shard, ksid)
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateEqual: %v", err)
+		}
+	}
 	vcursor.query.BindVariables[ksidName] = string(ksid)
 	rewritten := plan.Rewritten + fmt.Sprintf(dmlPostfix, ksid)
 	return rtr.scatterConn.Execute(
@@ -288,31 +528,28 @@ func (rtr *Router) execDeleteEqual(vcursor *requestContext, plan *planbuilder.Pl
 }
 
 func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
-	input := plan.Values.([]interface{})
-	keys, err := rtr.resolveKeys(input, vcursor.query.BindVariables)
+	if plan.Mid != nil {
+		return rtr.execInsertMulti(vcursor, plan)
+	}
+	seqGenerated, err := rtr.handleAutoinc(vcursor, plan, plan.Generate, 0, 1)
 	if err != nil {
-		return nil, fmt.Errorf("execInsertSharded: %v", err)
+		return nil, err
 	}
-	ksid, generated, err := rtr.handlePrimary(vcursor, keys[0], plan.Table.ColVindexes[0], vcursor.query.BindVariables)
+	input := plan.Values.([]interface{})
+	ksid, generated, err := rtr.handleInsertRow(vcursor, plan, input, 0, 1)
 	if err != nil {
-		return nil, fmt.Errorf("execInsertSharded: %v", err)
+		return nil, err
+	}
+	if seqGenerated != 0 {
+		if generated != 0 {
+			return nil, fmt.Errorf("insert generated more than one value")
+		}
+		generated = seqGenerated
 	}
 	ks, shard, err := rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, ksid)
 	if err != nil {
 		return nil, fmt.Errorf("execInsertSharded: %v", err)
 	}
-	for i := 1; i < len(keys); i++ {
-		newgen, err := rtr.handleNonPrimary(vcursor, keys[i], plan.Table.ColVindexes[i], vcursor.query.BindVariables, ksid)
-		if err != nil {
-			return nil, err
-		}
-		if newgen != 0 {
-			if generated != 0 {
-				return nil, fmt.Errorf("insert generated more than one value")
-			}
-			generated = newgen
-		}
-	}
 	vcursor.query.BindVariables[ksidName] = string(ksid)
 	rewritten := plan.Rewritten + fmt.Sprintf(dmlPostfix, ksid)
 	result, err := rtr.scatterConn.Execute(
@@ -336,6 +573,169 @@ func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.
 	return result, nil
 }
 
+// execInsertMulti executes a multi-row InsertSharded. It computes
+// the keyspace id of each row, and sends one insert per shard
+// with the rows that belong to it.
+func (rtr *Router) execInsertMulti(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	rows := plan.Values.([]interface{})
+	var ks string
+	var shards []string
+	shardRows := make(map[string][]int)
+	shardKsids := make(map[string][]string)
+	var firstGenerated int64
+	var generate []interface{}
+	if plan.Generate != nil {
+		generate = plan.Generate.([]interface{})
+	}
+	for rownum, row := range rows {
+		var rowGenerate interface{}
+		if generate != nil {
+			rowGenerate = generate[rownum]
+		}
+		seqGenerated, err := rtr.handleAutoinc(vcursor, plan, rowGenerate, rownum, len(rows))
+		if err != nil {
+			return nil, err
+		}
+		ksid, generated, err := rtr.handleInsertRow(vcursor, plan, row.([]interface{}), rownum, len(rows))
+		if err != nil {
+			return nil, err
+		}
+		if seqGenerated != 0 {
+			if generated != 0 {
+				return nil, fmt.Errorf("insert generated more than one value")
+			}
+			generated = seqGenerated
+		}
+		if generated != 0 && firstGenerated == 0 {
+			firstGenerated = generated
+		}
+		var shard string
+		ks, shard, err = rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, ksid)
+		if err != nil {
+			return nil, fmt.Errorf("execInsertMulti: %v", err)
+		}
+		if _, ok := shardRows[shard]; !ok {
+			shards = append(shards, shard)
+		}
+		shardRows[shard] = append(shardRows[shard], rownum)
+		if !containsString(shardKsids[shard], ksid.String()) {
+			shardKsids[shard] = append(shardKsids[shard], ksid.String())
+		}
+	}
+	result := &mproto.QueryResult{}
+	for _, shard := range shards {
+		mids := make([]string, 0, len(shardRows[shard]))
+		for _, rownum := range shardRows[shard] {
+			mids = append(mids, plan.Mid[rownum])
+		}
+		rewritten := plan.Prefix + strings.Join(mids, ", ") + plan.Suffix + fmt.Sprintf(dmlPostfix, strings.Join(shardKsids[shard], ","))
+		qr, err := rtr.scatterConn.Execute(
+			vcursor.ctx,
+			rewritten,
+			vcursor.query.BindVariables,
+			ks,
+			[]string{shard},
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session),
+			vcursor.query.NotInTransaction)
+		if err != nil {
+			return nil, fmt.Errorf("execInsertMulti: %v", err)
+		}
+		result.RowsAffected += qr.RowsAffected
+		if result.InsertId == 0 {
+			result.InsertId = qr.InsertId
+		}
+	}
+	if firstGenerated != 0 {
+		if result.InsertId != 0 {
+			return nil, fmt.Errorf("vindex and db generated a value each for insert")
+		}
+		result.InsertId = uint64(firstGenerated)
+	}
+	return result, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAutoinc sets the bind var for the autoinc column of row
+// rownum of an insert. If no value was supplied for the column, it
+// takes one from the sequence of the table and returns it.
+func (rtr *Router) handleAutoinc(vcursor *requestContext, plan *planbuilder.Plan, val interface{}, rownum, numrows int) (generated int64, err error) {
+	autoinc := plan.Table.Autoinc
+	if autoinc == nil {
+		return 0, nil
+	}
+	keys, err := rtr.resolveKeys([]interface{}{val}, vcursor.query.BindVariables)
+	if err != nil {
+		return 0, fmt.Errorf("handleAutoinc: %v", err)
+	}
+	val = keys[0]
+	if val == nil {
+		generated, err = rtr.sequences.Next(vcursor.ctx, rtr, autoinc.Sequence)
+		if err != nil {
+			return 0, fmt.Errorf("handleAutoinc: %v", err)
+		}
+		val = generated
+	}
+	vcursor.query.BindVariables[planbuilder.InsertVarName(autoinc.Col, rownum, numrows)] = val
+	return generated, nil
+}
+
+// handleInsertRow computes the keyspace id of row rownum of an insert,
+// creates the entries of its owned vindexes, and sets the bind vars
+// for its vindex columns. It returns the keyspace id, and the value
+// generated by a vindex, if any.
+func (rtr *Router) handleInsertRow(vcursor *requestContext, plan *planbuilder.Plan, input []interface{}, rownum, numrows int) (ksid key.KeyspaceId, generated int64, err error) {
+	keys, err := rtr.resolveKeys(input, vcursor.query.BindVariables)
+	if err != nil {
+		return "", 0, fmt.Errorf("execInsertSharded: %v", err)
+	}
+	bvName := planbuilder.InsertVarName(plan.Table.ColVindexes[0].Col, rownum, numrows)
+	ksid, generated, err = rtr.handlePrimary(vcursor, keys[0], plan.Table.ColVindexes[0], vcursor.query.BindVariables, bvName)
+	if err != nil {
+		return "", 0, fmt.Errorf("execInsertSharded: %v", err)
+	}
+	for i := 1; i < len(keys); i++ {
+		bvName = planbuilder.InsertVarName(plan.Table.ColVindexes[i].Col, rownum, numrows)
+		newgen, err := rtr.handleNonPrimary(vcursor, keys[i], plan.Table.ColVindexes[i], vcursor.query.BindVariables, ksid, bvName)
+		if err != nil {
+			return "", 0, err
+		}
+		if newgen != 0 {
+			if generated != 0 {
+				return "", 0, fmt.Errorf("insert generated more than one value")
+			}
+			generated = newgen
+		}
+	}
+	return ksid, generated, nil
+}
+
+// resolveList returns the keys of an IN clause. The values are
+// either a list, or the name of a list bind var.
+func (rtr *Router) resolveList(vals interface{}, bindVars map[string]interface{}) ([]interface{}, error) {
+	name, ok := vals.(string)
+	if !ok {
+		return rtr.resolveKeys(vals.([]interface{}), bindVars)
+	}
+	v, ok := bindVars[name[2:]]
+	if !ok {
+		return nil, fmt.Errorf("could not find bind var %s", name)
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("bind var %s is not a list: %T", name, v)
+	}
+	return list, nil
+}
+
 func (rtr *Router) resolveKeys(vals []interface{}, bindVars map[string]interface{}) (keys []interface{}, err error) {
 	keys = make([]interface{}, 0, len(vals))
 	for _, val := range vals {
@@ -468,7 +868,7 @@ func (rtr *Router) deleteVindexEntries(vcursor *requestContext, plan *planbuilde
 	return nil
 }
 
-func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}) (ksid key.KeyspaceId, generated int64, err error) {
+func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}, bvName string) (ksid key.KeyspaceId, generated int64, err error) {
 	if colVindex.Owned {
 		if vindexKey == nil {
 			generator, ok := colVindex.Vindex.(planbuilder.FunctionalGenerator)
@@ -499,11 +899,11 @@ func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{},
 	if ksid == key.MinKey {
 		return "", 0, fmt.Errorf("could not map %v to a keyspace id", vindexKey)
 	}
-	bv["_"+colVindex.Col] = vindexKey
+	bv[bvName] = vindexKey
 	return ksid, generated, nil
 }
 
-func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}, ksid key.KeyspaceId) (generated int64, err error) {
+func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}, ksid key.KeyspaceId, bvName string) (generated int64, err error) {
 	if colVindex.Owned {
 		if vindexKey == nil {
 			generator, ok := colVindex.Vindex.(planbuilder.LookupGenerator)
@@ -544,7 +944,7 @@ func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface
 			}
 		}
 	}
-	bv["_"+colVindex.Col] = vindexKey
+	bv[bvName] = vindexKey
 	return generated, nil
 }
 
@@ -8,11 +8,15 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	_ "github.com/youtube/vitess/go/vt/vtgate/vindexes"
+	"golang.org/x/net/context"
 )
 
 func TestUpdateEqual(t *testing.T) {
@@ -77,6 +81,211 @@ func TestUpdateEqual(t *testing.T) {
 	}
 }
 
+func TestUpdateEqualChangeVindex(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.String("myname")},
+		}},
+	}})
+	_, err := routerExec(router, "update user set a = 2, name = :name where id = 1", map[string]interface{}{
+		"name": "newname",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select name from user where id = 1 for update",
+		BindVariables: map[string]interface{}{
+			"name": "newname",
+		},
+	}, {
+		Sql: "update user set a = 2, name = :name where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: map[string]interface{}{
+			"name":        "newname",
+			"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"myname"},
+		},
+	}, {
+		Sql: "insert into name_user_map(name, user_id) values(:name, :user_id)",
+		BindVariables: map[string]interface{}{
+			"name":    "newname",
+			"user_id": int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+
+	// No rows match: the vindex entries are left alone.
+	sbc1.Queries = nil
+	sbclookup.Queries = nil
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	_, err = routerExec(router, "update user set name = 'newname' where id = 1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(sbc1.Queries) != 2 {
+		t.Errorf("sbc1.Queries: %+v, want 2 queries", sbc1.Queries)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %+v, want nil\n", sbclookup.Queries)
+	}
+}
+
+func TestUpdateEqualChangeMixedVindexes(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+
+	// music_id is not owned by mixed_table, but it's returned
+	// by the subquery before the owned name column.
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"music_id", 8, mproto.VT_ZEROVALUE_FLAG},
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("2")},
+			{sqltypes.String("oldname")},
+		}},
+	}})
+	_, err := routerExec(router, "update mixed_table set music_id = 3, name = :name where user_id = 1", map[string]interface{}{
+		"name": "newname",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "select music_id, name from mixed_table where user_id = 1 for update"; sbc1.Queries[0].Sql != want {
+		t.Errorf("subquery: %s, want %s", sbc1.Queries[0].Sql, want)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select music_id from music_user_map where music_id = :music_id and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"music_id": int64(3),
+			"user_id":  int64(1),
+		},
+	}, {
+		Sql: "delete from mixed_name_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"oldname"},
+		},
+	}, {
+		Sql: "insert into mixed_name_map(name, user_id) values(:name, :user_id)",
+		BindVariables: map[string]interface{}{
+			"name":    "newname",
+			"user_id": int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries:\n%+v, want\n%+v", sbclookup.Queries, wantQueries)
+	}
+}
+
+func TestUpdateEqualMoveRows(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+			{"a", 3, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+			{sqltypes.Numeric("5")},
+		}},
+	}})
+	result, err := router.Execute(context.Background(), &proto.Query{
+		Sql:        "update user set id = 3, a = 2 where id = 1",
+		TabletType: topo.TYPE_MASTER,
+		Session:    &proto.Session{InTransaction: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 1 {
+		t.Errorf("result.RowsAffected: %d, want 1", result.RowsAffected)
+	}
+	wantBindVars := map[string]interface{}{
+		"keyspace_id": "N\xb1\x90ɢ\xfa\x16\x9c",
+		"_id":         int64(3),
+		"_name":       "myname",
+		"_a":          int64(2),
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select * from user where id = 1 for update",
+		BindVariables: map[string]interface{}{},
+	}, {
+		Sql: "delete from user where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: map[string]interface{}{
+			"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql:           "insert into user(id, name, a) values (:_id, :_name, :_a) /* _routing keyspace_id:4eb190c9a2fa169c */",
+		BindVariables: wantBindVars,
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(1)},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"myname"},
+		},
+	}, {
+		Sql: "insert into user_idx(id) values(:id)",
+		BindVariables: map[string]interface{}{
+			"id": int64(3),
+		},
+	}, {
+		Sql: "insert into name_user_map(name, user_id) values(:name, :user_id)",
+		BindVariables: map[string]interface{}{
+			"name":    "myname",
+			"user_id": int64(3),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+
+	sbc1.Queries = nil
+	_, err = routerExec(router, "update user set id = 3 where id = 1", nil)
+	want := "execUpdateEqual: changing the value of column id requires a transaction"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+	if sbc1.Queries != nil {
+		t.Errorf("sbc1.Queries: %+v, want nil\n", sbc1.Queries)
+	}
+}
+
 func TestUpdateEqualFail(t *testing.T) {
 	router, _, _, _ := createRouterEnv()
 	s := getSandbox("TestRouter")
@@ -288,6 +497,168 @@ func TestDeleteVindexFail(t *testing.T) {
 	}
 }
 
+func TestUpdateIN(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	_, err := routerExec(router, "update /* multi_shard */ user set a = 2 where id in (1, 3)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select id from user where id in ::_vals limit :_limit for update",
+		BindVariables: map[string]interface{}{
+			"_vals":  []interface{}{int64(1)},
+			"_limit": int64(10001),
+		},
+	}, {
+		Sql: "update /* multi_shard */ user set a = 2 where id in ::_vals",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(1)},
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "select id from user where id in ::_vals limit :_limit for update",
+		BindVariables: map[string]interface{}{
+			"_vals":  []interface{}{int64(3)},
+			"_limit": int64(10001),
+		},
+	}, {
+		Sql: "update /* multi_shard */ user set a = 2 where id in ::_vals",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(3)},
+		},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+}
+
+func TestDeleteScatter(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	l := createSandbox(KsTestUnsharded)
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	conns[0].setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+			{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+		}, {
+			{sqltypes.Numeric("1")},
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname2")},
+		}, {
+			{sqltypes.Numeric("3")},
+			{sqltypes.Numeric("3")},
+			{sqltypes.String("myname3")},
+		}},
+	}})
+	_, err := routerExec(router, "delete /* multi_shard */ from user where a = :a", map[string]interface{}{
+		"a": 1,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select id, id, name from user where a = :a limit :_limit for update",
+		BindVariables: map[string]interface{}{
+			"a":      1,
+			"_limit": int64(10001),
+		},
+	}, {
+		Sql: "delete /* multi_shard */ from user where a = :a",
+		BindVariables: map[string]interface{}{
+			"a": 1,
+		},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries: %+v, want %+v\n", conn.Queries, wantQueries)
+		}
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(1)},
+		},
+	}, {
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(3)},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"myname", "myname2"},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(3),
+			"name":    []interface{}{"myname3"},
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+}
+
+func TestMultiShardDMLFail(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	_, err := routerExec(router, "update user set a = 2 where id in (1, 3)", nil)
+	want := "cannot route query: update user set a = 2 where id in (1, 3): update has multi-shard where clause"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	saved := *maxDMLRows
+	*maxDMLRows = 1
+	defer func() { *maxDMLRows = saved }()
+	_, err = routerExec(router, "update /* multi_shard */ user set a = 2 where id in (1, 3)", nil)
+	want = "execMultiShardDML: multi-shard dml affects 2 rows, which exceeds the limit of 1"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+	if len(sbc1.Queries) != 1 || len(sbc2.Queries) != 1 {
+		t.Errorf("sbc1.Queries: %+v, sbc2.Queries: %+v, want only the subquery", sbc1.Queries, sbc2.Queries)
+	}
+	if limit := sbc1.Queries[0].BindVariables["_limit"]; limit != int64(2) {
+		t.Errorf("subquery limit: %v, want 2", limit)
+	}
+	*maxDMLRows = saved
+
+	sbc1.mustFailServer = 1
+	_, err = routerExec(router, "delete /* multi_shard */ from user where id in (1, 3)", nil)
+	want = "execMultiShardDML: shard, host: TestRouter.-20.master"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("routerExec: %v, want prefix %v", err, want)
+	}
+}
+
 func TestInsertSharded(t *testing.T) {
 	router, sbc1, sbc2, sbclookup := createRouterEnv()
 
@@ -362,6 +733,77 @@ func TestInsertSharded(t *testing.T) {
 	}
 }
 
+func TestInsertShardedMultiRow(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{RowsAffected: 2}})
+	sbc2.setResults([]*mproto.QueryResult{&mproto.QueryResult{RowsAffected: 1}})
+	result, err := routerExec(router, "insert into user(id, v, name) values (1, 2, 'myname'), (3, 4, 'myname2'), (1, 5, :name)", map[string]interface{}{
+		"name": "myname3",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult := &mproto.QueryResult{RowsAffected: 3}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("routerExec: %+v, want %+v", result, wantResult)
+	}
+	wantBindVars := map[string]interface{}{
+		"name":    "myname3",
+		"_id_0":   int64(1),
+		"_name_0": "myname",
+		"_id_1":   int64(3),
+		"_name_1": "myname2",
+		"_id_2":   int64(1),
+		"_name_2": "myname3",
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "insert into user(id, v, name) values (:_id_0, 2, :_name_0), (:_id_2, 5, :_name_2) /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: wantBindVars,
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql:           "insert into user(id, v, name) values (:_id_1, 4, :_name_1) /* _routing keyspace_id:4eb190c9a2fa169c */",
+		BindVariables: wantBindVars,
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	if len(sbclookup.Queries) != 6 {
+		t.Errorf("len(sbclookup.Queries): %d, want 6", len(sbclookup.Queries))
+	}
+}
+
+func TestInsertShardedMultiRowGenerator(t *testing.T) {
+	router, sbc, _, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{
+		&mproto.QueryResult{RowsAffected: 1, InsertId: 1},
+		&mproto.QueryResult{RowsAffected: 1, InsertId: 2},
+	})
+	result, err := routerExec(router, "insert into music(user_id, id) values (1, null), (1, null)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "insert into music(user_id, id) values (:_user_id_0, :_id_0), (:_user_id_1, :_id_1) /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: map[string]interface{}{
+			"_user_id_0": int64(1),
+			"_id_0":      int64(1),
+			"_user_id_1": int64(1),
+			"_id_1":      int64(2),
+		},
+	}}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("sbc.Queries: %+v, want %+v\n", sbc.Queries, wantQueries)
+	}
+	if result.InsertId != 1 {
+		t.Errorf("result.InsertId: %d, want 1", result.InsertId)
+	}
+}
+
 func TestInsertGenerator(t *testing.T) {
 	router, sbc, _, sbclookup := createRouterEnv()
 
@@ -403,6 +845,118 @@ func TestInsertGenerator(t *testing.T) {
 	}
 }
 
+func TestInsertSequence(t *testing.T) {
+	router, sbc, _, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{
+		&mproto.QueryResult{RowsAffected: 1, InsertId: 12},
+		&mproto.QueryResult{RowsAffected: 1, InsertId: 14},
+	})
+	result, err := routerExec(router, "insert into user_extra(user_id) values (1), (1), (1)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "insert into user_extra(user_id, id) values (:_user_id_0, :_id_0), (:_user_id_1, :_id_1), (:_user_id_2, :_id_2) /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: map[string]interface{}{
+			"_user_id_0": int64(1),
+			"_id_0":      int64(10),
+			"_user_id_1": int64(1),
+			"_id_1":      int64(11),
+			"_user_id_2": int64(1),
+			"_id_2":      int64(12),
+		},
+	}}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("sbc.Queries: %+v, want %+v\n", sbc.Queries, wantQueries)
+	}
+	seqQuery := tproto.BoundQuery{
+		Sql: "update user_extra_seq set next_id = last_insert_id(next_id + :cache) where id = 0",
+		BindVariables: map[string]interface{}{
+			"cache": int64(2),
+		},
+	}
+	wantQueries = []tproto.BoundQuery{seqQuery, seqQuery}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	if result.InsertId != 10 {
+		t.Errorf("result.InsertId: %d, want 10", result.InsertId)
+	}
+
+	// The next value comes from the cached block.
+	sbc.Queries = nil
+	sbclookup.Queries = nil
+	result, err = routerExec(router, "insert into user_extra(user_id, id) values (1, null)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(sbclookup.Queries) != 0 {
+		t.Errorf("sbclookup.Queries: %+v, want none", sbclookup.Queries)
+	}
+	if result.InsertId != 13 {
+		t.Errorf("result.InsertId: %d, want 13", result.InsertId)
+	}
+
+	// A supplied value is not generated.
+	sbclookup.Queries = nil
+	result, err = routerExec(router, "insert into user_extra(user_id, id) values (1, :id)", map[string]interface{}{
+		"id": int64(20),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(sbclookup.Queries) != 0 {
+		t.Errorf("sbclookup.Queries: %+v, want none", sbclookup.Queries)
+	}
+	if result.InsertId != 0 {
+		t.Errorf("result.InsertId: %d, want 0", result.InsertId)
+	}
+}
+
+func TestInsertSequenceFail(t *testing.T) {
+	router, _, _, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	_, err := routerExec(router, "insert into user_extra(user_id) values (1)", nil)
+	want := "handleAutoinc: sequence user_extra_seq: sequence row not found"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	sbclookup.mustFailServer = 1
+	_, err = routerExec(router, "insert into user_extra(user_id) values (1)", nil)
+	want = "handleAutoinc: sequence user_extra_seq: "
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("routerExec: %v, want prefix %v", err, want)
+	}
+}
+
+func TestInsertSequenceIndependent(t *testing.T) {
+	router, _, _, sbclookup := createRouterEnv()
+
+	// A reservation in flight for another sequence
+	// must not hold up user_extra_seq.
+	other := router.sequences.block("other_seq")
+	other.mu.Lock()
+	defer other.mu.Unlock()
+
+	sbclookup.setResults([]*mproto.QueryResult{&mproto.QueryResult{RowsAffected: 1, InsertId: 12}})
+	done := make(chan error)
+	go func() {
+		_, err := routerExec(router, "insert into user_extra(user_id) values (1)", nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("insert is blocked by the reservation of another sequence")
+	}
+}
+
 func TestInsertLookupOwned(t *testing.T) {
 	router, sbc, _, sbclookup := createRouterEnv()
 
@@ -66,6 +66,15 @@ var routerSchema = createTestSchema(`
             "To": "val"
           }
         },
+        "mixed_name_map": {
+          "Type": "lookup_hash",
+          "Owner": "mixed_table",
+          "Params": {
+            "Table": "mixed_name_map",
+            "From": "name",
+            "To": "user_id"
+          }
+        },
         "idx_noauto": {
           "Type": "hash",
           "Owner": "noauto_table"
@@ -93,7 +102,11 @@ var routerSchema = createTestSchema(`
               "Col": "user_id",
               "Name": "user_index"
             }
-          ]
+          ],
+          "Autoinc": {
+            "Col": "id",
+            "Sequence": "user_extra_seq"
+          }
         },
         "music": {
           "ColVindexes": [
@@ -143,6 +156,22 @@ var routerSchema = createTestSchema(`
             }
           ]
         },
+        "mixed_table": {
+          "ColVindexes": [
+            {
+              "Col": "user_id",
+              "Name": "user_index"
+            },
+            {
+              "Col": "music_id",
+              "Name": "music_user_map"
+            },
+            {
+              "Col": "name",
+              "Name": "mixed_name_map"
+            }
+          ]
+        },
         "noauto_table": {
           "ColVindexes": [
             {
@@ -167,6 +196,7 @@ var routerSchema = createTestSchema(`
         "music_extra": "music_extra",
         "music_extra_reversed": "music_extra_reversed",
         "multi_autoinc_table": "multi_autoinc_table",
+        "mixed_table": "mixed_table",
         "noauto_table": "noauto_table",
         "ksid_table": "ksid_table"
       }
@@ -184,7 +214,13 @@ var routerSchema = createTestSchema(`
         "music_user_map": "",
         "name_user_map": "",
         "idx1": "",
-        "idx2": ""
+        "idx2": "",
+        "mixed_name_map": ""
+      },
+      "Sequences": {
+        "user_extra_seq": {
+          "Cache": 2
+        }
       }
     }
   }
@@ -5,11 +5,14 @@
 package vtgate
 
 import (
+	"bytes"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/youtube/vitess/go/mysql/collation"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
@@ -615,3 +618,936 @@ func TestSelectScatterFail(t *testing.T) {
 		t.Errorf("routerExec: %v, want %v", err, want)
 	}
 }
+
+func TestSelectColocatedJoin(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	_, err := routerExec(router, "select * from user u join user_extra e on u.id = e.user_id where e.user_id = 3", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select * from user as u join user_extra as e on u.id = e.user_id where e.user_id = 3",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	if sbc1.Queries != nil {
+		t.Errorf("sbc1.Queries: %+v, want nil\n", sbc1.Queries)
+	}
+
+}
+
+func TestSelectColocatedJoinScatter(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	_, err := routerExec(router, "select * from user u join user_extra e on u.id = e.user_id", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select * from user as u join user_extra as e on u.id = e.user_id",
+		BindVariables: map[string]interface{}{},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+}
+
+func TestSelectMerge(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields:       singleRowResult.Fields,
+			RowsAffected: 2,
+			Rows: [][]sqltypes.Value{{
+				{sqltypes.Numeric(fmt.Sprintf("%d", i+8))},
+				{sqltypes.String("foo")},
+			}, {
+				{sqltypes.Numeric(fmt.Sprintf("%d", i))},
+				{sqltypes.String("foo")},
+			}},
+		}})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	result, err := routerExec(router, "select * from user order by id desc limit 1, 3", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select * from user order by id desc limit 4",
+		BindVariables: map[string]interface{}{},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	wantResult := &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("14")},
+			{sqltypes.String("foo")},
+		}, {
+			{sqltypes.Numeric("13")},
+			{sqltypes.String("foo")},
+		}, {
+			{sqltypes.Numeric("12")},
+			{sqltypes.String("foo")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	for _, conn := range conns {
+		conn.Queries = nil
+	}
+	bv := map[string]interface{}{
+		"a": 2,
+		"b": int64(1),
+	}
+	result, err = routerExec(router, "select * from user limit :a, :b", bv)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "select * from user limit :_limit",
+		BindVariables: map[string]interface{}{
+			"a":      2,
+			"b":      int64(1),
+			"_limit": int64(3),
+		},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	if len(result.Rows) != 1 {
+		t.Errorf("len(result.Rows): %d, want 1", len(result.Rows))
+	}
+	// The bind vars of the client must not be modified.
+	if _, ok := bv["_limit"]; ok {
+		t.Errorf("bind vars: %v, must not contain _limit", bv)
+	}
+}
+
+func TestSelectMergeCollation(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	fields := []mproto.Field{{"name", mproto.VT_VAR_STRING, mproto.VT_ZEROVALUE_FLAG}}
+	shardRows := [][]string{{"Z"}, {"a", "b"}}
+	for i, shard := range shards {
+		var rows [][]sqltypes.Value
+		if i < len(shardRows) {
+			for _, name := range shardRows[i] {
+				rows = append(rows, []sqltypes.Value{{sqltypes.String(name)}})
+			}
+		}
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields:       fields,
+			RowsAffected: uint64(len(rows)),
+			Rows:         rows,
+		}})
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	// Like MySQL, the strings must be sorted without regard to case.
+	result, err := routerExec(router, "select name from user order by name limit 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := [][]sqltypes.Value{{{sqltypes.String("a")}}}
+	if !reflect.DeepEqual(result.Rows, wantRows) {
+		t.Errorf("result.Rows: %+v, want %+v", result.Rows, wantRows)
+	}
+}
+
+func TestStreamSelectMerge(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: singleRowResult.Fields,
+			Rows: [][]sqltypes.Value{{
+				{sqltypes.Numeric(fmt.Sprintf("%d", i))},
+				{sqltypes.String("foo")},
+			}, {
+				{sqltypes.Numeric(fmt.Sprintf("%d", i+8))},
+				{sqltypes.String("foo")},
+			}},
+		}})
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "select * from user order by id limit 10",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := routerStream(router, &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: singleRowResult.Fields,
+	}
+	for i := 0; i < 10; i++ {
+		wantResult.Rows = append(wantResult.Rows, []sqltypes.Value{
+			{sqltypes.Numeric(fmt.Sprintf("%d", i))},
+			{sqltypes.String("foo")},
+		})
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectMergeFail(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for _, shard := range shards {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	_, err := routerExec(router, "select * from user order by a", nil)
+	want := "order by column a not found in result"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	_, err = routerExec(router, "select * from user limit :a", nil)
+	want = "paramsSelectMerge: could not find bind var :a"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	q := proto.Query{
+		Sql:        "select * from user order by a",
+		TabletType: topo.TYPE_MASTER,
+	}
+	_, err = routerStream(router, &q)
+	want = "order by column a not found in result"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerStream: %v, want %v", err, want)
+	}
+}
+
+func TestSelectAggregate(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	fields := []mproto.Field{
+		{Name: "name", Type: mproto.VT_VAR_STRING},
+		{Name: "count(*)", Type: mproto.VT_LONGLONG},
+		{Name: "sum(id)", Type: mproto.VT_NEWDECIMAL},
+		{Name: "min(id)", Type: mproto.VT_LONG},
+		{Name: "max(id)", Type: mproto.VT_LONG},
+		{Name: "sum(id)", Type: mproto.VT_NEWDECIMAL},
+		{Name: "count(id)", Type: mproto.VT_LONGLONG},
+	}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		shardResult := &mproto.QueryResult{
+			Fields: fields,
+			Rows: [][]sqltypes.Value{{
+				{sqltypes.String("b")},
+				{sqltypes.Numeric("1")},
+				{sqltypes.Fractional(fmt.Sprintf("%d", i))},
+				{sqltypes.Numeric(fmt.Sprintf("%d", i))},
+				{sqltypes.Numeric(fmt.Sprintf("%d", i))},
+				{sqltypes.Fractional(fmt.Sprintf("%d", i))},
+				{sqltypes.Numeric("1")},
+			}, {
+				{sqltypes.String("a")},
+				{sqltypes.Numeric("2")},
+				{sqltypes.Fractional(fmt.Sprintf("%d", 2*i+1))},
+				{sqltypes.Numeric(fmt.Sprintf("%d", i))},
+				{sqltypes.Numeric(fmt.Sprintf("%d", i+1))},
+				{sqltypes.Fractional(fmt.Sprintf("%d", 2*i+1))},
+				{sqltypes.Numeric("2")},
+			}},
+		}
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{shardResult, shardResult})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	result, err := routerExec(router, "select name, count(*), sum(id), min(id), max(id), avg(id) from user group by name", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select name, count(*), sum(id), min(id), max(id), sum(id), count(id) from user group by name",
+		BindVariables: map[string]interface{}{},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	wantFields := append([]mproto.Field{}, fields[:5]...)
+	wantFields = append(wantFields, mproto.Field{Name: "avg(id)", Type: mproto.VT_NEWDECIMAL})
+	wantResult := &mproto.QueryResult{
+		Fields:       wantFields,
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.String("a")},
+			{sqltypes.Numeric("16")},
+			{sqltypes.Fractional("64")},
+			{sqltypes.Numeric("0")},
+			{sqltypes.Numeric("8")},
+			{sqltypes.Fractional("4.0000")},
+		}, {
+			{sqltypes.String("b")},
+			{sqltypes.Numeric("8")},
+			{sqltypes.Fractional("28")},
+			{sqltypes.Numeric("0")},
+			{sqltypes.Numeric("7")},
+			{sqltypes.Fractional("3.5000")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	result, err = routerExec(router, "select name, count(*) as c from user group by name having max(id) < :max order by c limit 1", map[string]interface{}{
+		"max": 10,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult = &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "name", Type: mproto.VT_VAR_STRING},
+			{Name: "c", Type: mproto.VT_LONGLONG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.String("b")},
+			{sqltypes.Numeric("8")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestStreamSelectAggregate(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	fields := []mproto.Field{
+		{Name: "count(*)", Type: mproto.VT_LONGLONG},
+		{Name: "sum(id)", Type: mproto.VT_DOUBLE},
+		{Name: "count(id)", Type: mproto.VT_LONGLONG},
+	}
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: fields,
+			Rows: [][]sqltypes.Value{{
+				{sqltypes.Numeric("2")},
+				{sqltypes.Fractional(fmt.Sprintf("%d.5", i))},
+				{sqltypes.Numeric("2")},
+			}},
+		}})
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "select count(*), avg(id) from user",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := routerStream(router, &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "count(*)", Type: mproto.VT_LONGLONG},
+			{Name: "avg(id)", Type: mproto.VT_DOUBLE},
+		},
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("16")},
+			{sqltypes.Fractional("2")},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectAggregateCollation(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	fields := []mproto.Field{
+		{Name: "name", Type: mproto.VT_VAR_STRING},
+		{Name: "count(*)", Type: mproto.VT_LONGLONG},
+		{Name: "min(value)", Type: mproto.VT_VAR_STRING},
+	}
+	shardRows := [][][]sqltypes.Value{{
+		{{sqltypes.String("a")}, {sqltypes.Numeric("1")}, {sqltypes.String("b")}},
+		{{sqltypes.String("é")}, {sqltypes.Numeric("1")}, {sqltypes.String("x")}},
+	}, {
+		{{sqltypes.String("A")}, {sqltypes.Numeric("2")}, {sqltypes.String("B")}},
+		{{sqltypes.String("e")}, {sqltypes.Numeric("3")}, {sqltypes.String("Y")}},
+	}}
+	for i, shard := range shards {
+		shardResult := &mproto.QueryResult{Fields: fields}
+		if i < len(shardRows) {
+			shardResult.Rows = shardRows[i]
+		}
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{shardResult})
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	// Like MySQL, values that differ only by case or accents
+	// belong to the same group. Which of them is returned depends
+	// on the order in which the shards answer.
+	result, err := routerExec(router, "select name, count(*), min(value) from user group by name", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a", "3", "b"}, {"e", "4", "x"}}
+	if len(result.Rows) != len(want) {
+		t.Fatalf("result.Rows: %+v, want %v", result.Rows, want)
+	}
+	for i, row := range result.Rows {
+		for j, val := range row {
+			if collation.Compare(val.Raw(), []byte(want[i][j])) != 0 {
+				t.Errorf("result.Rows[%d][%d]: %v, want %v", i, j, val, want[i][j])
+			}
+		}
+	}
+}
+
+func TestSelectAggregateFail(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for _, shard := range shards {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	_, err := routerExec(router, "select count(*) from user limit :a", nil)
+	want := "paramsSelectAggregate: could not find bind var :a"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	_, err = routerExec(router, "select count(*) from user having count(*) > :a", nil)
+	want = "execSelectAggregate: having: could not find bind var :a"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	// singleRowResult has a string column, which cannot be added.
+	_, err = routerExec(router, "select value, sum(id) from user group by value", nil)
+	want = "execSelectAggregate: cannot add non-numeric values: foo, foo"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
+// joinLookupResult is the result of the batched right query of
+// the joins with music_user_map. Its last column is music_id.
+var joinLookupResult = &mproto.QueryResult{
+	Fields: []mproto.Field{
+		singleRowResult.Fields[0],
+		{"music_id", 3, mproto.VT_ZEROVALUE_FLAG},
+	},
+	RowsAffected: 1,
+	Rows: [][]sqltypes.Value{{
+		singleRowResult.Rows[0][0],
+		singleRowResult.Rows[0][0],
+	}},
+}
+
+func TestSelectJoin(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{joinLookupResult})
+	result, err := routerExec(router, "select u.id, m.user_id from user u join music_user_map m on u.id = m.music_id where u.id = 1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select u.id from user as u where u.id = 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	if sbc2.Queries != nil {
+		t.Errorf("sbc2.Queries: %+v, want nil\n", sbc2.Queries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "select m.user_id, m.music_id from music_user_map as m where m.music_id in ::_u.id",
+		BindVariables: map[string]interface{}{
+			"_u.id": []interface{}{int64(1)},
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			singleRowResult.Fields[0],
+			singleRowResult.Fields[0],
+		},
+		Rows: [][]sqltypes.Value{{
+			singleRowResult.Rows[0][0],
+			singleRowResult.Rows[0][0],
+		}},
+		RowsAffected: 1,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectLeftJoin(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{{
+		Fields: singleRowResult.Fields,
+		Rows: [][]sqltypes.Value{
+			singleRowResult.Rows[0],
+			singleRowResult.Rows[0],
+			{{}, singleRowResult.Rows[0][1]},
+		},
+		RowsAffected: 3,
+	}})
+	sbclookup.setResults([]*mproto.QueryResult{{}})
+	result, err := routerExec(router, "select u.id, m.user_id from user u left join music_user_map m on u.id = m.music_id where u.id = 1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	// The right side must be sent the duplicate value only once,
+	// and not the null value. The fields of the right side are
+	// fetched separately because it didn't return any.
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select m.user_id, m.music_id from music_user_map as m where m.music_id in ::_u.id",
+		BindVariables: map[string]interface{}{
+			"_u.id": []interface{}{int64(1)},
+		},
+	}, {
+		Sql:           "select m.user_id from music_user_map as m where 1 != 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			singleRowResult.Fields[0],
+			singleRowResult.Fields[0],
+		},
+		Rows: [][]sqltypes.Value{
+			{singleRowResult.Rows[0][0], {}},
+			{singleRowResult.Rows[0][0], {}},
+			{{}, {}},
+		},
+		RowsAffected: 3,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectJoinBatch(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+	defer func(saved int) { *joinBatchSize = saved }(*joinBatchSize)
+	*joinBatchSize = 2
+
+	idField := singleRowResult.Fields[0]
+	musicIDField := mproto.Field{"music_id", 3, mproto.VT_ZEROVALUE_FLAG}
+	sbc1.setResults([]*mproto.QueryResult{{
+		Fields: []mproto.Field{idField},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeNumeric([]byte("1"))},
+			{sqltypes.MakeNumeric([]byte("2"))},
+			{sqltypes.MakeNumeric([]byte("3"))},
+			{sqltypes.MakeNumeric([]byte("1"))},
+			{{}},
+		},
+		RowsAffected: 5,
+	}})
+	sbclookup.setResults([]*mproto.QueryResult{{
+		Fields: []mproto.Field{idField, musicIDField},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeNumeric([]byte("10")), sqltypes.MakeNumeric([]byte("1"))},
+			{sqltypes.MakeNumeric([]byte("20")), sqltypes.MakeNumeric([]byte("2"))},
+			{sqltypes.MakeNumeric([]byte("11")), sqltypes.MakeNumeric([]byte("1"))},
+		},
+		RowsAffected: 3,
+	}, {
+		Fields: []mproto.Field{idField, musicIDField},
+	}})
+	result, err := routerExec(router, "select u.id, m.user_id from user u join music_user_map m on u.id = m.music_id where u.id = 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The distinct values are sent in batches of two,
+	// and the rows are matched to them by music_id.
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select m.user_id, m.music_id from music_user_map as m where m.music_id in ::_u.id",
+		BindVariables: map[string]interface{}{
+			"_u.id": []interface{}{int64(1), int64(2)},
+		},
+	}, {
+		Sql: "select m.user_id, m.music_id from music_user_map as m where m.music_id in ::_u.id",
+		BindVariables: map[string]interface{}{
+			"_u.id": []interface{}{int64(3)},
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	row := func(left, right string) []sqltypes.Value {
+		return []sqltypes.Value{sqltypes.MakeNumeric([]byte(left)), sqltypes.MakeNumeric([]byte(right))}
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{idField, idField},
+		Rows: [][]sqltypes.Value{
+			row("1", "10"),
+			row("1", "11"),
+			row("2", "20"),
+			row("1", "10"),
+			row("1", "11"),
+		},
+		RowsAffected: 5,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectJoinBatchCollated(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+
+	// MySQL compares the strings with a collation, which VTGate
+	// can only approximate. So, the Right rows are looked up
+	// one value at a time instead of being matched to a batch.
+	nameField := mproto.Field{"name", mproto.VT_VAR_STRING, mproto.VT_ZEROVALUE_FLAG}
+	sbc1.setResults([]*mproto.QueryResult{{
+		Fields: []mproto.Field{nameField},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeString([]byte("e"))},
+			{sqltypes.MakeString([]byte("f"))},
+		},
+		RowsAffected: 2,
+	}})
+	sbclookup.setResults([]*mproto.QueryResult{{
+		Fields: []mproto.Field{singleRowResult.Fields[0]},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeNumeric([]byte("10"))},
+		},
+		RowsAffected: 1,
+	}, {
+		Fields: []mproto.Field{singleRowResult.Fields[0]},
+	}})
+	result, err := routerExec(router, "select u.name, m.user_id from user u join music_user_map m on u.name = m.music_id where u.id = 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select m.user_id from music_user_map as m where m.music_id = :_u.name",
+		BindVariables: map[string]interface{}{
+			"_u.name": []byte("e"),
+		},
+	}, {
+		Sql: "select m.user_id from music_user_map as m where m.music_id = :_u.name",
+		BindVariables: map[string]interface{}{
+			"_u.name": []byte("f"),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantRows := [][]sqltypes.Value{
+		{sqltypes.MakeString([]byte("e")), sqltypes.MakeNumeric([]byte("10"))},
+	}
+	if !reflect.DeepEqual(result.Rows, wantRows) {
+		t.Errorf("result.Rows: %+v, want %+v", result.Rows, wantRows)
+	}
+}
+
+func TestValueKey(t *testing.T) {
+	intField := mproto.Field{"a", mproto.VT_LONGLONG, mproto.VT_ZEROVALUE_FLAG}
+	decimalField := mproto.Field{"a", mproto.VT_NEWDECIMAL, mproto.VT_ZEROVALUE_FLAG}
+	textField := mproto.Field{"a", mproto.VT_VAR_STRING, mproto.VT_ZEROVALUE_FLAG}
+	binaryField := mproto.Field{"a", mproto.VT_VAR_STRING, mproto.VT_BINARY_FLAG}
+	testcases := []struct {
+		field      mproto.Field
+		val1, val2 string
+		equal      bool
+	}{
+		{intField, "1", "01", true},
+		{intField, "1", "2", false},
+		{intField, "18446744073709551615", "18446744073709551615", true},
+		{decimalField, "1.50", "1.5", true},
+		{decimalField, "1", "1.00", true},
+		{textField, "Abc ", "aBC", true},
+		{textField, "abc", "abd", false},
+		{textField, "é", "E", true},
+		{binaryField, "Abc", "abc", false},
+		{binaryField, "abc", "abc", true},
+	}
+	for _, tcase := range testcases {
+		key1 := valueKey(tcase.field, sqltypes.MakeString([]byte(tcase.val1)))
+		key2 := valueKey(tcase.field, sqltypes.MakeString([]byte(tcase.val2)))
+		if bytes.Equal(key1, key2) != tcase.equal {
+			t.Errorf("valueKey(%v, %q) = %q, valueKey(%v, %q) = %q, want equal: %v", tcase.field.Type, tcase.val1, key1, tcase.field.Type, tcase.val2, key2, tcase.equal)
+		}
+	}
+}
+
+func TestStreamSelectJoin(t *testing.T) {
+	router, _, _, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{joinLookupResult})
+	q := proto.Query{
+		Sql:        "select u.id, m.user_id from user u join music_user_map m on u.id = m.music_id where u.id = 1",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := routerStream(router, &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			singleRowResult.Fields[0],
+			singleRowResult.Fields[0],
+		},
+		Rows: [][]sqltypes.Value{{
+			singleRowResult.Rows[0][0],
+			singleRowResult.Rows[0][0],
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectJoinFail(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+
+	sbc1.mustFailServer = 1
+	_, err := routerExec(router, "select u.id, m.user_id from user u join music_user_map m on u.id = m.music_id where u.id = 1", nil)
+	want := "error: err"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("routerExec: %v, want prefix %v", err, want)
+	}
+
+	sbclookup.mustFailServer = 1
+	_, err = routerExec(router, "select u.id, m.user_id from user u join music_user_map m on u.id = m.music_id where u.id = 1", nil)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("routerExec: %v, want prefix %v", err, want)
+	}
+
+	defer func(saved int) { *maxJoinRows = saved }(*maxJoinRows)
+	*maxJoinRows = 1
+	_, err = routerExec(router, "select u.id, m.user_id from user u join music_user_map m on u.id = m.music_id where u.id = 1", nil)
+	want = "cross-shard join exceeded the limit of 1 rows"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
+func TestSelectUnion(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	result, err := routerExec(router, "select id, value from user where id = 1 union all select id, value from user where id = 3", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select id, value from user where id = 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql:           "select id, value from user where id = 3",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: singleRowResult.Fields,
+		Rows: [][]sqltypes.Value{
+			singleRowResult.Rows[0],
+			singleRowResult.Rows[0],
+		},
+		RowsAffected: 2,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	result, err = routerExec(router, "select id, value from user where id = 1 union select id, value from user where id = 3", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult = &mproto.QueryResult{
+		Fields:       singleRowResult.Fields,
+		Rows:         singleRowResult.Rows,
+		RowsAffected: 1,
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectUnionCollation(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{{
+		Fields:       singleRowResult.Fields,
+		Rows:         [][]sqltypes.Value{{{sqltypes.Numeric("1")}, {sqltypes.String("abc")}}},
+		RowsAffected: 1,
+	}})
+	sbc2.setResults([]*mproto.QueryResult{{
+		Fields: singleRowResult.Fields,
+		Rows: [][]sqltypes.Value{
+			{{sqltypes.Numeric("1")}, {sqltypes.String("ABC ")}},
+			{{sqltypes.Numeric("1")}, {sqltypes.String("abd")}},
+		},
+		RowsAffected: 2,
+	}})
+	// Like MySQL, the UNION must keep only one of the
+	// values that differ by case or trailing spaces.
+	result, err := routerExec(router, "select id, value from user where id = 1 union select id, value from user where id = 3", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantRows := [][]sqltypes.Value{
+		{{sqltypes.Numeric("1")}, {sqltypes.String("abc")}},
+		{{sqltypes.Numeric("1")}, {sqltypes.String("abd")}},
+	}
+	if !reflect.DeepEqual(result.Rows, wantRows) {
+		t.Errorf("result.Rows: %+v, want %+v", result.Rows, wantRows)
+	}
+}
+
+func TestSelectUnionSameShard(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	_, err := routerExec(router, "select id from user where id = 1 union select user_id from music where user_id = 1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select id from user where id = 1 union select user_id from music where user_id = 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	if sbc2.Queries != nil {
+		t.Errorf("sbc2.Queries: %+v, want nil\n", sbc2.Queries)
+	}
+}
+
+func TestStreamSelectUnion(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+
+	for _, sql := range []string{
+		"select id, value from user where id = 1 union all select id, value from user where id = 3",
+		"select id, value from user where id = 1 union select id, value from user where id = 3",
+	} {
+		q := proto.Query{
+			Sql:        sql,
+			TabletType: topo.TYPE_MASTER,
+		}
+		result, err := routerStream(router, &q)
+		if err != nil {
+			t.Error(err)
+		}
+		wantResult := &mproto.QueryResult{
+			Fields: singleRowResult.Fields,
+			Rows:   singleRowResult.Rows,
+		}
+		if strings.Contains(sql, "union all") {
+			// The rows of both sides are streamed as is.
+			wantResult.Rows = [][]sqltypes.Value{
+				singleRowResult.Rows[0],
+				singleRowResult.Rows[0],
+			}
+			wantResult.RowsAffected = 2
+		}
+		if !reflect.DeepEqual(result, wantResult) {
+			t.Errorf("%s: result: %+v, want %+v", sql, result, wantResult)
+		}
+	}
+}
+
+func TestSelectUnionFail(t *testing.T) {
+	router, _, sbc2, _ := createRouterEnv()
+
+	sbc2.setResults([]*mproto.QueryResult{{
+		Fields: singleRowResult.Fields[:1],
+	}})
+	_, err := routerExec(router, "select id, value from user where id = 1 union select id from user where id = 3", nil)
+	want := "execSelectUnion: the selects of the union return a different number of columns: 2, 1"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
@@ -5,10 +5,35 @@
 package vtgate
 
 import (
+	"flag"
+	"fmt"
 	"sync"
 
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vterrors"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
+
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+const (
+	// transactionModeSingle only allows transactions on a single shard.
+	transactionModeSingle = "single"
+	// transactionModeMulti allows transactions that span multiple shards.
+	transactionModeMulti = "multi"
+)
+
+var (
+	transactionMode = flag.String("transaction_mode", transactionModeMulti, "default transaction mode of the sessions: single only allows single-shard transactions, multi also allows multi-shard transactions. A session can always restrict itself to a single shard")
+
+	// multiShardTransactions counts the transactions that
+	// span multiple shards, by keyspace of their first shard.
+	multiShardTransactions = stats.NewCounters("VtgateMultiShardTransactions")
+	// multiShardRejections counts the statements rejected because
+	// they would have made a single-shard transaction multi-shard,
+	// by keyspace of the first shard of the transaction.
+	multiShardRejections = stats.NewCounters("VtgateMultiShardRejections")
 )
 
 type SafeSession struct {
@@ -43,10 +68,66 @@ func (session *SafeSession) Find(keyspace, shard string, tabletType topo.TabletT
 	return 0
 }
 
-func (session *SafeSession) Append(shardSession *proto.ShardSession) {
+// keyspaceShard identifies a shard a statement is sent to.
+type keyspaceShard struct {
+	keyspace, shard string
+}
+
+// CheckSingleShard returns an error if sending a statement to targets
+// would make a single-shard transaction span multiple shards. It's called
+// before the statement is sent to any shard, so that a rejected statement
+// doesn't leave a partial write in the transaction.
+func (session *SafeSession) CheckSingleShard(targets []keyspaceShard) error {
+	if !session.InTransaction() {
+		return nil
+	}
 	session.mu.Lock()
 	defer session.mu.Unlock()
+	if !session.SingleShard && *transactionMode != transactionModeSingle {
+		return nil
+	}
+	var first *keyspaceShard
+	if len(session.ShardSessions) != 0 {
+		first = &keyspaceShard{session.ShardSessions[0].Keyspace, session.ShardSessions[0].Shard}
+	}
+	for i, target := range targets {
+		if first == nil {
+			first = &targets[i]
+			continue
+		}
+		if target != *first {
+			return session.rejectMultiShard(first.keyspace, first.shard, target.keyspace, target.shard)
+		}
+	}
+	return nil
+}
+
+// Append adds a shard session to the transaction. It fails if that would
+// make a single-shard transaction span multiple shards.
+func (session *SafeSession) Append(shardSession *proto.ShardSession) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if len(session.ShardSessions) == 1 {
+		first := session.ShardSessions[0]
+		if first.Keyspace != shardSession.Keyspace || first.Shard != shardSession.Shard {
+			if session.SingleShard || *transactionMode == transactionModeSingle {
+				return session.rejectMultiShard(first.Keyspace, first.Shard, shardSession.Keyspace, shardSession.Shard)
+			}
+			multiShardTransactions.Add(first.Keyspace, 1)
+		}
+	}
 	session.ShardSessions = append(session.ShardSessions, shardSession)
+	return nil
+}
+
+// rejectMultiShard returns the error of a statement that would have
+// made a single-shard transaction span multiple shards.
+func (session *SafeSession) rejectMultiShard(keyspace, shard, newKeyspace, newShard string) error {
+	multiShardRejections.Add(keyspace, 1)
+	return vterrors.FromError(
+		int64(pbv.ErrorCode_BAD_INPUT),
+		fmt.Errorf("multi-shard transaction attempted in single-shard mode: transaction is on %s/%s, statement requires %s/%s", keyspace, shard, newKeyspace, newShard),
+	)
 }
 
 func (session *SafeSession) Reset() {
@@ -55,3 +136,40 @@ func (session *SafeSession) Reset() {
 	session.Session.InTransaction = false
 	session.ShardSessions = nil
 }
+
+// FindPosition returns the replication position of the last commit
+// of a read-your-writes session on keyspace/shard, if any.
+func (session *SafeSession) FindPosition(keyspace, shard string) string {
+	if session == nil || session.Session == nil {
+		return ""
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.ReadYourWrites {
+		return ""
+	}
+	for _, shardPosition := range session.ShardPositions {
+		if keyspace == shardPosition.Keyspace && shard == shardPosition.Shard {
+			return shardPosition.Position
+		}
+	}
+	return ""
+}
+
+// RecordPosition saves the replication position of a commit of the
+// session on keyspace/shard. It survives the end of the transaction.
+func (session *SafeSession) RecordPosition(keyspace, shard, position string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, shardPosition := range session.ShardPositions {
+		if keyspace == shardPosition.Keyspace && shard == shardPosition.Shard {
+			shardPosition.Position = position
+			return
+		}
+	}
+	session.ShardPositions = append(session.ShardPositions, &proto.ShardPosition{
+		Keyspace: keyspace,
+		Shard:    shard,
+		Position: position,
+	})
+}
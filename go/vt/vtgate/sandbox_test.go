@@ -274,10 +274,14 @@ func (sct *sandboxTopo) GetSrvShard(ctx context.Context, cell, keyspace, shard s
 
 func (sct *sandboxTopo) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType pbt.TabletType) (*pbt.EndPoints, int64, error) {
 	sand := getSandbox(keyspace)
+	sand.sandmu.Lock()
 	sand.EndPointCounter++
+	sand.sandmu.Unlock()
 	if sct.callbackGetEndPoints != nil {
 		sct.callbackGetEndPoints(sct)
 	}
+	sand.sandmu.Lock()
+	defer sand.sandmu.Unlock()
 	if sand.EndPointMustFail > 0 {
 		sand.EndPointMustFail--
 		return nil, -1, fmt.Errorf("topo error")
@@ -323,6 +327,19 @@ type sandboxConn struct {
 	mustFailNotTx  int
 	mustDelay      time.Duration
 
+	// mustFailPrepare makes the next Prepare calls fail.
+	mustFailPrepare int
+
+	// position is returned by CommitWithPosition. If
+	// mustFailWaitForPosition is set, WaitForPosition fails
+	// for positions other than caughtUpPosition.
+	position                string
+	caughtUpPosition        string
+	mustFailWaitForPosition bool
+
+	// healthResponses is returned by StreamHealth if set.
+	healthResponses chan *pb.StreamHealthResponse
+
 	// A callback to tweak the behavior on each conn call
 	onConnUse func(*sandboxConn)
 
@@ -334,6 +351,25 @@ type sandboxConn struct {
 	RollbackCount      sync2.AtomicInt64
 	CloseCount         sync2.AtomicInt64
 	AsTransactionCount sync2.AtomicInt64
+	StreamHealthCount  sync2.AtomicInt64
+
+	// These Count vars report how often the two-phase
+	// commit functions were called.
+	PrepareCount             sync2.AtomicInt64
+	CommitPreparedCount      sync2.AtomicInt64
+	RollbackPreparedCount    sync2.AtomicInt64
+	CreateTransactionCount   sync2.AtomicInt64
+	StartCommitCount         sync2.AtomicInt64
+	ConcludeTransactionCount sync2.AtomicInt64
+
+	// Participants stores the participants of the last
+	// CreateTransaction call.
+	Participants []*pb.Target
+
+	// These Count vars report how often the read-your-writes
+	// functions were called.
+	CommitWithPositionCount sync2.AtomicInt64
+	WaitForPositionCount    sync2.AtomicInt64
 
 	// Queries stores the requests received.
 	Queries []tproto.BoundQuery
@@ -495,6 +531,66 @@ func (sbc *sandboxConn) Rollback2(ctx context.Context, transactionID int64) erro
 	return sbc.Rollback(ctx, transactionID)
 }
 
+func (sbc *sandboxConn) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.PrepareCount.Add(1)
+	if sbc.mustFailPrepare > 0 {
+		sbc.mustFailPrepare--
+		return &tabletconn.ServerError{Code: tabletconn.ERR_NORMAL, Err: "error: err"}
+	}
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CommitPrepared(ctx context.Context, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.CommitPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	sbc.ExecCount.Add(1)
+	sbc.RollbackPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CreateTransaction(ctx context.Context, dtid string, participants []*pb.Target) error {
+	sbc.ExecCount.Add(1)
+	sbc.CreateTransactionCount.Add(1)
+	sbc.Participants = participants
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.StartCommitCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) ConcludeTransaction(ctx context.Context, dtid string) error {
+	sbc.ExecCount.Add(1)
+	sbc.ConcludeTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CommitWithPosition(ctx context.Context, transactionID int64) (string, error) {
+	sbc.ExecCount.Add(1)
+	sbc.CommitCount.Add(1)
+	sbc.CommitWithPositionCount.Add(1)
+	if err := sbc.getError(); err != nil {
+		return "", err
+	}
+	return sbc.position, nil
+}
+
+func (sbc *sandboxConn) WaitForPosition(ctx context.Context, position string, timeout time.Duration) error {
+	sbc.ExecCount.Add(1)
+	sbc.WaitForPositionCount.Add(1)
+	if sbc.mustFailWaitForPosition && position != sbc.caughtUpPosition {
+		return &tabletconn.ServerError{Code: tabletconn.ERR_NORMAL, Err: "error: timeout waiting for position"}
+	}
+	return sbc.getError()
+}
+
 var sandboxSQRowCount = int64(10)
 
 // Fake SplitQuery creates splits from the original query by appending the
@@ -514,9 +610,13 @@ func (sbc *sandboxConn) SplitQuery(ctx context.Context, query tproto.BoundQuery,
 	return splits, nil
 }
 
-// StreamHealth does nothing
+// StreamHealth returns healthResponses if set.
 func (sbc *sandboxConn) StreamHealth(ctx context.Context) (<-chan *pb.StreamHealthResponse, tabletconn.ErrFunc, error) {
-	return nil, nil, fmt.Errorf("Not implemented in test")
+	sbc.StreamHealthCount.Add(1)
+	if sbc.healthResponses == nil {
+		return nil, nil, fmt.Errorf("Not implemented in test")
+	}
+	return sbc.healthResponses, func() error { return nil }, nil
 }
 
 // Close does not change ExecCount
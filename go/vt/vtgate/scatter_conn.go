@@ -6,11 +6,13 @@ package vtgate
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/concurrency"
@@ -265,12 +267,22 @@ func (stc *ScatterConn) ExecuteBatch(
 	session *SafeSession) (qrs *tproto.QueryResultList, err error) {
 	allErrors := new(concurrency.AllErrorRecorder)
 
+	targets := make([]keyspaceShard, 0, len(batchRequest.Requests))
+	for _, req := range batchRequest.Requests {
+		targets = append(targets, keyspaceShard{req.Keyspace, req.Shard})
+	}
+	if err := session.CheckSingleShard(targets); err != nil {
+		allErrors.RecordError(err)
+		return nil, allErrors.AggrError(stc.aggregateErrors)
+	}
+
 	qrs = &tproto.QueryResultList{}
 	qrs.List = make([]mproto.QueryResult, batchRequest.Length)
 	var resMutex sync.Mutex
 
 	var wg sync.WaitGroup
 	for _, req := range batchRequest.Requests {
+		logStatsFromContext(ctx).addShards(req.Keyspace, []string{req.Shard})
 		wg.Add(1)
 		go func(req *shardBatchRequest) {
 			statsKey := []string{"ExecuteBatch", req.Keyspace, req.Shard, string(tabletType)}
@@ -279,6 +291,7 @@ func (stc *ScatterConn) ExecuteBatch(
 			defer stc.timings.Record(statsKey, startTime)
 
 			sdc := stc.getConnection(ctx, req.Keyspace, req.Shard, tabletType)
+			sdc = stc.readYourWritesConn(ctx, sdc, req.Keyspace, req.Shard, tabletType, session)
 			transactionID, err := stc.updateSession(ctx, sdc, req.Keyspace, req.Shard, tabletType, session, false)
 			if err != nil {
 				allErrors.RecordError(err)
@@ -429,7 +442,137 @@ func (stc *ScatterConn) StreamExecuteMulti(
 	return allErrors.AggrError(stc.aggregateErrors)
 }
 
+// ExecuteMerge is like ExecuteMulti, but the results from the
+// shards are merge-sorted, and the offset and limit are applied
+// as specified by merge.
+func (stc *ScatterConn) ExecuteMerge(
+	ctx context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+	notInTransaction bool,
+	merge *mergeParams,
+) (*mproto.QueryResult, error) {
+	shards := getShards(shardVars)
+	results, allErrors := stc.multiGo(
+		ctx,
+		"Execute",
+		keyspace,
+		shards,
+		tabletType,
+		session,
+		notInTransaction,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			innerqr, err := sdc.Execute(ctx, query, shardVars[sdc.shard], transactionId)
+			if err != nil {
+				return err
+			}
+			sResults <- &shardResult{shard: sdc.shard, qr: innerqr}
+			sResults <- &shardResult{shard: sdc.shard}
+			return nil
+		})
+
+	qr := new(mproto.QueryResult)
+	merger := newResultMerger(merge, shards)
+	var mergeErr error
+	for sr := range results {
+		// We still need to finish pumping
+		if mergeErr != nil {
+			continue
+		}
+		var rows [][]sqltypes.Value
+		rows, mergeErr = merger.Add(sr.(*shardResult))
+		qr.Rows = append(qr.Rows, rows...)
+	}
+	if allErrors.HasErrors() {
+		return nil, allErrors.AggrError(stc.aggregateErrors)
+	}
+	if mergeErr != nil {
+		return nil, mergeErr
+	}
+	qr.Rows = append(qr.Rows, merger.Finish()...)
+	qr.Fields = merger.Fields()
+	qr.RowsAffected = uint64(len(qr.Rows))
+	return qr, nil
+}
+
+// StreamExecuteMerge is like StreamExecuteMulti, but the results
+// from the shards are merge-sorted, and the offset and limit are
+// applied as specified by merge. Rows are sent as soon as their
+// position in the merged result is known.
+func (stc *ScatterConn) StreamExecuteMerge(
+	ctx context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+	merge *mergeParams,
+	sendReply func(reply *mproto.QueryResult) error,
+	notInTransaction bool,
+) error {
+	shards := getShards(shardVars)
+	results, allErrors := stc.multiGo(
+		ctx,
+		"StreamExecute",
+		keyspace,
+		shards,
+		tabletType,
+		session,
+		notInTransaction,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			sr, errFunc := sdc.StreamExecute(ctx, query, shardVars[sdc.shard], transactionId)
+			if sr != nil {
+				for qr := range sr {
+					sResults <- &shardResult{shard: sdc.shard, qr: qr}
+				}
+			}
+			sResults <- &shardResult{shard: sdc.shard}
+			return errFunc()
+		})
+	merger := newResultMerger(merge, shards)
+	fieldSent := false
+	send := func(rows [][]sqltypes.Value) error {
+		// only send field info once for scattered streaming
+		if !fieldSent && merger.Fields() != nil {
+			fieldSent = true
+			if err := sendReply(&mproto.QueryResult{Fields: merger.Fields()}); err != nil {
+				return err
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return sendReply(&mproto.QueryResult{Rows: rows})
+	}
+	var replyErr error
+	for sr := range results {
+		// We still need to finish pumping
+		if replyErr != nil {
+			continue
+		}
+		var rows [][]sqltypes.Value
+		rows, replyErr = merger.Add(sr.(*shardResult))
+		if replyErr != nil {
+			continue
+		}
+		replyErr = send(rows)
+	}
+	if replyErr == nil && !allErrors.HasErrors() {
+		replyErr = send(merger.Finish())
+	}
+	if replyErr != nil {
+		allErrors.RecordError(replyErr)
+	}
+	return allErrors.AggrError(stc.aggregateErrors)
+}
+
 // Commit commits the current transaction. There are no retries on this operation.
+// If -twopc_enable is set, multi-shard transactions use a two-phase commit.
+// Otherwise, if the session asked for read-your-writes, the replication
+// positions of the master commits are saved in the session.
 func (stc *ScatterConn) Commit(ctx context.Context, session *SafeSession) (err error) {
 	if session == nil {
 		return fmt.Errorf("cannot commit: empty session")
@@ -437,6 +580,11 @@ func (stc *ScatterConn) Commit(ctx context.Context, session *SafeSession) (err e
 	if !session.InTransaction() {
 		return fmt.Errorf("cannot commit: not in transaction")
 	}
+	if *twopcEnable && len(session.ShardSessions) > 1 {
+		err = stc.commit2PC(ctx, session)
+		session.Reset()
+		return err
+	}
 	committing := true
 	for _, shardSession := range session.ShardSessions {
 		sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
@@ -444,9 +592,21 @@ func (stc *ScatterConn) Commit(ctx context.Context, session *SafeSession) (err e
 			sdc.Rollback(ctx, shardSession.TransactionId)
 			continue
 		}
-		if err = sdc.Commit(ctx, shardSession.TransactionId); err != nil {
+		if !session.ReadYourWrites || shardSession.TabletType != topo.TYPE_MASTER {
+			if err = sdc.Commit(ctx, shardSession.TransactionId); err != nil {
+				committing = false
+			}
+			continue
+		}
+		var position string
+		if position, err = sdc.CommitWithPosition(ctx, shardSession.TransactionId); err != nil {
 			committing = false
+			continue
 		}
+		if position == "" {
+			position = unknownPosition
+		}
+		session.RecordPosition(shardSession.Keyspace, shardSession.Shard, position)
 	}
 	session.Reset()
 	return err
@@ -570,6 +730,49 @@ func (stc *ScatterConn) Close() error {
 	return nil
 }
 
+// ShardBalancerStatus is the status of the Balancer of a ShardConn.
+type ShardBalancerStatus struct {
+	Keyspace   string
+	Shard      string
+	TabletType topo.TabletType
+	Policy     string
+	EndPoints  []*EndPointStatus
+}
+
+// BalancerStatus returns the status of the Balancers of the
+// replica and rdonly ShardConns, sorted by keyspace, shard and type.
+func (stc *ScatterConn) BalancerStatus() []*ShardBalancerStatus {
+	stc.mu.Lock()
+	keys := make([]string, 0, len(stc.shardConns))
+	for key, sdc := range stc.shardConns {
+		if sdc.tabletType != topo.TYPE_MASTER {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	shardConns := make([]*ShardConn, 0, len(keys))
+	for _, key := range keys {
+		shardConns = append(shardConns, stc.shardConns[key])
+	}
+	stc.mu.Unlock()
+
+	result := make([]*ShardBalancerStatus, 0, len(shardConns))
+	for _, sdc := range shardConns {
+		status := &ShardBalancerStatus{
+			Keyspace:   sdc.keyspace,
+			Shard:      sdc.shard,
+			TabletType: sdc.tabletType,
+			Policy:     balancerPolicyShuffle,
+			EndPoints:  sdc.balancer.Status(),
+		}
+		if sdc.balancer.UsesHealth() {
+			status.Policy = balancerPolicyHealth
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
 // ScatterConnError is the ScatterConn specific error.
 type ScatterConnError struct {
 	Code int
@@ -630,8 +833,21 @@ func (stc *ScatterConn) multiGo(
 	notInTransaction bool,
 	action shardActionFunc,
 ) (rResults <-chan interface{}, allErrors *concurrency.AllErrorRecorder) {
+	logStatsFromContext(ctx).addShards(keyspace, shards)
+	shardCounterFromContext(ctx).add(len(shards))
 	allErrors = new(concurrency.AllErrorRecorder)
 	results := make(chan interface{}, len(shards))
+	if !notInTransaction {
+		targets := make([]keyspaceShard, 0, len(shards))
+		for _, shard := range shards {
+			targets = append(targets, keyspaceShard{keyspace, shard})
+		}
+		if err := session.CheckSingleShard(targets); err != nil {
+			allErrors.RecordError(err)
+			close(results)
+			return results, allErrors
+		}
+	}
 	var wg sync.WaitGroup
 	for shard := range unique(shards) {
 		wg.Add(1)
@@ -642,6 +858,7 @@ func (stc *ScatterConn) multiGo(
 			defer stc.timings.Record(statsKey, startTime)
 
 			sdc := stc.getConnection(ctx, keyspace, shard, tabletType)
+			sdc = stc.readYourWritesConn(ctx, sdc, keyspace, shard, tabletType, session)
 			transactionID, err := stc.updateSession(ctx, sdc, keyspace, shard, tabletType, session, notInTransaction)
 			if err != nil {
 				allErrors.RecordError(err)
@@ -720,12 +937,17 @@ func (stc *ScatterConn) updateSession(
 	if err != nil {
 		return 0, err
 	}
-	session.Append(&proto.ShardSession{
+	err = session.Append(&proto.ShardSession{
 		Keyspace:      keyspace,
 		TabletType:    tabletType,
 		Shard:         shard,
 		TransactionId: transactionID,
 	})
+	if err != nil {
+		// The transaction we just started is not part of the session.
+		sdc.Rollback(ctx, transactionID)
+		return 0, err
+	}
 	return transactionID, nil
 }
 
@@ -16,6 +16,9 @@ import (
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
 )
 
 // This file uses the sandbox_test framework.
@@ -324,6 +327,121 @@ func TestScatterConnRollback(t *testing.T) {
 	}
 }
 
+func TestScatterConnCommit2PC(t *testing.T) {
+	*twopcEnable = true
+	defer func() { *twopcEnable = false }()
+	s := createSandbox("TestScatterConnCommit2PC")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommit2PC", []string{"0"}, topo.TYPE_MASTER, session, false)
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommit2PC", []string{"0", "1"}, topo.TYPE_MASTER, session, false)
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	wantSession := proto.Session{}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%+v, got\n%+v", wantSession, *session.Session)
+	}
+	wantParticipants := []*pbq.Target{{
+		Keyspace:   "TestScatterConnCommit2PC",
+		Shard:      "1",
+		TabletType: pb.TabletType_MASTER,
+	}}
+	if !reflect.DeepEqual(sbc0.Participants, wantParticipants) {
+		t.Errorf("Participants: %+v, want %+v", sbc0.Participants, wantParticipants)
+	}
+	counts := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"sbc0.CreateTransaction", sbc0.CreateTransactionCount.Get(), 1},
+		{"sbc0.StartCommit", sbc0.StartCommitCount.Get(), 1},
+		{"sbc0.ConcludeTransaction", sbc0.ConcludeTransactionCount.Get(), 1},
+		{"sbc0.Commit", sbc0.CommitCount.Get(), 0},
+		{"sbc1.Prepare", sbc1.PrepareCount.Get(), 1},
+		{"sbc1.CommitPrepared", sbc1.CommitPreparedCount.Get(), 1},
+		{"sbc1.Commit", sbc1.CommitCount.Get(), 0},
+	}
+	for _, c := range counts {
+		if c.got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestScatterConnCommit2PCPrepareFail(t *testing.T) {
+	*twopcEnable = true
+	defer func() { *twopcEnable = false }()
+	s := createSandbox("TestScatterConnCommit2PCPrepareFail")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommit2PCPrepareFail", []string{"0"}, topo.TYPE_MASTER, session, false)
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommit2PCPrepareFail", []string{"0", "1"}, topo.TYPE_MASTER, session, false)
+	sbc1.mustFailPrepare = 1
+	if err := stc.Commit(context.Background(), session); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	wantSession := proto.Session{}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%+v, got\n%+v", wantSession, *session.Session)
+	}
+	counts := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"sbc0.CreateTransaction", sbc0.CreateTransactionCount.Get(), 1},
+		{"sbc0.StartCommit", sbc0.StartCommitCount.Get(), 0},
+		{"sbc0.Rollback", sbc0.RollbackCount.Get(), 1},
+		{"sbc0.ConcludeTransaction", sbc0.ConcludeTransactionCount.Get(), 1},
+		{"sbc1.Prepare", sbc1.PrepareCount.Get(), 1},
+		{"sbc1.RollbackPrepared", sbc1.RollbackPreparedCount.Get(), 1},
+		{"sbc1.CommitPrepared", sbc1.CommitPreparedCount.Get(), 0},
+	}
+	for _, c := range counts {
+		if c.got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestScatterConnCommit2PCReplica(t *testing.T) {
+	*twopcEnable = true
+	defer func() { *twopcEnable = false }()
+	s := createSandbox("TestScatterConnCommit2PCReplica")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommit2PCReplica", []string{"0"}, topo.TYPE_REPLICA, session, false)
+	stc.Execute(context.Background(), "query1", nil, "TestScatterConnCommit2PCReplica", []string{"0", "1"}, topo.TYPE_REPLICA, session, false)
+	err := stc.Commit(context.Background(), session)
+	want := "two-phase commit requires master tablets, got replica for TestScatterConnCommit2PCReplica/0"
+	if err == nil || err.Error() != want {
+		t.Errorf("Commit: %v, want %s", err, want)
+	}
+	if rollbackCount := sbc1.RollbackCount.Get(); rollbackCount != 1 {
+		t.Errorf("want 1, got %d", rollbackCount)
+	}
+	if createCount := sbc0.CreateTransactionCount.Get(); createCount != 0 {
+		t.Errorf("want 0, got %d", createCount)
+	}
+}
+
 func TestScatterConnClose(t *testing.T) {
 	s := createSandbox("TestScatterConnClose")
 	sbc := &sandboxConn{}
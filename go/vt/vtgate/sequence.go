@@ -0,0 +1,105 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// sequenceReserve reserves a block of values from a sequence table.
+// A sequence table lives in an unsharded keyspace, and has a single
+// row with id 0. Its next_id column contains the next value that
+// has not been handed out yet:
+//
+//	create table user_seq(id int, next_id bigint, primary key(id))
+//
+// last_insert_id makes the new value available as the InsertId
+// of the result.
+const sequenceReserve = "update %s set next_id = last_insert_id(next_id + :cache) where id = 0"
+
+// sequenceBlock is a range of reserved values of a sequence.
+// next is the next value to be handed out, and end is the first
+// value past the block. mu is held while a new block is reserved,
+// so that concurrent callers of the same sequence wait for it
+// instead of reserving blocks of their own.
+type sequenceBlock struct {
+	mu        sync.Mutex
+	next, end int64
+}
+
+// sequencer hands out values from sequence tables. It reserves
+// them in blocks of Sequence.Cache values, which are served from
+// memory. Unused values are lost when vtgate restarts.
+type sequencer struct {
+	// mu only protects blocks. The round trip of a reservation
+	// is done under the lock of the sequenceBlock, so that it
+	// doesn't stall the other sequences.
+	mu     sync.Mutex
+	blocks map[string]*sequenceBlock
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{blocks: make(map[string]*sequenceBlock)}
+}
+
+// Next returns the next value of seq. If the current block is
+// exhausted, it reserves a new one.
+func (sqr *sequencer) Next(ctx context.Context, rtr *Router, seq *planbuilder.Sequence) (int64, error) {
+	block := sqr.block(seq.Name)
+	block.mu.Lock()
+	defer block.mu.Unlock()
+	if block.next >= block.end {
+		end, err := sqr.reserve(ctx, rtr, seq)
+		if err != nil {
+			return 0, fmt.Errorf("sequence %s: %v", seq.Name, err)
+		}
+		block.next = end - seq.Cache
+		block.end = end
+	}
+	val := block.next
+	block.next++
+	return val, nil
+}
+
+// block returns the sequenceBlock of the sequence name, creating
+// an empty one if needed.
+func (sqr *sequencer) block(name string) *sequenceBlock {
+	sqr.mu.Lock()
+	defer sqr.mu.Unlock()
+	block, ok := sqr.blocks[name]
+	if !ok {
+		block = &sequenceBlock{}
+		sqr.blocks[name] = block
+	}
+	return block
+}
+
+// reserve fetches a new block from the sequence table, and returns
+// its end. The update is sent to the master outside of any
+// transaction of the caller, so that a rollback cannot hand out
+// the same values twice.
+func (sqr *sequencer) reserve(ctx context.Context, rtr *Router, seq *planbuilder.Sequence) (int64, error) {
+	query := &proto.Query{
+		Sql:           fmt.Sprintf(sequenceReserve, seq.Name),
+		BindVariables: map[string]interface{}{"cache": seq.Cache},
+		TabletType:    topo.TYPE_MASTER,
+	}
+	result, err := rtr.Execute(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	if result.RowsAffected != 1 {
+		return 0, fmt.Errorf("sequence row not found")
+	}
+	return int64(result.InsertId), nil
+}
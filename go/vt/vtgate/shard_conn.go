@@ -19,10 +19,23 @@ import (
 	"github.com/youtube/vitess/go/vt/topo"
 	"golang.org/x/net/context"
 
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
 	pb "github.com/youtube/vitess/go/vt/proto/topodata"
 )
 
-var danglingTabletConn = stats.NewInt("DanglingTabletConn")
+var (
+	danglingTabletConn = stats.NewInt("DanglingTabletConn")
+
+	// crossCellQueries counts the queries that were served
+	// by an end point of another cell, by serving cell.
+	crossCellQueries = stats.NewMultiCounters("VtgateCrossCellQueryCount", []string{"Keyspace", "ShardName", "DbType", "ServingCell"})
+)
+
+// fallbackCellServer is implemented by the SrvTopoServers that can
+// serve the end points of a cell from another cell.
+type fallbackCellServer interface {
+	FallbackCell(cell, keyspace, shard string, tabletType pb.TabletType) string
+}
 
 // ShardConn represents a load balanced connection to a group
 // of vttablets that belong to the same shard. ShardConn can
@@ -41,30 +54,53 @@ type ShardConn struct {
 	consolidator       *sync2.Consolidator
 	ticker             *timer.RandTicker
 
+	// buffer is only set for the master, with -enable_buffer.
+	buffer *masterBuffer
+	// latencies is only set for replica and rdonly, with -hedging_percentile.
+	latencies *latencyTracker
+
+	// fallbackCells maps the uids of the current end points to
+	// their cell, if they were served by another cell. The map
+	// is replaced, never modified. It's protected by mu.
+	fallbackCells map[uint32]string
+
 	connectTimings *stats.MultiTimings
 
+	// pinned is set for the ShardConns returned by WaitForPosition.
+	// They only use conn, which belongs to another ShardConn.
+	pinned bool
+
 	// conn needs a mutex because it can change during the lifetime of ShardConn.
 	mu   sync.Mutex
 	conn tabletconn.TabletConn
+	// hedgeConn is the connection used by hedged requests.
+	hedgeConn tabletconn.TabletConn
 }
 
 // NewShardConn creates a new ShardConn. It creates a Balancer using
 // serv, cell, keyspace, tabletType and retryDelay. retryCount is the max
 // number of retries before a ShardConn returns an error on an operation.
 func NewShardConn(ctx context.Context, serv SrvTopoServer, cell, keyspace, shard string, tabletType topo.TabletType, retryDelay time.Duration, retryCount int, connTimeoutTotal, connTimeoutPerConn, connLife time.Duration, tabletConnectTimings *stats.MultiTimings) *ShardConn {
+	var sdc *ShardConn
 	getAddresses := func() (*pb.EndPoints, error) {
 		endpoints, _, err := serv.GetEndPoints(ctx, cell, keyspace, shard, topo.TabletTypeToProto(tabletType))
 		if err != nil {
 			return nil, fmt.Errorf("endpoints fetch error: %v", err)
 		}
+		if fcs, ok := serv.(fallbackCellServer); ok {
+			sdc.setFallbackCells(endpoints, fcs.FallbackCell(cell, keyspace, shard, topo.TabletTypeToProto(tabletType)))
+		}
 		return endpoints, nil
 	}
 	blc := NewBalancer(getAddresses, retryDelay)
 	var ticker *timer.RandTicker
 	if tabletType != topo.TYPE_MASTER {
 		ticker = timer.NewRandTicker(connLife, connLife/2)
+		if *balancerPolicy == balancerPolicyHealth {
+			blc.UseHealth(serv, cell, keyspace, shard, connTimeoutPerConn)
+		}
 	}
-	sdc := &ShardConn{
+	sdc = &ShardConn{
 		keyspace:           keyspace,
 		shard:              shard,
 		tabletType:         tabletType,
@@ -78,6 +114,12 @@ func NewShardConn(ctx context.Context, serv SrvTopoServer, cell, keyspace, shard
 		consolidator:       sync2.NewConsolidator(),
 		connectTimings:     tabletConnectTimings,
 	}
+	if tabletType == topo.TYPE_MASTER && *enableBuffer {
+		sdc.buffer = newMasterBuffer(keyspace, shard, sdc.hasNewMaster, sdc.closeCurrent)
+	}
+	if tabletType != topo.TYPE_MASTER && *hedgingPercentile > 0 {
+		sdc.latencies = newLatencyTracker(*hedgingPercentile, *hedgingMinDelay)
+	}
 	if ticker != nil {
 		go func() {
 			for range ticker.C {
@@ -115,11 +157,21 @@ func (sdc *ShardConn) Dial(ctx context.Context) error {
 
 // Execute executes a non-streaming query on vttablet. If there are connection errors,
 // it retries retryCount times before failing. It does not retry if the connection is in
-// the middle of a transaction.
+// the middle of a transaction. Selects outside of a transaction may be hedged.
 func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (qr *mproto.QueryResult, err error) {
+	if sdc.latencies != nil && transactionID == 0 && isSelect(query) {
+		return sdc.hedgedExecute(ctx, query, bindVars)
+	}
+	return sdc.execute(ctx, query, bindVars, transactionID)
+}
+
+func (sdc *ShardConn) execute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (qr *mproto.QueryResult, err error) {
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		var innerErr error
+		startTime := time.Now()
 		qr, innerErr = conn.Execute2(ctx, query, bindVars, transactionID)
+		sdc.recordLatency(conn, startTime, innerErr)
+		sdc.recordCrossCell(conn, innerErr)
 		return innerErr
 	}, transactionID, false)
 	return qr, err
@@ -129,7 +181,10 @@ func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[st
 func (sdc *ShardConn) ExecuteBatch(ctx context.Context, queries []tproto.BoundQuery, asTransaction bool, transactionID int64) (qrs *tproto.QueryResultList, err error) {
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
 		var innerErr error
+		startTime := time.Now()
 		qrs, innerErr = conn.ExecuteBatch2(ctx, queries, asTransaction, transactionID)
+		sdc.recordLatency(conn, startTime, innerErr)
+		sdc.recordCrossCell(conn, innerErr)
 		return innerErr
 	}, transactionID, false)
 	return qrs, err
@@ -144,6 +199,7 @@ func (sdc *ShardConn) StreamExecute(ctx context.Context, query string, bindVars
 		var err error
 		results, erFunc, err = conn.StreamExecute2(ctx, query, bindVars, transactionID)
 		usedConn = conn
+		sdc.recordCrossCell(conn, err)
 		return err
 	}, transactionID, true)
 	if err != nil {
@@ -177,6 +233,107 @@ func (sdc *ShardConn) Rollback(ctx context.Context, transactionID int64) (err er
 	}, transactionID, false)
 }
 
+// Prepare prepares the transaction for a two-phase commit.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) Prepare(ctx context.Context, transactionID int64, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.Prepare(ctx, transactionID, dtid)
+	}, transactionID, false)
+}
+
+// CommitPrepared commits a prepared transaction. It's idempotent,
+// so it can be retried outside of a transaction.
+func (sdc *ShardConn) CommitPrepared(ctx context.Context, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.CommitPrepared(ctx, dtid)
+	}, 0, false)
+}
+
+// RollbackPrepared rolls back a prepared transaction, or the
+// transaction originalID if it was not prepared yet.
+func (sdc *ShardConn) RollbackPrepared(ctx context.Context, dtid string, originalID int64) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.RollbackPrepared(ctx, dtid, originalID)
+	}, 0, false)
+}
+
+// CreateTransaction saves the metadata of a distributed transaction.
+func (sdc *ShardConn) CreateTransaction(ctx context.Context, dtid string, participants []*pbq.Target) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.CreateTransaction(ctx, dtid, participants)
+	}, 0, false)
+}
+
+// StartCommit records the commit decision of a distributed transaction
+// and commits its transaction on the metadata shard.
+func (sdc *ShardConn) StartCommit(ctx context.Context, transactionID int64, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.StartCommit(ctx, transactionID, dtid)
+	}, transactionID, false)
+}
+
+// ConcludeTransaction deletes the metadata of a resolved distributed transaction.
+func (sdc *ShardConn) ConcludeTransaction(ctx context.Context, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.ConcludeTransaction(ctx, dtid)
+	}, 0, false)
+}
+
+// CommitWithPosition commits the current transaction, and returns the
+// replication position of the master after the commit.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) CommitWithPosition(ctx context.Context, transactionID int64) (position string, err error) {
+	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		var innerErr error
+		position, innerErr = conn.CommitWithPosition(ctx, transactionID)
+		return innerErr
+	}, transactionID, false)
+	return position, err
+}
+
+// WaitForPosition waits until a tablet has applied a replication
+// position, for at most timeout. It returns a ShardConn pinned to that
+// tablet: the reads that need the position must use it, because a
+// reconnect, a retry or a hedged request could send them to another
+// tablet, which may not have caught up.
+func (sdc *ShardConn) WaitForPosition(ctx context.Context, position string, timeout time.Duration) (pinned *ShardConn, err error) {
+	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		if innerErr := conn.WaitForPosition(ctx, position, timeout); innerErr != nil {
+			return innerErr
+		}
+		pinned = sdc.pin(conn)
+		return nil
+	}, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return pinned, nil
+}
+
+// pin returns a ShardConn that only uses conn. It doesn't hedge,
+// retry or reconnect, and it doesn't close conn, which still
+// belongs to sdc. It must not be closed.
+func (sdc *ShardConn) pin(conn tabletconn.TabletConn) *ShardConn {
+	sdc.mu.Lock()
+	fallbackCells := sdc.fallbackCells
+	sdc.mu.Unlock()
+	return &ShardConn{
+		keyspace:           sdc.keyspace,
+		shard:              sdc.shard,
+		tabletType:         sdc.tabletType,
+		retryDelay:         sdc.retryDelay,
+		connTimeoutTotal:   sdc.connTimeoutTotal,
+		connTimeoutPerConn: sdc.connTimeoutPerConn,
+		connLife:           sdc.connLife,
+		balancer:           sdc.balancer,
+		consolidator:       sync2.NewConsolidator(),
+		connectTimings:     sdc.connectTimings,
+		fallbackCells:      fallbackCells,
+		pinned:             true,
+		conn:               conn,
+	}
+}
+
 // SplitQuery splits a query into sub queries. The retry rules are the same as Execute.
 func (sdc *ShardConn) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitColumn string, splitCount int) (queries []tproto.QuerySplit, err error) {
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
@@ -193,6 +350,13 @@ func (sdc *ShardConn) Close() {
 		sdc.ticker.Stop()
 	}
 	sdc.closeCurrent()
+	sdc.mu.Lock()
+	hedgeConn := sdc.hedgeConn
+	sdc.mu.Unlock()
+	if hedgeConn != nil {
+		sdc.closeHedgeConn(hedgeConn)
+	}
+	sdc.balancer.Close()
 }
 
 func (sdc *ShardConn) closeCurrent() {
@@ -201,6 +365,10 @@ func (sdc *ShardConn) closeCurrent() {
 	if sdc.conn == nil {
 		return
 	}
+	if sdc.pinned {
+		sdc.conn = nil
+		return
+	}
 	go func(conn tabletconn.TabletConn) {
 		danglingTabletConn.Add(1)
 		conn.Close()
@@ -215,11 +383,37 @@ func (sdc *ShardConn) closeCurrent() {
 // a resharding event, and set the re-resolve bit and let the upper layers
 // re-resolve and retry.
 func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn.TabletConn) error, transactionID int64, isStreaming bool) error {
+	inTransaction := (transactionID != 0)
+	if sdc.buffer != nil && !inTransaction {
+		if err := sdc.buffer.wait(ctx); err != nil {
+			return sdc.WrapError(err, nil, inTransaction)
+		}
+	}
+	endPoint, usedEndPoint, err := sdc.retry(ctx, action, transactionID, isStreaming)
+	if sdc.buffer != nil && !inTransaction && isFailoverError(ctx, err, endPoint == nil) {
+		// The master may be failing over: hold the request
+		// until a new master is available, and replay it once.
+		sdc.buffer.startFailover(usedEndPoint, err)
+		if bufErr := sdc.buffer.wait(ctx); bufErr != nil {
+			return sdc.WrapError(bufErr, endPoint, inTransaction)
+		}
+		endPoint, _, err = sdc.retry(ctx, action, transactionID, isStreaming)
+	}
+	if err == nil && !inTransaction && !isStreaming && sdc.balancer.ShouldReplace(endPoint.Uid) {
+		// The end point became unhealthy or started lagging:
+		// the next query will use a better one.
+		sdc.closeCurrent()
+	}
+	return sdc.WrapError(err, endPoint, inTransaction)
+}
+
+// retry executes the action, and retries it retryCount times
+// on connection errors, as described in withRetry. It returns the
+// end point of the last attempt, which is nil if it couldn't connect,
+// and the last end point the action was executed on.
+func (sdc *ShardConn) retry(ctx context.Context, action func(conn tabletconn.TabletConn) error, transactionID int64, isStreaming bool) (endPoint, usedEndPoint *pb.EndPoint, err error) {
 	var conn tabletconn.TabletConn
-	var endPoint *pb.EndPoint
-	var err error
 	var isTimeout bool
-	inTransaction := (transactionID != 0)
 	// execute the action at least once even without retrying
 	for i := 0; i < sdc.retryCount+1; i++ {
 		conn, endPoint, isTimeout, err = sdc.getConn(ctx)
@@ -230,13 +424,81 @@ func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn
 			time.Sleep(sdc.retryDelay)
 			continue
 		}
+		usedEndPoint = endPoint
 		err = action(conn)
 		if sdc.canRetry(ctx, err, transactionID, conn, isStreaming) {
 			continue
 		}
 		break
 	}
-	return sdc.WrapError(err, endPoint, inTransaction)
+	return endPoint, usedEndPoint, err
+}
+
+// isFailoverError returns true if err means that the master may be
+// failing over, and that the request didn't reach MySQL: the master
+// doesn't serve queries anymore, or no connection could be made
+// (connectFailed). Other errors, like operational errors, may happen
+// after the statement was executed, and replaying it could apply it
+// twice. That's also why canRetry doesn't retry them.
+func isFailoverError(ctx context.Context, err error, connectFailed bool) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if serverError, ok := err.(*tabletconn.ServerError); ok {
+		return serverError.Code == tabletconn.ERR_RETRY || serverError.Code == tabletconn.ERR_FATAL
+	}
+	return connectFailed
+}
+
+// hasNewMaster returns true if the shard has a master
+// other than oldMaster. It's used by the master buffer.
+func (sdc *ShardConn) hasNewMaster(oldMaster *pb.EndPoint) bool {
+	endPoints, err := sdc.balancer.Get()
+	if err != nil {
+		return false
+	}
+	for _, endPoint := range endPoints {
+		if oldMaster == nil || endPoint.Uid != oldMaster.Uid {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLatency reports the latency of a successful query to the balancer.
+func (sdc *ShardConn) recordLatency(conn tabletconn.TabletConn, startTime time.Time, err error) {
+	if err == nil {
+		sdc.balancer.RecordLatency(conn.EndPoint().Uid, time.Now().Sub(startTime))
+	}
+}
+
+// setFallbackCells records that endPoints were served
+// by fallbackCell, or by the local cell if it's empty.
+func (sdc *ShardConn) setFallbackCells(endPoints *pb.EndPoints, fallbackCell string) {
+	var fallbackCells map[uint32]string
+	if fallbackCell != "" {
+		fallbackCells = make(map[uint32]string)
+		for _, endPoint := range endPoints.Entries {
+			fallbackCells[endPoint.Uid] = fallbackCell
+		}
+	}
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	sdc.fallbackCells = fallbackCells
+}
+
+// recordCrossCell counts a successful query if it was
+// served by an end point of another cell.
+func (sdc *ShardConn) recordCrossCell(conn tabletconn.TabletConn, err error) {
+	if err != nil {
+		return
+	}
+	sdc.mu.Lock()
+	servingCell, ok := sdc.fallbackCells[conn.EndPoint().Uid]
+	sdc.mu.Unlock()
+	if ok {
+		crossCellQueries.Add([]string{sdc.keyspace, sdc.shard, string(sdc.tabletType), servingCell}, 1)
+	}
 }
 
 type connectResult struct {
@@ -257,6 +519,10 @@ func (sdc *ShardConn) getConn(ctx context.Context) (conn tabletconn.TabletConn,
 		sdc.mu.Unlock()
 		return conn, endPoint, false, nil
 	}
+	if sdc.pinned {
+		sdc.mu.Unlock()
+		return nil, nil, false, fmt.Errorf("pinned tablet connection is not usable anymore")
+	}
 
 	key := fmt.Sprintf("%s.%s.%s", sdc.keyspace, sdc.shard, sdc.tabletType)
 	q, ok := sdc.consolidator.Create(key)
@@ -380,6 +646,10 @@ func (sdc *ShardConn) markDown(conn tabletconn.TabletConn, reason string) {
 		return
 	}
 	sdc.balancer.MarkDown(conn.EndPoint().Uid, reason)
+	if sdc.pinned {
+		sdc.conn = nil
+		return
+	}
 
 	go func(conn tabletconn.TabletConn) {
 		danglingTabletConn.Add(1)
@@ -14,6 +14,8 @@ import (
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/topo"
 	"golang.org/x/net/context"
+
+	pbt "github.com/youtube/vitess/go/vt/proto/topodata"
 )
 
 // This file uses the sandbox_test framework.
@@ -799,3 +801,48 @@ func TestMasterShardConnLife(t *testing.T) {
 	}
 	sdc.Close()
 }
+
+// fallbackTopo is a sandboxTopo that reports that
+// the end points are served by fallbackCell.
+type fallbackTopo struct {
+	sandboxTopo
+	fallbackCell string
+}
+
+func (ft *fallbackTopo) FallbackCell(cell, keyspace, shard string, tabletType pbt.TabletType) string {
+	return ft.fallbackCell
+}
+
+func TestShardConnCrossCellQueries(t *testing.T) {
+	s := createSandbox("TestShardConnCrossCellQueries")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	statsKey := "TestShardConnCrossCellQueries.0.replica.bb"
+
+	sdc := NewShardConn(context.Background(), &fallbackTopo{fallbackCell: "bb"}, "aa", "TestShardConnCrossCellQueries", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	for i := 0; i < 2; i++ {
+		if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := crossCellQueries.Counts()[statsKey]; got != 2 {
+		t.Errorf("%v: %v, want 2", statsKey, got)
+	}
+	sdc.Close()
+
+	// Failed queries and local end points are not counted.
+	sbc.mustFailServer = 1
+	sdc = NewShardConn(context.Background(), &fallbackTopo{fallbackCell: "bb"}, "aa", "TestShardConnCrossCellQueries", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err == nil {
+		t.Errorf("Execute: nil, want error")
+	}
+	sdc.Close()
+	sdc = NewShardConn(context.Background(), &fallbackTopo{}, "aa", "TestShardConnCrossCellQueries", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	sdc.Close()
+	if got := crossCellQueries.Counts()[statsKey]; got != 2 {
+		t.Errorf("%v: %v, want 2", statsKey, got)
+	}
+}
@@ -26,6 +26,7 @@ var (
 	srvTopoCacheTTL    = flag.Duration("srv_topo_cache_ttl", 1*time.Second, "how long to use cached entries for topology")
 	enableRemoteMaster = flag.Bool("enable_remote_master", false, "enable remote master access")
 	srvTopoTimeout     = flag.Duration("srv_topo_timeout", 2*time.Second, "topo server timeout")
+	cellPreferences    = flag.String("cell_preferences", "", "semicolon-separated list of keyspace:cell1,cell2 entries. If a cell has no healthy replica or rdonly end point for a shard of the keyspace, the listed cells are tried in order. A * keyspace applies to the keyspaces that are not listed. The cells of -healthcheck_cells don't fall back")
 )
 
 const (
@@ -34,6 +35,7 @@ const (
 	errorCategory       = "error"
 	remoteQueryCategory = "remote-query"
 	remoteErrorCategory = "remote-error"
+	crossCellCategory   = "cross-cell"
 )
 
 // SrvTopoServer is a subset of topo.Server that only contains the serving
@@ -56,7 +58,9 @@ type ResilientSrvTopoServer struct {
 	topoServer         topo.Server
 	cacheTTL           time.Duration
 	enableRemoteMaster bool
-	counts             *stats.Counters
+	// cellPreferences maps a keyspace to the cells to fall back to.
+	cellPreferences map[string][]string
+	counts          *stats.Counters
 
 	// mutex protects the cache map itself, not the individual
 	// values in the cache.
@@ -165,6 +169,10 @@ type endPointsEntry struct {
 
 	lastError    error
 	lastErrorCtx context.Context
+
+	// fallbackCell is the cell the end points were served from
+	// by the last GetEndPoints, if it's not cell.
+	fallbackCell string
 }
 
 func endPointIsHealthy(ep *pb.EndPoint) bool {
@@ -200,13 +208,44 @@ func filterUnhealthyServers(endPoints *pb.EndPoints) *pb.EndPoints {
 	return endPoints
 }
 
+// hasHealthyEndPoints returns true if a GetEndPoints result
+// can be served. Results are filtered, so if the first end point
+// is unhealthy, they all are.
+func hasHealthyEndPoints(endPoints *pb.EndPoints, err error) bool {
+	return err == nil && endPoints != nil && len(endPoints.Entries) > 0 && endPointIsHealthy(endPoints.Entries[0])
+}
+
+// parseCellPreferences parses the value of -cell_preferences.
+func parseCellPreferences(value string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	if value == "" {
+		return result, nil
+	}
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid cell preference %q, expected keyspace:cell1,cell2", entry)
+		}
+		if _, ok := result[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate cell preference for keyspace %v", parts[0])
+		}
+		result[parts[0]] = strings.Split(parts[1], ",")
+	}
+	return result, nil
+}
+
 // NewResilientSrvTopoServer creates a new ResilientSrvTopoServer
 // based on the provided SrvTopoServer.
 func NewResilientSrvTopoServer(base topo.Server, counterPrefix string) *ResilientSrvTopoServer {
+	preferences, err := parseCellPreferences(*cellPreferences)
+	if err != nil {
+		log.Fatalf("Invalid cell_preferences: %v", err)
+	}
 	return &ResilientSrvTopoServer{
 		topoServer:         base,
 		cacheTTL:           *srvTopoCacheTTL,
 		enableRemoteMaster: *enableRemoteMaster,
+		cellPreferences:    preferences,
 		counts:             stats.NewCounters(counterPrefix + "Counts"),
 
 		srvKeyspaceNamesCache: make(map[string]*srvKeyspaceNamesEntry),
@@ -375,16 +414,58 @@ func (server *ResilientSrvTopoServer) GetSrvShard(ctx context.Context, cell, key
 }
 
 // GetEndPoints return all endpoints for the given cell, keyspace, shard, and tablet type.
-func (server *ResilientSrvTopoServer) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType pb.TabletType) (result *pb.EndPoints, version int64, err error) {
-	shard = strings.ToLower(shard)
-	key := []string{cell, keyspace, shard, strings.ToLower(tabletType.String())}
+// For non-master tablet types, if the cell has no healthy end point,
+// the cells listed in -cell_preferences for the keyspace are tried in order.
+func (server *ResilientSrvTopoServer) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType pb.TabletType) (*pb.EndPoints, int64, error) {
+	result, version, err := server.getCellEndPoints(ctx, cell, keyspace, shard, tabletType)
+	if tabletType == pb.TabletType_MASTER || hasHealthyEndPoints(result, err) {
+		server.setFallbackCell(cell, keyspace, shard, tabletType, "")
+		return result, version, err
+	}
+	preferences, ok := server.cellPreferences[keyspace]
+	if !ok {
+		preferences = server.cellPreferences["*"]
+	}
+	for _, fallbackCell := range preferences {
+		if fallbackCell == cell {
+			continue
+		}
+		fallbackResult, fallbackVersion, fallbackErr := server.getCellEndPoints(ctx, fallbackCell, keyspace, shard, tabletType)
+		if !hasHealthyEndPoints(fallbackResult, fallbackErr) {
+			continue
+		}
+		server.counts.Add(crossCellCategory, 1)
+		server.setFallbackCell(cell, keyspace, shard, tabletType, fallbackCell)
+		return fallbackResult, fallbackVersion, nil
+	}
+	server.setFallbackCell(cell, keyspace, shard, tabletType, "")
+	return result, version, err
+}
 
-	server.counts.Add(queryCategory, 1)
-	server.endPointCounters.queries.Add(key, 1)
+// FallbackCell returns the cell the last GetEndPoints for cell,
+// keyspace, shard and tabletType was served from, if it's not cell.
+// It's used by ShardConn to count the queries served by another cell.
+func (server *ResilientSrvTopoServer) FallbackCell(cell, keyspace, shard string, tabletType pb.TabletType) string {
+	entry := server.getEndPointsEntry(cell, keyspace, shard, tabletType)
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	return entry.fallbackCell
+}
 
-	// find the entry in the cache, add it if not there
-	keyStr := strings.Join(key, ".")
+// setFallbackCell records the cell the end points were served from.
+func (server *ResilientSrvTopoServer) setFallbackCell(cell, keyspace, shard string, tabletType pb.TabletType, fallbackCell string) {
+	entry := server.getEndPointsEntry(cell, keyspace, shard, tabletType)
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.fallbackCell = fallbackCell
+}
+
+// getEndPointsEntry finds the entry in the cache, and adds it if not there.
+func (server *ResilientSrvTopoServer) getEndPointsEntry(cell, keyspace, shard string, tabletType pb.TabletType) *endPointsEntry {
+	shard = strings.ToLower(shard)
+	keyStr := strings.Join([]string{cell, keyspace, shard, strings.ToLower(tabletType.String())}, ".")
 	server.mutex.Lock()
+	defer server.mutex.Unlock()
 	entry, ok := server.endPointsCache[keyStr]
 	if !ok {
 		entry = &endPointsEntry{
@@ -395,7 +476,18 @@ func (server *ResilientSrvTopoServer) GetEndPoints(ctx context.Context, cell, ke
 		}
 		server.endPointsCache[keyStr] = entry
 	}
-	server.mutex.Unlock()
+	return entry
+}
+
+// getCellEndPoints returns the endpoints of a single cell, using the cache.
+func (server *ResilientSrvTopoServer) getCellEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType pb.TabletType) (result *pb.EndPoints, version int64, err error) {
+	shard = strings.ToLower(shard)
+	key := []string{cell, keyspace, shard, strings.ToLower(tabletType.String())}
+
+	server.counts.Add(queryCategory, 1)
+	server.endPointCounters.queries.Add(key, 1)
+
+	entry := server.getEndPointsEntry(cell, keyspace, shard, tabletType)
 
 	// Lock the entry, and do everything holding the lock.  This
 	// means two concurrent requests will only issue one
@@ -621,11 +713,21 @@ type EndPointsCacheStatus struct {
 	OriginalValue *pb.EndPoints
 	LastError     error
 	LastErrorCtx  context.Context
+	FallbackCell  string
 }
 
 // StatusAsHTML returns an HTML version of our status.
 // It works best if there is data in the cache.
 func (st *EndPointsCacheStatus) StatusAsHTML() template.HTML {
+	status := st.cellStatusAsHTML()
+	if st.FallbackCell != "" {
+		status += template.HTML(fmt.Sprintf(", <b>serving from cell %v</b>", template.HTMLEscapeString(st.FallbackCell)))
+	}
+	return status
+}
+
+// cellStatusAsHTML returns the status of the end points of the cell.
+func (st *EndPointsCacheStatus) cellStatusAsHTML() template.HTML {
 	ovl := 0
 	if st.OriginalValue != nil {
 		ovl = len(st.OriginalValue.Entries)
@@ -752,6 +854,7 @@ func (server *ResilientSrvTopoServer) CacheStatus() *ResilientSrvTopoServerCache
 			OriginalValue: entry.originalValue,
 			LastError:     entry.lastError,
 			LastErrorCtx:  entry.lastErrorCtx,
+			FallbackCell:  entry.fallbackCell,
 		})
 		entry.mutex.Unlock()
 	}
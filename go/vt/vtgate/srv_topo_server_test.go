@@ -352,3 +352,120 @@ func TestCachedErrors(t *testing.T) {
 		t.Fatalf("GetSrvKeyspace was not called again: %v times", ft.callCount)
 	}
 }
+
+// fakeTopoCellPreferences returns the end points in endPoints,
+// and an error for the other cells.
+type fakeTopoCellPreferences struct {
+	fakeTopo
+	endPoints map[string]*pb.EndPoints
+}
+
+func (ft *fakeTopoCellPreferences) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType pb.TabletType) (*pb.EndPoints, int64, error) {
+	if ep, ok := ft.endPoints[cell]; ok {
+		return ep, -1, nil
+	}
+	return nil, -1, fmt.Errorf("No endpoints")
+}
+
+// TestCellPreferences will test the fall back to other cells.
+func TestCellPreferences(t *testing.T) {
+	ft := &fakeTopoCellPreferences{
+		endPoints: map[string]*pb.EndPoints{
+			"cell1": &pb.EndPoints{},
+			"cell2": &pb.EndPoints{
+				Entries: []*pb.EndPoint{
+					&pb.EndPoint{
+						Uid:       2,
+						HealthMap: map[string]string{topo.ReplicationLag: topo.ReplicationLagHigh},
+					},
+				},
+			},
+			"cell3": &pb.EndPoints{
+				Entries: []*pb.EndPoint{
+					&pb.EndPoint{
+						Uid: 3,
+					},
+				},
+			},
+		},
+	}
+	rsts := NewResilientSrvTopoServer(ft, "TestCellPreferences")
+	var err error
+	rsts.cellPreferences, err = parseCellPreferences("test_ks:cell1,cell2,cell3;*:cell2")
+	if err != nil {
+		t.Fatalf("parseCellPreferences failed: %v", err)
+	}
+
+	// cell2 is degraded, so cell3 is used
+	ep, _, err := rsts.GetEndPoints(context.Background(), "cell1", "test_ks", "0", pb.TabletType_REPLICA)
+	if err != nil {
+		t.Fatalf("GetEndPoints got unexpected error: %v", err)
+	}
+	if len(ep.Entries) != 1 || ep.Entries[0].Uid != 3 {
+		t.Fatalf("GetEndPoints got %v want uid 3", ep)
+	}
+	if got := rsts.FallbackCell("cell1", "test_ks", "0", pb.TabletType_REPLICA); got != "cell3" {
+		t.Errorf("FallbackCell got %v want cell3", got)
+	}
+	status := rsts.CacheStatus()
+	for _, st := range status.EndPoints {
+		want := ""
+		if st.Cell == "cell1" {
+			want = "cell3"
+		}
+		if st.FallbackCell != want {
+			t.Errorf("FallbackCell for %v got %v want %v", st.Cell, st.FallbackCell, want)
+		}
+	}
+
+	// no healthy end point in the listed cells: the local result is returned
+	ep, _, err = rsts.GetEndPoints(context.Background(), "cell4", "other_ks", "0", pb.TabletType_RDONLY)
+	if err == nil {
+		t.Fatalf("GetEndPoints did not return an error: %v", ep)
+	}
+
+	// no fall back for master
+	ep, _, err = rsts.GetEndPoints(context.Background(), "cell1", "test_ks", "0", pb.TabletType_MASTER)
+	if err != nil || len(ep.Entries) != 0 {
+		t.Fatalf("GetEndPoints got %v, %v want no end point", ep, err)
+	}
+
+	// the local cell is used again when it has healthy end points
+	ft.endPoints["cell1"] = &pb.EndPoints{
+		Entries: []*pb.EndPoint{
+			&pb.EndPoint{
+				Uid: 1,
+			},
+		},
+	}
+	rsts.cacheTTL = 0
+	ep, _, err = rsts.GetEndPoints(context.Background(), "cell1", "test_ks", "0", pb.TabletType_REPLICA)
+	if err != nil || len(ep.Entries) != 1 || ep.Entries[0].Uid != 1 {
+		t.Fatalf("GetEndPoints got %v, %v want uid 1", ep, err)
+	}
+	if got := rsts.FallbackCell("cell1", "test_ks", "0", pb.TabletType_REPLICA); got != "" {
+		t.Errorf("FallbackCell got %v want empty", got)
+	}
+	if counts := rsts.counts.Counts()[crossCellCategory]; counts != 1 {
+		t.Errorf("crossCellCategory count got %v want 1", counts)
+	}
+}
+
+func TestParseCellPreferences(t *testing.T) {
+	for _, value := range []string{"test_ks", "test_ks:", ":cell1", "test_ks:cell1;test_ks:cell2"} {
+		if _, err := parseCellPreferences(value); err == nil {
+			t.Errorf("parseCellPreferences(%q) did not return an error", value)
+		}
+	}
+	got, err := parseCellPreferences("ks1:cell1,cell2;*:cell3")
+	if err != nil {
+		t.Fatalf("parseCellPreferences failed: %v", err)
+	}
+	want := map[string][]string{
+		"ks1": []string{"cell1", "cell2"},
+		"*":   []string{"cell3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCellPreferences got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+var (
+	twopcEnable = flag.Bool("twopc_enable", false, "use two-phase commit for transactions that span multiple shards. The vttablets must also be started with -twopc-enable")
+
+	// twoPCCounts counts the outcomes of two-phase commits.
+	twoPCCounts = stats.NewCounters("VtgateTwoPC")
+)
+
+// commit2PC commits a multi-shard transaction atomically. The first
+// shard of the session is the metadata manager: it stores the state
+// of the distributed transaction, and its commit is the commit decision.
+// The other shards are the participants. If vtgate fails half-way, the
+// vttablet of the metadata manager resolves the transaction.
+func (stc *ScatterConn) commit2PC(ctx context.Context, session *SafeSession) error {
+	mm := session.ShardSessions[0]
+	participants := session.ShardSessions[1:]
+	for _, shardSession := range session.ShardSessions {
+		if shardSession.TabletType != topo.TYPE_MASTER {
+			stc.rollbackAll(ctx, session.ShardSessions)
+			return fmt.Errorf("two-phase commit requires master tablets, got %s for %s/%s", shardSession.TabletType, shardSession.Keyspace, shardSession.Shard)
+		}
+	}
+	dtid := fmt.Sprintf("%s:%s:%d", mm.Keyspace, mm.Shard, mm.TransactionId)
+	mmConn := stc.getConnection(ctx, mm.Keyspace, mm.Shard, mm.TabletType)
+
+	targets := make([]*pbq.Target, 0, len(participants))
+	for _, shardSession := range participants {
+		targets = append(targets, &pbq.Target{
+			Keyspace:   shardSession.Keyspace,
+			Shard:      shardSession.Shard,
+			TabletType: pb.TabletType_MASTER,
+		})
+	}
+	if err := mmConn.CreateTransaction(ctx, dtid, targets); err != nil {
+		// Nothing was recorded yet: a regular rollback is enough.
+		stc.rollbackAll(ctx, session.ShardSessions)
+		twoPCCounts.Add("Rollback", 1)
+		return err
+	}
+
+	err := stc.forEachParticipant(ctx, participants, func(sdc *ShardConn, shardSession *proto.ShardSession) error {
+		return sdc.Prepare(ctx, shardSession.TransactionId, dtid)
+	})
+	if err != nil {
+		// The transaction of the metadata manager was not committed,
+		// so the decision is to roll back.
+		mmConn.Rollback(ctx, mm.TransactionId)
+		rbErr := stc.forEachParticipant(ctx, participants, func(sdc *ShardConn, shardSession *proto.ShardSession) error {
+			return sdc.RollbackPrepared(ctx, dtid, shardSession.TransactionId)
+		})
+		if rbErr != nil {
+			// The vttablet of the metadata manager will finish the rollback.
+			log.Warningf("Rollback of distributed transaction %s is incomplete: %v", dtid, rbErr)
+			twoPCCounts.Add("Unresolved", 1)
+		} else {
+			mmConn.ConcludeTransaction(ctx, dtid)
+		}
+		twoPCCounts.Add("Rollback", 1)
+		return err
+	}
+
+	if err := mmConn.StartCommit(ctx, mm.TransactionId, dtid); err != nil {
+		// The decision is unknown. The vttablet of the metadata
+		// manager will resolve the transaction.
+		twoPCCounts.Add("Unresolved", 1)
+		return err
+	}
+
+	// The transaction is committed. Failures from now on only
+	// delay the visibility of the changes on the participants.
+	err = stc.forEachParticipant(ctx, participants, func(sdc *ShardConn, shardSession *proto.ShardSession) error {
+		return sdc.CommitPrepared(ctx, dtid)
+	})
+	if err != nil {
+		log.Warningf("Commit of distributed transaction %s is incomplete: %v", dtid, err)
+		twoPCCounts.Add("Unresolved", 1)
+	} else if err := mmConn.ConcludeTransaction(ctx, dtid); err != nil {
+		log.Warningf("ConcludeTransaction failed for distributed transaction %s: %v", dtid, err)
+	}
+	twoPCCounts.Add("Commit", 1)
+	return nil
+}
+
+// forEachParticipant calls action in parallel for all shardSessions,
+// and returns the aggregated errors.
+func (stc *ScatterConn) forEachParticipant(ctx context.Context, shardSessions []*proto.ShardSession, action func(*ShardConn, *proto.ShardSession) error) error {
+	var wg sync.WaitGroup
+	allErrors := new(concurrency.AllErrorRecorder)
+	for _, shardSession := range shardSessions {
+		wg.Add(1)
+		go func(shardSession *proto.ShardSession) {
+			defer wg.Done()
+			sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+			if err := action(sdc, shardSession); err != nil {
+				allErrors.RecordError(err)
+			}
+		}(shardSession)
+	}
+	wg.Wait()
+	return allErrors.AggrError(stc.aggregateErrors)
+}
+
+// rollbackAll rolls back the transactions of all shardSessions.
+func (stc *ScatterConn) rollbackAll(ctx context.Context, shardSessions []*proto.ShardSession) {
+	for _, shardSession := range shardSessions {
+		sdc := stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		sdc.Rollback(ctx, shardSession.TransactionId)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func (rtr *Router) execSelectUnion(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	left, err := rtr.execSubplan(vcursor, plan.Left, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	right, err := rtr.execSubplan(vcursor, plan.Right, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	if len(left.Fields) != len(right.Fields) {
+		return nil, fmt.Errorf("execSelectUnion: the selects of the union return a different number of columns: %d, %d", len(left.Fields), len(right.Fields))
+	}
+	result := &mproto.QueryResult{Fields: left.Fields}
+	if plan.IsDistinct {
+		seen := make(map[string]bool)
+		for _, rows := range [][][]sqltypes.Value{left.Rows, right.Rows} {
+			for _, row := range rows {
+				key := rowKey(result.Fields, row)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				result.Rows = append(result.Rows, row)
+			}
+		}
+	} else {
+		result.Rows = append(left.Rows, right.Rows...)
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result, nil
+}
+
+// streamSelectUnion streams the rows of both sides for a UNION ALL.
+// A UNION cannot send any row until it has seen all the rows of both
+// sides. So, it's effectively non-streaming.
+func (rtr *Router) streamSelectUnion(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	if plan.IsDistinct {
+		result, err := rtr.execSelectUnion(vcursor, plan)
+		if err != nil {
+			return err
+		}
+		if err := sendReply(&mproto.QueryResult{Fields: result.Fields}); err != nil {
+			return err
+		}
+		if len(result.Rows) == 0 {
+			return nil
+		}
+		return sendReply(&mproto.QueryResult{Rows: result.Rows})
+	}
+	err := rtr.streamPlan(newSubplanContext(vcursor, plan.Left, vcursor.query.BindVariables), plan.Left, sendReply)
+	if err != nil {
+		return err
+	}
+	// The fields were already sent by the left side.
+	return rtr.streamPlan(newSubplanContext(vcursor, plan.Right, vcursor.query.BindVariables), plan.Right, func(qr *mproto.QueryResult) error {
+		if len(qr.Rows) == 0 {
+			return nil
+		}
+		rows := *qr
+		rows.Fields = nil
+		return sendReply(&rows)
+	})
+}
+
+// rowKey returns a key that uniquely identifies the values
+// of the row, as compared by MySQL: numbers are compared by
+// value, and strings with their collation.
+func rowKey(fields []mproto.Field, row []sqltypes.Value) string {
+	var buf bytes.Buffer
+	var lenbuf [binary.MaxVarintLen64]byte
+	for i, v := range row {
+		if v.IsNull() {
+			buf.WriteByte(0)
+			continue
+		}
+		buf.WriteByte(1)
+		key := v.Raw()
+		if i < len(fields) {
+			key = valueKey(fields[i], v)
+		}
+		buf.Write(lenbuf[:binary.PutUvarint(lenbuf[:], uint64(len(key)))])
+		buf.Write(key)
+	}
+	return buf.String()
+}
@@ -0,0 +1,85 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var binMD5 planbuilder.Vindex
+
+func init() {
+	hv, err := planbuilder.CreateVindex("binary_md5", nil)
+	if err != nil {
+		panic(err)
+	}
+	binMD5 = hv
+}
+
+func TestBinaryMD5Cost(t *testing.T) {
+	if binMD5.Cost() != 1 {
+		t.Errorf("Cost(): %d, want 1", binMD5.Cost())
+	}
+}
+
+func TestBinaryMD5Map(t *testing.T) {
+	got, err := binMD5.(planbuilder.Unique).Map(nil, []interface{}{"test", []byte("test"), "Test"})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []key.KeyspaceId{
+		"\x09\x8f\x6b\xcd\x46\x21\xd3\x73\xca\xde\x4e\x83\x26\x27\xb4\xf6",
+		"\x09\x8f\x6b\xcd\x46\x21\xd3\x73\xca\xde\x4e\x83\x26\x27\xb4\xf6",
+		"\x0c\xbc\x66\x11\xf5\x54\x0b\xd0\x80\x9a\x38\x8d\xc9\x5a\x61\x5b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+}
+
+func TestBinaryMD5MapBadData(t *testing.T) {
+	_, err := binMD5.(planbuilder.Unique).Map(nil, []interface{}{1})
+	want := "BinaryMD5.Map: unexpected type for 1: int"
+	if err == nil || err.Error() != want {
+		t.Errorf("binMD5.Map: %v, want %v", err, want)
+	}
+}
+
+func TestBinaryMD5Verify(t *testing.T) {
+	success, err := binMD5.Verify(nil, "test", "\x09\x8f\x6b\xcd\x46\x21\xd3\x73\xca\xde\x4e\x83\x26\x27\xb4\xf6")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+	success, err = binMD5.Verify(nil, "Test", "\x09\x8f\x6b\xcd\x46\x21\xd3\x73\xca\xde\x4e\x83\x26\x27\xb4\xf6")
+	if err != nil {
+		t.Error(err)
+	}
+	if success {
+		t.Errorf("Verify(): %+v, want false", success)
+	}
+}
+
+func TestBinaryMD5Functional(t *testing.T) {
+	vind, ok := binMD5.(planbuilder.Functional)
+	if !ok {
+		t.Fatalf("binMD5.(planbuilder.Functional): false, want true")
+	}
+	if err := vind.Create(nil, "test"); err != nil {
+		t.Error(err)
+	}
+	if err := vind.Delete(nil, []interface{}{"test"}, ""); err != nil {
+		t.Error(err)
+	}
+	if _, ok := binMD5.(planbuilder.Reversible); ok {
+		t.Errorf("binMD5.(planbuilder.Reversible): true, want false")
+	}
+}
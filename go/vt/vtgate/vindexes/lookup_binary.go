@@ -0,0 +1,103 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func init() {
+	planbuilder.Register("lookup_binary", NewLookupBinary)
+	planbuilder.Register("lookup_binary_unique", NewLookupBinaryUnique)
+}
+
+//====================================================================
+
+// LookupBinary defines a vindex that uses a lookup table.
+// Unlike LookupHash, the To column of the table contains the
+// keyspace id itself. This allows the target table to use
+// a primary vindex that's not Reversible, like BinaryMD5.
+// The table is expected to define the id column as unique.
+// It's NonUnique and a Lookup.
+type LookupBinary struct {
+	lkp lookup
+}
+
+// NewLookupBinary creates a LookupBinary vindex.
+func NewLookupBinary(m map[string]interface{}) (planbuilder.Vindex, error) {
+	lb := &LookupBinary{}
+	lb.lkp.Init(m)
+	lb.lkp.binary = true
+	return lb, nil
+}
+
+// Cost returns the cost of this vindex as 20.
+func (vind *LookupBinary) Cost() int {
+	return 20
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (vind *LookupBinary) Map(vcursor planbuilder.VCursor, ids []interface{}) ([][]key.KeyspaceId, error) {
+	return vind.lkp.Map2(vcursor, ids)
+}
+
+// Verify returns true if id maps to ksid.
+func (vind *LookupBinary) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	return vind.lkp.Verify(vcursor, id, ksid)
+}
+
+// Create reserves the id by inserting it into the vindex table.
+func (vind *LookupBinary) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) error {
+	return vind.lkp.Create(vcursor, id, ksid)
+}
+
+// Delete deletes the entry from the vindex table.
+func (vind *LookupBinary) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
+	return vind.lkp.Delete(vcursor, ids, ksid)
+}
+
+//====================================================================
+
+// LookupBinaryUnique defines a vindex that uses a lookup table.
+// Unlike LookupHashUnique, the To column of the table contains
+// the keyspace id itself. The table is expected to define the id
+// column as unique. It's Unique and a Lookup.
+type LookupBinaryUnique struct {
+	lkp lookup
+}
+
+// NewLookupBinaryUnique creates a LookupBinaryUnique vindex.
+func NewLookupBinaryUnique(m map[string]interface{}) (planbuilder.Vindex, error) {
+	lbu := &LookupBinaryUnique{}
+	lbu.lkp.Init(m)
+	lbu.lkp.binary = true
+	return lbu, nil
+}
+
+// Cost returns the cost of this vindex as 10.
+func (vind *LookupBinaryUnique) Cost() int {
+	return 10
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (vind *LookupBinaryUnique) Map(vcursor planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	return vind.lkp.Map1(vcursor, ids)
+}
+
+// Verify returns true if id maps to ksid.
+func (vind *LookupBinaryUnique) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	return vind.lkp.Verify(vcursor, id, ksid)
+}
+
+// Create reserves the id by inserting it into the vindex table.
+func (vind *LookupBinaryUnique) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) error {
+	return vind.lkp.Create(vcursor, id, ksid)
+}
+
+// Delete deletes the entry from the vindex table.
+func (vind *LookupBinaryUnique) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
+	return vind.lkp.Delete(vcursor, ids, ksid)
+}
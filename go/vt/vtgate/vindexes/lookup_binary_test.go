@@ -0,0 +1,148 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var lb, lbu planbuilder.Vindex
+
+func init() {
+	h, err := planbuilder.CreateVindex("lookup_binary", map[string]interface{}{"Table": "t", "From": "fromc", "To": "toc"})
+	if err != nil {
+		panic(err)
+	}
+	lb = h
+	h, err = planbuilder.CreateVindex("lookup_binary_unique", map[string]interface{}{"Table": "t", "From": "fromc", "To": "toc"})
+	if err != nil {
+		panic(err)
+	}
+	lbu = h
+}
+
+func binaryResult(ksids ...string) *mproto.QueryResult {
+	result := &mproto.QueryResult{
+		Fields: []mproto.Field{{
+			Type: mproto.VT_VAR_STRING,
+		}},
+		RowsAffected: uint64(len(ksids)),
+	}
+	for _, ksid := range ksids {
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.MakeString([]byte(ksid)),
+		})
+	}
+	return result
+}
+
+func TestLookupBinaryCost(t *testing.T) {
+	if lb.Cost() != 20 {
+		t.Errorf("Cost(): %d, want 20", lb.Cost())
+	}
+	if lbu.Cost() != 10 {
+		t.Errorf("Cost(): %d, want 10", lbu.Cost())
+	}
+}
+
+func TestLookupBinaryMap(t *testing.T) {
+	vc := &vcursor{result: binaryResult("\x01\x02", "\x03\x04")}
+	got, err := lb.(planbuilder.NonUnique).Map(vc, []interface{}{"a@b.com"})
+	if err != nil {
+		t.Error(err)
+	}
+	want := [][]key.KeyspaceId{{
+		"\x01\x02",
+		"\x03\x04",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %+v", got, want)
+	}
+	wantQuery := &tproto.BoundQuery{
+		Sql: "select toc from t where fromc = :fromc",
+		BindVariables: map[string]interface{}{
+			"fromc": "a@b.com",
+		},
+	}
+	if !reflect.DeepEqual(vc.query, wantQuery) {
+		t.Errorf("vc.query = %#v, want %#v", vc.query, wantQuery)
+	}
+}
+
+func TestLookupBinaryUniqueMap(t *testing.T) {
+	vc := &vcursor{result: binaryResult("\x01\x02")}
+	got, err := lbu.(planbuilder.Unique).Map(vc, []interface{}{"a@b.com"})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []key.KeyspaceId{"\x01\x02"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %+v", got, want)
+	}
+}
+
+func TestLookupBinaryVerify(t *testing.T) {
+	vc := &vcursor{numRows: 1}
+	success, err := lbu.Verify(vc, "a@b.com", "\x01\x02")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+	wantQuery := &tproto.BoundQuery{
+		Sql: "select fromc from t where fromc = :fromc and toc = :toc",
+		BindVariables: map[string]interface{}{
+			"fromc": "a@b.com",
+			"toc":   []byte("\x01\x02"),
+		},
+	}
+	if !reflect.DeepEqual(vc.query, wantQuery) {
+		t.Errorf("vc.query = %#v, want %#v", vc.query, wantQuery)
+	}
+}
+
+func TestLookupBinaryCreate(t *testing.T) {
+	vc := &vcursor{}
+	err := lb.(planbuilder.Lookup).Create(vc, "a@b.com", "\x01\x02")
+	if err != nil {
+		t.Error(err)
+	}
+	wantQuery := &tproto.BoundQuery{
+		Sql: "insert into t(fromc, toc) values(:fromc, :toc)",
+		BindVariables: map[string]interface{}{
+			"fromc": "a@b.com",
+			"toc":   []byte("\x01\x02"),
+		},
+	}
+	if !reflect.DeepEqual(vc.query, wantQuery) {
+		t.Errorf("vc.query = %#v, want %#v", vc.query, wantQuery)
+	}
+}
+
+func TestLookupBinaryDelete(t *testing.T) {
+	vc := &vcursor{}
+	err := lbu.(planbuilder.Lookup).Delete(vc, []interface{}{"a@b.com"}, "\x01\x02")
+	if err != nil {
+		t.Error(err)
+	}
+	wantQuery := &tproto.BoundQuery{
+		Sql: "delete from t where fromc in ::fromc and toc = :toc",
+		BindVariables: map[string]interface{}{
+			"fromc": []interface{}{"a@b.com"},
+			"toc":   []byte("\x01\x02"),
+		},
+	}
+	if !reflect.DeepEqual(vc.query, wantQuery) {
+		t.Errorf("vc.query = %#v, want %#v", vc.query, wantQuery)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/vt/key"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
@@ -199,9 +200,13 @@ func (vind *LookupHashUniqueAuto) Delete(vcursor planbuilder.VCursor, ids []inte
 //====================================================================
 
 // lookup implements the functions for the Lookup vindexes.
+// If binary is false, the To column contains the number that
+// hashes to the keyspace id. Otherwise, it contains the keyspace
+// id itself.
 type lookup struct {
 	Table, From, To    string
 	sel, ver, ins, del string
+	binary             bool
 }
 
 func (lkp *lookup) Init(m map[string]interface{}) {
@@ -243,15 +248,11 @@ func (lkp *lookup) Map1(vcursor planbuilder.VCursor, ids []interface{}) ([]key.K
 		if len(result.Rows) != 1 {
 			return nil, fmt.Errorf("lookup.Map: unexpected multiple results from vindex %s: %v", lkp.Table, id)
 		}
-		inum, err := mproto.Convert(result.Fields[0], result.Rows[0][0])
+		ksid, err := lkp.toKeyspaceId(result.Fields[0], result.Rows[0][0])
 		if err != nil {
 			return nil, fmt.Errorf("lookup.Map: %v", err)
 		}
-		num, err := getNumber(inum)
-		if err != nil {
-			return nil, fmt.Errorf("lookup.Map: %v", err)
-		}
-		out = append(out, vhash(num))
+		out = append(out, ksid)
 	}
 	return out, nil
 }
@@ -272,15 +273,11 @@ func (lkp *lookup) Map2(vcursor planbuilder.VCursor, ids []interface{}) ([][]key
 		}
 		var ksids []key.KeyspaceId
 		for _, row := range result.Rows {
-			inum, err := mproto.Convert(result.Fields[0], row[0])
-			if err != nil {
-				return nil, fmt.Errorf("lookup.Map: %v", err)
-			}
-			num, err := getNumber(inum)
+			ksid, err := lkp.toKeyspaceId(result.Fields[0], row[0])
 			if err != nil {
 				return nil, fmt.Errorf("lookup.Map: %v", err)
 			}
-			ksids = append(ksids, vhash(num))
+			ksids = append(ksids, ksid)
 		}
 		out = append(out, ksids)
 	}
@@ -289,7 +286,7 @@ func (lkp *lookup) Map2(vcursor planbuilder.VCursor, ids []interface{}) ([][]key
 
 // Verify returns true if id maps to ksid.
 func (lkp *lookup) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
-	val, err := vunhash(ksid)
+	val, err := lkp.fromKeyspaceId(ksid)
 	if err != nil {
 		return false, fmt.Errorf("lookup.Verify: %v", err)
 	}
@@ -312,7 +309,7 @@ func (lkp *lookup) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.
 
 // Create creates an association between id and ksid by inserting a row in the vindex table.
 func (lkp *lookup) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) error {
-	val, err := vunhash(ksid)
+	val, err := lkp.fromKeyspaceId(ksid)
 	if err != nil {
 		return fmt.Errorf("lookup.Create: %v", err)
 	}
@@ -331,7 +328,7 @@ func (lkp *lookup) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.
 
 // Generate generates an id and associates the ksid to the new id.
 func (lkp *lookup) Generate(vcursor planbuilder.VCursor, ksid key.KeyspaceId) (id int64, err error) {
-	val, err := vunhash(ksid)
+	val, err := lkp.fromKeyspaceId(ksid)
 	if err != nil {
 		return 0, fmt.Errorf("lookup.Generate: %v", err)
 	}
@@ -351,7 +348,7 @@ func (lkp *lookup) Generate(vcursor planbuilder.VCursor, ksid key.KeyspaceId) (i
 
 // Delete deletes the association between ids and ksid.
 func (lkp *lookup) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
-	val, err := vunhash(ksid)
+	val, err := lkp.fromKeyspaceId(ksid)
 	if err != nil {
 		return fmt.Errorf("lookup.Delete: %v", err)
 	}
@@ -367,3 +364,27 @@ func (lkp *lookup) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid k
 	}
 	return nil
 }
+
+// toKeyspaceId converts the value of the To column to a keyspace id.
+func (lkp *lookup) toKeyspaceId(field mproto.Field, v sqltypes.Value) (key.KeyspaceId, error) {
+	if lkp.binary {
+		return key.KeyspaceId(v.Raw()), nil
+	}
+	inum, err := mproto.Convert(field, v)
+	if err != nil {
+		return "", err
+	}
+	num, err := getNumber(inum)
+	if err != nil {
+		return "", err
+	}
+	return vhash(num), nil
+}
+
+// fromKeyspaceId converts a keyspace id to the value of the To column.
+func (lkp *lookup) fromKeyspaceId(ksid key.KeyspaceId) (interface{}, error) {
+	if lkp.binary {
+		return []byte(ksid), nil
+	}
+	return vunhash(ksid)
+}
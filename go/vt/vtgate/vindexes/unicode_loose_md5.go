@@ -0,0 +1,72 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/mysql/collation"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// UnicodeLooseMD5 defines a vindex that hashes a string to a
+// KeyspaceId by using MD5 on its utf8_general_ci collation key.
+// The collation ignores case, accents and trailing spaces, so that
+// values that compare equal in a VARCHAR column with the default
+// collation map to the same KeyspaceId. It's Unique and Functional,
+// but it's not Reversible.
+type UnicodeLooseMD5 struct{}
+
+// NewUnicodeLooseMD5 creates a new UnicodeLooseMD5.
+func NewUnicodeLooseMD5(_ map[string]interface{}) (planbuilder.Vindex, error) {
+	return UnicodeLooseMD5{}, nil
+}
+
+// Cost returns the cost of this vindex as 1.
+func (UnicodeLooseMD5) Cost() int {
+	return 1
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (UnicodeLooseMD5) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		data, err := getBytes(id)
+		if err != nil {
+			return nil, fmt.Errorf("UnicodeLooseMD5.Map: %v", err)
+		}
+		out = append(out, binHash(normalize(data)))
+	}
+	return out, nil
+}
+
+// Verify returns true if id maps to ksid.
+func (UnicodeLooseMD5) Verify(_ planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	data, err := getBytes(id)
+	if err != nil {
+		return false, fmt.Errorf("UnicodeLooseMD5.Verify: %v", err)
+	}
+	return binHash(normalize(data)) == ksid, nil
+}
+
+// Create is a no-op because there's no vindex table.
+func (UnicodeLooseMD5) Create(planbuilder.VCursor, interface{}) error {
+	return nil
+}
+
+// Delete is a no-op because there's no vindex table.
+func (UnicodeLooseMD5) Delete(planbuilder.VCursor, []interface{}, key.KeyspaceId) error {
+	return nil
+}
+
+// normalize returns the collation key of data.
+func normalize(data []byte) []byte {
+	return collation.Key(data)
+}
+
+func init() {
+	planbuilder.Register("unicode_loose_md5", NewUnicodeLooseMD5)
+}
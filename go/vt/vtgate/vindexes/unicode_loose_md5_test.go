@@ -0,0 +1,105 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var unicodeMD5 planbuilder.Vindex
+
+func init() {
+	hv, err := planbuilder.CreateVindex("unicode_loose_md5", nil)
+	if err != nil {
+		panic(err)
+	}
+	unicodeMD5 = hv
+}
+
+func TestUnicodeLooseMD5Cost(t *testing.T) {
+	if unicodeMD5.Cost() != 1 {
+		t.Errorf("Cost(): %d, want 1", unicodeMD5.Cost())
+	}
+}
+
+func TestUnicodeLooseMD5Map(t *testing.T) {
+	tcases := []struct {
+		in, out interface{}
+		equal   bool
+	}{
+		{"test", "test", true},
+		{"Test", "test", true},
+		{"TEST", "test", true},
+		{"tést", "test", true},
+		{"test  ", "test", true},
+		{[]byte("Test"), "test", true},
+		{"test", "tests", false},
+		{" test", "test", false},
+		{"Straße", "Strase", true},
+		{"Straße", "Strasse", false},
+	}
+	for _, tcase := range tcases {
+		got, err := unicodeMD5.(planbuilder.Unique).Map(nil, []interface{}{tcase.in, tcase.out})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if (got[0] == got[1]) != tcase.equal {
+			t.Errorf("Map(%v) == Map(%v): %v, want %v", tcase.in, tcase.out, got[0] == got[1], tcase.equal)
+		}
+	}
+}
+
+// TestUnicodeLooseMD5MapGolden guards against changes of the
+// collation keys, which would move existing rows to other shards.
+func TestUnicodeLooseMD5MapGolden(t *testing.T) {
+	got, err := unicodeMD5.(planbuilder.Unique).Map(nil, []interface{}{"test", "Straße", "über", "日本語", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []key.KeyspaceId{
+		"\xbd\x0b\x2f\x9f\xd9\x1c\xa3\x21\xa0\xa8\x7d\x0a\xd6\x7d\x2b\xe5",
+		"\x5a\x2a\xd9\x54\x84\x8c\x4c\x51\x8d\x90\x58\xf5\x23\xa8\x3d\x89",
+		"\xea\x2e\x0a\x02\x8e\x3d\xec\x24\xff\x6c\x37\x30\x82\x03\xa1\xae",
+		"\x75\x2e\x1a\x43\x7d\xff\x53\x5f\x31\x5a\x3a\xe9\x2b\xfb\xa0\x62",
+		"\xd4\x1d\x8c\xd9\x8f\x00\xb2\x04\xe9\x80\x09\x98\xec\xf8\x42\x7e",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+}
+
+func TestUnicodeLooseMD5MapBadData(t *testing.T) {
+	_, err := unicodeMD5.(planbuilder.Unique).Map(nil, []interface{}{1})
+	want := "UnicodeLooseMD5.Map: unexpected type for 1: int"
+	if err == nil || err.Error() != want {
+		t.Errorf("unicodeMD5.Map: %v, want %v", err, want)
+	}
+}
+
+func TestUnicodeLooseMD5Verify(t *testing.T) {
+	ksids, err := unicodeMD5.(planbuilder.Unique).Map(nil, []interface{}{"test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	success, err := unicodeMD5.Verify(nil, "TÉST", ksids[0])
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+	success, err = unicodeMD5.Verify(nil, "other", ksids[0])
+	if err != nil {
+		t.Error(err)
+	}
+	if success {
+		t.Errorf("Verify(): %+v, want false", success)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// VSchemaWatcher keeps the schema of a Router in sync with
+// the vschema stored in the topo.
+type VSchemaWatcher struct {
+	router     *Router
+	schemafier topo.Schemafier
+
+	mu           sync.Mutex
+	stopWatching chan<- struct{}
+	loaded       bool
+	version      int64
+	lastUpdate   time.Time
+	lastError    error
+}
+
+// NewVSchemaWatcher creates a new VSchemaWatcher for router.
+func NewVSchemaWatcher(router *Router, schemafier topo.Schemafier) *VSchemaWatcher {
+	return &VSchemaWatcher{
+		router:     router,
+		schemafier: schemafier,
+	}
+}
+
+// WatchVSchema creates and starts a VSchemaWatcher for the router
+// of vtgate. It must be called after Init.
+func WatchVSchema(ctx context.Context, schemafier topo.Schemafier) (*VSchemaWatcher, error) {
+	vw := NewVSchemaWatcher(rpcVTGate.router, schemafier)
+	if err := vw.Start(ctx); err != nil {
+		return nil, err
+	}
+	return vw, nil
+}
+
+// Start starts watching the vschema. Every new version is
+// applied to the router as it arrives.
+func (vw *VSchemaWatcher) Start(ctx context.Context) error {
+	notifications, stopWatching, err := vw.schemafier.WatchVSchema(ctx)
+	if err != nil {
+		return fmt.Errorf("WatchVSchema failed: %v", err)
+	}
+	vw.mu.Lock()
+	vw.stopWatching = stopWatching
+	vw.mu.Unlock()
+	go func() {
+		for info := range notifications {
+			vw.update(info)
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the vschema. The router keeps
+// the last schema it received.
+func (vw *VSchemaWatcher) Stop() {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+	if vw.stopWatching != nil {
+		close(vw.stopWatching)
+		vw.stopWatching = nil
+	}
+}
+
+// update applies a new version of the vschema. If it's invalid,
+// the router keeps using the previous one.
+func (vw *VSchemaWatcher) update(info *topo.VSchemaInfo) {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+	if vw.loaded && info.Version == vw.version {
+		// Duplicate notification.
+		return
+	}
+	schema, err := planbuilder.NewSchema([]byte(info.VSchema))
+	if err != nil {
+		log.Errorf("Invalid vschema version %d, keeping version %d: %v", info.Version, vw.version, err)
+		vw.lastError = fmt.Errorf("version %d: %v", info.Version, err)
+		return
+	}
+	qrs, err := NewVSchemaQueryRules([]byte(info.VSchema))
+	if err != nil {
+		log.Errorf("Invalid vschema version %d, keeping version %d: %v", info.Version, vw.version, err)
+		vw.lastError = fmt.Errorf("version %d: %v", info.Version, err)
+		return
+	}
+	vw.router.SetSchema(schema)
+	vw.router.SetQueryRules(VSchemaQueryRuleSource, qrs)
+	vw.loaded = true
+	vw.version = info.Version
+	vw.lastUpdate = time.Now()
+	vw.lastError = nil
+	log.Infof("Loaded vschema version %d", info.Version)
+}
+
+// VSchemaStatus is the status of a VSchemaWatcher.
+// It's used to display the active vschema on the status page.
+type VSchemaStatus struct {
+	Loaded     bool
+	Version    int64
+	LastUpdate time.Time
+	LastError  string
+}
+
+// Status returns the current status of the watcher.
+func (vw *VSchemaWatcher) Status() *VSchemaStatus {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+	status := &VSchemaStatus{
+		Loaded:     vw.loaded,
+		Version:    vw.version,
+		LastUpdate: vw.lastUpdate,
+	}
+	if vw.lastError != nil {
+		status.LastError = vw.lastError.Error()
+	}
+	return status
+}
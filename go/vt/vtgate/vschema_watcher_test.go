@@ -0,0 +1,191 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// fakeSchemafier is a topo.Schemafier that sends the
+// vschemas written to its channel to the watcher.
+type fakeSchemafier struct {
+	notifications chan *topo.VSchemaInfo
+	stopWatching  chan struct{}
+}
+
+func newFakeSchemafier() *fakeSchemafier {
+	return &fakeSchemafier{
+		notifications: make(chan *topo.VSchemaInfo, 10),
+		stopWatching:  make(chan struct{}),
+	}
+}
+
+func (fs *fakeSchemafier) SaveVSchema(context.Context, string) error {
+	return nil
+}
+
+func (fs *fakeSchemafier) GetVSchema(context.Context) (string, error) {
+	return "{}", nil
+}
+
+func (fs *fakeSchemafier) WatchVSchema(context.Context) (<-chan *topo.VSchemaInfo, chan<- struct{}, error) {
+	return fs.notifications, fs.stopWatching, nil
+}
+
+// waitForStatus waits until the status of vw satisfies cond.
+func waitForStatus(t *testing.T, vw *VSchemaWatcher, cond func(*VSchemaStatus) bool) *VSchemaStatus {
+	timeout := time.After(5 * time.Second)
+	for {
+		status := vw.Status()
+		if cond(status) {
+			return status
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for vschema status, last: %+v", status)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestVSchemaWatcher(t *testing.T) {
+	router, _, _, sbclookup := createRouterEnv()
+	fs := newFakeSchemafier()
+	vw := NewVSchemaWatcher(router, fs)
+	if err := vw.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The initial schema of the router must be replaced.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: `{"Keyspaces": {"TestUnsharded": {"Tables": {"t1": ""}}}}`,
+		Version: 3,
+	}
+	waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.Loaded && status.Version == 3 })
+	if _, err := routerExec(router, "select * from t1", nil); err != nil {
+		t.Error(err)
+	}
+	if execCount := sbclookup.ExecCount.Get(); execCount != 1 {
+		t.Errorf("want 1, got %v", execCount)
+	}
+	_, err := routerExec(router, "select * from user", nil)
+	want := "cannot route query: select * from user: table user not found"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	// An invalid schema must be rejected, and the previous one kept.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: "invalid",
+		Version: 4,
+	}
+	status := waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.LastError != "" })
+	if status.Version != 3 {
+		t.Errorf("Version: %d, want 3", status.Version)
+	}
+	if want := "version 4: Unmarshal failed"; !strings.HasPrefix(status.LastError, want) {
+		t.Errorf("LastError: %s, want prefix %s", status.LastError, want)
+	}
+	if _, err := routerExec(router, "select * from t1", nil); err != nil {
+		t.Error(err)
+	}
+
+	// A valid schema clears the error.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: `{"Keyspaces": {"TestUnsharded": {"Tables": {"t2": ""}}}}`,
+		Version: 5,
+	}
+	status = waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.Version == 5 })
+	if status.LastError != "" {
+		t.Errorf("LastError: %s, want empty", status.LastError)
+	}
+	_, err = routerExec(router, "select * from t1", nil)
+	want = "cannot route query: select * from t1: table t1 not found"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	vw.Stop()
+	select {
+	case <-fs.stopWatching:
+	default:
+		t.Errorf("Stop did not close stopWatching")
+	}
+}
+
+func TestVSchemaWatcherCreated(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+	fs := newFakeSchemafier()
+	vw := NewVSchemaWatcher(router, fs)
+	if err := vw.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer vw.Stop()
+
+	// The vschema doesn't exist yet.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: "{}",
+		Version: topo.NoVSchemaVersion,
+	}
+	waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.Loaded })
+
+	// Its creation must not be mistaken for a duplicate.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: `{"Keyspaces": {"TestUnsharded": {"Tables": {"t1": ""}}}}`,
+		Version: 0,
+	}
+	waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.Version == 0 })
+	if _, err := routerExec(router, "select * from t1", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVSchemaWatcherQueryRules(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+	fs := newFakeSchemafier()
+	vw := NewVSchemaWatcher(router, fs)
+	if err := vw.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer vw.Stop()
+
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: `{"Keyspaces": {"TestUnsharded": {"Tables": {"t1": ""}}}, "QueryRules": [{"Name": "r1", "Description": "no t1", "TableNames": ["t1"]}]}`,
+		Version: 1,
+	}
+	waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.Version == 1 })
+	_, err := routerExec(router, "select * from t1", nil)
+	if want := "query disallowed due to rule: no t1"; err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %s", err, want)
+	}
+
+	// Invalid rules reject the whole version.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: `{"Keyspaces": {"TestUnsharded": {"Tables": {"t2": ""}}}, "QueryRules": [{"Action": "BLOCK"}]}`,
+		Version: 2,
+	}
+	status := waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.LastError != "" })
+	if want := "version 2: invalid query rules: invalid Action BLOCK"; status.LastError != want {
+		t.Errorf("LastError: %s, want %s", status.LastError, want)
+	}
+	if _, err := routerExec(router, "select * from t1", nil); err == nil {
+		t.Errorf("routerExec: nil, want rule error")
+	}
+
+	// A vschema without rules removes them.
+	fs.notifications <- &topo.VSchemaInfo{
+		VSchema: `{"Keyspaces": {"TestUnsharded": {"Tables": {"t1": ""}}}}`,
+		Version: 3,
+	}
+	waitForStatus(t, vw, func(status *VSchemaStatus) bool { return status.Version == 3 })
+	if _, err := routerExec(router, "select * from t1", nil); err != nil {
+		t.Error(err)
+	}
+}
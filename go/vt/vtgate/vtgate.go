@@ -11,6 +11,7 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"net/http"
 	"strings"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/youtube/vitess/go/vt/servenv"
 	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vterrors"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	// import vindexes implementations
@@ -65,6 +67,7 @@ type VTGate struct {
 
 	maxInFlight int64
 	inFlight    sync2.AtomicInt64
+	quotas      *callerQuotas
 
 	// the throttled loggers for all errors, one per API entry
 	logExecute                  *logutil.ThrottledLogger
@@ -96,6 +99,21 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 	if rpcVTGate != nil {
 		log.Fatalf("VTGate already initialized")
 	}
+	if *transactionMode != transactionModeSingle && *transactionMode != transactionModeMulti {
+		log.Fatalf("Invalid transaction_mode: %s", *transactionMode)
+	}
+	if *balancerPolicy != balancerPolicyShuffle && *balancerPolicy != balancerPolicyHealth {
+		log.Fatalf("Invalid balancer_policy: %s", *balancerPolicy)
+	}
+	if *twopcEnable && *tabletconn.TabletProtocol == "grpc" {
+		// The gRPC query service doesn't have the
+		// two-phase commit calls yet.
+		log.Fatalf("-twopc_enable is not supported with -tablet_protocol grpc")
+	}
+	quotas, err := loadCallerQuotas(*callerQuotasFile)
+	if err != nil {
+		log.Fatalf("Invalid caller_quotas_file: %v", err)
+	}
 	rpcVTGate = &VTGate{
 		resolver:     NewResolver(serv, "VttabletCall", cell, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, connLife),
 		timings:      stats.NewMultiTimings("VtgateApi", []string{"Operation", "Keyspace", "DbType"}),
@@ -103,6 +121,7 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 
 		maxInFlight: int64(maxInFlight),
 		inFlight:    sync2.NewAtomicInt64(0),
+		quotas:      quotas,
 
 		logExecute:                  logutil.NewThrottledLogger("Execute", 5*time.Second),
 		logExecuteShard:             logutil.NewThrottledLogger("ExecuteShard", 5*time.Second),
@@ -118,6 +137,13 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 	}
 	// Resuse resolver's scatterConn.
 	rpcVTGate.router = NewRouter(serv, cell, schema, "VTGateRouter", rpcVTGate.resolver.scatterConn)
+	if *queryRulesFile != "" {
+		qrs, err := LoadQueryRulesFile(*queryRulesFile)
+		if err != nil {
+			log.Fatalf("Invalid query_rules_file: %v", err)
+		}
+		rpcVTGate.router.SetQueryRules(FileQueryRuleSource, qrs)
+	}
 	normalErrors = stats.NewMultiCounters("VtgateApiErrorCounts", []string{"Operation", "Keyspace", "DbType"})
 	infoErrors = stats.NewCounters("VtgateInfoErrorCounts")
 	internalErrors = stats.NewCounters("VtgateInternalErrorCounts")
@@ -130,11 +156,34 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 	errorsByKeyspace = stats.NewRates("ErrorsByKeyspace", stats.CounterForDimension(normalErrors, "Keyspace"), 15, 1*time.Minute)
 	errorsByDbType = stats.NewRates("ErrorsByDbType", stats.CounterForDimension(normalErrors, "DbType"), 15, 1*time.Minute)
 
+	QueryLogger.ServeLogs(*queryLogHandler, buildFmter(QueryLogger))
+	http.HandleFunc("/queryz", func(w http.ResponseWriter, r *http.Request) {
+		queryzHandler(rpcVTGate.router.planner, w, r)
+	})
+	http.HandleFunc("/debug/query_stats", func(w http.ResponseWriter, r *http.Request) {
+		queryStatsHandler(rpcVTGate.router.planner, w, r)
+	})
+
 	for _, f := range RegisterVTGates {
 		f(rpcVTGate)
 	}
 }
 
+// SetQueryRules replaces the query rules of source in
+// the router of vtgate. It must be called after Init.
+func SetQueryRules(source string, qrs *QueryRules) {
+	rpcVTGate.router.SetQueryRules(source, qrs)
+}
+
+// BalancerStatus returns the status of the load balancing
+// of the replica and rdonly tablets, for the status page.
+func BalancerStatus() []*ShardBalancerStatus {
+	if rpcVTGate == nil {
+		return nil
+	}
+	return rpcVTGate.resolver.scatterConn.BalancerStatus()
+}
+
 // InitializeConnections pre-initializes VTGate by connecting to vttablets of all keyspace/shard/type.
 // It is not necessary to call this function before serving queries,
 // but it would reduce connection overhead when serving.
@@ -163,12 +212,25 @@ func (vtg *VTGate) Execute(ctx context.Context, query *proto.Query, reply *proto
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "Execute", query.Sql, query.BindVariables, "", query.TabletType)
+	defer logStats.Send()
+
 	qr, err := vtg.router.Execute(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsAffected = qr.RowsAffected
+		logStats.RowsReturned = len(qr.Rows)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecute)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = query.Session
 	return nil
@@ -186,6 +248,15 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "ExecuteShard", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	qr, err := vtg.resolver.Execute(
 		ctx,
 		query.Sql,
@@ -201,8 +272,12 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsAffected = qr.RowsAffected
+		logStats.RowsReturned = len(qr.Rows)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteShard)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = query.Session
 	return nil
@@ -220,12 +295,25 @@ func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, query *proto.Keyspace
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "ExecuteKeyspaceIds", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	qr, err := vtg.resolver.ExecuteKeyspaceIds(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsAffected = qr.RowsAffected
+		logStats.RowsReturned = len(qr.Rows)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteKeyspaceIds)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = query.Session
 	return nil
@@ -243,12 +331,25 @@ func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, query *proto.KeyRangeQu
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "ExecuteKeyRanges", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	qr, err := vtg.resolver.ExecuteKeyRanges(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsAffected = qr.RowsAffected
+		logStats.RowsReturned = len(qr.Rows)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteKeyRanges)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = query.Session
 	return nil
@@ -266,12 +367,25 @@ func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, query *proto.EntityIdsQ
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "ExecuteEntityIds", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	qr, err := vtg.resolver.ExecuteEntityIds(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsAffected = qr.RowsAffected
+		logStats.RowsReturned = len(qr.Rows)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteEntityIds)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = query.Session
 	return nil
@@ -289,6 +403,15 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, batchQuery.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "ExecuteBatchShard", batchSql(batchQuery.Queries), nil, "", batchQuery.TabletType)
+	defer logStats.Send()
+
 	qrs, err := vtg.resolver.ExecuteBatch(
 		ctx,
 		batchQuery.TabletType,
@@ -304,8 +427,11 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 			rowCount += int64(len(qr.Rows))
 		}
 		vtg.rowsReturned.Add(statsKey, rowCount)
+		logStats.RowsReturned = int(rowCount)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, batchQuery, vtg.logExecuteBatchShard)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = batchQuery.Session
 	return nil
@@ -323,6 +449,15 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "ExecuteBatchKeyspaceIds", batchKeyspaceIdSql(query.Queries), nil, "", query.TabletType)
+	defer logStats.Send()
+
 	qrs, err := vtg.resolver.ExecuteBatchKeyspaceIds(
 		ctx,
 		query)
@@ -333,8 +468,11 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 			rowCount += int64(len(qr.Rows))
 		}
 		vtg.rowsReturned.Add(statsKey, rowCount)
+		logStats.RowsReturned = int(rowCount)
 	} else {
+		logStats.Error = err
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteBatchKeyspaceIds)
+		reply.Err = codedRPCError(err, reply.Error)
 	}
 	reply.Session = query.Session
 	return nil
@@ -352,8 +490,17 @@ func (vtg *VTGate) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "StreamExecute", query.Sql, query.BindVariables, "", query.TabletType)
+	defer logStats.Send()
+
 	var rowCount int64
-	err := vtg.router.StreamExecute(
+	err = vtg.router.StreamExecute(
 		ctx,
 		query,
 		func(mreply *mproto.QueryResult) error {
@@ -366,6 +513,8 @@ func (vtg *VTGate) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 			return sendReply(reply)
 		})
 
+	logStats.RowsReturned = int(rowCount)
+	logStats.Error = err
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
 		logError(err, query, vtg.logStreamExecute)
@@ -394,8 +543,17 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "StreamExecuteKeyspaceIds", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	var rowCount int64
-	err := vtg.resolver.StreamExecuteKeyspaceIds(
+	err = vtg.resolver.StreamExecuteKeyspaceIds(
 		ctx,
 		query,
 		func(mreply *mproto.QueryResult) error {
@@ -408,6 +566,8 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 			return sendReply(reply)
 		})
 
+	logStats.RowsReturned = int(rowCount)
+	logStats.Error = err
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
 		logError(err, query, vtg.logStreamExecuteKeyspaceIds)
@@ -436,8 +596,17 @@ func (vtg *VTGate) StreamExecuteKeyRanges(ctx context.Context, query *proto.KeyR
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "StreamExecuteKeyRanges", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	var rowCount int64
-	err := vtg.resolver.StreamExecuteKeyRanges(
+	err = vtg.resolver.StreamExecuteKeyRanges(
 		ctx,
 		query,
 		func(mreply *mproto.QueryResult) error {
@@ -450,6 +619,8 @@ func (vtg *VTGate) StreamExecuteKeyRanges(ctx context.Context, query *proto.KeyR
 			return sendReply(reply)
 		})
 
+	logStats.RowsReturned = int(rowCount)
+	logStats.Error = err
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
 		logError(err, query, vtg.logStreamExecuteKeyRanges)
@@ -473,8 +644,17 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 		return errTooManyInFlight
 	}
 
+	release, err := vtg.quotas.acquire(ctx, query.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logStats, ctx := newLogStats(ctx, "StreamExecuteShard", query.Sql, query.BindVariables, query.Keyspace, query.TabletType)
+	defer logStats.Send()
+
 	var rowCount int64
-	err := vtg.resolver.StreamExecute(
+	err = vtg.resolver.StreamExecute(
 		ctx,
 		query.Sql,
 		query.BindVariables,
@@ -495,6 +675,8 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 		},
 		query.NotInTransaction)
 
+	logStats.RowsReturned = int(rowCount)
+	logStats.Error = err
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
 		logError(err, query, vtg.logStreamExecuteShard)
@@ -507,6 +689,7 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 }
 
 // Begin begins a transaction. It has to be concluded by a Commit or Rollback.
+// If outSession is SingleShard, the transaction is limited to a single shard.
 func (vtg *VTGate) Begin(ctx context.Context, outSession *proto.Session) error {
 	outSession.InTransaction = true
 	return nil
@@ -569,6 +752,35 @@ func (vtg *VTGate) GetSrvKeyspace(ctx context.Context, keyspace string) (*topo.S
 	return vtg.resolver.scatterConn.toposerv.GetSrvKeyspace(ctx, vtg.resolver.scatterConn.cell, keyspace)
 }
 
+// Explain describes the plan of a V3 query, and the shards it would be
+// sent to, without executing it.
+func (vtg *VTGate) Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error {
+	startTime := time.Now()
+	statsKey := []string{"Explain", "Any", string(req.TabletType)}
+	defer vtg.timings.Record(statsKey, startTime)
+
+	x := vtg.inFlight.Add(1)
+	defer vtg.inFlight.Add(-1)
+	if 0 < vtg.maxInFlight && vtg.maxInFlight < x {
+		return errTooManyInFlight
+	}
+
+	// Resolving the shards may read lookup vindexes,
+	// so the quotas apply.
+	release, err := vtg.quotas.acquire(ctx, req.TabletType)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reply.Plan = vtg.router.Explain(ctx, &proto.Query{
+		Sql:           req.Sql,
+		BindVariables: req.BindVariables,
+		TabletType:    req.TabletType,
+	})
+	return nil
+}
+
 // Any errors that are caused by VTGate dependencies (e.g, VtTablet) should be logged
 // as errors in those components, but logged to Info in VTGate itself.
 func logError(err error, query interface{}, logger *logutil.ThrottledLogger) {
@@ -631,7 +843,11 @@ func formatError(err error) error {
 	if err == nil {
 		return nil
 	}
-	return fmt.Errorf("%v, vtgate: %v", err, servenv.ListeningURL.String())
+	formatted := fmt.Errorf("%v, vtgate: %v", err, servenv.ListeningURL.String())
+	if code := vtgateErrorCode(err); code != vterrors.UnknownVtgateError {
+		return vterrors.FromError(code, formatted)
+	}
+	return formatted
 }
 
 // HandlePanic recovers from panics, and logs / increment counters
@@ -16,15 +16,50 @@ func rpcErrFromVtGateError(err error) *mproto.RPCError {
 	if err == nil {
 		return nil
 	}
-	// TODO(aaijazi): for now, we don't have any differentiation of VtGate errors.
-	// However, we should have them soon, so that clients don't have to parse the
-	// returned error string.
 	return &mproto.RPCError{
-		Code:    vterrors.UnknownVtgateError,
+		Code:    vtgateErrorCode(err),
 		Message: err.Error(),
 	}
 }
 
+// vtgateErrorCode returns the code of a vtgate error. Errors that
+// don't carry a code, and aggregated errors that don't all have the
+// same code, are unknown vtgate errors.
+// The codes of the vtrpc.ErrorCode enum are below 1000, so they
+// can share the code field with the legacy codes.
+func vtgateErrorCode(err error) int64 {
+	switch err := err.(type) {
+	case *vterrors.VitessError:
+		return err.Code
+	case *ScatterConnError:
+		if len(err.Errs) == 0 {
+			break
+		}
+		code := vtgateErrorCode(err.Errs[0])
+		for _, e := range err.Errs[1:] {
+			if vtgateErrorCode(e) != code {
+				return vterrors.UnknownVtgateError
+			}
+		}
+		return code
+	}
+	return vterrors.UnknownVtgateError
+}
+
+// codedRPCError returns an *mproto.RPCError with the code of err and
+// the message errString, or nil if err doesn't carry a code. It's used
+// by the execute calls, which return their errors as strings.
+func codedRPCError(err error, errString string) *mproto.RPCError {
+	code := vtgateErrorCode(err)
+	if code == vterrors.UnknownVtgateError {
+		return nil
+	}
+	return &mproto.RPCError{
+		Code:    code,
+		Message: errString,
+	}
+}
+
 // AddVtGateErrorToQueryResult will mutate a QueryResult struct to fill in the Err
 // field with details from the VTGate error.
 func AddVtGateErrorToQueryResult(err error, reply *proto.QueryResult) {
@@ -79,6 +114,15 @@ func AddVtGateErrorToRollbackResponse(err error, reply *proto.RollbackResponse)
 	reply.Err = rpcErrFromVtGateError(err)
 }
 
+// AddVtGateErrorToExplainResult will mutate an ExplainResult struct to fill in the Err
+// field with details from the VTGate error.
+func AddVtGateErrorToExplainResult(err error, reply *proto.ExplainResult) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromVtGateError(err)
+}
+
 // VtGateErrorToVtRPCError converts a vtgate error into a vtrpc error.
 func VtGateErrorToVtRPCError(err error, errString string) *vtrpc.RPCError {
 	if err == nil && errString == "" {
@@ -90,8 +134,25 @@ func VtGateErrorToVtRPCError(err error, errString string) *vtrpc.RPCError {
 	} else {
 		message = errString
 	}
+	code := vtrpc.ErrorCodeDeprecated_UnknownVtgateError
+	if err != nil {
+		code = vtrpc.ErrorCodeDeprecated(vtgateErrorCode(err))
+	}
 	return &vtrpc.RPCError{
-		Code:    vtrpc.ErrorCodeDeprecated_UnknownVtgateError,
+		Code:    code,
 		Message: message,
 	}
 }
+
+// ExecuteErrorToVtRPCError converts the error of an execute call
+// into a vtrpc error. Execute calls return their errors in the reply:
+// as errString, and also as rpcErr if the error has a code.
+func ExecuteErrorToVtRPCError(err error, errString string, rpcErr *mproto.RPCError) *vtrpc.RPCError {
+	if err == nil && rpcErr != nil {
+		return &vtrpc.RPCError{
+			Code:    vtrpc.ErrorCodeDeprecated(rpcErr.Code),
+			Message: rpcErr.Message,
+		}
+	}
+	return VtGateErrorToVtRPCError(err, errString)
+}
@@ -7,6 +7,7 @@ package vtgate
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +18,8 @@ import (
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
+
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
 )
 
 // This file uses the sandbox_test framework.
@@ -150,6 +153,89 @@ func TestVTGateExecuteShard(t *testing.T) {
 	*/
 }
 
+func TestVTGateSingleShardTransaction(t *testing.T) {
+	sandbox := createSandbox("TestVTGateSingleShardTransaction")
+	sbc0 := &sandboxConn{}
+	sandbox.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	sandbox.MapTestConn("1", sbc1)
+	q := proto.QueryShard{
+		Sql:        "query",
+		Keyspace:   "TestVTGateSingleShardTransaction",
+		Shards:     []string{"0"},
+		TabletType: topo.TYPE_MASTER,
+	}
+	wantErr := "multi-shard transaction attempted in single-shard mode: transaction is on TestVTGateSingleShardTransaction/0, statement requires TestVTGateSingleShardTransaction/1"
+
+	// A single-shard session rejects a second shard.
+	q.Session = &proto.Session{SingleShard: true}
+	rpcVTGate.Begin(context.Background(), q.Session)
+	if !q.Session.InTransaction || !q.Session.SingleShard {
+		t.Errorf("Begin: %+v, want InTransaction and SingleShard", q.Session)
+	}
+	qr := new(proto.QueryResult)
+	rpcVTGate.ExecuteShard(context.Background(), &q, qr)
+	if qr.Error != "" {
+		t.Errorf("ExecuteShard: %s, want no error", qr.Error)
+	}
+	q.Shards = []string{"1"}
+	qr = new(proto.QueryResult)
+	rpcVTGate.ExecuteShard(context.Background(), &q, qr)
+	if !strings.HasPrefix(qr.Error, wantErr) {
+		t.Errorf("ExecuteShard: %s, want prefix %s", qr.Error, wantErr)
+	}
+	if qr.Err == nil || qr.Err.Code != int64(pbv.ErrorCode_BAD_INPUT) {
+		t.Errorf("ExecuteShard: Err %+v, want code BAD_INPUT", qr.Err)
+	}
+	// The statement must be rejected before it's sent to shard 1.
+	if beginCount := sbc1.BeginCount.Get(); beginCount != 0 {
+		t.Errorf("sbc1.BeginCount: %d, want 0", beginCount)
+	}
+	if len(q.Session.ShardSessions) != 1 {
+		t.Errorf("ShardSessions: %+v, want only shard 0", q.Session.ShardSessions)
+	}
+	rpcVTGate.Rollback(context.Background(), q.Session)
+
+	// The default mode of vtgate applies to all sessions.
+	*transactionMode = transactionModeSingle
+	defer func() { *transactionMode = transactionModeMulti }()
+	q.Session = new(proto.Session)
+	rpcVTGate.Begin(context.Background(), q.Session)
+	q.Shards = []string{"0", "1"}
+	execCount := sbc0.ExecCount.Get()
+	qr = new(proto.QueryResult)
+	rpcVTGate.ExecuteShard(context.Background(), &q, qr)
+	if qr.Err == nil || qr.Err.Code != int64(pbv.ErrorCode_BAD_INPUT) {
+		t.Errorf("ExecuteShard: Err %+v, want code BAD_INPUT", qr.Err)
+	}
+	// No shard may run a part of the rejected statement.
+	if got := sbc0.ExecCount.Get(); got != execCount {
+		t.Errorf("sbc0.ExecCount: %d, want %d", got, execCount)
+	}
+	if got := sbc1.ExecCount.Get(); got != 0 {
+		t.Errorf("sbc1.ExecCount: %d, want 0", got)
+	}
+	rpcVTGate.Rollback(context.Background(), q.Session)
+
+	// In multi mode, multi-shard transactions are counted.
+	*transactionMode = transactionModeMulti
+	before := multiShardTransactions.Counts()["TestVTGateSingleShardTransaction"]
+	q.Session = new(proto.Session)
+	rpcVTGate.Begin(context.Background(), q.Session)
+	q.Shards = []string{"0"}
+	rpcVTGate.ExecuteShard(context.Background(), &q, new(proto.QueryResult))
+	q.Shards = []string{"1"}
+	qr = new(proto.QueryResult)
+	rpcVTGate.ExecuteShard(context.Background(), &q, qr)
+	if qr.Error != "" {
+		t.Errorf("ExecuteShard: %s, want no error", qr.Error)
+	}
+	if got := multiShardTransactions.Counts()["TestVTGateSingleShardTransaction"]; got != before+1 {
+		t.Errorf("VtgateMultiShardTransactions: %d, want %d", got, before+1)
+	}
+	rpcVTGate.Rollback(context.Background(), q.Session)
+}
+
 func TestVTGateExecuteKeyspaceIds(t *testing.T) {
 	s := createSandbox("TestVTGateExecuteKeyspaceIds")
 	sbc1 := &sandboxConn{}
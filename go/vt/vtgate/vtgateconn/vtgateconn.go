@@ -7,6 +7,7 @@ package vtgateconn
 import (
 	"flag"
 	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
@@ -48,48 +49,136 @@ func (e OperationalError) Error() string { return string(e) }
 // It can be used concurrently across goroutines.
 type VTGateConn struct {
 	impl Impl
+
+	// mu protects readYourWrites and shardPositions.
+	mu             sync.Mutex
+	readYourWrites bool
+	// shardPositions are the replication positions of the
+	// last commits of the connection on each shard.
+	shardPositions []*proto.ShardPosition
+}
+
+// SetReadYourWrites enables or disables read-your-writes consistency
+// for the connection. When it's enabled, the commits of the connection
+// return the replication positions of their shards, and the later
+// replica reads of the connection that are not in a transaction wait
+// until the replicas have applied them. Streaming queries don't wait.
+func (conn *VTGateConn) SetReadYourWrites(enabled bool) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.readYourWrites = enabled
+	if !enabled {
+		conn.shardPositions = nil
+	}
+}
+
+// copyPositions returns a copy of the replication positions of the
+// connection. It must be called with mu held.
+func (conn *VTGateConn) copyPositions() []*proto.ShardPosition {
+	positions := make([]*proto.ShardPosition, len(conn.shardPositions))
+	for i, shardPosition := range conn.shardPositions {
+		sp := *shardPosition
+		positions[i] = &sp
+	}
+	return positions
+}
+
+// readSession returns the session to send with the queries that are
+// not in a transaction. It's nil, unless the connection has
+// read-your-writes enabled and has committed something.
+func (conn *VTGateConn) readSession() interface{} {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.readYourWrites || len(conn.shardPositions) == 0 {
+		return nil
+	}
+	return conn.impl.SetReadYourWrites(nil, conn.copyPositions())
+}
+
+// txSession enables read-your-writes on the session of a new
+// transaction if the connection has it enabled. The session carries
+// the positions of the previous commits, so that its commit returns
+// them along with its own.
+func (conn *VTGateConn) txSession(session interface{}) interface{} {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.readYourWrites {
+		return session
+	}
+	return conn.impl.SetReadYourWrites(session, conn.copyPositions())
+}
+
+// recordPositions saves the replication positions
+// returned by the commit of session.
+func (conn *VTGateConn) recordPositions(session interface{}) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.readYourWrites {
+		return
+	}
+	for _, position := range conn.impl.ShardPositions(session) {
+		found := false
+		for _, shardPosition := range conn.shardPositions {
+			if shardPosition.Keyspace == position.Keyspace && shardPosition.Shard == position.Shard {
+				shardPosition.Position = position.Position
+				found = true
+				break
+			}
+		}
+		if !found {
+			conn.shardPositions = append(conn.shardPositions, position)
+		}
+	}
 }
 
 // Execute executes a non-streaming query on vtgate.
 // This is using v3 API.
 func (conn *VTGateConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, tabletType pb.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.Execute(ctx, query, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.Execute(ctx, query, bindVars, tabletType, false, conn.readSession())
 	return res, err
 }
 
 // ExecuteShard executes a non-streaming query for multiple shards on vtgate.
 func (conn *VTGateConn) ExecuteShard(ctx context.Context, query string, keyspace string, shards []string, bindVars map[string]interface{}, tabletType pb.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteShard(ctx, query, keyspace, shards, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteShard(ctx, query, keyspace, shards, bindVars, tabletType, false, conn.readSession())
 	return res, err
 }
 
 // ExecuteKeyspaceIds executes a non-streaming query for multiple keyspace_ids.
 func (conn *VTGateConn) ExecuteKeyspaceIds(ctx context.Context, query string, keyspace string, keyspaceIds [][]byte, bindVars map[string]interface{}, tabletType pb.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteKeyspaceIds(ctx, query, keyspace, keyspaceIds, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteKeyspaceIds(ctx, query, keyspace, keyspaceIds, bindVars, tabletType, false, conn.readSession())
 	return res, err
 }
 
 // ExecuteKeyRanges executes a non-streaming query on a key range.
 func (conn *VTGateConn) ExecuteKeyRanges(ctx context.Context, query string, keyspace string, keyRanges []*pb.KeyRange, bindVars map[string]interface{}, tabletType pb.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteKeyRanges(ctx, query, keyspace, keyRanges, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteKeyRanges(ctx, query, keyspace, keyRanges, bindVars, tabletType, false, conn.readSession())
 	return res, err
 }
 
 // ExecuteEntityIds executes a non-streaming query for multiple entities.
 func (conn *VTGateConn) ExecuteEntityIds(ctx context.Context, query string, keyspace string, entityColumnName string, entityKeyspaceIDs []proto.EntityId, bindVars map[string]interface{}, tabletType pb.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteEntityIds(ctx, query, keyspace, entityColumnName, entityKeyspaceIDs, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteEntityIds(ctx, query, keyspace, entityColumnName, entityKeyspaceIDs, bindVars, tabletType, false, conn.readSession())
 	return res, err
 }
 
 // ExecuteBatchShard executes a set of non-streaming queries for multiple shards.
 func (conn *VTGateConn) ExecuteBatchShard(ctx context.Context, queries []proto.BoundShardQuery, tabletType pb.TabletType, asTransaction bool) ([]mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteBatchShard(ctx, queries, tabletType, asTransaction, nil)
+	var session interface{}
+	if !asTransaction {
+		session = conn.readSession()
+	}
+	res, _, err := conn.impl.ExecuteBatchShard(ctx, queries, tabletType, asTransaction, session)
 	return res, err
 }
 
 // ExecuteBatchKeyspaceIds executes a set of non-streaming queries for multiple keyspace ids.
 func (conn *VTGateConn) ExecuteBatchKeyspaceIds(ctx context.Context, queries []proto.BoundKeyspaceIdQuery, tabletType pb.TabletType, asTransaction bool) ([]mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteBatchKeyspaceIds(ctx, queries, tabletType, asTransaction, nil)
+	var session interface{}
+	if !asTransaction {
+		session = conn.readSession()
+	}
+	res, _, err := conn.impl.ExecuteBatchKeyspaceIds(ctx, queries, tabletType, asTransaction, session)
 	return res, err
 }
 
@@ -173,8 +262,9 @@ func (conn *VTGateConn) Begin(ctx context.Context) (*VTGateTx, error) {
 	}
 
 	return &VTGateTx{
+		conn:    conn,
 		impl:    conn.impl,
-		session: session,
+		session: conn.txSession(session),
 	}, nil
 }
 
@@ -186,8 +276,25 @@ func (conn *VTGateConn) Begin2(ctx context.Context) (*VTGateTx, error) {
 	}
 
 	return &VTGateTx{
+		conn:    conn,
+		impl:    conn.impl,
+		session: conn.txSession(session),
+	}, nil
+}
+
+// BeginSingleShard starts a transaction that is limited to a single
+// shard, and returns a VTGateTX. Statements that would add a second
+// shard to the transaction fail.
+func (conn *VTGateConn) BeginSingleShard(ctx context.Context) (*VTGateTx, error) {
+	session, err := conn.impl.BeginSingleShard(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VTGateTx{
+		conn:    conn,
 		impl:    conn.impl,
-		session: session,
+		session: conn.txSession(session),
 	}, nil
 }
 
@@ -208,9 +315,17 @@ func (conn *VTGateConn) GetSrvKeyspace(ctx context.Context, keyspace string) (*t
 	return conn.impl.GetSrvKeyspace(ctx, keyspace)
 }
 
+// Explain returns the plan of a V3 query, and the shards it would be
+// sent to, without executing it.
+// This is using v3 API.
+func (conn *VTGateConn) Explain(ctx context.Context, query string, bindVars map[string]interface{}, tabletType pb.TabletType) (*proto.PlanDescription, error) {
+	return conn.impl.Explain(ctx, query, bindVars, tabletType)
+}
+
 // VTGateTx defines an ongoing transaction.
 // It should not be concurrently used across goroutines.
 type VTGateTx struct {
+	conn    *VTGateConn
 	impl    Impl
 	session interface{}
 }
@@ -291,6 +406,9 @@ func (tx *VTGateTx) Commit(ctx context.Context) error {
 		return fmt.Errorf("commit: not in transaction")
 	}
 	err := tx.impl.Commit(ctx, tx.session)
+	if err == nil {
+		tx.conn.recordPositions(tx.session)
+	}
 	tx.session = nil
 	return err
 }
@@ -311,6 +429,9 @@ func (tx *VTGateTx) Commit2(ctx context.Context) error {
 		return fmt.Errorf("commit: not in transaction")
 	}
 	err := tx.impl.Commit2(ctx, tx.session)
+	if err == nil {
+		tx.conn.recordPositions(tx.session)
+	}
 	tx.session = nil
 	return err
 }
@@ -383,7 +504,9 @@ type Impl interface {
 	// Begin starts a transaction and returns a VTGateTX.
 	Begin(ctx context.Context) (interface{}, error)
 
-	// Commit commits the current transaction.
+	// Commit commits the current transaction. If the session has
+	// read-your-writes enabled, the replication positions returned
+	// by vtgate are copied into it.
 	Commit(ctx context.Context, session interface{}) error
 
 	// Rollback rolls back the current transaction.
@@ -393,11 +516,22 @@ type Impl interface {
 
 	// Begin starts a transaction and returns a VTGateTX.
 	Begin2(ctx context.Context) (interface{}, error)
-	// Commit commits the current transaction.
+	// Commit commits the current transaction, like Commit.
 	Commit2(ctx context.Context, session interface{}) error
 	// Rollback rolls back the current transaction.
 	Rollback2(ctx context.Context, session interface{}) error
 
+	// BeginSingleShard starts a transaction limited to a single shard.
+	BeginSingleShard(ctx context.Context) (interface{}, error)
+
+	// SetReadYourWrites enables read-your-writes on session, and sets
+	// its replication positions. If session is nil, it returns a new
+	// session that is not in a transaction.
+	SetReadYourWrites(session interface{}, positions []*proto.ShardPosition) interface{}
+
+	// ShardPositions returns the replication positions of session.
+	ShardPositions(session interface{}) []*proto.ShardPosition
+
 	// SplitQuery splits a query into equally sized smaller queries by
 	// appending primary key range clauses to the original query.
 	SplitQuery(ctx context.Context, keyspace string, query tproto.BoundQuery, splitColumn string, splitCount int) ([]proto.SplitQueryPart, error)
@@ -405,6 +539,9 @@ type Impl interface {
 	// GetSrvKeyspace returns a topo.SrvKeyspace.
 	GetSrvKeyspace(ctx context.Context, keyspace string) (*topo.SrvKeyspace, error)
 
+	// Explain returns the plan of a V3 query without executing it.
+	Explain(ctx context.Context, query string, bindVars map[string]interface{}, tabletType pb.TabletType) (*proto.PlanDescription, error)
+
 	// Close must be called for releasing resources.
 	Close()
 }
@@ -40,6 +40,9 @@ type fakeVTGateService struct {
 	forceBeginSuccess bool
 	hasCallerID       bool
 	errorWait         chan struct{}
+	// rywPositions are the replication positions returned
+	// by the read-your-writes commits.
+	rywPositions []*proto.ShardPosition
 }
 
 var errTestVtGateError = errors.New("test vtgate error")
@@ -74,6 +77,16 @@ func (f *fakeVTGateService) Execute(ctx context.Context, query *proto.Query, rep
 	}
 	f.checkCallerID(ctx, "Execute")
 	query.CallerID = nil
+	if query.Sql == rywQuery {
+		if query.Session == nil || !query.Session.ReadYourWrites || query.Session.InTransaction {
+			return fmt.Errorf("execute: session is not a read-your-writes session: %+v", query.Session)
+		}
+		if err := f.checkPositions(query.Session.ShardPositions); err != nil {
+			return fmt.Errorf("execute: %v", err)
+		}
+		*reply = *execMap["request1"].reply
+		return nil
+	}
 	execCase, ok := execMap[query.Sql]
 	if !ok {
 		return fmt.Errorf("no match for: %s", query.Sql)
@@ -418,12 +431,51 @@ func (f *fakeVTGateService) Commit(ctx context.Context, inSession *proto.Session
 	if f.panics {
 		panic(fmt.Errorf("test forced panic"))
 	}
+	if inSession.ReadYourWrites {
+		return f.commitReadYourWrites(inSession)
+	}
 	if !reflect.DeepEqual(inSession, session2) {
 		return errors.New("commit: session mismatch")
 	}
 	return nil
 }
 
+// commitReadYourWrites checks that inSession carries the positions of
+// the previous commits, and records a position on a new shard, like
+// vtgate does.
+func (f *fakeVTGateService) commitReadYourWrites(inSession *proto.Session) error {
+	if err := f.checkPositions(inSession.ShardPositions); err != nil {
+		return fmt.Errorf("commit: %v", err)
+	}
+	f.rywPositions = append(f.rywPositions, &proto.ShardPosition{
+		Keyspace: "ks",
+		Shard:    fmt.Sprintf("%v", len(f.rywPositions)+1),
+		Position: fmt.Sprintf("MariaDB/0-1-%v", len(f.rywPositions)+1),
+	})
+	inSession.InTransaction = false
+	inSession.ShardSessions = nil
+	inSession.ShardPositions = nil
+	for _, position := range f.rywPositions {
+		sp := *position
+		inSession.ShardPositions = append(inSession.ShardPositions, &sp)
+	}
+	return nil
+}
+
+// checkPositions checks that positions are the ones
+// returned by the read-your-writes commits.
+func (f *fakeVTGateService) checkPositions(positions []*proto.ShardPosition) error {
+	if len(positions) != len(f.rywPositions) {
+		return fmt.Errorf("got %v shard positions, want %v", len(positions), len(f.rywPositions))
+	}
+	for i, position := range positions {
+		if *position != *f.rywPositions[i] {
+			return fmt.Errorf("shard position %v: %+v, want %+v", i, position, f.rywPositions[i])
+		}
+	}
+	return nil
+}
+
 // Rollback is part of the VTGateService interface
 func (f *fakeVTGateService) Rollback(ctx context.Context, inSession *proto.Session) error {
 	if f.hasError {
@@ -470,6 +522,23 @@ func (f *fakeVTGateService) GetSrvKeyspace(ctx context.Context, keyspace string)
 	return getSrvKeyspaceResult, nil
 }
 
+// Explain is part of the VTGateService interface
+func (f *fakeVTGateService) Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error {
+	if f.hasError {
+		return errTestVtGateError
+	}
+	if f.panics {
+		panic(fmt.Errorf("test forced panic"))
+	}
+	f.checkCallerID(ctx, "Explain")
+	req.CallerID = nil
+	if !reflect.DeepEqual(req, explainRequest) {
+		f.t.Errorf("Explain has wrong input: got %#v wanted %#v", req, explainRequest)
+	}
+	*reply = *explainResult
+	return nil
+}
+
 // CreateFakeServer returns the fake server for the tests
 func CreateFakeServer(t *testing.T) vtgateservice.VTGateService {
 	return &fakeVTGateService{
@@ -520,6 +589,10 @@ func TestSuite(t *testing.T, impl vtgateconn.Impl, fakeServer vtgateservice.VTGa
 	testTx2Fail(t, conn)
 	testSplitQuery(t, conn)
 	testGetSrvKeyspace(t, conn)
+	testExplain(t, conn)
+	fs.hasCallerID = false
+	testReadYourWrites(t, fs)
+	fs.hasCallerID = true
 
 	// return an error for every call, make sure they're handled properly
 	fs.hasError = true
@@ -551,6 +624,7 @@ func TestSuite(t *testing.T, impl vtgateconn.Impl, fakeServer vtgateservice.VTGa
 	testStreamExecuteKeyspaceIds2Error(t, conn, fs)
 	testSplitQueryError(t, conn)
 	testGetSrvKeyspaceError(t, conn)
+	testExplainError(t, conn)
 	fs.hasError = false
 
 	// force a panic at every call, then test that works
@@ -579,6 +653,7 @@ func TestSuite(t *testing.T, impl vtgateconn.Impl, fakeServer vtgateservice.VTGa
 	testStreamExecuteKeyspaceIdsPanic(t, conn)
 	testSplitQueryPanic(t, conn)
 	testGetSrvKeyspacePanic(t, conn)
+	testExplainPanic(t, conn)
 	fs.panics = false
 }
 
@@ -1354,6 +1429,42 @@ func testStreamExecuteKeyspaceIdsPanic(t *testing.T, conn *vtgateconn.VTGateConn
 	expectPanic(t, err)
 }
 
+func testReadYourWrites(t *testing.T, fake *fakeVTGateService) {
+	ctx := newContext()
+	conn, err := vtgateconn.DialProtocol(ctx, "test", "", 0)
+	if err != nil {
+		t.Fatalf("Got err: %v from vtgateconn.DialProtocol", err)
+	}
+	conn.SetReadYourWrites(true)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Execute(ctx, rywQuery, nil, pb.TabletType_REPLICA); err != nil {
+		t.Errorf("Execute after Commit: %v", err)
+	}
+
+	// The second commit carries the position of the
+	// first one, and returns both.
+	tx, err = conn.Begin2(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit2(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.rywPositions) != 2 {
+		t.Errorf("got %v read-your-writes commits, want 2", len(fake.rywPositions))
+	}
+	if _, err := conn.Execute(ctx, rywQuery, nil, pb.TabletType_REPLICA); err != nil {
+		t.Errorf("Execute after Commit2: %v", err)
+	}
+}
+
 func testTxPass(t *testing.T, conn *vtgateconn.VTGateConn) {
 	ctx := newContext()
 	execCase := execMap["txRequest"]
@@ -1976,6 +2087,29 @@ func testGetSrvKeyspacePanic(t *testing.T, conn *vtgateconn.VTGateConn) {
 	expectPanic(t, err)
 }
 
+func testExplain(t *testing.T, conn *vtgateconn.VTGateConn) {
+	ctx := newContext()
+	pd, err := conn.Explain(ctx, explainRequest.Sql, explainRequest.BindVariables, topo.TabletTypeToProto(explainRequest.TabletType))
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if !reflect.DeepEqual(pd, explainResult.Plan) {
+		t.Errorf("Explain returned wrong result: got %+v wanted %+v", pd, explainResult.Plan)
+	}
+}
+
+func testExplainError(t *testing.T, conn *vtgateconn.VTGateConn) {
+	ctx := newContext()
+	_, err := conn.Explain(ctx, explainRequest.Sql, explainRequest.BindVariables, topo.TabletTypeToProto(explainRequest.TabletType))
+	verifyError(t, err, "Explain")
+}
+
+func testExplainPanic(t *testing.T, conn *vtgateconn.VTGateConn) {
+	ctx := newContext()
+	_, err := conn.Explain(ctx, explainRequest.Sql, explainRequest.BindVariables, topo.TabletTypeToProto(explainRequest.TabletType))
+	expectPanic(t, err)
+}
+
 var testCallerID = &pbv.CallerID{
 	Principal:    "test_principal",
 	Component:    "test_component",
@@ -2441,9 +2575,14 @@ var streamResult1 = mproto.QueryResult{
 	Rows:         [][]sqltypes.Value{},
 }
 
+// rywQuery is checked by the fake server to carry
+// the positions of the read-your-writes commits.
+const rywQuery = "rywRequest"
+
 var session1 = &proto.Session{
-	InTransaction: true,
-	ShardSessions: []*proto.ShardSession{},
+	InTransaction:  true,
+	ShardSessions:  []*proto.ShardSession{},
+	ShardPositions: []*proto.ShardPosition{},
 }
 
 var session2 = &proto.Session{
@@ -2456,6 +2595,7 @@ var session2 = &proto.Session{
 			TransactionId: 1,
 		},
 	},
+	ShardPositions: []*proto.ShardPosition{},
 }
 
 var splitQueryRequest = &proto.SplitQueryRequest{
@@ -2514,3 +2654,34 @@ var getSrvKeyspaceResult = &topo.SrvKeyspace{
 	},
 	SplitShardCount: 128,
 }
+
+var explainRequest = &proto.ExplainRequest{
+	Sql: "select * from t1 join t2 on t1.id = t2.id where t1.id = :id",
+	BindVariables: map[string]interface{}{
+		"id": int64(1),
+	},
+	TabletType: topo.TYPE_REPLICA,
+}
+
+var explainResult = &proto.ExplainResult{
+	Plan: &proto.PlanDescription{
+		PlanID:   "SelectJoin",
+		Original: "select * from t1 join t2 on t1.id = t2.id where t1.id = :id",
+		Left: &proto.PlanDescription{
+			PlanID:    "SelectEqual",
+			Table:     "t1",
+			Keyspace:  "ks",
+			Vindex:    "hash",
+			Rewritten: "select t1.id from t1 where t1.id = :id",
+			Shards:    []string{"-80"},
+		},
+		Right: &proto.PlanDescription{
+			PlanID:      "SelectEqual",
+			Table:       "t2",
+			Keyspace:    "ks",
+			Vindex:      "hash",
+			Rewritten:   "select * from t2 where t2.id = :t1_id",
+			ShardsError: "could not find bind var :t1_id",
+		},
+	},
+}
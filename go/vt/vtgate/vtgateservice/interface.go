@@ -41,6 +41,9 @@ type VTGateService interface {
 	// Topology support
 	GetSrvKeyspace(ctx context.Context, keyspace string) (*topo.SrvKeyspace, error)
 
+	// Query introspection
+	Explain(ctx context.Context, req *proto.ExplainRequest, reply *proto.ExplainResult) error
+
 	// HandlePanic should be called with defer at the beginning of each
 	// RPC implementation method, before calling any of the previous methods
 	HandlePanic(err *error)
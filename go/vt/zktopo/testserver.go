@@ -52,7 +52,7 @@ func (s *TestServer) LockSrvShardForAction(ctx context.Context, cell, keyspace,
 
 // SaveVSchema has to be redefined here.
 // Otherwise the test type assertion fails.
-// TODO(sougou): Remove these two functions after they're
+// TODO(sougou): Remove these functions after they're
 // migrated into topo.Server.
 func (s *TestServer) SaveVSchema(ctx context.Context, vschema string) error {
 	return s.Server.(topo.Schemafier).SaveVSchema(ctx, vschema)
@@ -63,3 +63,9 @@ func (s *TestServer) SaveVSchema(ctx context.Context, vschema string) error {
 func (s *TestServer) GetVSchema(ctx context.Context) (string, error) {
 	return s.Server.(topo.Schemafier).GetVSchema(ctx)
 }
+
+// WatchVSchema has to be redefined here.
+// Otherwise the test type assertion fails.
+func (s *TestServer) WatchVSchema(ctx context.Context) (<-chan *topo.VSchemaInfo, chan<- struct{}, error) {
+	return s.Server.(topo.Schemafier).WatchVSchema(ctx)
+}
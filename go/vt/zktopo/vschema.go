@@ -5,6 +5,10 @@
 package zktopo
 
 import (
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"golang.org/x/net/context"
 	// vindexes needs to be imported so that they register
@@ -44,3 +48,82 @@ func (zkts *Server) GetVSchema(ctx context.Context) (string, error) {
 	}
 	return data, nil
 }
+
+// WatchVSchema is part of the topo.Schemafier interface.
+func (zkts *Server) WatchVSchema(ctx context.Context) (<-chan *topo.VSchemaInfo, chan<- struct{}, error) {
+	notifications := make(chan *topo.VSchemaInfo, 10)
+	stopWatching := make(chan struct{})
+
+	// waitOrInterrupted will return true if stopWatching is triggered
+	waitOrInterrupted := func() bool {
+		timer := time.After(WatchSleepDuration)
+		select {
+		case <-stopWatching:
+			close(notifications)
+			return true
+		case <-timer:
+		}
+		return false
+	}
+
+	go func() {
+		for {
+			// set the watch
+			data, stat, watch, err := zkts.zconn.GetW(globalVSchemaPath)
+			if err != nil {
+				if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+					log.Errorf("Cannot set watch on %v, waiting for %v to retry: %v", globalVSchemaPath, WatchSleepDuration, err)
+					if waitOrInterrupted() {
+						return
+					}
+					continue
+				}
+				// The vschema doesn't exist yet. Watch for its creation.
+				stat, watch, err = zkts.zconn.ExistsW(globalVSchemaPath)
+				if err != nil {
+					log.Errorf("Cannot set watch on %v, waiting for %v to retry: %v", globalVSchemaPath, WatchSleepDuration, err)
+					if waitOrInterrupted() {
+						return
+					}
+					continue
+				}
+				if stat != nil {
+					// It was created in the meantime.
+					continue
+				}
+				data = "{}"
+			}
+
+			info := &topo.VSchemaInfo{VSchema: data, Version: topo.NoVSchemaVersion}
+			if stat != nil {
+				info.Version = int64(stat.Version())
+			}
+			notifications <- info
+
+			// now act on the watch
+			select {
+			case event, ok := <-watch:
+				if !ok {
+					log.Warningf("watch on %v was closed, waiting for %v to retry", globalVSchemaPath, WatchSleepDuration)
+					if waitOrInterrupted() {
+						return
+					}
+					continue
+				}
+
+				if !event.Ok() {
+					log.Warningf("received a non-OK event for %v, waiting for %v to retry", globalVSchemaPath, WatchSleepDuration)
+					if waitOrInterrupted() {
+						return
+					}
+				}
+			case <-stopWatching:
+				// user is not interested any more
+				close(notifications)
+				return
+			}
+		}
+	}()
+
+	return notifications, stopWatching, nil
+}
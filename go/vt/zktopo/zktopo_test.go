@@ -7,6 +7,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/topo/test"
 	"github.com/youtube/vitess/go/zk"
 	"launchpad.net/gozk/zookeeper"
@@ -91,6 +92,47 @@ func TestVSchema(t *testing.T) {
 	test.CheckVSchema(ctx, t, ts)
 }
 
+func TestWatchVSchema(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+	test.CheckWatchVSchema(ctx, t, ts)
+}
+
+// TestWatchVSchemaCreated is a ZK specific unit test. A newly created
+// znode has version 0, which must not be mistaken for the version of
+// the missing vschema.
+func TestWatchVSchemaCreated(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestServer(t, []string{"test"})
+	defer ts.Close()
+
+	notifications, stopWatching, err := ts.WatchVSchema(ctx)
+	if err != nil {
+		t.Fatalf("WatchVSchema failed: %v", err)
+	}
+	defer close(stopWatching)
+	info := <-notifications
+	if info.VSchema != "{}" || info.Version != topo.NoVSchemaVersion {
+		t.Fatalf("first value: %+v, want {} with version %v", info, topo.NoVSchemaVersion)
+	}
+
+	want := `{ "Keyspaces": { "aa": { "Sharded": false}}}`
+	if err := ts.SaveVSchema(ctx, want); err != nil {
+		t.Fatalf("SaveVSchema failed: %v", err)
+	}
+	for {
+		info = <-notifications
+		if info.VSchema == "{}" {
+			continue
+		}
+		if info.VSchema != want || info.Version != 0 {
+			t.Fatalf("value after create: %+v, want %s with version 0", info, want)
+		}
+		break
+	}
+}
+
 // TestPurgeActions is a ZK specific unit test
 func TestPurgeActions(t *testing.T) {
 	ctx := context.Background()